@@ -0,0 +1,408 @@
+package gvcode
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gioui.org/io/clipboard"
+	"gioui.org/io/system"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"github.com/oligo/gvcode/keymap"
+	"github.com/oligo/gvcode/textview"
+)
+
+// Command is a named command of the editor. Commands can be bound to key
+// chords in the keymap by their IDs, and run programmatically with
+// ExecuteCommand, e.g. from a menu or a command palette.
+type Command struct {
+	// ID is the unique name of the command, e.g. "edit.undo".
+	ID string
+	// Title is the human readable name of the command, e.g. "Undo".
+	Title string
+	// Handler runs the command. args is the payload passed to ExecuteCommand,
+	// and is nil when the command is run by a key binding.
+	Handler CommandFunc
+}
+
+// CommandFunc is the handler of a named command. It returns an EditorEvent if
+// there is any, or an error if args are not valid for the command.
+type CommandFunc func(gtx layout.Context, args any) (EditorEvent, error)
+
+// Keymap returns the keymap of the editor. Changes to the keymap, such as
+// binding or unbinding keys, take effect in the next frame. The default keymap
+// is used if none is set with WithKeymap.
+func (e *Editor) Keymap() *keymap.Keymap {
+	if e.keymap == nil {
+		e.keymap = keymap.Default()
+	}
+	return e.keymap
+}
+
+// PendingChord returns the key strokes typed so far of a multi-stroke key
+// chord, e.g. "Ctrl+K".
+func (e *Editor) PendingChord() string {
+	return keymap.Chord(e.chord).String()
+}
+
+// AddCommand adds a named command to the editor, replacing the command of the
+// same ID, including the builtin ones.
+func (e *Editor) AddCommand(cmd Command) {
+	if cmd.ID == "" || cmd.Handler == nil {
+		return
+	}
+	if e.namedCommands == nil {
+		e.buildNamedCommands()
+	}
+	e.namedCommands[cmd.ID] = cmd
+}
+
+// RemoveCommand removes the named command of the id.
+func (e *Editor) RemoveCommand(id string) {
+	if e.namedCommands == nil {
+		e.buildNamedCommands()
+	}
+	delete(e.namedCommands, id)
+}
+
+// LookupCommand returns the named command of the id.
+func (e *Editor) LookupCommand(id string) (Command, bool) {
+	if e.namedCommands == nil {
+		e.buildNamedCommands()
+	}
+	cmd, ok := e.namedCommands[id]
+	return cmd, ok
+}
+
+// Commands returns all the named commands of the editor, sorted by the IDs.
+func (e *Editor) Commands() []Command {
+	if e.namedCommands == nil {
+		e.buildNamedCommands()
+	}
+	cmds := make([]Command, 0, len(e.namedCommands))
+	for _, cmd := range e.namedCommands {
+		cmds = append(cmds, cmd)
+	}
+	slices.SortFunc(cmds, func(a, b Command) int { return strings.Compare(a.ID, b.ID) })
+	return cmds
+}
+
+// ExecuteCommand runs the named command of the id with args. The events
+// produced by the command are returned by the next calls of Update.
+func (e *Editor) ExecuteCommand(gtx layout.Context, id string, args any) error {
+	e.initBuffer()
+	cmd, ok := e.LookupCommand(id)
+	if !ok {
+		return fmt.Errorf("unknown command %q", id)
+	}
+
+	selStart, selEnd := e.Selection()
+	e.changes = nil
+	evt, err := cmd.Handler(gtx, args)
+	if err != nil {
+		return err
+	}
+	if evt != nil {
+		e.pending = append(e.pending, e.withChanges(evt))
+	}
+	if start, end := e.Selection(); start != selStart || end != selEnd {
+		e.pending = append(e.pending, SelectEvent{})
+	}
+
+	e.blinkStart = gtx.Now
+	e.scrollCaret = true
+	gtx.Execute(op.InvalidateCmd{})
+	return nil
+}
+
+// runCommand runs the named command of the id for a key binding.
+func (e *Editor) runCommand(gtx layout.Context, id string) EditorEvent {
+	cmd, ok := e.LookupCommand(id)
+	if !ok {
+		return nil
+	}
+	evt, _ := cmd.Handler(gtx, nil)
+	return evt
+}
+
+func (e *Editor) buildNamedCommands() {
+	e.namedCommands = make(map[string]Command)
+	add := func(id, title string, run func(gtx layout.Context) EditorEvent) {
+		e.namedCommands[id] = Command{ID: id, Title: title, Handler: func(gtx layout.Context, args any) (EditorEvent, error) {
+			return run(gtx), nil
+		}}
+	}
+
+	add("edit.newLine", "Insert Line Break", func(gtx layout.Context) EditorEvent {
+		return e.onInsertLineBreak()
+	})
+	add("edit.indent", "Indent", func(gtx layout.Context) EditorEvent {
+		return e.onTab(false)
+	})
+	add("edit.outdent", "Outdent", func(gtx layout.Context) EditorEvent {
+		return e.onTab(true)
+	})
+	add("edit.deleteLeft", "Delete Left", func(gtx layout.Context) EditorEvent {
+		return e.deleteAction(-1, false)
+	})
+	add("edit.deleteWordLeft", "Delete Word Left", func(gtx layout.Context) EditorEvent {
+		return e.deleteAction(-1, true)
+	})
+	add("edit.deleteRight", "Delete Right", func(gtx layout.Context) EditorEvent {
+		return e.deleteAction(1, false)
+	})
+	add("edit.deleteWordRight", "Delete Word Right", func(gtx layout.Context) EditorEvent {
+		return e.deleteAction(1, true)
+	})
+	add("edit.copy", "Copy", func(gtx layout.Context) EditorEvent {
+		return e.onCopyCut(gtx, false)
+	})
+	add("edit.cut", "Cut", func(gtx layout.Context) EditorEvent {
+		return e.onCopyCut(gtx, true)
+	})
+	// Initiate a paste operation, by requesting the clipboard contents; other
+	// half is in Editor.processKey() under clipboard.Event.
+	add("edit.paste", "Paste", func(gtx layout.Context) EditorEvent {
+		if e.mode != ModeReadOnly {
+			gtx.Execute(clipboard.ReadCmd{Tag: e})
+		}
+		return nil
+	})
+	add("edit.undo", "Undo", func(gtx layout.Context) EditorEvent {
+		if e.mode != ModeReadOnly {
+			if ev, ok := e.undo(); ok {
+				return ev
+			}
+		}
+		return nil
+	})
+	add("edit.redo", "Redo", func(gtx layout.Context) EditorEvent {
+		if e.mode != ModeReadOnly {
+			if ev, ok := e.redo(); ok {
+				return ev
+			}
+		}
+		return nil
+	})
+	add("edit.earlier", "Go Earlier in Undo History", func(gtx layout.Context) EditorEvent {
+		if e.mode != ModeReadOnly && e.Earlier(1) {
+			return ChangeEvent{}
+		}
+		return nil
+	})
+	add("edit.later", "Go Later in Undo History", func(gtx layout.Context) EditorEvent {
+		if e.mode != ModeReadOnly && e.Later(1) {
+			return ChangeEvent{}
+		}
+		return nil
+	})
+	// edit.insertText inserts the string of args at the caret, replacing the
+	// selection.
+	e.namedCommands["edit.insertText"] = Command{ID: "edit.insertText", Title: "Insert Text",
+		Handler: func(gtx layout.Context, args any) (EditorEvent, error) {
+			s, ok := args.(string)
+			if !ok {
+				return nil, fmt.Errorf("edit.insertText: want a string argument, got %T", args)
+			}
+			if e.mode == ModeReadOnly || e.Insert(s) == 0 {
+				return nil, nil
+			}
+			return ChangeEvent{}, nil
+		},
+	}
+	add("editor.toggleReadOnly", "Toggle Read-Only Mode", func(gtx layout.Context) EditorEvent {
+		if e.mode == ModeReadOnly {
+			e.setMode(ModeNormal)
+		} else {
+			e.setMode(ModeReadOnly)
+		}
+		return nil
+	})
+	for _, le := range []LineEnding{LF, CRLF, CR} {
+		add("editor.lineEnding."+strings.ToLower(le.String()), "Change Line Endings to "+le.String(), func(gtx layout.Context) EditorEvent {
+			e.SetLineEnding(le)
+			return nil
+		})
+	}
+
+	add("selection.all", "Select All", func(gtx layout.Context) EditorEvent {
+		e.text.ClearCarets()
+		e.text.SetCaret(0, e.text.Len())
+		return nil
+	})
+	add("selection.addNextOccurrence", "Add Selection To Next Find Match", func(gtx layout.Context) EditorEvent {
+		e.text.AddNextOccurrence()
+		return nil
+	})
+	add("selection.clearCarets", "Remove Secondary Carets", func(gtx layout.Context) EditorEvent {
+		e.text.ClearCarets()
+		return nil
+	})
+	add("selection.columnLeft", "Column Select Left", func(gtx layout.Context) EditorEvent {
+		e.text.MoveColumnSelection(-1, 0)
+		return nil
+	})
+	add("selection.columnRight", "Column Select Right", func(gtx layout.Context) EditorEvent {
+		e.text.MoveColumnSelection(1, 0)
+		return nil
+	})
+	add("selection.columnUp", "Column Select Up", func(gtx layout.Context) EditorEvent {
+		e.text.MoveColumnSelection(0, -1)
+		return nil
+	})
+	add("selection.columnDown", "Column Select Down", func(gtx layout.Context) EditorEvent {
+		e.text.MoveColumnSelection(0, 1)
+		return nil
+	})
+
+	add("cursor.addCaretAbove", "Add Cursor Above", func(gtx layout.Context) EditorEvent {
+		e.text.AddCaretVertical(-1)
+		return nil
+	})
+	add("cursor.addCaretBelow", "Add Cursor Below", func(gtx layout.Context) EditorEvent {
+		e.text.AddCaretVertical(1)
+		return nil
+	})
+	// cursor.goToLine moves the caret to the start of the line numbered by
+	// args, counting from 1.
+	e.namedCommands["cursor.goToLine"] = Command{ID: "cursor.goToLine", Title: "Go to Line",
+		Handler: func(gtx layout.Context, args any) (EditorEvent, error) {
+			line, ok := args.(int)
+			if !ok {
+				return nil, fmt.Errorf("cursor.goToLine: want an int argument, got %T", args)
+			}
+			line = min(max(0, line-1), max(0, e.buffer.Lines()-1))
+			caret := e.buffer.LineStart(line)
+			e.SetCaret(caret, caret)
+			return nil, nil
+		},
+	}
+
+	add("fold.toggle", "Toggle Fold", func(gtx layout.Context) EditorEvent {
+		line, _ := e.CaretPos()
+		e.ToggleFold(line)
+		return nil
+	})
+	add("fold.unfoldAll", "Unfold All", func(gtx layout.Context) EditorEvent {
+		e.UnfoldAll()
+		return nil
+	})
+
+	// The cursor movements, with the variants extending the selection.
+	moves := []struct {
+		id, title string
+		move      func(gtx layout.Context, selAct textview.SelectionAction)
+	}{
+		{"cursor.left", "Cursor Left", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.moveHorizontal(gtx, -1, false, selAct)
+		}},
+		{"cursor.right", "Cursor Right", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.moveHorizontal(gtx, 1, false, selAct)
+		}},
+		{"cursor.wordLeft", "Cursor Word Left", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.moveHorizontal(gtx, -1, true, selAct)
+		}},
+		{"cursor.wordRight", "Cursor Word Right", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.moveHorizontal(gtx, 1, true, selAct)
+		}},
+		{"cursor.up", "Cursor Up", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.moveVertical(gtx, -1, selAct)
+		}},
+		{"cursor.down", "Cursor Down", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.moveVertical(gtx, 1, selAct)
+		}},
+		{"cursor.lineStart", "Cursor Line Start", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.text.EachCaret(func() { e.text.MoveLineStart(selAct) })
+		}},
+		{"cursor.lineEnd", "Cursor Line End", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.text.EachCaret(func() { e.text.MoveLineEnd(selAct) })
+		}},
+		{"cursor.textStart", "Cursor Text Start", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.text.EachCaret(func() { e.text.MoveTextStart(selAct) })
+		}},
+		{"cursor.textEnd", "Cursor Text End", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.text.EachCaret(func() { e.text.MoveTextEnd(selAct) })
+		}},
+		{"cursor.pageUp", "Cursor Page Up", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.text.EachCaret(func() { e.text.MovePages(-1, selAct) })
+		}},
+		{"cursor.pageDown", "Cursor Page Down", func(gtx layout.Context, selAct textview.SelectionAction) {
+			e.text.EachCaret(func() { e.text.MovePages(+1, selAct) })
+		}},
+	}
+	for _, m := range moves {
+		add(m.id, m.title, func(gtx layout.Context) EditorEvent {
+			m.move(gtx, textview.SelectionClear)
+			return nil
+		})
+		add(m.id+"Select", m.title+" Select", func(gtx layout.Context) EditorEvent {
+			m.move(gtx, textview.SelectionExtend)
+			return nil
+		})
+	}
+}
+
+func (e *Editor) deleteAction(direction int, byWord bool) EditorEvent {
+	if e.mode == ModeReadOnly {
+		return nil
+	}
+
+	deleted := 0
+	if byWord {
+		deleted = e.deleteWord(direction)
+	} else {
+		deleted = e.Delete(direction)
+	}
+	if deleted != 0 {
+		return ChangeEvent{}
+	}
+	return nil
+}
+
+// caretAtEdges reports whether the primary caret is at the beginning or the
+// end of the text, in the direction of the locale.
+func (e *Editor) caretAtEdges(gtx layout.Context) (atBeginning, atEnd bool) {
+	caret, _ := e.text.Selection()
+	atBeginning = caret == 0
+	atEnd = caret == e.text.Len()
+	if gtx.Locale.Direction.Progression() != system.FromOrigin {
+		atEnd, atBeginning = atBeginning, atEnd
+	}
+	return atBeginning, atEnd
+}
+
+// moveHorizontal moves every caret to the left, or to the right, by one
+// grapheme cluster or by one word.
+func (e *Editor) moveHorizontal(gtx layout.Context, distance int, byWord bool, selAct textview.SelectionAction) {
+	if gtx.Locale.Direction.Progression() == system.TowardOrigin {
+		distance = -distance
+	}
+
+	e.text.EachCaret(func() {
+		atBeginning, atEnd := e.caretAtEdges(gtx)
+		if (distance < 0 && atBeginning) || (distance > 0 && atEnd) {
+			return
+		}
+
+		if byWord {
+			e.text.MoveWords(distance, selAct)
+		} else {
+			if selAct == textview.SelectionClear {
+				e.text.ClearSelection()
+			}
+			e.text.MoveCaret(distance, distance*int(selAct))
+		}
+	})
+}
+
+// moveVertical moves every caret up or down by lines.
+func (e *Editor) moveVertical(gtx layout.Context, distance int, selAct textview.SelectionAction) {
+	e.text.EachCaret(func() {
+		atBeginning, atEnd := e.caretAtEdges(gtx)
+		if (distance < 0 && atBeginning) || (distance > 0 && atEnd) {
+			return
+		}
+		e.text.MoveLines(distance, selAct)
+	})
+}
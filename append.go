@@ -0,0 +1,61 @@
+package gvcode
+
+import (
+	"strings"
+
+	"github.com/oligo/gvcode/buffer"
+)
+
+// tailState tracks the text appended by Append.
+type tailState struct {
+	// following is set if the viewport is at the bottom of the text after the
+	// last layout, and is not scrolled up since. The viewport stays at the
+	// bottom as the text is appended.
+	following bool
+	// appended is set if text is appended since the last layout.
+	appended bool
+	// cr is set if the text last appended ends with "\r", which is held back
+	// until the next text tells if it is a "\r\n".
+	cr bool
+}
+
+// Append adds text to the end of the document, which is meant for the text
+// streamed in, such as the output of a build or a log. Unlike Insert, the text
+// is not recorded in the undo history, nor does it make the document modified,
+// only the last paragraphs are laid out again, and the carets stay where they
+// are. If the viewport is at the bottom of the text, it follows the text
+// appended, until it is scrolled up. The oldest lines beyond the limit set by
+// WithMaxLines are trimmed. It returns the number of runes appended.
+func (e *Editor) Append(text string) int {
+	e.initBuffer()
+
+	if e.tail.cr {
+		text = "\r" + text
+		e.tail.cr = false
+	}
+	if strings.HasSuffix(text, "\r") {
+		text = text[:len(text)-1]
+		e.tail.cr = true
+	}
+
+	n := e.text.Append(buffer.NormalizeLineEndings(text))
+	if n == 0 {
+		return 0
+	}
+
+	if lines := e.buffer.Lines(); e.maxLines > 0 && lines > e.maxLines {
+		removed := e.text.TrimLines(lines - e.maxLines)
+		e.ime.start = max(e.ime.start-removed, 0)
+		e.ime.end = max(e.ime.end-removed, 0)
+		e.adjustAutoInsertions(0, removed, 0)
+	}
+
+	e.tail.appended = true
+	return n
+}
+
+// trackTail records whether the viewport is at the bottom of the text after
+// the layout.
+func (e *Editor) trackTail() {
+	e.tail.following = e.text.ScrollOff().Y >= e.text.ScrollBounds().Max.Y
+}
@@ -0,0 +1,213 @@
+package buffer
+
+// compactThreshold is the minimum size in bytes of the trimmed text left in
+// the buffers to compact them.
+const compactThreshold = 1 << 20
+
+// Append adds text to the end of the sequence, without recording it in the
+// undo history. The changes in the history are kept, and the text appended
+// stays at the end as they are undone and redone. Appending again before the
+// text is edited extends the last piece, so that streaming text in small
+// chunks does not make a piece for each of them.
+func (pt *PieceTable) Append(text string) bool {
+	defer pt.notifyChanges()
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if text == "" {
+		return false
+	}
+
+	pt.recordChange(pt.seqLength, pt.seqLength, text, ChangeEdit)
+	end := pt.seqLength
+	runeOff, byteOff, runes := pt.addToBuffer(modify, []byte(text))
+
+	last := pt.lastAppend
+	if last != nil && last == pt.pieces.Tail() && last.source == modify && last.offset+last.length == runeOff {
+		last.length += runes
+		last.byteLength += len(text)
+		pt.pieces.resizePiece(last)
+	} else {
+		last = pt.pieces.growTail(piece{
+			source:     modify,
+			offset:     runeOff,
+			length:     runes,
+			byteOff:    byteOff,
+			byteLength: len(text),
+		})
+		pt.lastAppend = last
+	}
+	pt.seqLength += runes
+	pt.seqBytes += len(text)
+
+	// The markers at the end are pushed by the text if they are forward biased.
+	for _, m := range pt.markers {
+		if m.offset == end && m.bias == BiasForward {
+			m.update(last, last.length)
+			m.offset = pt.seqLength
+		}
+	}
+
+	// The text typed last is no longer at the end of the modify buffer.
+	pt.resetLastInsert()
+	pt.markChanged()
+	return true
+}
+
+// growTail adds a piece with the text of p to the end of the list, by turning
+// the tail sentinel into the piece and linking a new sentinel after it. Unlike
+// Append, the pieces saved in the undo history which are linked to the tail
+// are then linked to the new piece, which stays at the end of the list as
+// they are restored.
+func (pl *pieceList) growTail(p piece) *piece {
+	last := pl.tail
+	pl.tail = &piece{prev: last}
+	pl.relink(last.prev, pl.tail, func() {
+		prev := last.prev
+		*last = p
+		last.prev = prev
+		last.next = pl.tail
+	})
+	return last
+}
+
+// TrimLines removes the first n lines of the sequence, without recording it
+// in the undo history. The changes in the history are kept unless they change
+// the lines removed, in which case they can no longer be undone and the
+// history is cleared. The buffers are compacted once they mostly hold the text
+// removed and the history is empty, so that the memory used by a text trimmed
+// as it is appended to stays bounded.
+func (pt *PieceTable) TrimLines(n int) int {
+	defer pt.notifyChanges()
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if n <= 0 {
+		return 0
+	}
+
+	end := pt.lineStart(n)
+	if end <= 0 {
+		return 0
+	}
+
+	pt.recordChange(0, end, "", ChangeEdit)
+	first, inRuneOff, _ := pt.pieces.FindPiece(end)
+	trimmed := map[*piece]bool{pt.pieces.head: true}
+	for p := pt.pieces.Head(); p != first; p = p.next {
+		trimmed[p] = true
+	}
+	if pt.history.changesFront(trimmed, first) {
+		pt.dropHistory()
+	} else {
+		pt.history.shiftCursors(-end)
+	}
+
+	// Move the markers in the text removed to the start.
+	for _, m := range pt.markers {
+		switch {
+		case m.piece == first:
+			m.pieceOffset = max(m.pieceOffset-inRuneOff, 0)
+		case trimmed[m.piece] && first == pt.pieces.tail:
+			m.update(pt.pieces.head, 0)
+		case trimmed[m.piece]:
+			m.update(first, 0)
+		}
+	}
+
+	pt.seqBytes -= pt.trimFront(first, inRuneOff)
+	pt.seqLength -= end
+	pt.syncMarkerOffset(nil)
+	pt.resetLastInsert()
+	pt.markChanged()
+	pt.compact()
+	return end
+}
+
+// trimFront unlinks the pieces before first, and the first runes of it, which
+// must be less than its length. It returns the number of bytes removed.
+func (pt *PieceTable) trimFront(first *piece, runes int) int {
+	pl := pt.pieces
+	removed := 0
+	for p := pl.Head(); p != first; p = p.next {
+		removed += p.byteLength
+	}
+
+	b := first
+	if first != pl.tail {
+		b = first.next
+	}
+	pl.relink(pl.head, b, func() {
+		if runes > 0 {
+			byteOff := pt.getBuf(first.source).RuneOffset(first.offset + runes)
+			removed += byteOff - first.byteOff
+			first.offset += runes
+			first.length -= runes
+			first.byteLength -= byteOff - first.byteOff
+			first.byteOff = byteOff
+		}
+		pl.head.next = first
+		first.prev = pl.head
+	})
+	return removed
+}
+
+// changesFront reports whether any change in the history links the pieces
+// trimmed from the front of the list, which includes the head sentinel, or
+// the piece first after them. The text trimmed is otherwise the same in all
+// the states of the history.
+func (t *undoTree) changesFront(trimmed map[*piece]bool, first *piece) bool {
+	for _, node := range t.nodes[1:] {
+		for _, rng := range node.ranges {
+			a, b := rng.neighbors()
+			if trimmed[a] || trimmed[b] || b == first {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shiftCursors moves the cursor positions of the changes in the history by
+// delta runes, after the text before them is trimmed.
+func (t *undoTree) shiftCursors(delta int) {
+	for _, node := range t.nodes[1:] {
+		for _, rng := range node.ranges {
+			rng.cursor.Start = max(rng.cursor.Start+delta, 0)
+			rng.cursor.End = max(rng.cursor.End+delta, 0)
+		}
+	}
+}
+
+// compact copies the text to new buffers if the text no longer referenced by
+// the pieces takes more space than the text itself. It does nothing unless
+// the undo history is empty, as the changes in it reference the old pieces.
+func (pt *PieceTable) compact() {
+	unused := len(pt.originalBuf.buf) + len(pt.modifyBuf.buf) - pt.seqBytes
+	if unused < max(pt.seqBytes, compactThreshold) || len(pt.history.nodes) > 1 {
+		return
+	}
+
+	text := make([]byte, 0, pt.seqBytes)
+	for p := pt.pieces.Head(); p != pt.pieces.tail; p = p.next {
+		text = append(text, pt.getBuf(p.source).getTextByRange(p.byteOff, p.byteLength)...)
+	}
+
+	pt.originalBuf = newTextBuffer()
+	pt.modifyBuf = newTextBuffer()
+	pt.pieces = newPieceList()
+	pt.seqLength = 0
+	pt.seqBytes = 0
+	pt.lastAppend = nil
+	pt.init(text)
+
+	// Move the markers to the new pieces, keeping their offsets.
+	for _, m := range pt.markers {
+		p, inRuneOff, _ := pt.pieces.FindPiece(m.offset)
+		if p == pt.pieces.tail {
+			p = pt.pieces.Tail()
+			inRuneOff = p.length
+		}
+		m.update(p, inRuneOff)
+	}
+}
@@ -0,0 +1,190 @@
+package buffer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAppendTrimLines(t *testing.T) {
+	pt := NewPieceTable([]byte("a\nb"))
+	pt.Replace(0, 0, "世")
+	mirror := readTableContent(pt)
+	pt.Subscribe(func(c TextChange) { mirror = applyChange(t, mirror, c) })
+	check := func(want string) {
+		t.Helper()
+		if got := readTableContent(pt); got != want || got != mirror {
+			t.Fatalf("want %q, got %q, mirrored %q", want, got, mirror)
+		}
+	}
+
+	forward, _ := pt.CreateMarker(pt.Len(), BiasForward)
+	backward, _ := pt.CreateMarker(pt.Len(), BiasBackward)
+	inside, _ := pt.CreateMarker(2, BiasForward)
+
+	pt.Append("c\n")
+	pieces := pt.pieces.Length()
+	pt.Append("d\n")
+	check("世a\nbc\nd\n")
+	if pt.pieces.Length() != pieces {
+		t.Errorf("want the last piece extended, got %d pieces from %d", pt.pieces.Length(), pieces)
+	}
+	if pt.Lines() != 3 {
+		t.Errorf("want 3 lines, got %d", pt.Lines())
+	}
+	if forward.Offset() != pt.Len() || backward.Offset() != 4 {
+		t.Errorf("want the markers at %d and 4, got %d and %d", pt.Len(), forward.Offset(), backward.Offset())
+	}
+
+	// The appended text is not undoable, and stays as the changes before it
+	// are undone and redone.
+	if _, ok := pt.Undo(); !ok {
+		t.Fatal("want the insertion undone")
+	}
+	check("a\nbc\nd\n")
+	if _, ok := pt.Undo(); ok {
+		t.Error("want nothing more to undo")
+	}
+	pt.Redo()
+	check("世a\nbc\nd\n")
+
+	// The text appended after undoing is in a new piece.
+	pt.Append("e")
+	if pt.pieces.Length() != pieces+1 {
+		t.Errorf("want a new piece, got %d pieces from %d", pt.pieces.Length(), pieces)
+	}
+
+	// The lines trimmed are changed by the insertion, which is dropped from
+	// the history.
+	if n := pt.TrimLines(2); n != 6 {
+		t.Errorf("want 6 runes trimmed, got %d", n)
+	}
+	check("d\ne")
+	if forward.Offset() != 3 || inside.Offset() != 0 {
+		t.Errorf("want the markers at 3 and 0, got %d and %d", forward.Offset(), inside.Offset())
+	}
+	if _, ok := pt.Undo(); ok {
+		t.Error("want nothing to undo")
+	}
+	if !pt.Modified() {
+		t.Error("want the text modified")
+	}
+	if n := pt.TrimLines(5); n != 3 || pt.Len() != 0 || pt.Lines() != 0 {
+		t.Errorf("want all the text trimmed, got %d runes trimmed and %q left", n, readTableContent(pt))
+	}
+	pt.Append("f")
+	check("f")
+}
+
+func TestAppendKeepsHistory(t *testing.T) {
+	pt := NewPieceTable([]byte("a\nb"))
+	mirror := readTableContent(pt)
+	pt.Subscribe(func(c TextChange) { mirror = applyChange(t, mirror, c) })
+	check := func(want string) {
+		t.Helper()
+		if got := readTableContent(pt); got != want || got != mirror {
+			t.Fatalf("want %q, got %q, mirrored %q", want, got, mirror)
+		}
+	}
+
+	// Edit the end of the text, and save it.
+	pt.Replace(3, 3, "x")
+	pt.Replace(4, 4, "yz")
+	pt.MarkSaved()
+
+	pt.Append("\nc")
+	pt.Append("\nd")
+	check("a\nbxyz\nc\nd")
+	if pt.Modified() {
+		t.Error("want the text unmodified by appending")
+	}
+
+	pt.Undo()
+	check("a\nbx\nc\nd")
+	if !pt.Modified() {
+		t.Error("want the text modified by undoing")
+	}
+	pt.Undo()
+	check("a\nb\nc\nd")
+	pt.Redo()
+	pt.Append("\ne")
+	check("a\nbx\nc\nd\ne")
+
+	// Trimming the lines before the changes keeps them.
+	if n := pt.TrimLines(1); n != 2 {
+		t.Errorf("want 2 runes trimmed, got %d", n)
+	}
+	check("bx\nc\nd\ne")
+	cursors, ok := pt.Undo()
+	check("b\nc\nd\ne")
+	if !ok || len(cursors) != 1 || cursors[0] != (CursorPos{Start: 1, End: 1}) {
+		t.Errorf("want the insertion at 1 undone, got %v, %v", cursors, ok)
+	}
+	pt.Redo()
+	pt.Redo()
+	check("bxyz\nc\nd\ne")
+	if pt.Modified() {
+		t.Error("want the text back to the saved state")
+	}
+	if nodes, _ := pt.History(); len(nodes) != 2 {
+		t.Errorf("want 2 changes kept, got %d", len(nodes))
+	}
+}
+
+func TestTypeWhileAppending(t *testing.T) {
+	pt := NewPieceTable(nil)
+	mirror := ""
+	pt.Subscribe(func(c TextChange) { mirror = applyChange(t, mirror, c) })
+	check := func(want string) {
+		t.Helper()
+		if got := readTableContent(pt); got != want || got != mirror {
+			t.Fatalf("want %q, got %q, mirrored %q", want, got, mirror)
+		}
+	}
+
+	// Typing goes on where it stopped, not over the text appended since.
+	pt.Replace(0, 0, "a")
+	pt.Replace(1, 1, "b")
+	pt.Append("LOG\n")
+	pt.Replace(2, 2, "c")
+	check("abcLOG\n")
+	pt.Replace(3, 3, "世")
+	pt.Append("世界\n")
+	pt.Replace(4, 4, "d")
+	check("abc世dLOG\n世界\n")
+
+	// Typing at the end, then appending.
+	pt.Replace(pt.Len(), pt.Len(), "e")
+	pt.Append("f")
+	pt.Replace(pt.Len()-1, pt.Len()-1, "g")
+	check("abc世dLOG\n世界\negf")
+
+	for _, ok := pt.Undo(); ok; _, ok = pt.Undo() {
+	}
+	check("LOG\n世界\nf")
+}
+
+func TestTrimLinesCompact(t *testing.T) {
+	pt := NewPieceTable(nil)
+	const maxLines = 100
+	var text strings.Builder
+	for i := 0; i < 20000; i++ {
+		line := fmt.Sprintf("line %d of the build output\n", i)
+		text.WriteString(line)
+		pt.Append(line)
+		if lines := pt.Lines(); lines > maxLines {
+			pt.TrimLines(lines - maxLines)
+		}
+	}
+
+	want := strings.Join(strings.SplitAfter(text.String(), "\n")[20000-maxLines:], "")
+	if got := readTableContent(pt); got != want {
+		t.Fatalf("want the last %d lines, got %q", maxLines, got)
+	}
+	if size := len(pt.originalBuf.buf) + len(pt.modifyBuf.buf); size > 2*compactThreshold+len(want) {
+		t.Errorf("want the buffers compacted, got %d bytes for %d bytes of text", size, len(want))
+	}
+	if m, _ := pt.CreateMarker(5, BiasForward); pt.LineStart(1) != pt.LineLength(0) || m.Offset() != 5 {
+		t.Error("inconsistent sequence after compacting")
+	}
+}
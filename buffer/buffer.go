@@ -1,8 +1,10 @@
 package buffer
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"sort"
 	"unicode/utf8"
 )
 
@@ -20,6 +22,8 @@ type textBuffer struct {
 	runeOffIndex
 	// Length of the  buffer in runes.
 	length int
+	// lineBreaks saves the rune offsets of all the line breaks in the buffer.
+	lineBreaks []int
 }
 
 func newTextBuffer() *textBuffer {
@@ -43,6 +47,8 @@ func (tb *textBuffer) ReadRuneAt(byteOff int64) (rune, int, error) {
 func (tb *textBuffer) set(buf []byte) int {
 	tb.buf = buf
 	tb.length = utf8.RuneCount(buf)
+	tb.lineBreaks = tb.lineBreaks[:0]
+	tb.indexLineBreaks(buf, 0)
 	return tb.length
 }
 
@@ -69,9 +75,37 @@ func (tb *textBuffer) append(buf []byte) (runeOff int, byteOff int, runeLen int)
 	tb.buf = append(tb.buf, buf...)
 	runeLen = utf8.RuneCount(buf)
 	tb.length += runeLen
+	tb.indexLineBreaks(buf, runeOff)
 	return
 }
 
+// indexLineBreaks adds the line breaks in buf to the line break index. runeOff
+// is the rune offset of buf in the buffer.
+func (tb *textBuffer) indexLineBreaks(buf []byte, runeOff int) {
+	for {
+		idx := bytes.IndexByte(buf, lineBreak)
+		if idx < 0 {
+			return
+		}
+
+		runeOff += utf8.RuneCount(buf[:idx])
+		tb.lineBreaks = append(tb.lineBreaks, runeOff)
+		runeOff++
+		buf = buf[idx+1:]
+	}
+}
+
+// lineBreaksInRange returns the number of line breaks in the rune range.
+func (tb *textBuffer) lineBreaksInRange(runeIdx int, runeLen int) int {
+	return sort.SearchInts(tb.lineBreaks, runeIdx+runeLen) - sort.SearchInts(tb.lineBreaks, runeIdx)
+}
+
+// nthLineBreak returns the rune offset of the nth line break starting from
+// runeIdx, where n starts from 1.
+func (tb *textBuffer) nthLineBreak(runeIdx int, n int) int {
+	return tb.lineBreaks[sort.SearchInts(tb.lineBreaks, runeIdx)+n-1]
+}
+
 func (tb *textBuffer) bytesForRange(runeIdx int, runeLen int) int {
 	start := tb.RuneOffset(runeIdx)
 	end := tb.RuneOffset(runeIdx + runeLen)
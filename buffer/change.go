@@ -0,0 +1,211 @@
+package buffer
+
+import (
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChangeOrigin tells how a change of the text is made.
+type ChangeOrigin uint8
+
+const (
+	// ChangeEdit is a change made by Replace or SetText.
+	ChangeEdit ChangeOrigin = iota
+	// ChangeUndo is a change made by undoing an edit.
+	ChangeUndo
+	// ChangeRedo is a change made by redoing an edit.
+	ChangeRedo
+)
+
+func (o ChangeOrigin) String() string {
+	switch o {
+	case ChangeUndo:
+		return "undo"
+	case ChangeRedo:
+		return "redo"
+	default:
+		return "edit"
+	}
+}
+
+// TextPos is a position in the text by line and column, both counted from
+// zero. The column is measured in runes.
+type TextPos struct {
+	Line   int
+	Column int
+}
+
+// TextChange describes a change of the text, which replaces the text from
+// Start to Start+RemovedRunes with Text. Applying the changes in the order
+// they are notified to a copy of the text keeps it the same as the source.
+type TextChange struct {
+	// Start is the rune offset of the change.
+	Start int
+	// ByteStart is the byte offset of the change.
+	ByteStart int
+	// RemovedRunes is the length in runes of the removed text.
+	RemovedRunes int
+	// RemovedBytes is the length in bytes of the removed text.
+	RemovedBytes int
+	// Text is the inserted text.
+	Text string
+	// StartPos is the position of Start.
+	StartPos TextPos
+	// OldEndPos is the end position of the removed text, before the change.
+	OldEndPos TextPos
+	// NewEndPos is the end position of the inserted text, after the change.
+	NewEndPos TextPos
+	// Origin tells whether the change is an edit, an undo or a redo.
+	Origin ChangeOrigin
+}
+
+// changeListener is a function subscribed to the changes. It is referred to
+// by pointer so that it can be unsubscribed.
+type changeListener struct {
+	fn func(TextChange)
+}
+
+// Subscribe registers fn to be called with every change of the text, after
+// the change is made. fn is called in the goroutine making the change, and
+// may read the text source, but should not change it. Call the returned
+// function to unsubscribe.
+func (pt *PieceTable) Subscribe(fn func(TextChange)) (cancel func()) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	l := &changeListener{fn: fn}
+	pt.listeners = append(pt.listeners, l)
+	return func() {
+		pt.mu.Lock()
+		defer pt.mu.Unlock()
+		pt.listeners = slices.DeleteFunc(pt.listeners, func(e *changeListener) bool { return e == l })
+	}
+}
+
+// recordChange records the change replacing the runes from start to end with
+// text, which must be called before the change is made.
+func (pt *PieceTable) recordChange(start, end int, text string, origin ChangeOrigin) {
+	if len(pt.listeners) == 0 {
+		return
+	}
+
+	change := TextChange{
+		Start:        start,
+		ByteStart:    pt.runeOffset(start),
+		RemovedRunes: end - start,
+		Text:         text,
+		StartPos:     pt.textPos(start),
+		OldEndPos:    pt.textPos(end),
+		Origin:       origin,
+	}
+	change.RemovedBytes = pt.runeOffset(end) - change.ByteStart
+
+	change.NewEndPos = change.StartPos
+	if lines := strings.Count(text, "\n"); lines > 0 {
+		change.NewEndPos.Line += lines
+		change.NewEndPos.Column = utf8.RuneCountInString(text[strings.LastIndexByte(text, '\n')+1:])
+	} else {
+		change.NewEndPos.Column += utf8.RuneCountInString(text)
+	}
+
+	pt.changes = append(pt.changes, change)
+}
+
+// recordRestore records the change made by restoring rng, which must be called
+// before rng is restored. Only the text differing between the restored pieces
+// and the current ones is recorded, as they usually share most of the text,
+// like a piece and its halves split by an insertion.
+func (pt *PieceTable) recordRestore(rng *pieceRange, origin ChangeOrigin) {
+	if len(pt.listeners) == 0 {
+		return
+	}
+
+	a, b := rng.neighbors()
+	posA, _ := pt.pieces.position(a)
+	posB, _ := pt.pieces.position(b)
+	start, end := posA.runes+a.length, posB.runes
+
+	var current, restored []*piece
+	for n := a.next; n != b; n = n.next {
+		current = append(current, n)
+	}
+	restoredLen := 0
+	if !rng.boundary {
+		for n := rng.first; n != rng.last.next; n = n.next {
+			restored = append(restored, n)
+			restoredLen += n.length
+		}
+	}
+
+	prefix := commonRunes(current, restored, false)
+	suffix := min(commonRunes(current, restored, true), end-start-prefix, restoredLen-prefix)
+
+	var text strings.Builder
+	off := 0
+	for _, n := range restored {
+		from, to := max(prefix-off, 0), min(restoredLen-suffix-off, n.length)
+		if from < to {
+			buf := pt.getBuf(n.source)
+			byteOff := buf.RuneOffset(n.offset + from)
+			text.Write(buf.getTextByRange(byteOff, buf.RuneOffset(n.offset+to)-byteOff))
+		}
+		off += n.length
+	}
+	pt.recordChange(start+prefix, end-suffix, text.String(), origin)
+}
+
+// commonRunes returns the length in runes of the text at the start of the
+// pieces x and y, or at their end if fromEnd is set, which they share by
+// referring to the same text of the buffers.
+func commonRunes(x, y []*piece, fromEnd bool) int {
+	n := 0
+	// i and j are the pieces compared, and xOff and yOff are the runes of them
+	// compared so far.
+	i, j, xOff, yOff := 0, 0, 0, 0
+	for i < len(x) && j < len(y) {
+		p, q := x[i], y[j]
+		pOff, qOff := p.offset+xOff, q.offset+yOff
+		if fromEnd {
+			p, q = x[len(x)-1-i], y[len(y)-1-j]
+			pOff, qOff = p.offset+p.length-xOff, q.offset+q.length-yOff
+		}
+		if p.source != q.source || pOff != qOff {
+			break
+		}
+
+		k := min(p.length-xOff, q.length-yOff)
+		n += k
+		xOff += k
+		yOff += k
+		if xOff == p.length {
+			i, xOff = i+1, 0
+		}
+		if yOff == q.length {
+			j, yOff = j+1, 0
+		}
+	}
+	return n
+}
+
+// textPos returns the line and column of the rune at runeOff.
+func (pt *PieceTable) textPos(runeOff int) TextPos {
+	line := pt.lineOf(runeOff)
+	return TextPos{Line: line, Column: runeOff - pt.lineStart(line)}
+}
+
+// notifyChanges calls the listeners with the changes recorded. It must be
+// called without holding the lock, usually deferred before locking.
+func (pt *PieceTable) notifyChanges() {
+	pt.mu.Lock()
+	changes := pt.changes
+	pt.changes = nil
+	listeners := slices.Clone(pt.listeners)
+	pt.mu.Unlock()
+
+	for _, c := range changes {
+		for _, l := range listeners {
+			l.fn(c)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package buffer
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+// applyChange applies c to the mirrored text, checking the offsets.
+func applyChange(t *testing.T, text string, c TextChange) string {
+	t.Helper()
+	runes := []rune(text)
+	if c.Start < 0 || c.Start+c.RemovedRunes > len(runes) {
+		t.Fatalf("change out of range: %+v", c)
+	}
+	prefix := string(runes[:c.Start])
+	removed := string(runes[c.Start : c.Start+c.RemovedRunes])
+	if c.ByteStart != len(prefix) || c.RemovedBytes != len(removed) {
+		t.Errorf("want byte range (%d, %d), got %+v", len(prefix), len(removed), c)
+	}
+	if want := posOf(prefix); c.StartPos != want {
+		t.Errorf("want start at %v, got %v", want, c.StartPos)
+	}
+	if want := posOf(prefix + removed); c.OldEndPos != want {
+		t.Errorf("want old end at %v, got %v", want, c.OldEndPos)
+	}
+	if want := posOf(prefix + c.Text); c.NewEndPos != want {
+		t.Errorf("want new end at %v, got %v", want, c.NewEndPos)
+	}
+	return prefix + c.Text + string(runes[c.Start+c.RemovedRunes:])
+}
+
+func posOf(s string) TextPos {
+	pos := TextPos{}
+	for _, r := range s {
+		if r == '\n' {
+			pos.Line++
+			pos.Column = 0
+		} else {
+			pos.Column++
+		}
+	}
+	return pos
+}
+
+func TestSubscribeChanges(t *testing.T) {
+	initial := "你好\nworld"
+	pt := NewPieceTable([]byte(initial))
+
+	mirror := initial
+	var origins []ChangeOrigin
+	cancel := pt.Subscribe(func(c TextChange) {
+		// listeners may read the source.
+		if pt.Len() < 0 {
+			t.Error("invalid length")
+		}
+		mirror = applyChange(t, mirror, c)
+		origins = append(origins, c.Origin)
+	})
+
+	check := func(step string) {
+		t.Helper()
+		if got := readTableContent(pt); mirror != got {
+			t.Errorf("%s: want %q, got %q", step, got, mirror)
+		}
+	}
+
+	pt.Replace(2, 2, "!\n")
+	check("insert")
+	pt.Replace(3, 3, "a")
+	pt.Replace(4, 4, "b")
+	check("append")
+	pt.Replace(0, 1, "")
+	check("erase")
+	pt.Replace(5, 1, "")
+	check("erase backward")
+	pt.Replace(1, 4, "é\nx")
+	check("replace")
+	pt.Replace(0, 0, "")
+	pt.Replace(100, 100, "nope")
+	check("no change")
+
+	want := []ChangeOrigin{ChangeEdit, ChangeEdit, ChangeEdit, ChangeEdit, ChangeEdit, ChangeEdit}
+	if len(origins) != len(want) {
+		t.Fatalf("want %d changes, got %d", len(want), len(origins))
+	}
+
+	for pt.undoDepth() > 0 {
+		pt.Undo()
+		check("undo")
+	}
+	if mirror != initial {
+		t.Errorf("want %q after undoing all, got %q", initial, mirror)
+	}
+	for pt.redoDepth() > 0 {
+		pt.Redo()
+		check("redo")
+	}
+	pt.GotoHistory(2)
+	check("goto")
+	if last := origins[len(origins)-1]; last != ChangeUndo {
+		t.Errorf("want the last change from undo, got %v", last)
+	}
+
+	pt.SetText([]byte("reset"))
+	check("set text")
+
+	cancel()
+	pt.Replace(0, 0, "unseen")
+	if utf8.RuneCountInString(mirror) != pt.Len()-6 {
+		t.Error("want no changes after unsubscribing")
+	}
+}
+
+func TestUndoChangesAreMinimal(t *testing.T) {
+	initial := "func main() {}\nfunc init() {}\n"
+	pt := NewPieceTable([]byte(initial))
+
+	mirror := initial
+	var changes []TextChange
+	pt.Subscribe(func(c TextChange) {
+		mirror = applyChange(t, mirror, c)
+		changes = append(changes, c)
+	})
+
+	pt.Replace(5, 5, "x")
+	pt.Replace(20, 22, "")
+	pt.Undo()
+	pt.Undo()
+	pt.Redo()
+	if got := readTableContent(pt); mirror != got {
+		t.Fatalf("want %q, got %q", got, mirror)
+	}
+
+	// The restored pieces share the text around the edits, which is not
+	// reported as changed.
+	want := []TextChange{
+		{Start: 20, RemovedRunes: 0, Text: " i", Origin: ChangeUndo},
+		{Start: 5, RemovedRunes: 1, Text: "", Origin: ChangeUndo},
+		{Start: 5, RemovedRunes: 0, Text: "x", Origin: ChangeRedo},
+	}
+	changes = changes[2:]
+	if len(changes) != len(want) {
+		t.Fatalf("want %d changes, got %d", len(want), len(changes))
+	}
+	for i, c := range changes {
+		w := want[i]
+		if c.Start != w.Start || c.RemovedRunes != w.RemovedRunes || c.Text != w.Text || c.Origin != w.Origin {
+			t.Errorf("want change %+v, got %+v", w, c)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package buffer
+
+import (
+	"time"
+)
+
+// HistoryNode describes a change in the undo history. The history is a tree:
+// making a change after undoing others starts a new branch, and the undone
+// changes are kept in the old branch.
+type HistoryNode struct {
+	// Seq is the sequence number of the change, counting from 1 in the order
+	// the changes are made. 0 is the state before any change.
+	Seq int
+	// Parent is the Seq of the change this one is made upon.
+	Parent int
+	// Time is when the change is made, or last extended for changes of
+	// continuous typing.
+	Time time.Time
+}
+
+// historyNode is a node of the undo tree. It records a change of the text,
+// which is a single edit, or a group of edits batched by GroupOp.
+type historyNode struct {
+	seq    int
+	depth  int
+	parent *historyNode
+	// redoChild is the child to redo, which is the one last created or visited.
+	redoChild *historyNode
+	// ranges are the piece ranges swapped by the edits, in the order of the
+	// edits. Restoring them in reverse order undoes the change, after which
+	// they hold the pieces to restore in order to redo it.
+	ranges  []*pieceRange
+	batchId *int
+	time    time.Time
+}
+
+// undoTree keeps all the changes of the text, including the ones in undone
+// branches.
+type undoTree struct {
+	// nodes are indexed by the sequence numbers. nodes[0] is the root, which
+	// is the state before any change.
+	nodes   []*historyNode
+	current *historyNode
+	// saved is the state when the text is last saved, which is the root until
+	// the text is saved. It is nil if none of the states is the saved one.
+	saved *historyNode
+}
+
+func newUndoTree() *undoTree {
+	root := &historyNode{}
+	return &undoTree{nodes: []*historyNode{root}, current: root, saved: root}
+}
+
+// record adds the range to the current change if it belongs to the same batch,
+// or makes it a new change upon the current one.
+func (t *undoTree) record(rng *pieceRange) {
+	cur := t.current
+	// The saved change is never extended, so that the edits after saving are
+	// always a different state.
+	if rng.batchId != nil && cur.batchId == rng.batchId && cur.seq > 0 && cur.seq == len(t.nodes)-1 && cur != t.saved {
+		cur.ranges = append(cur.ranges, rng)
+		cur.time = time.Now()
+		return
+	}
+
+	node := &historyNode{
+		seq:     len(t.nodes),
+		depth:   cur.depth + 1,
+		parent:  cur,
+		ranges:  []*pieceRange{rng},
+		batchId: rng.batchId,
+		time:    time.Now(),
+	}
+	t.nodes = append(t.nodes, node)
+	cur.redoChild = node
+	t.current = node
+}
+
+// path returns the changes to undo and then the ones to redo to go from the
+// current state to the state after the change of target.
+func (t *undoTree) path(target *historyNode) (undo, redo []*historyNode) {
+	a, b := t.current, target
+	for a.depth > b.depth {
+		undo = append(undo, a)
+		a = a.parent
+	}
+	for b.depth > a.depth {
+		redo = append(redo, b)
+		b = b.parent
+	}
+	for a != b {
+		undo = append(undo, a)
+		redo = append(redo, b)
+		a, b = a.parent, b.parent
+	}
+
+	// Redo from the common ancestor down to target.
+	for i, j := 0, len(redo)-1; i < j; i, j = i+1, j-1 {
+		redo[i], redo[j] = redo[j], redo[i]
+	}
+	return undo, redo
+}
+
+// restore swaps the pieces saved in rng with the ones in the list.
+func (pt *PieceTable) restore(rng *pieceRange, origin ChangeOrigin) CursorPos {
+	pt.recordRestore(rng, origin)
+	pt.lastAppend = nil
+	newRuneLen, newBytes := rng.Size()
+
+	// restore to the old piece range.
+	pt.pieces.Restore(rng)
+
+	lastRuneLen, lastBytes := rng.Size()
+	pt.seqLength += newRuneLen - lastRuneLen
+	pt.seqBytes += newBytes - lastBytes
+	pt.markChanged()
+	return rng.cursor
+}
+
+// undoNode reverts the change of the current node, which must not be the root.
+// It returns the cursor positions of the reverted edits.
+func (pt *PieceTable) undoNode() []CursorPos {
+	node := pt.history.current
+	cursors := make([]CursorPos, 0, len(node.ranges))
+	for i := len(node.ranges) - 1; i >= 0; i-- {
+		cursors = append(cursors, pt.restore(node.ranges[i], ChangeUndo))
+	}
+
+	node.parent.redoChild = node
+	pt.history.current = node.parent
+	return cursors
+}
+
+// redoNode applies the change of node, which must be a child of the current
+// node. It returns the cursor positions of the applied edits.
+func (pt *PieceTable) redoNode(node *historyNode) []CursorPos {
+	cursors := make([]CursorPos, 0, len(node.ranges))
+	for _, rng := range node.ranges {
+		cursors = append(cursors, pt.restore(rng, ChangeRedo))
+	}
+
+	node.parent.redoChild = node
+	pt.history.current = node
+	return cursors
+}
+
+// resetLastInsert stops appending to the last inserted piece, as it may be
+// unlinked from the list by undo or redo.
+func (pt *PieceTable) resetLastInsert() {
+	pt.lastAction = actionUnknown
+	pt.lastInsertPiece = nil
+}
+
+// dropHistory clears the undo history when the changes in it can no longer be
+// undone. A modified text stays modified until it is marked saved again.
+func (pt *PieceTable) dropHistory() {
+	modified := pt.history.current != pt.history.saved
+	pt.history = newUndoTree()
+	if modified {
+		pt.history.saved = nil
+	}
+	pt.resetLastInsert()
+}
+
+// MarkSaved marks the current state as saved, like after the text is written
+// to a file.
+func (pt *PieceTable) MarkSaved() {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	pt.history.saved = pt.history.current
+	// Continuous typing after saving is a new change.
+	pt.resetLastInsert()
+}
+
+// Modified reports whether the text differs from the state last marked saved,
+// or the original text if it is never saved. Undoing and redoing back to the
+// saved state makes it unmodified again.
+func (pt *PieceTable) Modified() bool {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+	return pt.history.current != pt.history.saved
+}
+
+// History returns all the changes in the undo history, ordered by the sequence
+// numbers, and the sequence number of the current state.
+func (pt *PieceTable) History() ([]HistoryNode, int) {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+
+	nodes := make([]HistoryNode, 0, len(pt.history.nodes)-1)
+	for _, n := range pt.history.nodes[1:] {
+		nodes = append(nodes, HistoryNode{Seq: n.seq, Parent: n.parent.seq, Time: n.time})
+	}
+	return nodes, pt.history.current.seq
+}
+
+// GotoHistory restores the state after the change of seq, or the original
+// state if seq is 0, by undoing and redoing the changes between the current
+// state and that state. It returns the cursor positions of the last undone or
+// redone edits.
+func (pt *PieceTable) GotoHistory(seq int) ([]CursorPos, bool) {
+	defer pt.notifyChanges()
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	defer pt.inspect()
+	if seq < 0 || seq >= len(pt.history.nodes) || seq == pt.history.current.seq {
+		return nil, false
+	}
+
+	pt.resetLastInsert()
+	undo, redo := pt.history.path(pt.history.nodes[seq])
+	var cursors []CursorPos
+	for range undo {
+		cursors = pt.undoNode()
+	}
+	for _, node := range redo {
+		cursors = pt.redoNode(node)
+	}
+	return cursors, true
+}
+
+// undoDepth returns the number of changes that can be undone.
+func (pt *PieceTable) undoDepth() int {
+	return pt.history.current.depth
+}
+
+// redoDepth returns the number of changes that can be redone by following the
+// last visited branches.
+func (pt *PieceTable) redoDepth() int {
+	n := 0
+	for node := pt.history.current.redoChild; node != nil; node = node.redoChild {
+		n++
+	}
+	return n
+}
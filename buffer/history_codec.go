@@ -0,0 +1,592 @@
+package buffer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The undo history is saved in a versioned binary format, which is laid out
+// as:
+//
+//	magic "GVUH" and the format version
+//	SHA-256 hash of the document
+//	the original buffer and the modify buffer
+//	pieces: source, offset, length, byteOff, byteLength, prev, next
+//	changes: parent, time and the piece ranges of first, last, boundary, cursor
+//	the redo child of every change, including the original state
+//	the current change
+//
+// Integers are varints. Pieces are referred to by their indices plus 1, so
+// that 0 is nil, and the first two pieces are the head and tail sentinels. The
+// linkage of all the pieces is saved as is, including the ones unlinked from
+// the list, as the piece ranges rely on it to undo and redo the changes.
+const (
+	historyMagic   = "GVUH"
+	historyVersion = 1
+)
+
+var (
+	// ErrHistoryMismatch is returned by LoadHistory if the undo history is
+	// saved for a document different from the current one.
+	ErrHistoryMismatch = errors.New("undo history does not match the document")
+
+	errHistoryCorrupted = errors.New("corrupted undo history")
+)
+
+// SaveHistory writes the text buffers, the piece chain and the undo history
+// to w, which can be restored by LoadHistory later, for example after the
+// document is reopened.
+func (pt *PieceTable) SaveHistory(w io.Writer) error {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+
+	hw := &historyWriter{w: bufio.NewWriter(w)}
+	hw.write([]byte(historyMagic))
+	hw.uvarint(historyVersion)
+	hash := pt.contentHash()
+	hw.write(hash[:])
+	hw.bytes(pt.originalBuf.buf)
+	hw.bytes(pt.modifyBuf.buf)
+
+	// Collect the pieces linked from the list or from the piece ranges.
+	ids := make(map[*piece]int)
+	var pieces []*piece
+	var pending []*piece
+	visit := func(p *piece) {
+		if _, ok := ids[p]; p != nil && !ok {
+			ids[p] = len(pieces)
+			pieces = append(pieces, p)
+			pending = append(pending, p)
+		}
+	}
+	visit(pt.pieces.head)
+	visit(pt.pieces.tail)
+	for _, node := range pt.history.nodes {
+		for _, rng := range node.ranges {
+			visit(rng.first)
+			visit(rng.last)
+		}
+	}
+	for len(pending) > 0 {
+		p := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		visit(p.prev)
+		visit(p.next)
+	}
+
+	ref := func(p *piece) uint64 {
+		if p == nil {
+			return 0
+		}
+		return uint64(ids[p] + 1)
+	}
+
+	hw.uvarint(uint64(len(pieces)))
+	for _, p := range pieces {
+		hw.uvarint(uint64(p.source))
+		hw.uvarint(uint64(p.offset))
+		hw.uvarint(uint64(p.length))
+		hw.uvarint(uint64(p.byteOff))
+		hw.uvarint(uint64(p.byteLength))
+		hw.uvarint(ref(p.prev))
+		hw.uvarint(ref(p.next))
+	}
+
+	hw.uvarint(uint64(len(pt.history.nodes) - 1))
+	for _, node := range pt.history.nodes[1:] {
+		hw.uvarint(uint64(node.parent.seq))
+		hw.varint(node.time.UnixNano())
+		hw.uvarint(uint64(len(node.ranges)))
+		for _, rng := range node.ranges {
+			hw.uvarint(ref(rng.first))
+			hw.uvarint(ref(rng.last))
+			hw.bool(rng.boundary)
+			hw.varint(int64(rng.cursor.Start))
+			hw.varint(int64(rng.cursor.End))
+		}
+	}
+	for _, node := range pt.history.nodes {
+		if node.redoChild == nil {
+			hw.uvarint(0)
+		} else {
+			hw.uvarint(uint64(node.redoChild.seq))
+		}
+	}
+	hw.uvarint(uint64(pt.history.current.seq))
+
+	if hw.err != nil {
+		return hw.err
+	}
+	return hw.w.Flush()
+}
+
+// LoadHistory restores the text buffers, the piece chain and the undo history
+// saved by SaveHistory. The history is accepted only if it is saved for the
+// same text as the current document, otherwise ErrHistoryMismatch is returned.
+// The piece table is left unchanged if any error is returned.
+func (pt *PieceTable) LoadHistory(r io.Reader) error {
+	hr := &historyReader{r: bufio.NewReader(r)}
+	if magic := hr.read(len(historyMagic)); hr.err == nil && string(magic) != historyMagic {
+		return errHistoryCorrupted
+	}
+	if version := hr.uvarint(); hr.err == nil && version != historyVersion {
+		return fmt.Errorf("unsupported undo history version %d", version)
+	}
+	var hash [sha256.Size]byte
+	copy(hash[:], hr.read(sha256.Size))
+	if hr.err != nil {
+		return hr.err
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if hash != pt.contentHash() {
+		return ErrHistoryMismatch
+	}
+
+	loaded := &PieceTable{
+		originalBuf: newTextBuffer(),
+		modifyBuf:   newTextBuffer(),
+		pieces:      newPieceList(),
+		history:     newUndoTree(),
+	}
+	loaded.originalBuf.set(hr.bytes())
+	loaded.modifyBuf.append(hr.bytes())
+	if hr.err != nil {
+		return hr.err
+	}
+
+	pieces, err := loaded.readPieces(hr)
+	if err != nil {
+		return err
+	}
+	if err := loaded.readChanges(hr, pieces); err != nil {
+		return err
+	}
+	if loaded.contentHash() != hash {
+		return errHistoryCorrupted
+	}
+
+	pt.originalBuf = loaded.originalBuf
+	pt.modifyBuf = loaded.modifyBuf
+	pt.pieces = loaded.pieces
+	pt.history = loaded.history
+	pt.seqLength = loaded.seqLength
+	pt.seqBytes = loaded.seqBytes
+	pt.lastAction = actionUnknown
+	pt.lastActionEndIdx = 0
+	pt.lastInsertPiece = nil
+	pt.lastAppend = nil
+	pt.currentBatch = nil
+
+	// The text is unchanged, so the markers stay at their offsets, but they
+	// have to be moved to the new pieces.
+	for _, m := range pt.markers {
+		p, inRuneOff, _ := pt.pieces.FindPiece(m.offset)
+		if p == pt.pieces.tail {
+			p = pt.pieces.Tail()
+			inRuneOff = p.length
+		}
+		m.update(p, inRuneOff)
+	}
+	pt.syncMarkerOffset(nil)
+	return nil
+}
+
+// readPieces reads the pieces and builds the piece list of them. It returns
+// all the pieces read, indexed by their references minus 1.
+func (pt *PieceTable) readPieces(hr *historyReader) ([]*piece, error) {
+	n := hr.uvarint()
+	if hr.err != nil {
+		return nil, hr.err
+	}
+	if n < 2 {
+		return nil, errHistoryCorrupted
+	}
+
+	pieces := []*piece{pt.pieces.head, pt.pieces.tail}
+	deref := func(ref uint64) (*piece, bool) {
+		if ref == 0 {
+			return nil, true
+		}
+		if ref > n {
+			return nil, false
+		}
+		// Pieces may refer to the ones not read yet.
+		for uint64(len(pieces)) < ref {
+			pieces = append(pieces, &piece{})
+		}
+		return pieces[ref-1], true
+	}
+
+	for i := uint64(0); i < n; i++ {
+		source := hr.uvarint()
+		offset, length := hr.uvarint(), hr.uvarint()
+		byteOff, byteLength := hr.uvarint(), hr.uvarint()
+		prev, ok1 := deref(hr.uvarint())
+		next, ok2 := deref(hr.uvarint())
+		if hr.err != nil {
+			return nil, hr.err
+		}
+		if !ok1 || !ok2 || source > uint64(modify) {
+			return nil, errHistoryCorrupted
+		}
+
+		p, _ := deref(i + 1)
+		p.prev, p.next = prev, next
+		if i < 2 {
+			// the sentinels.
+			continue
+		}
+
+		buf := pt.getBuf(bufSrc(source))
+		if offset > uint64(buf.length) || length > uint64(buf.length)-offset ||
+			byteOff > uint64(len(buf.buf)) || byteLength > uint64(len(buf.buf))-byteOff {
+			return nil, errHistoryCorrupted
+		}
+		// The runes and the bytes of the piece must be the same text.
+		if buf.RuneOffset(int(offset)) != int(byteOff) || buf.RuneOffset(int(offset+length)) != int(byteOff+byteLength) {
+			return nil, errHistoryCorrupted
+		}
+		p.source = bufSrc(source)
+		p.offset, p.length = int(offset), int(length)
+		p.byteOff, p.byteLength = int(byteOff), int(byteLength)
+	}
+
+	// Make sure the list is well linked before indexing it.
+	if !pt.pieces.wellLinked(len(pieces)) {
+		return nil, errHistoryCorrupted
+	}
+
+	pt.pieces.lineCounter = pt.countLineBreaks
+	pt.pieces.relink(pt.pieces.head, pt.pieces.tail, func() {})
+	pt.seqLength = pt.pieces.Runes()
+	pt.seqBytes = pt.pieces.root.bytes()
+	return pieces, nil
+}
+
+// readChanges reads the changes and rebuilds the undo tree of them.
+func (pt *PieceTable) readChanges(hr *historyReader, pieces []*piece) error {
+	n := hr.uvarint()
+	if hr.err != nil {
+		return hr.err
+	}
+
+	deref := func(ref uint64) *piece {
+		if ref == 0 || ref > uint64(len(pieces)) {
+			return nil
+		}
+		return pieces[ref-1]
+	}
+
+	tree := pt.history
+	for seq := uint64(1); seq <= n; seq++ {
+		parent := hr.uvarint()
+		ts := hr.varint()
+		count := hr.uvarint()
+		if hr.err != nil {
+			return hr.err
+		}
+		if parent >= seq || count == 0 {
+			return errHistoryCorrupted
+		}
+
+		node := &historyNode{
+			seq:    int(seq),
+			parent: tree.nodes[parent],
+			time:   time.Unix(0, ts),
+		}
+		node.depth = node.parent.depth + 1
+		for i := uint64(0); i < count; i++ {
+			first, last := deref(hr.uvarint()), deref(hr.uvarint())
+			rng := &pieceRange{
+				first:    first,
+				last:     last,
+				boundary: hr.bool(),
+				cursor:   CursorPos{Start: int(hr.varint()), End: int(hr.varint())},
+			}
+			if hr.err != nil {
+				return hr.err
+			}
+			if first == nil || last == nil {
+				return errHistoryCorrupted
+			}
+			node.ranges = append(node.ranges, rng)
+		}
+		tree.nodes = append(tree.nodes, node)
+	}
+
+	for _, node := range tree.nodes {
+		seq := hr.uvarint()
+		if hr.err != nil {
+			return hr.err
+		}
+		if seq == 0 {
+			continue
+		}
+		if seq > n || tree.nodes[seq].parent != node {
+			return errHistoryCorrupted
+		}
+		node.redoChild = tree.nodes[seq]
+	}
+
+	current := hr.uvarint()
+	if hr.err != nil {
+		return hr.err
+	}
+	if current > n {
+		return errHistoryCorrupted
+	}
+	tree.current = tree.nodes[current]
+	// The history is loaded for the current text, which is the saved one.
+	tree.saved = tree.current
+	return pt.checkHistory(len(pieces))
+}
+
+// checkHistory makes sure every change of the loaded history can be undone
+// and redone, by visiting all the changes and checking the piece ranges
+// before restoring them, so that a corrupted history cannot break the piece
+// list later. It returns to the current change at the end. n is the number of
+// pieces, which bounds the walks along the links.
+func (pt *PieceTable) checkHistory(n int) error {
+	tree := pt.history
+	children := make([][]*historyNode, len(tree.nodes))
+	for _, node := range tree.nodes[1:] {
+		children[node.parent.seq] = append(children[node.parent.seq], node)
+	}
+
+	apply := func(node *historyNode, undo bool) error {
+		for i := range node.ranges {
+			rng := node.ranges[i]
+			if undo {
+				rng = node.ranges[len(node.ranges)-1-i]
+			}
+			if !pt.pieces.canRestore(rng, n) {
+				return errHistoryCorrupted
+			}
+			pt.restore(rng, ChangeUndo)
+			if !pt.pieces.wellLinked(n) {
+				return errHistoryCorrupted
+			}
+		}
+		return nil
+	}
+
+	// Undo to the original state, then visit the tree depth first, undoing
+	// every change after its subtree.
+	for node := tree.current; node.seq != 0; node = node.parent {
+		if err := apply(node, true); err != nil {
+			return err
+		}
+	}
+	type visit struct {
+		node *historyNode
+		next int
+	}
+	stack := []visit{{node: tree.nodes[0]}}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next < len(children[top.node.seq]) {
+			child := children[top.node.seq][top.next]
+			top.next++
+			if err := apply(child, false); err != nil {
+				return err
+			}
+			stack = append(stack, visit{node: child})
+			continue
+		}
+		stack = stack[:len(stack)-1]
+		if top.node.seq != 0 {
+			if err := apply(top.node, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	var path []*historyNode
+	for node := tree.current; node.seq != 0; node = node.parent {
+		path = append(path, node)
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		if err := apply(path[i], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wellLinked reports whether the pieces from head to tail are linked in both
+// directions, walking at most n pieces.
+func (pl *pieceList) wellLinked(n int) bool {
+	steps := 0
+	for p := pl.head; p != pl.tail; p = p.next {
+		if p.next == nil || p.next.prev != p || steps > n {
+			return false
+		}
+		steps++
+	}
+	return true
+}
+
+// canRestore reports whether rng can be restored to the list: the pieces
+// enclosing it are linked in order, and the pieces it saves are linked from
+// first to last out of the list. It walks at most n pieces.
+func (pl *pieceList) canRestore(rng *pieceRange, n int) bool {
+	if rng.first == nil || rng.last == nil {
+		return false
+	}
+	if !rng.boundary && (rng.first.prev == nil || rng.last.next == nil) {
+		return false
+	}
+
+	a, b := rng.neighbors()
+	linked := make(map[*piece]bool)
+	for p := pl.head; p != nil; p = p.next {
+		linked[p] = true
+		if p == pl.tail {
+			break
+		}
+	}
+	if !linked[a] || !linked[b] || a == pl.tail || b == pl.head {
+		return false
+	}
+	// b must follow a in the list.
+	steps := 0
+	for p := a; p != b; p = p.next {
+		if p == pl.tail || steps > n {
+			return false
+		}
+		steps++
+	}
+
+	if rng.boundary {
+		return true
+	}
+	steps = 0
+	for p := rng.first; p != rng.last; p = p.next {
+		if p == nil || linked[p] || steps > n {
+			return false
+		}
+		steps++
+	}
+	return !linked[rng.last]
+}
+
+// contentHash returns the SHA-256 hash of the text sequence.
+func (pt *PieceTable) contentHash() [sha256.Size]byte {
+	h := sha256.New()
+	for n := pt.pieces.Head(); n != pt.pieces.tail; n = n.next {
+		h.Write(pt.getBuf(n.source).getTextByRange(n.byteOff, n.byteLength))
+	}
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// historyWriter writes the undo history, keeping the first error.
+type historyWriter struct {
+	w   *bufio.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (hw *historyWriter) write(p []byte) {
+	if hw.err == nil {
+		_, hw.err = hw.w.Write(p)
+	}
+}
+
+func (hw *historyWriter) uvarint(v uint64) {
+	hw.write(hw.buf[:binary.PutUvarint(hw.buf[:], v)])
+}
+
+func (hw *historyWriter) varint(v int64) {
+	hw.write(hw.buf[:binary.PutVarint(hw.buf[:], v)])
+}
+
+func (hw *historyWriter) bool(v bool) {
+	if v {
+		hw.uvarint(1)
+	} else {
+		hw.uvarint(0)
+	}
+}
+
+func (hw *historyWriter) bytes(p []byte) {
+	hw.uvarint(uint64(len(p)))
+	hw.write(p)
+}
+
+// historyReader reads the undo history, keeping the first error. Truncated
+// data is reported as errHistoryCorrupted.
+type historyReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (hr *historyReader) fail(err error) {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = errHistoryCorrupted
+	}
+	hr.err = err
+}
+
+func (hr *historyReader) read(n int) []byte {
+	if hr.err != nil {
+		return nil
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(hr.r, p); err != nil {
+		hr.fail(err)
+	}
+	return p
+}
+
+func (hr *historyReader) uvarint() uint64 {
+	if hr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(hr.r)
+	if err != nil {
+		hr.fail(err)
+	}
+	return v
+}
+
+func (hr *historyReader) varint() int64 {
+	if hr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(hr.r)
+	if err != nil {
+		hr.fail(err)
+	}
+	return v
+}
+
+func (hr *historyReader) bool() bool {
+	return hr.uvarint() != 0
+}
+
+// bytes reads a buffer prefixed by its length. The buffer grows with the data
+// read rather than the length, which may be corrupted.
+func (hr *historyReader) bytes() []byte {
+	n := hr.uvarint()
+	if hr.err != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, hr.r, int64(n)); err != nil {
+		hr.fail(err)
+		return nil
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,325 @@
+package buffer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestUndoTreeBranches(t *testing.T) {
+	pt := NewPieceTable([]byte("Hello"))
+
+	pt.Replace(5, 5, ", world") // 1
+	pt.Replace(5, 5, "!")       // 2
+	pt.Undo()
+	pt.Undo()
+	// Start a new branch from the original text.
+	pt.Replace(0, 5, "Bye") // 3
+
+	if got := readTableContent(pt); got != "Bye" {
+		t.Fatalf("want Bye, got %q", got)
+	}
+	if pt.redoDepth() != 0 {
+		t.Errorf("want nothing to redo after a new change, got %d", pt.redoDepth())
+	}
+
+	nodes, current := pt.History()
+	if len(nodes) != 3 || current != 3 {
+		t.Fatalf("want 3 changes with the current 3, got %d and %d", len(nodes), current)
+	}
+	wantParents := []int{0, 1, 0}
+	for i, n := range nodes {
+		if n.Seq != i+1 || n.Parent != wantParents[i] {
+			t.Errorf("change %d: want seq %d and parent %d, got %+v", i, i+1, wantParents[i], n)
+		}
+		if n.Time.IsZero() {
+			t.Errorf("change %d: missing time", n.Seq)
+		}
+	}
+
+	// The abandoned branch is still reachable.
+	steps := []struct {
+		seq  int
+		want string
+	}{
+		{2, "Hello!, world"},
+		{1, "Hello, world"},
+		{3, "Bye"},
+		{0, "Hello"},
+		{2, "Hello!, world"},
+	}
+	for _, s := range steps {
+		if _, ok := pt.GotoHistory(s.seq); !ok {
+			t.Fatalf("goto %d failed", s.seq)
+		}
+		if got := readTableContent(pt); got != s.want {
+			t.Errorf("goto %d: want %q, got %q", s.seq, s.want, got)
+		}
+		if pt.Len() != len([]rune(s.want)) {
+			t.Errorf("goto %d: want length %d, got %d", s.seq, len([]rune(s.want)), pt.Len())
+		}
+		if _, current := pt.History(); current != s.seq {
+			t.Errorf("goto %d: current is %d", s.seq, current)
+		}
+	}
+
+	if _, ok := pt.GotoHistory(2); ok {
+		t.Error("goto the current state should do nothing")
+	}
+	if _, ok := pt.GotoHistory(4); ok {
+		t.Error("goto an unknown change should fail")
+	}
+
+	// Redo follows the branch visited last.
+	pt.GotoHistory(0)
+	pt.Redo()
+	pt.Redo()
+	if got := readTableContent(pt); got != "Hello!, world" {
+		t.Errorf("want Hello!, world, got %q", got)
+	}
+}
+
+func TestUndoTreeBatch(t *testing.T) {
+	pt := NewPieceTable([]byte("abc"))
+
+	pt.GroupOp()
+	pt.Replace(0, 0, "1")
+	pt.Replace(4, 4, "2")
+	pt.UnGroupOp()
+	pt.Replace(0, 1, "") // 2
+
+	nodes, _ := pt.History()
+	if len(nodes) != 2 {
+		t.Fatalf("want 2 changes, got %d", len(nodes))
+	}
+
+	pt.GotoHistory(0)
+	if got := readTableContent(pt); got != "abc" {
+		t.Errorf("want abc, got %q", got)
+	}
+	cursors, _ := pt.GotoHistory(1)
+	if got := readTableContent(pt); got != "1abc2" {
+		t.Errorf("want 1abc2, got %q", got)
+	}
+	if len(cursors) != 2 {
+		t.Errorf("want the cursors of 2 edits, got %v", cursors)
+	}
+}
+
+func TestSaveLoadHistory(t *testing.T) {
+	pt := NewPieceTable([]byte("Hello"))
+	pt.Replace(5, 5, ", world") // 1
+	pt.Replace(5, 5, "!")       // 2
+	pt.Undo()
+	pt.Replace(0, 5, "Bye") // 3
+	pt.GroupOp()
+	pt.Replace(0, 0, "1")
+	pt.Replace(1, 1, "2")
+	pt.UnGroupOp() // 4
+	pt.Undo()
+
+	var buf bytes.Buffer
+	if err := pt.SaveHistory(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+
+	loaded := NewPieceTable([]byte("Bye, world"))
+	marker, _ := loaded.CreateMarker(4, BiasForward)
+	if err := loaded.LoadHistory(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTableContent(loaded); got != "Bye, world" {
+		t.Fatalf("want Bye, world, got %q", got)
+	}
+	if marker.Offset() != 4 {
+		t.Errorf("want the marker at 4, got %d", marker.Offset())
+	}
+
+	wantNodes, wantCurrent := pt.History()
+	nodes, current := loaded.History()
+	if current != wantCurrent || len(nodes) != len(wantNodes) {
+		t.Fatalf("want %d changes with the current %d, got %d and %d", len(wantNodes), wantCurrent, len(nodes), current)
+	}
+	for i := range nodes {
+		if nodes[i].Seq != wantNodes[i].Seq || nodes[i].Parent != wantNodes[i].Parent || !nodes[i].Time.Equal(wantNodes[i].Time) {
+			t.Errorf("want change %+v, got %+v", wantNodes[i], nodes[i])
+		}
+	}
+
+	if _, ok := loaded.Redo(); !ok {
+		t.Fatal("redo failed")
+	}
+	if got := readTableContent(loaded); got != "12Bye, world" {
+		t.Errorf("redo: want 12Bye, world, got %q", got)
+	}
+
+	steps := []struct {
+		seq  int
+		want string
+	}{
+		{2, "Hello!, world"},
+		{0, "Hello"},
+		{3, "Bye, world"},
+		{1, "Hello, world"},
+	}
+	for _, s := range steps {
+		if _, ok := loaded.GotoHistory(s.seq); !ok {
+			t.Fatalf("goto %d failed", s.seq)
+		}
+		if got := readTableContent(loaded); got != s.want {
+			t.Errorf("goto %d: want %q, got %q", s.seq, s.want, got)
+		}
+		if loaded.Len() != len([]rune(s.want)) || loaded.Size() != len(s.want) {
+			t.Errorf("goto %d: want size %d, got %d", s.seq, len(s.want), loaded.Size())
+		}
+	}
+
+	// New changes continue the loaded history.
+	loaded.Replace(0, 0, ">")
+	if _, current := loaded.History(); current != 5 {
+		t.Errorf("want the new change 5, got %d", current)
+	}
+	loaded.Undo()
+	if got := readTableContent(loaded); got != "Hello, world" {
+		t.Errorf("want Hello, world, got %q", got)
+	}
+}
+
+func TestLoadHistoryErrors(t *testing.T) {
+	pt := NewPieceTable([]byte("abc"))
+	pt.Replace(3, 3, "d")
+
+	var buf bytes.Buffer
+	if err := pt.SaveHistory(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+
+	other := NewPieceTable([]byte("abc"))
+	if err := other.LoadHistory(bytes.NewReader(saved)); !errors.Is(err, ErrHistoryMismatch) {
+		t.Errorf("want ErrHistoryMismatch, got %v", err)
+	}
+	if got := readTableContent(other); got != "abc" {
+		t.Errorf("want the text unchanged, got %q", got)
+	}
+	if other.undoDepth() != 0 {
+		t.Errorf("want the history unchanged, got %d changes", other.undoDepth())
+	}
+
+	same := NewPieceTable([]byte("abcd"))
+	if err := same.LoadHistory(bytes.NewReader([]byte("GVUX"))); err == nil {
+		t.Error("want an error for the bad magic")
+	}
+	badVersion := bytes.Clone(saved)
+	badVersion[len(historyMagic)] = historyVersion + 1
+	if err := same.LoadHistory(bytes.NewReader(badVersion)); err == nil {
+		t.Error("want an error for the unknown version")
+	}
+	for _, n := range []int{0, len(historyMagic) + 1, len(saved) / 2, len(saved) - 1} {
+		if err := same.LoadHistory(bytes.NewReader(saved[:n])); err == nil {
+			t.Errorf("want an error for the data truncated at %d", n)
+		}
+	}
+	if same.undoDepth() != 0 {
+		t.Errorf("want the history unchanged, got %d changes", same.undoDepth())
+	}
+
+	if err := same.LoadHistory(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	same.Undo()
+	if got := readTableContent(same); got != "abc" {
+		t.Errorf("want abc, got %q", got)
+	}
+}
+
+func TestLoadCorruptedHistory(t *testing.T) {
+	corruptions := []struct {
+		name    string
+		corrupt func(rng *pieceRange, pt *PieceTable)
+	}{
+		{"byte length", func(rng *pieceRange, pt *PieceTable) { rng.first.byteLength++ }},
+		{"rune length", func(rng *pieceRange, pt *PieceTable) { rng.first.length-- }},
+		{"link to the head", func(rng *pieceRange, pt *PieceTable) { rng.last.next = pt.pieces.head }},
+		{"cycle", func(rng *pieceRange, pt *PieceTable) { rng.first.prev = rng.last }},
+		{"linked piece", func(rng *pieceRange, pt *PieceTable) { rng.last = pt.pieces.head.next }},
+	}
+
+	for _, c := range corruptions {
+		pt := NewPieceTable([]byte("hello world"))
+		pt.Replace(0, 5, "HELLO")
+		pt.Replace(11, 11, "!")
+		rng := pt.history.nodes[1].ranges[0]
+		if rng.boundary {
+			t.Fatal("want the replaced pieces saved")
+		}
+		c.corrupt(rng, pt)
+
+		var buf bytes.Buffer
+		if err := pt.SaveHistory(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		same := NewPieceTable([]byte("HELLO world!"))
+		if err := same.LoadHistory(&buf); err == nil {
+			t.Errorf("%s: want an error", c.name)
+		}
+		if got := readTableContent(same); got != "HELLO world!" {
+			t.Errorf("%s: want the text unchanged, got %q", c.name, got)
+		}
+		if same.undoDepth() != 0 {
+			t.Errorf("%s: want the history unchanged, got %d changes", c.name, same.undoDepth())
+		}
+	}
+}
+
+func TestModified(t *testing.T) {
+	pt := NewPieceTable([]byte("Hello"))
+	if pt.Modified() {
+		t.Fatal("want the original text unmodified")
+	}
+
+	pt.Replace(5, 5, ",")
+	pt.Replace(6, 6, " ")
+	if !pt.Modified() {
+		t.Fatal("want modified after editing")
+	}
+	pt.MarkSaved()
+	if pt.Modified() {
+		t.Fatal("want unmodified after saving")
+	}
+
+	// Continuous typing after saving must not extend the saved change.
+	pt.Replace(7, 7, "w")
+	if !pt.Modified() {
+		t.Fatal("want modified after typing")
+	}
+	pt.Undo()
+	if pt.Modified() || readTableContent(pt) != "Hello, " {
+		t.Fatalf("want unmodified after undoing back to the saved text, got %q", readTableContent(pt))
+	}
+	pt.Undo()
+	if !pt.Modified() {
+		t.Fatal("want modified after undoing the saved change")
+	}
+	pt.Redo()
+	if pt.Modified() {
+		t.Fatal("want unmodified after redoing to the saved text")
+	}
+
+	// A batch started before saving is not extended either.
+	pt.GroupOp()
+	pt.Replace(7, 7, "w")
+	pt.MarkSaved()
+	pt.Replace(8, 8, "o")
+	pt.UnGroupOp()
+	if !pt.Modified() {
+		t.Fatal("want modified after editing in a saved batch")
+	}
+
+	pt.SetText([]byte("Bye"))
+	if pt.Modified() {
+		t.Fatal("want unmodified after setting the text")
+	}
+}
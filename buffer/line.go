@@ -26,7 +26,9 @@ type lineOpStack struct {
 	ops []*lineOp
 }
 
-// Deprecated: We don't need to calculate precise line range anymore.
+// Deprecated: The line breaks are indexed by the piece tree, which is updated
+// along with the pieces. Use the LineStart, LineOf and LineLength methods of
+// PieceTable instead.
 //
 // lineIndex manages a line index for the text sequence using a hybrid strategy:
 //  1. update the index when insert or erase occurs in an incremental manner.
@@ -0,0 +1,119 @@
+package buffer
+
+import (
+	"strings"
+)
+
+// LineEnding is the style of the line breaks of a text.
+type LineEnding uint8
+
+const (
+	// LF is the line feed "\n", used by Unix-like systems.
+	LF LineEnding = iota
+	// CRLF is the carriage return and line feed "\r\n", used by Windows.
+	CRLF
+	// CR is the carriage return "\r", used by classic Mac OS.
+	CR
+)
+
+func (le LineEnding) String() string {
+	switch le {
+	case CRLF:
+		return "CRLF"
+	case CR:
+		return "CR"
+	default:
+		return "LF"
+	}
+}
+
+// Sequence returns the line break of the style.
+func (le LineEnding) Sequence() string {
+	switch le {
+	case CRLF:
+		return "\r\n"
+	case CR:
+		return "\r"
+	default:
+		return "\n"
+	}
+}
+
+// Convert converts the line breaks of text, which must be normalized to LF,
+// to the style.
+func (le LineEnding) Convert(text string) string {
+	if le == LF {
+		return text
+	}
+	return strings.ReplaceAll(text, "\n", le.Sequence())
+}
+
+// ParseLineEnding parses the name of a line ending style, which is one of
+// "LF", "CRLF" and "CR", case-insensitively.
+func ParseLineEnding(name string) (LineEnding, bool) {
+	for _, le := range []LineEnding{LF, CRLF, CR} {
+		if strings.EqualFold(name, le.String()) {
+			return le, true
+		}
+	}
+	return LF, false
+}
+
+// LineEndingCounts counts the line breaks of a text by their styles, indexed
+// by LineEnding.
+type LineEndingCounts [3]int
+
+// CountLineEndings counts the line breaks of text. A "\r\n" is counted as a
+// single line break.
+func CountLineEndings(text string) LineEndingCounts {
+	var counts LineEndingCounts
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			counts[LF]++
+		case '\r':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				counts[CRLF]++
+				i++
+			} else {
+				counts[CR]++
+			}
+		}
+	}
+	return counts
+}
+
+// Total returns the number of all the line breaks.
+func (c LineEndingCounts) Total() int {
+	return c[LF] + c[CRLF] + c[CR]
+}
+
+// Dominant returns the style of the most line breaks, preferring LF and then
+// CRLF if there is a tie. Text without line breaks is considered LF.
+func (c LineEndingCounts) Dominant() LineEnding {
+	dominant := LF
+	for _, le := range []LineEnding{CRLF, CR} {
+		if c[le] > c[dominant] {
+			dominant = le
+		}
+	}
+	return dominant
+}
+
+// DetectLineEnding returns the dominant line ending style of text, and the
+// number of line breaks of the other styles.
+func DetectLineEnding(text string) (dominant LineEnding, mixed int) {
+	counts := CountLineEndings(text)
+	dominant = counts.Dominant()
+	return dominant, counts.Total() - counts[dominant]
+}
+
+// NormalizeLineEndings converts all the "\r\n" and "\r" line breaks of text to
+// "\n".
+func NormalizeLineEndings(text string) string {
+	if !strings.Contains(text, "\r") {
+		return text
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\r", "\n")
+}
@@ -0,0 +1,51 @@
+package buffer
+
+import (
+	"testing"
+)
+
+func TestDetectLineEnding(t *testing.T) {
+	cases := []struct {
+		text     string
+		dominant LineEnding
+		mixed    int
+	}{
+		{"", LF, 0},
+		{"no breaks", LF, 0},
+		{"a\nb\n", LF, 0},
+		{"a\r\nb\r\nc", CRLF, 0},
+		{"a\rb\r", CR, 0},
+		{"a\r\nb\r\nc\nd", CRLF, 1},
+		{"a\nb\r\n", LF, 1},
+		{"a\r\r\nb\n\r", CR, 2},
+	}
+
+	for _, c := range cases {
+		dominant, mixed := DetectLineEnding(c.text)
+		if dominant != c.dominant || mixed != c.mixed {
+			t.Errorf("%q: want %v and %d mixed, got %v and %d", c.text, c.dominant, c.mixed, dominant, mixed)
+		}
+	}
+}
+
+func TestNormalizeLineEndings(t *testing.T) {
+	text := NormalizeLineEndings("a\r\nb\rc\n\r\n")
+	if text != "a\nb\nc\n\n" {
+		t.Errorf("want all line breaks normalized, got %q", text)
+	}
+	if got := CRLF.Convert(text); got != "a\r\nb\r\nc\r\n\r\n" {
+		t.Errorf("want CRLF, got %q", got)
+	}
+	if got := CR.Convert(text); got != "a\rb\rc\r\r" {
+		t.Errorf("want CR, got %q", got)
+	}
+
+	for _, le := range []LineEnding{LF, CRLF, CR} {
+		if parsed, ok := ParseLineEnding(le.String()); !ok || parsed != le {
+			t.Errorf("parse %v: got %v", le, parsed)
+		}
+	}
+	if _, ok := ParseLineEnding("crlf"); !ok {
+		t.Error("want names parsed case-insensitively")
+	}
+}
@@ -47,6 +47,23 @@ func (m *Marker) Offset() int {
 	return m.offset
 }
 
+// NewMarker creates a marker at runeOff, which is not tracked by a piece table.
+// It is used by the other TextSource implementations, which move the markers
+// they create with SetOffset as the text changes.
+func NewMarker(runeOff int, bias MarkerBias) *Marker {
+	return &Marker{offset: runeOff, bias: bias}
+}
+
+// SetOffset moves a marker created by NewMarker to runeOff.
+func (m *Marker) SetOffset(runeOff int) {
+	m.offset = runeOff
+}
+
+// Bias returns the bias of the marker.
+func (m *Marker) Bias() MarkerBias {
+	return m.bias
+}
+
 func newMarker(p *piece, pieceOffset int, bais MarkerBias) *Marker {
 	return &Marker{
 		piece:       p,
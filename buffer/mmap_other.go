@@ -0,0 +1,17 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package buffer
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile is not supported on the platform, so the files are read on demand.
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -1,10 +1,12 @@
 package buffer
 
 // piece is a single piece of text in the piece table.
-// We use doubly linked list to represent a piece table here.
+// We use doubly linked list to represent a piece table here, and index
+// the pieces with a balanced tree. See piecetree.go for the details.
 type piece struct {
 	next *piece
 	prev *piece
+	pieceNode
 
 	// offset is the rune offset in the buffer.
 	offset int
@@ -31,6 +33,10 @@ type pieceCache struct {
 // Use sentinel nodes to be used as head and tail, as pointed out in https://www.catch22.net/tuts/neatpad/piece-chains/.
 type pieceList struct {
 	head, tail *piece
+	// root of the tree indexing the pieces between head and tail.
+	root *piece
+	// lineCounter counts the line breaks of pieces added to the list.
+	lineCounter lineCounter
 	// piece cache for rapid offset query.
 	cache pieceCache
 	// rev tracks the revision of the overall piece list. Everytime some pieces in the list
@@ -82,17 +88,21 @@ func (pl *pieceList) Tail() *piece {
 }
 
 func (pl *pieceList) InsertBefore(existing *piece, newPiece *piece) {
-	newPiece.next = existing
-	newPiece.prev = existing.prev
-	existing.prev.next = newPiece
-	existing.prev = newPiece
+	pl.relink(existing.prev, existing, func() {
+		newPiece.next = existing
+		newPiece.prev = existing.prev
+		existing.prev.next = newPiece
+		existing.prev = newPiece
+	})
 }
 
 func (pl *pieceList) InsertAfter(existing *piece, newPiece *piece) {
-	newPiece.prev = existing
-	newPiece.next = existing.next
-	existing.next.prev = newPiece
-	existing.next = newPiece
+	pl.relink(existing, existing.next, func() {
+		newPiece.prev = existing
+		newPiece.next = existing.next
+		existing.next.prev = newPiece
+		existing.next = newPiece
+	})
 }
 
 func (pl *pieceList) Append(newPiece *piece) {
@@ -115,26 +125,16 @@ func (pl *pieceList) FindPiece(runeIndex int) (p *piece, offset int, bytesOff in
 		}
 	}
 
-	// Fallback to scan if the cache is invalid or missed.
-	pieceOff := 0
-	for n := pl.head.next; n != nil; n = n.next {
-		nextPos := pieceOff + n.length
-		if runeIndex < nextPos {
-			// update cache
-			pl.cache.lastPiece = n
-			pl.cache.startRunes = pieceOff
-			pl.cache.startBytes = bytesOff
-			pl.cache.rev = pl.rev
-
-			return n, runeIndex - pieceOff, bytesOff
-		}
-
-		pieceOff = nextPos
-		bytesOff += n.byteLength
+	// Fallback to search the tree if the cache is invalid or missed.
+	n, offset, pos := pl.seek(runeIndex)
+	if n != pl.tail {
+		pl.cache.lastPiece = n
+		pl.cache.startRunes = pos.runes
+		pl.cache.startBytes = pos.bytes
+		pl.cache.rev = pl.rev
 	}
 
-	// reached tail sentinel
-	return pl.tail, 0, bytesOff
+	return n, offset, pos.bytes
 }
 
 // FindPieceByBytes finds a piece by a byteIndex in the sequence/document, returning
@@ -151,26 +151,16 @@ func (pl *pieceList) FindPieceByBytes(byteIndex int) (*piece, int) {
 		}
 	}
 
-	// Fallback  to scan if the cache is invalid or missed.
-	bytesOff := 0
-	runesOff := 0
-	for n := pl.head.next; n != nil; n = n.next {
-		nextPos := bytesOff + n.byteLength
-		if byteIndex < nextPos {
-			// update cache
-			pl.cache.lastPiece = n
-			pl.cache.startRunes = runesOff
-			pl.cache.startBytes = bytesOff
-			pl.cache.rev = pl.rev
-
-			return n, byteIndex - bytesOff
-		}
-
-		bytesOff = nextPos
-		runesOff += n.length
+	// Fallback to search the tree if the cache is invalid or missed.
+	n, offset, pos := pl.seekBytes(byteIndex)
+	if n != pl.tail {
+		pl.cache.lastPiece = n
+		pl.cache.startRunes = pos.runes
+		pl.cache.startBytes = pos.bytes
+		pl.cache.rev = pl.rev
 	}
 
-	return pl.tail, 0
+	return n, offset
 }
 
 func (pl *pieceList) invalidateCache() {
@@ -183,8 +173,29 @@ func (pl *pieceList) Remove(piece *piece) {
 		return
 	}
 
-	piece.prev.next = piece.next
-	piece.next.prev = piece.prev
+	pl.relink(piece.prev, piece.next, func() {
+		piece.prev.next = piece.next
+		piece.next.prev = piece.prev
+	})
+}
+
+// Swap links the pieces of dest into the list to replace the pieces of rng.
+// See pieceRange.Swap.
+func (pl *pieceList) Swap(rng *pieceRange, dest *pieceRange) {
+	a, b := rng.neighbors()
+	pl.relink(a, b, func() { rng.Swap(dest) })
+}
+
+// Restore links the pieces saved in rng back to the list. See
+// pieceRange.Restore.
+func (pl *pieceList) Restore(rng *pieceRange) {
+	a, b := rng.neighbors()
+	pl.relink(a, b, rng.Restore)
+}
+
+// Runes returns the total runes of the pieces in the chain.
+func (pl *pieceList) Runes() int {
+	return pl.root.runes()
 }
 
 // Length returns total pieces of the chain
@@ -205,6 +216,15 @@ func (p *pieceRange) AsBoundary(target *piece) {
 	p.boundary = true
 }
 
+// neighbors returns the two pieces in the list enclosing the range, which are
+// not changed when the range is swapped or restored.
+func (p *pieceRange) neighbors() (*piece, *piece) {
+	if p.boundary {
+		return p.first, p.last
+	}
+	return p.first.prev, p.last.next
+}
+
 func (p *pieceRange) Append(piece *piece) {
 	if piece == nil {
 		return
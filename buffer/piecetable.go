@@ -1,12 +1,15 @@
 package buffer
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 	"unicode/utf8"
+	"unsafe"
 )
 
 type bufSrc uint8
@@ -43,9 +46,8 @@ type PieceTable struct {
 	// bytes size of the text sequence.
 	seqBytes int
 
-	// undo stack and redo stack
-	undoStack *pieceRangeStack
-	redoStack *pieceRangeStack
+	// history is the undo tree.
+	history *undoTree
 	// piece list
 	pieces *pieceList
 
@@ -54,16 +56,23 @@ type PieceTable struct {
 	lastActionEndIdx int
 	// last inserted piece, for insertion optimization purpose.
 	lastInsertPiece *piece
+	// lastAppend is the piece of the text last appended by Append, which is
+	// extended by the next text appended unless the text is edited since.
+	lastAppend *piece
 	// changed tracks whether the sequence content has changed since the last call to Changed.
 	changed bool
+	// version increases with every change of the sequence content.
+	version int
 	// setting a batchId to group
 	currentBatch *int
 	mu           sync.RWMutex
 
-	// Index of the slice saves the continuous line number starting from zero.
-	// The value contains the rune length of the line.
-	lines   []lineInfo
 	markers []*Marker
+
+	// listeners are subscribed to the changes, which are recorded while
+	// holding the lock, and notified after it is released.
+	listeners []*changeListener
+	changes   []TextChange
 }
 
 func NewPieceTable(text []byte) *PieceTable {
@@ -71,8 +80,7 @@ func NewPieceTable(text []byte) *PieceTable {
 		originalBuf: newTextBuffer(),
 		modifyBuf:   newTextBuffer(),
 		pieces:      newPieceList(),
-		undoStack:   &pieceRangeStack{},
-		redoStack:   &pieceRangeStack{},
+		history:     newUndoTree(),
 	}
 	pt.init(text)
 
@@ -80,28 +88,68 @@ func NewPieceTable(text []byte) *PieceTable {
 }
 
 func (pt *PieceTable) SetText(text []byte) {
+	defer pt.notifyChanges()
 	pt.mu.Lock()
 	defer pt.mu.Unlock()
 
+	if pt.seqLength > 0 || len(text) > 0 {
+		// text is kept by the original buffer, which is never changed, so the
+		// change shares it instead of copying a possibly huge text.
+		pt.recordChange(0, pt.seqLength, unsafe.String(unsafe.SliceData(text), len(text)), ChangeEdit)
+	}
+
 	pt.originalBuf = newTextBuffer()
 	pt.modifyBuf = newTextBuffer()
 	pt.pieces = newPieceList()
-	pt.undoStack.clear()
-	pt.redoStack.clear()
+	pt.history = newUndoTree()
 	pt.seqBytes = 0
 	pt.seqLength = 0
 	pt.lastAction = actionUnknown
 	pt.lastActionEndIdx = 0
 	pt.lastInsertPiece = nil
+	pt.lastAppend = nil
 	pt.changed = false
+	pt.version++
 	pt.currentBatch = nil
 	pt.markers = pt.markers[:0]
 	pt.init(text)
 }
 
+// ReadFrom resets the piece table with the text read from r until EOF, like
+// SetText. The text is read into the original buffer directly. If r has a
+// Size method, like [bytes.Reader], the buffer is allocated at that size
+// first, so that the text is never copied. Otherwise the buffer grows as the
+// text is read. It implements [io.ReaderFrom].
+func (pt *PieceTable) ReadFrom(r io.Reader) (int64, error) {
+	size := 0
+	if sr, ok := r.(interface{ Size() int64 }); ok {
+		size = int(max(sr.Size(), 0))
+	}
+
+	// Leave room to read EOF without growing the buffer.
+	buf := make([]byte, 0, size+bytes.MinRead)
+	for {
+		if cap(buf)-len(buf) < bytes.MinRead {
+			buf = slices.Grow(buf, bytes.MinRead)
+		}
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return int64(len(buf)), err
+		}
+	}
+
+	pt.SetText(buf)
+	return int64(len(buf)), nil
+}
+
 // Initialize the piece table with the text by adding the text to the original buffer,
 // and create the first piece point to the buffer.
 func (pt *PieceTable) init(text []byte) {
+	pt.pieces.lineCounter = pt.countLineBreaks
 	_, _, runeCnt := pt.addToBuffer(original, text)
 	if runeCnt <= 0 {
 		return
@@ -140,6 +188,17 @@ func (pt *PieceTable) getBuf(source bufSrc) *textBuffer {
 	return pt.modifyBuf
 }
 
+// countLineBreaks returns the number of line breaks in the piece.
+func (pt *PieceTable) countLineBreaks(p *piece) int {
+	return pt.getBuf(p.source).lineBreaksInRange(p.offset, p.length)
+}
+
+// markChanged marks the sequence content changed.
+func (pt *PieceTable) markChanged() {
+	pt.changed = true
+	pt.version++
+}
+
 func (pt *PieceTable) recordAction(action action, runeIndex int) {
 	if pt.lastAction != 0 && pt.lastAction != action {
 		pt.lastInsertPiece = nil
@@ -153,10 +212,10 @@ func (pt *PieceTable) push2UndoStack(rng, newRng *pieceRange) {
 		rng.batchId = pt.currentBatch
 	}
 
-	pt.undoStack.push(rng)
+	pt.history.record(rng)
+	pt.lastAppend = nil
 	// swap link the new piece into the sequence
-	rng.Swap(newRng)
-	pt.pieces.invalidateCache()
+	pt.pieces.Swap(rng, newRng)
 }
 
 // insert insert text at the logical position specifed by runeIndex. runeIndex is measured by rune.
@@ -168,11 +227,9 @@ func (pt *PieceTable) insert(runeIndex int, text string) bool {
 		return false
 	}
 
-	pt.redoStack.clear()
-
 	// special-case: inserting at the end of a prior insertion at a piece boundary.
 	if pt.tryAppendToLastPiece(runeIndex, text) {
-		pt.changed = true
+		pt.markChanged()
 		return true
 	}
 
@@ -184,7 +241,7 @@ func (pt *PieceTable) insert(runeIndex int, text string) bool {
 		pt.insertInMiddle(runeIndex, text, oldPiece, inRuneOff)
 	}
 
-	pt.changed = true
+	pt.markChanged()
 	return true
 }
 
@@ -205,6 +262,8 @@ func (pt *PieceTable) tryAppendToLastPiece(runeIndex int, text string) bool {
 
 	pt.lastInsertPiece.length += textRunes
 	pt.lastInsertPiece.byteLength += len(text)
+	pt.pieces.resizePiece(pt.lastInsertPiece)
+	pt.history.current.time = time.Now()
 
 	pt.seqLength += textRunes
 	pt.seqBytes += len(text)
@@ -296,51 +355,6 @@ func (pt *PieceTable) insertInMiddle(runeIndex int, text string, oldPiece *piece
 	pt.recordAction(actionInsert, runeIndex+textRunes)
 }
 
-// undoRedo restores operation saved in src to dest. If there is a valid batchId, the src stack
-// is searched for continuous batched operations to restore one by one.
-// It returns all cursor postion(start and end rune offset) after restoration for all the operation.
-func (pt *PieceTable) undoRedo(src *pieceRangeStack, dest *pieceRangeStack) ([]CursorPos, bool) {
-	if src.depth() <= 0 {
-		return nil, false
-	}
-
-	restoreFunc := func(rng *pieceRange) CursorPos {
-		newRuneLen, newBytes := rng.Size()
-
-		// restore to the old piece range.
-		rng.Restore()
-		// add the restored range onto the destination stack
-		dest.push(rng)
-
-		lastRuneLen, lastBytes := rng.Size()
-		pt.seqLength += newRuneLen - lastRuneLen
-		pt.seqBytes += newBytes - lastBytes
-		pt.changed = true
-		pt.pieces.invalidateCache()
-		return rng.cursor
-	}
-
-	cursors := make([]CursorPos, 0)
-	// remove the next event from the source stack
-	rng := src.peek()
-	batchId := rng.batchId
-	if batchId == nil {
-		src.pop()
-		cursors = append(cursors, restoreFunc(rng))
-		return cursors, true
-	}
-
-	for batchId != nil && rng != nil && batchId == rng.batchId {
-		src.pop()
-		cursors = append(cursors, restoreFunc(rng))
-
-		// Try the next.
-		rng = src.peek()
-	}
-
-	return cursors, true
-}
-
 func (pt *PieceTable) erase(startOff, endOff int) bool {
 	cursor := CursorPos{Start: startOff, End: endOff}
 
@@ -356,9 +370,8 @@ func (pt *PieceTable) erase(startOff, endOff int) bool {
 		return false
 	}
 
-	pt.redoStack.clear()
 	defer func() {
-		pt.changed = true
+		pt.markChanged()
 		pt.recordAction(actionErase, startOff)
 	}()
 
@@ -473,6 +486,7 @@ func (pt *PieceTable) erase(startOff, endOff int) bool {
 
 // Replace removes text from startOff to endOff(exclusive), and insert text at the position of startOff.
 func (pt *PieceTable) Replace(startOff, endOff int, text string) bool {
+	defer pt.notifyChanges()
 	pt.mu.Lock()
 	defer pt.mu.Unlock()
 
@@ -485,16 +499,25 @@ func (pt *PieceTable) Replace(startOff, endOff int, text string) bool {
 	defer pt.syncMarkerOffset(nil)
 
 	if startOff == endOff && text != "" {
+		if startOff >= 0 && startOff <= pt.seqLength {
+			pt.recordChange(startOff, startOff, text, ChangeEdit)
+		}
 		return pt.insert(startOff, text)
 	}
 
 	if text == "" {
+		if start, end := min(startOff, endOff), max(startOff, endOff); start >= 0 && start < end {
+			pt.recordChange(start, end, "", ChangeEdit)
+		}
 		return pt.erase(startOff, endOff)
 	}
 
 	pt.groupOp()
 	defer pt.unGroupOp()
 
+	if startOff >= 0 && startOff < endOff {
+		pt.recordChange(startOff, endOff, text, ChangeEdit)
+	}
 	if !pt.erase(startOff, endOff) {
 		return false
 	}
@@ -502,20 +525,37 @@ func (pt *PieceTable) Replace(startOff, endOff int, text string) bool {
 	return pt.insert(startOff, text)
 }
 
+// Undo reverts the current change, which is the last edit, or a group of
+// edits. It returns the cursor positions of the reverted edits.
 func (pt *PieceTable) Undo() ([]CursorPos, bool) {
+	defer pt.notifyChanges()
 	pt.mu.Lock()
 	defer pt.mu.Unlock()
 
 	defer pt.inspect()
-	return pt.undoRedo(pt.undoStack, pt.redoStack)
+	if pt.history.current.parent == nil {
+		return nil, false
+	}
+
+	pt.resetLastInsert()
+	return pt.undoNode(), true
 }
 
+// Redo applies the change last undone from the current state. It returns
+// the cursor positions of the applied edits.
 func (pt *PieceTable) Redo() ([]CursorPos, bool) {
+	defer pt.notifyChanges()
 	pt.mu.Lock()
 	defer pt.mu.Unlock()
 
 	defer pt.inspect()
-	return pt.undoRedo(pt.redoStack, pt.undoStack)
+	node := pt.history.current.redoChild
+	if node == nil {
+		return nil, false
+	}
+
+	pt.resetLastInsert()
+	return pt.redoNode(node), true
 }
 
 // Group operations such as insert, earase or replace in a batch.
@@ -566,6 +606,13 @@ func (pt *PieceTable) Size() int {
 	return pt.seqBytes
 }
 
+// Version returns the version of the text, which increases with every change.
+func (pt *PieceTable) Version() int {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+	return pt.version
+}
+
 func (pt *PieceTable) Changed() bool {
 	pt.mu.Lock()
 	defer pt.mu.Unlock()
@@ -575,6 +622,23 @@ func (pt *PieceTable) Changed() bool {
 	return c
 }
 
+// WriteTo writes the text to w piece by piece, without copying it. It
+// implements [io.WriterTo].
+func (pt *PieceTable) WriteTo(w io.Writer) (int64, error) {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+
+	var total int64
+	for n := pt.pieces.Head(); n != pt.pieces.tail; n = n.next {
+		written, err := w.Write(pt.getBuf(n.source).getTextByRange(n.byteOff, n.byteLength))
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
 // ReadAt implements [io.ReaderAt]
 func (pt *PieceTable) ReadAt(p []byte, offset int64) (total int, err error) {
 	pt.mu.RLock()
@@ -776,24 +840,22 @@ func (pt *PieceTable) updateMarkersOnErase(oldPieces *pieceRange, newPieces *pie
 	}
 }
 
-// getMarkerOffset returns the rune offset of the marker in the document.
+// syncMarkerOffset updates the rune offset in the document of the marker, or
+// all the markers if marker is nil.
 func (pt *PieceTable) syncMarkerOffset(marker *Marker) {
-	absOff := 0
-
-	for n := pt.pieces.Head(); n != pt.pieces.tail; n = n.next {
-		if marker == nil {
-			for _, m := range pt.markers {
-				if m.piece == n {
-					m.offset = absOff + m.pieceOffset
-				}
-			}
-		} else {
-			if marker.piece == n {
-				marker.offset = absOff + marker.pieceOffset
-			}
+	sync := func(m *Marker) {
+		if pos, ok := pt.pieces.position(m.piece); ok {
+			m.offset = pos.runes + m.pieceOffset
 		}
+	}
+
+	if marker != nil {
+		sync(marker)
+		return
+	}
 
-		absOff += n.length
+	for _, m := range pt.markers {
+		sync(m)
 	}
 }
 
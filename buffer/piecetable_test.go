@@ -1,8 +1,12 @@
 package buffer
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"unicode/utf8"
 )
 
 func readTableContent(pt *PieceTable) string {
@@ -64,11 +68,11 @@ func TestUndo(t *testing.T) {
 	pt.Replace(0, 0, "Hello, ")
 	pt.Replace(7, 7, "world")
 
-	if pt.undoStack.depth() != 2 {
+	if pt.undoDepth() != 2 {
 		t.Fail()
 	}
 
-	if pt.redoStack.depth() != 0 {
+	if pt.redoDepth() != 0 {
 		t.Fail()
 	}
 
@@ -81,11 +85,11 @@ func TestUndo(t *testing.T) {
 	}
 
 	pt.Undo()
-	if pt.undoStack.depth() != 1 {
+	if pt.undoDepth() != 1 {
 		t.Fail()
 	}
 
-	if pt.redoStack.depth() != 1 {
+	if pt.redoDepth() != 1 {
 		t.Fail()
 	}
 
@@ -104,10 +108,10 @@ func TestUndo(t *testing.T) {
 
 	pt.Undo()
 
-	if pt.undoStack.depth() != 0 {
+	if pt.undoDepth() != 0 {
 		t.Fail()
 	}
-	if pt.redoStack.depth() != 2 {
+	if pt.redoDepth() != 2 {
 		t.Fail()
 	}
 
@@ -123,33 +127,33 @@ func TestUndoRedo(t *testing.T) {
 
 	pt.Replace(0, 0, "Hello")
 
-	if pt.undoStack.depth() != 1 {
+	if pt.undoDepth() != 1 {
 		t.Fail()
 	}
 
-	//runeLen, bytes :=  pt.undoStack.ranges[0].Length()
+	//runeLen, bytes :=  pt.history.current.ranges[0].Length()
 
 	//t.Logf("undostack range length: %d, %d", runeLen, bytes)
 
-	if pt.redoStack.depth() != 0 {
+	if pt.redoDepth() != 0 {
 		t.Fail()
 	}
 
 	pt.Undo()
-	if pt.undoStack.depth() != 0 {
+	if pt.undoDepth() != 0 {
 		t.Fail()
 	}
 
-	if pt.redoStack.depth() != 1 {
+	if pt.redoDepth() != 1 {
 		t.Fail()
 	}
 
 	pt.Redo()
-	if pt.undoStack.depth() != 1 {
+	if pt.undoDepth() != 1 {
 		t.Fail()
 	}
 
-	if pt.redoStack.depth() != 0 {
+	if pt.redoDepth() != 0 {
 		t.Fail()
 	}
 
@@ -157,7 +161,7 @@ func TestUndoRedo(t *testing.T) {
 	pt.Replace(5, 5, "world")
 	pt.Undo()
 	pt.Replace(5, 5, "Golang")
-	if pt.redoStack.depth() > 0 {
+	if pt.redoDepth() > 0 {
 		t.Fail()
 	}
 
@@ -436,3 +440,49 @@ func TestMarkerOnErase(t *testing.T) {
 		})
 	}
 }
+
+func TestReadFromWriteTo(t *testing.T) {
+	pt := NewPieceTable([]byte("old"))
+	pt.Replace(0, 0, "x")
+
+	text := strings.Repeat("Hello, 世界\n", 1000)
+	n, err := pt.ReadFrom(strings.NewReader(text))
+	if err != nil || n != int64(len(text)) {
+		t.Fatalf("ReadFrom: want %d bytes, got %d, %v", len(text), n, err)
+	}
+	if got := readTableContent(pt); got != text {
+		t.Fatal("ReadFrom: text mismatch")
+	}
+	if pt.Lines() != 1000 || pt.redoDepth() != 0 {
+		t.Errorf("ReadFrom: want 1000 lines and a new history, got %d lines", pt.Lines())
+	}
+	if _, ok := pt.Undo(); ok {
+		t.Error("ReadFrom: want nothing to undo")
+	}
+	if pt.Size() != len(text) || pt.Len() != utf8.RuneCountInString(text) {
+		t.Errorf("ReadFrom: want %d bytes and %d runes, got %d and %d",
+			len(text), utf8.RuneCountInString(text), pt.Size(), pt.Len())
+	}
+	// The size of the reader is unknown.
+	other := NewTextSource()
+	n, err = other.ReadFrom(iotest.HalfReader(strings.NewReader(text)))
+	if err != nil || n != int64(len(text)) || readTableContent(other) != text {
+		t.Fatalf("ReadFrom: want %d bytes, got %d, %v", len(text), n, err)
+	}
+	if other.Size() != len(text) || other.Len() != pt.Len() {
+		t.Errorf("ReadFrom: want %d bytes and %d runes, got %d and %d", len(text), pt.Len(), other.Size(), other.Len())
+	}
+
+	pt.Replace(0, 5, "Bye")
+	pt.Replace(pt.Len(), pt.Len(), "end")
+	want := "Bye" + text[5:] + "end"
+
+	var buf bytes.Buffer
+	n, err = pt.WriteTo(&buf)
+	if err != nil || n != int64(len(want)) {
+		t.Fatalf("WriteTo: want %d bytes, got %d, %v", len(want), n, err)
+	}
+	if buf.String() != want {
+		t.Error("WriteTo: text mismatch")
+	}
+}
@@ -0,0 +1,314 @@
+package buffer
+
+import (
+	"math/rand/v2"
+)
+
+// The pieces linked in the piece list are also indexed by a treap, a randomized
+// balanced binary tree, ordered by their positions in the list. Every node of
+// the tree saves the sizes of its subtree, including the rune, byte and line
+// break counts, so locating a piece by an offset or a line takes O(log n) time
+// rather than scanning the list from the head.
+//
+// The linked list is still the source of truth of the piece order, as the undo
+// and redo stack relies on the linkage of the pieceRange. After the pieces are
+// linked or unlinked, the affected part of the tree is rebuilt from the list.
+
+// pieceNode holds the tree fields of a piece.
+type pieceNode struct {
+	left, right, parent *piece
+	priority            uint32
+	// lineBreaks is the number of line breaks of the piece itself.
+	lineBreaks int
+	// sizes of the subtree rooted at this piece.
+	treePieces int
+	treeRunes  int
+	treeBytes  int
+	treeLines  int
+}
+
+// piecePos is the position of a piece in the document.
+type piecePos struct {
+	// runes before the piece.
+	runes int
+	// bytes before the piece.
+	bytes int
+	// line breaks before the piece.
+	lines int
+}
+
+// lineCounter counts the line breaks of a piece.
+type lineCounter func(p *piece) int
+
+func (n *piece) pieces() int {
+	if n == nil {
+		return 0
+	}
+	return n.treePieces
+}
+
+func (n *piece) runes() int {
+	if n == nil {
+		return 0
+	}
+	return n.treeRunes
+}
+
+func (n *piece) bytes() int {
+	if n == nil {
+		return 0
+	}
+	return n.treeBytes
+}
+
+func (n *piece) lines() int {
+	if n == nil {
+		return 0
+	}
+	return n.treeLines
+}
+
+// resize recalculates the subtree sizes from the children.
+func (n *piece) resize() {
+	n.treePieces = n.left.pieces() + 1 + n.right.pieces()
+	n.treeRunes = n.left.runes() + n.length + n.right.runes()
+	n.treeBytes = n.left.bytes() + n.byteLength + n.right.bytes()
+	n.treeLines = n.left.lines() + n.lineBreaks + n.right.lines()
+}
+
+func (n *piece) setLeft(child *piece) {
+	n.left = child
+	if child != nil {
+		child.parent = n
+	}
+}
+
+func (n *piece) setRight(child *piece) {
+	n.right = child
+	if child != nil {
+		child.parent = n
+	}
+}
+
+// splitTree splits the tree into two trees, the left one contains the first k
+// pieces, and the right one contains the rest.
+func splitTree(t *piece, k int) (*piece, *piece) {
+	if t == nil {
+		return nil, nil
+	}
+
+	if k <= t.left.pieces() {
+		l, r := splitTree(t.left, k)
+		t.setLeft(r)
+		t.resize()
+		if l != nil {
+			l.parent = nil
+		}
+		t.parent = nil
+		return l, t
+	}
+
+	l, r := splitTree(t.right, k-t.left.pieces()-1)
+	t.setRight(l)
+	t.resize()
+	if r != nil {
+		r.parent = nil
+	}
+	t.parent = nil
+	return t, r
+}
+
+// mergeTree joins two trees, with all pieces of a placed before the ones of b.
+func mergeTree(a, b *piece) *piece {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	if a.priority > b.priority {
+		a.setRight(mergeTree(a.right, b))
+		a.resize()
+		return a
+	}
+
+	b.setLeft(mergeTree(a, b.left))
+	b.resize()
+	return b
+}
+
+// clearTree resets the tree fields of all the pieces in tree t, which is
+// removed from the piece list.
+func clearTree(t *piece) {
+	if t == nil {
+		return
+	}
+
+	clearTree(t.left)
+	clearTree(t.right)
+	t.pieceNode = pieceNode{}
+}
+
+// rank returns the index of p in the tree.
+func (pl *pieceList) rank(p *piece) int {
+	switch p {
+	case pl.head:
+		return -1
+	case pl.tail:
+		return pl.root.pieces()
+	}
+
+	r := p.left.pieces()
+	for n := p; n.parent != nil; n = n.parent {
+		if n == n.parent.right {
+			r += n.parent.left.pieces() + 1
+		}
+	}
+	return r
+}
+
+// position returns the position of p in the document. It returns false if p is
+// not in the piece list.
+func (pl *pieceList) position(p *piece) (piecePos, bool) {
+	switch p {
+	case pl.head:
+		return piecePos{}, true
+	case pl.tail:
+		return piecePos{runes: pl.root.runes(), bytes: pl.root.bytes(), lines: pl.root.lines()}, true
+	}
+
+	pos := piecePos{runes: p.left.runes(), bytes: p.left.bytes(), lines: p.left.lines()}
+	n := p
+	for ; n.parent != nil; n = n.parent {
+		if n == n.parent.right {
+			parent := n.parent
+			pos.runes += parent.left.runes() + parent.length
+			pos.bytes += parent.left.bytes() + parent.byteLength
+			pos.lines += parent.left.lines() + parent.lineBreaks
+		}
+	}
+
+	return pos, n == pl.root
+}
+
+// relink rebuilds the tree for the pieces between a and b after the pieces are
+// relinked by the link function. a and b are two pieces in the list, including
+// the sentinels, which are not changed by link.
+func (pl *pieceList) relink(a, b *piece, link func()) {
+	lo, hi := pl.rank(a)+1, pl.rank(b)
+	left, rest := splitTree(pl.root, lo)
+	mid, right := splitTree(rest, hi-lo)
+	clearTree(mid)
+
+	link()
+
+	mid = nil
+	for n := a.next; n != b; n = n.next {
+		n.pieceNode = pieceNode{priority: rand.Uint32()}
+		if pl.lineCounter != nil {
+			n.lineBreaks = pl.lineCounter(n)
+		}
+		n.resize()
+		mid = mergeTree(mid, n)
+	}
+
+	pl.root = mergeTree(mergeTree(left, mid), right)
+	pl.invalidateCache()
+}
+
+// resizePiece updates the tree after the length of p is changed.
+func (pl *pieceList) resizePiece(p *piece) {
+	if pl.lineCounter != nil {
+		p.lineBreaks = pl.lineCounter(p)
+	}
+
+	for n := p; n != nil; n = n.parent {
+		n.resize()
+	}
+	pl.invalidateCache()
+}
+
+// seek finds the piece containing the rune at runeIndex, returning the found
+// piece, the rune offset in it and the position of the piece. If runeIndex
+// reaches the end of the piece chain, the sentinel tail piece is returned.
+func (pl *pieceList) seek(runeIndex int) (*piece, int, piecePos) {
+	var pos piecePos
+	n := pl.root
+	for n != nil {
+		if runeIndex < pos.runes+n.left.runes() {
+			n = n.left
+			continue
+		}
+
+		pos.runes += n.left.runes()
+		pos.bytes += n.left.bytes()
+		pos.lines += n.left.lines()
+		if runeIndex < pos.runes+n.length {
+			return n, runeIndex - pos.runes, pos
+		}
+
+		pos.runes += n.length
+		pos.bytes += n.byteLength
+		pos.lines += n.lineBreaks
+		n = n.right
+	}
+
+	return pl.tail, 0, pos
+}
+
+// seekBytes is like seek, but finds the piece by a byte offset. It returns the
+// byte offset in the found piece.
+func (pl *pieceList) seekBytes(byteIndex int) (*piece, int, piecePos) {
+	var pos piecePos
+	n := pl.root
+	for n != nil {
+		if byteIndex < pos.bytes+n.left.bytes() {
+			n = n.left
+			continue
+		}
+
+		pos.runes += n.left.runes()
+		pos.bytes += n.left.bytes()
+		pos.lines += n.left.lines()
+		if byteIndex < pos.bytes+n.byteLength {
+			return n, byteIndex - pos.bytes, pos
+		}
+
+		pos.runes += n.length
+		pos.bytes += n.byteLength
+		pos.lines += n.lineBreaks
+		n = n.right
+	}
+
+	return pl.tail, 0, pos
+}
+
+// seekLineBreak finds the piece containing the nth line break of the document,
+// where n starts from 1. It returns the found piece, the index of the line
+// break in it, starting from 1, and the position of the piece. If there are
+// less than n line breaks, the sentinel tail piece is returned.
+func (pl *pieceList) seekLineBreak(nth int) (*piece, int, piecePos) {
+	var pos piecePos
+	n := pl.root
+	for n != nil {
+		if nth <= pos.lines+n.left.lines() {
+			n = n.left
+			continue
+		}
+
+		pos.runes += n.left.runes()
+		pos.bytes += n.left.bytes()
+		pos.lines += n.left.lines()
+		if nth <= pos.lines+n.lineBreaks {
+			return n, nth - pos.lines, pos
+		}
+
+		pos.runes += n.length
+		pos.bytes += n.byteLength
+		pos.lines += n.lineBreaks
+		n = n.right
+	}
+
+	return pl.tail, 0, pos
+}
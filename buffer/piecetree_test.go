@@ -0,0 +1,157 @@
+package buffer
+
+import (
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// checkPieceTree verifies the tree is consistent with the piece list.
+func checkPieceTree(t *testing.T, pt *PieceTable) {
+	t.Helper()
+
+	var inorder []*piece
+	var walk func(n *piece)
+	walk = func(n *piece) {
+		if n == nil {
+			return
+		}
+		walk(n.left)
+		inorder = append(inorder, n)
+		walk(n.right)
+
+		if n.left != nil && n.left.parent != n || n.right != nil && n.right.parent != n {
+			t.Fatal("broken parent link")
+		}
+		if n.treePieces != n.left.pieces()+1+n.right.pieces() ||
+			n.treeRunes != n.left.runes()+n.length+n.right.runes() ||
+			n.treeBytes != n.left.bytes()+n.byteLength+n.right.bytes() ||
+			n.treeLines != n.left.lines()+n.lineBreaks+n.right.lines() {
+			t.Fatal("invalid subtree sizes")
+		}
+	}
+	walk(pt.pieces.root)
+
+	idx, runes, bytes := 0, 0, 0
+	for n := pt.pieces.Head(); n != pt.pieces.tail; n = n.next {
+		if idx >= len(inorder) || inorder[idx] != n {
+			t.Fatalf("piece #%d is not indexed in order", idx)
+		}
+		if n.lineBreaks != pt.countLineBreaks(n) {
+			t.Fatalf("piece #%d has invalid line breaks", idx)
+		}
+
+		p, off, byteOff := pt.pieces.FindPiece(runes)
+		if n.length > 0 && (p != n || off != 0 || byteOff != bytes) {
+			t.Fatalf("FindPiece(%d) returns the wrong piece", runes)
+		}
+
+		idx++
+		runes += n.length
+		bytes += n.byteLength
+	}
+
+	if idx != len(inorder) || runes != pt.seqLength || bytes != pt.seqBytes {
+		t.Fatalf("tree size mismatch, pieces: %d/%d, runes: %d/%d, bytes: %d/%d",
+			idx, len(inorder), runes, pt.seqLength, bytes, pt.seqBytes)
+	}
+}
+
+func TestPieceTreeRandomEdits(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	words := []string{"a", "你好", "\n", "foo\nbar", "🎉", "line\n\n"}
+
+	pt := NewPieceTable([]byte("Hello,\nworld\n"))
+	for i := 0; i < 2000; i++ {
+		length := pt.Len()
+		switch op := rnd.IntN(10); {
+		case op < 5:
+			pos := rnd.IntN(length + 1)
+			pt.Replace(pos, pos, words[rnd.IntN(len(words))])
+		case op < 7 && length > 0:
+			start := rnd.IntN(length)
+			pt.Replace(start, min(length, start+rnd.IntN(8)+1), "")
+		case op < 8 && length > 0:
+			start := rnd.IntN(length)
+			pt.Replace(start, min(length, start+rnd.IntN(8)+1), words[rnd.IntN(len(words))])
+		case op < 9:
+			pt.Undo()
+		default:
+			pt.Redo()
+		}
+
+		checkPieceTree(t, pt)
+	}
+
+	content := readTableContent(pt)
+	if pt.Len() != utf8.RuneCountInString(content) {
+		t.Fatalf("length mismatch: %d, %d", pt.Len(), utf8.RuneCountInString(content))
+	}
+
+	wantLines := strings.Count(content, "\n")
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		wantLines++
+	}
+	if pt.Lines() != wantLines {
+		t.Fatalf("want %d lines, got %d", wantLines, pt.Lines())
+	}
+}
+
+func TestSeekLineBreak(t *testing.T) {
+	pt := NewPieceTable([]byte("ab\ncd\n"))
+	pt.Replace(4, 4, "x\ny")
+	pt.Replace(0, 0, "\n")
+	// content: "\nab\ncx\nyd\n"
+	content := []rune(readTableContent(pt))
+
+	nth := 0
+	for i, r := range content {
+		if r != lineBreak {
+			continue
+		}
+		nth++
+
+		p, idx, pos := pt.pieces.seekLineBreak(nth)
+		if p == pt.pieces.tail {
+			t.Fatalf("line break #%d not found", nth)
+		}
+		off := pt.getBuf(p.source).nthLineBreak(p.offset, idx) - p.offset + pos.runes
+		if off != i {
+			t.Fatalf("want line break #%d at %d, got %d", nth, i, off)
+		}
+	}
+
+	if p, _, _ := pt.pieces.seekLineBreak(nth + 1); p != pt.pieces.tail {
+		t.Fatal("expected the tail piece")
+	}
+}
+
+func TestMarkerOffsetAfterInsert(t *testing.T) {
+	pt := NewPieceTable([]byte("Hello world"))
+	m, _ := pt.CreateMarker(6, BiasForward)
+
+	pt.Replace(0, 0, "abc")
+	if m.Offset() != 9 {
+		t.Fatalf("want marker at 9, got %d", m.Offset())
+	}
+
+	pt.Replace(2, 2, "xyz")
+	if m.Offset() != 12 {
+		t.Fatalf("want marker at 12, got %d", m.Offset())
+	}
+}
+
+func BenchmarkReadRuneAfterScatteredEdits(b *testing.B) {
+	pt := NewPieceTable([]byte(strings.Repeat("Hello world\n", 10000)))
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 5000; i++ {
+		pos := rnd.IntN(pt.Len())
+		pt.Replace(pos, pos, "ab")
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pt.ReadRuneAt(rnd.IntN(pt.Len()))
+	}
+}
@@ -10,7 +10,10 @@ var _ TextSource = (*PieceTable)(nil)
 func (pt *PieceTable) RuneOffset(runeOff int) int {
 	pt.mu.RLock()
 	defer pt.mu.RUnlock()
+	return pt.runeOffset(runeOff)
+}
 
+func (pt *PieceTable) runeOffset(runeOff int) int {
 	if pt.seqLength == 0 {
 		return 0
 	}
@@ -30,7 +33,10 @@ func (pt *PieceTable) RuneOffset(runeOff int) int {
 func (pt *PieceTable) ReadRuneAt(runeOff int) (rune, error) {
 	pt.mu.RLock()
 	defer pt.mu.RUnlock()
+	return pt.readRuneAt(runeOff)
+}
 
+func (pt *PieceTable) readRuneAt(runeOff int) (rune, error) {
 	n, off, _ := pt.pieces.FindPiece(runeOff)
 	if n == nil {
 		return 0, io.EOF
@@ -39,49 +45,77 @@ func (pt *PieceTable) ReadRuneAt(runeOff int) (rune, error) {
 	return pt.getBuf(n.source).getRuneAt(n.offset + off)
 }
 
-func (r *PieceTable) parseLine(text []byte) []lineInfo {
-	var lines []lineInfo
+// Lines returns the number of lines of the text. The text after the last line
+// break is counted as a line if it is not empty.
+func (pt *PieceTable) Lines() int {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
 
-	n := 0
-	for _, c := range string(text) {
-		n++
-		if c == lineBreak {
-			lines = append(lines, lineInfo{length: n, hasLineBreak: true})
-			n = 0
-		}
+	if pt.seqLength == 0 {
+		return 0
 	}
 
-	// The remaining bytes that don't end with a line break.
-	if n > 0 {
-		lines = append(lines, lineInfo{length: n})
+	lines := pt.pieces.root.lines()
+	if r, _ := pt.readRuneAt(pt.seqLength - 1); r != lineBreak {
+		lines++
 	}
-
 	return lines
 }
 
-func (pt *PieceTable) Lines() int {
+// LineStart returns the rune offset of the start of the line. Lines are
+// counted from zero, and the line after the last line break is always valid,
+// even if it is empty. Lines out of range are clamped.
+func (pt *PieceTable) LineStart(line int) int {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+	return pt.lineStart(line)
+}
+
+func (pt *PieceTable) lineStart(line int) int {
+	if line <= 0 {
+		return 0
+	}
+
+	// The line starts after the line break of the previous line.
+	p, nth, pos := pt.pieces.seekLineBreak(line)
+	if p == pt.pieces.tail {
+		return pt.seqLength
+	}
+
+	return pos.runes + pt.getBuf(p.source).nthLineBreak(p.offset, nth) - p.offset + 1
+}
+
+// LineOf returns the line of the rune at runeOff. Lines are counted from zero.
+func (pt *PieceTable) LineOf(runeOff int) int {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+	return pt.lineOf(runeOff)
+}
+
+func (pt *PieceTable) lineOf(runeOff int) int {
+	if runeOff <= 0 {
+		return 0
+	}
+
+	p, offset, pos := pt.pieces.seek(runeOff)
+	if p == pt.pieces.tail {
+		return pos.lines
+	}
+
+	return pos.lines + pt.getBuf(p.source).lineBreaksInRange(p.offset, offset)
+}
+
+// LineLength returns the length in runes of the line, including the trailing
+// line break if there is one.
+func (pt *PieceTable) LineLength(line int) int {
 	pt.mu.RLock()
 	defer pt.mu.RUnlock()
 
-	pt.lines = pt.lines[:0]
-	for n := pt.pieces.Head(); n != pt.pieces.tail; n = n.next {
-		pieceText := pt.getBuf(n.source).getTextByRange(n.byteOff, n.byteLength)
-		lines := pt.parseLine(pieceText)
-		if len(lines) > 0 {
-			if len(pt.lines) > 0 {
-				lastLine := pt.lines[len(pt.lines)-1]
-				if !lastLine.hasLineBreak {
-					// merge with lastLine
-					lines[0].length += lastLine.length
-					pt.lines = pt.lines[:len(pt.lines)-1]
-				}
-			}
-
-			pt.lines = append(pt.lines, lines...)
-		}
+	if line < 0 {
+		return 0
 	}
 
-	return len(pt.lines)
+	return pt.lineStart(line+1) - pt.lineStart(line)
 }
 
 // pieceTableReader implements a [TextSource].
@@ -0,0 +1,110 @@
+package buffer
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRuneOffset(t *testing.T) {
+	src := NewTextSource()
+	src.Replace(0, 0, "hello,world.")
+	if src.RuneOffset(12) != 12 {
+		t.Fail()
+	}
+
+	src.Replace(12, 12, "你好，世界")
+
+	_, size := utf8.DecodeRuneInString("你好，世界")
+	t.Log(size)
+	if src.RuneOffset(13) != 15 {
+		t.Fail()
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	src := NewTextSource()
+	reader := NewReader(src)
+	src.Replace(0, 0, "hello,world.")
+
+	if src.Len() != 12 {
+		t.Fail()
+	}
+
+	buf := make([]byte, 5)
+	n, err := src.ReadAt(buf, 0)
+	if err != nil {
+		t.Fail()
+	}
+
+	if n != 5 || string(buf) != "hello" {
+		t.Fail()
+	}
+
+	content := reader.ReadAll(buf)
+	if string(content) != "hello,world." {
+		t.Fail()
+	}
+}
+
+func TestReadRuneAt(t *testing.T) {
+	src := NewTextSource()
+	src.SetText([]byte("hello,world.你好，世界"))
+
+	r, err := src.ReadRuneAt(6)
+	if err != nil {
+		t.Logf("ReadRuneAt error: %v", err)
+		t.Fail()
+	}
+	if r != 'w' {
+		t.Fail()
+	}
+
+	r, err = src.ReadRuneAt(12)
+	if err != nil {
+		t.Logf("ReadRuneAt error: %v", err)
+		t.Fail()
+	}
+	if r != '你' {
+		t.Fail()
+	}
+
+}
+
+func TestLineQueries(t *testing.T) {
+	src := NewTextSource()
+	src.Replace(0, 0, "你好\nworld\n")
+	src.Replace(9, 9, "\n\nfoo")
+	src.Replace(3, 3, "ab\n")
+	// content: "你好\nab\nworld\n\n\nfoo"
+
+	lineStarts := []int{0, 3, 6, 12, 13, 14}
+	lineLengths := []int{3, 3, 6, 1, 1, 3}
+	for line, want := range lineStarts {
+		if got := src.LineStart(line); got != want {
+			t.Errorf("LineStart(%d): want %d, got %d", line, want, got)
+		}
+		if got := src.LineLength(line); got != lineLengths[line] {
+			t.Errorf("LineLength(%d): want %d, got %d", line, lineLengths[line], got)
+		}
+	}
+
+	if src.LineStart(10) != src.Len() || src.LineLength(10) != 0 {
+		t.Error("lines out of range should be clamped")
+	}
+
+	line := 0
+	for off := 0; off <= src.Len(); off++ {
+		if line+1 < len(lineStarts) && off == lineStarts[line+1] {
+			line++
+		}
+		if got := src.LineOf(off); got != line {
+			t.Errorf("LineOf(%d): want %d, got %d", off, line, got)
+		}
+	}
+
+	src.Undo()
+	// content: "你好\nworld\n\n\nfoo"
+	if src.LineOf(6) != 1 || src.LineStart(2) != 9 || src.Lines() != 5 {
+		t.Errorf("unexpected lines after undo: %d, %d, %d", src.LineOf(6), src.LineStart(2), src.Lines())
+	}
+}
@@ -0,0 +1,691 @@
+package buffer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrReadOnly is returned by the methods of ReadOnlySource that would change
+// the text.
+var ErrReadOnly = errors.New("buffer: the text source is read-only")
+
+// indexBlockSize is the number of bytes between the checkpoints of the sparse
+// line index of ReadOnlySource.
+const indexBlockSize = 64 * 1024
+
+// checkpoint is a position in the text indexed by ReadOnlySource. raw is the
+// offset in the data read, and bytes the offset in the text, whose "\r\n"
+// line breaks are a single byte.
+type checkpoint struct {
+	raw   int
+	bytes int
+	runes int
+	lines int
+}
+
+// ReadOnlySource is a TextSource of immutable UTF-8 text, read from an
+// [io.ReaderAt] such as a memory-mapped file, so that very large files can be
+// viewed without loading them into memory.
+//
+// Instead of indexing every line, a sparse index of the positions of about
+// every 64KB of the text is built in a background goroutine, and the
+// positions in between are found by scanning the text of the block. The text
+// of the source is the part indexed when Refresh is last called, so that the
+// queries never wait for the index, and the text does not change while it is
+// read. The editor refreshes the source as it lays out the text, which grows
+// until the text is fully indexed.
+//
+// The "\r\n" and "\r" line breaks are read as "\n", the same way the editor
+// normalizes the text it loads, so that the offsets and the text of the source
+// are those of the normalized text. LineEndings counts the line breaks as they
+// are in the data. The methods that would change the text do nothing, or fail
+// with ErrReadOnly, so it should be used by a read-only editor.
+type ReadOnlySource struct {
+	r    io.ReaderAt
+	size int
+	// data is the text if it is mapped into memory.
+	data []byte
+	// closer releases the resources of the source, if it owns any.
+	closer func() error
+
+	mu sync.Mutex
+	// checkpoints are the positions of the start of the indexed blocks, ending
+	// with the end of the indexed text.
+	checkpoints []checkpoint
+	// last is the index of the checkpoint ending the text of the source.
+	last        int
+	lineEndings LineEndingCounts
+	closed      bool
+	err         error
+	ready       chan struct{}
+	// blockIdx and block cache the text of the last block read, which is
+	// read into raw and normalized into norm if needed.
+	blockIdx int
+	block    []byte
+	raw      []byte
+	norm     []byte
+	// cursor is the last rune found by seekRune, in the block of the index.
+	cursor struct {
+		block int
+		off   int
+		runes int
+	}
+}
+
+// NewReadOnlySource returns a source of the size bytes of text read from r,
+// and starts indexing it in the background.
+func NewReadOnlySource(r io.ReaderAt, size int64) *ReadOnlySource {
+	return newReadOnlySource(r, nil, int(size))
+}
+
+// OpenReadOnlySource opens the file at path as a source. The file is mapped
+// into memory if the platform supports it, or read on demand otherwise. Call
+// Close to release the file when the source is no longer used.
+func OpenReadOnlySource(path string) (*ReadOnlySource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	size := fi.Size()
+	if size == 0 {
+		f.Close()
+		return newReadOnlySource(bytes.NewReader(nil), nil, 0), nil
+	}
+
+	data, err := mapFile(f, size)
+	if err != nil {
+		// Read the file on demand instead.
+		s := newReadOnlySource(f, nil, int(size))
+		s.closer = f.Close
+		return s, nil
+	}
+	// The mapping stays valid after the file is closed.
+	f.Close()
+	s := newReadOnlySource(bytes.NewReader(data), data, int(size))
+	s.closer = func() error { return unmapFile(data) }
+	return s, nil
+}
+
+func newReadOnlySource(r io.ReaderAt, data []byte, size int) *ReadOnlySource {
+	s := &ReadOnlySource{
+		r:           r,
+		size:        size,
+		data:        data,
+		checkpoints: []checkpoint{{}},
+		ready:       make(chan struct{}),
+		blockIdx:    -1,
+	}
+	s.cursor.block = -1
+	go s.buildIndex()
+	return s
+}
+
+// buildIndex records a checkpoint after every block of the text, which ends at
+// a rune boundary and never splits a "\r\n".
+func (s *ReadOnlySource) buildIndex() {
+	defer close(s.ready)
+
+	buf := make([]byte, indexBlockSize+1)
+	var cp checkpoint
+	var err error
+	for cp.raw < s.size {
+		var n int
+		n, err = s.r.ReadAt(buf[:min(len(buf), s.size-cp.raw)], int64(cp.raw))
+		if n == 0 {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			break
+		}
+
+		end := n
+		if n > indexBlockSize {
+			end = runeBoundary(buf[:n], indexBlockSize)
+			if buf[end-1] == '\r' && buf[end] == '\n' {
+				end--
+			}
+		}
+		block := buf[:end]
+		crlf := bytes.Count(block, []byte("\r\n"))
+		counts := LineEndingCounts{
+			LF:   bytes.Count(block, []byte{'\n'}) - crlf,
+			CRLF: crlf,
+			CR:   bytes.Count(block, []byte{'\r'}) - crlf,
+		}
+		cp = checkpoint{
+			raw:   cp.raw + end,
+			bytes: cp.bytes + end - crlf,
+			runes: cp.runes + utf8.RuneCount(block) - crlf,
+			lines: cp.lines + counts.Total(),
+		}
+
+		s.mu.Lock()
+		s.checkpoints = append(s.checkpoints, cp)
+		for le, n := range counts {
+			s.lineEndings[le] += n
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			break
+		}
+		err = nil
+	}
+
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// runeBoundary moves end back to the start of the rune containing the byte
+// at end, so that splitting data at end does not break a rune. Any byte that
+// is not a continuation byte starts a rune, and so does a byte after three
+// continuation bytes, as the encoding of a rune is at most 4 bytes.
+func runeBoundary(data []byte, end int) int {
+	for i := end; i > end-utf8.UTFMax && i > 0; i-- {
+		if utf8.RuneStart(data[i]) {
+			return i
+		}
+	}
+	return end
+}
+
+// Ready returns a channel which is closed when the text is fully indexed. The
+// text indexed is added to the source by Refresh.
+func (s *ReadOnlySource) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Refresh adds the text indexed since the last call to the text of the source.
+// It returns whether the text grows.
+func (s *ReadOnlySource) Refresh() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := len(s.checkpoints) - 1
+	if last == s.last {
+		return false
+	}
+	s.last = last
+	return true
+}
+
+// IndexProgress returns the number of bytes indexed, and the size of the data
+// read.
+func (s *ReadOnlySource) IndexProgress() (indexed, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(s.checkpoints[len(s.checkpoints)-1].raw), int64(s.size)
+}
+
+// LineEndings counts the line breaks of the text indexed by their styles.
+func (s *ReadOnlySource) LineEndings() LineEndingCounts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lineEndings
+}
+
+// Err returns the error reading the text while indexing it, if any. The text
+// after the error is not available.
+func (s *ReadOnlySource) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close stops indexing the text, and releases the file opened by
+// OpenReadOnlySource. The source and its snapshots must not be used after it
+// is closed.
+func (s *ReadOnlySource) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	closer := s.closer
+	s.closer = nil
+	s.mu.Unlock()
+
+	<-s.ready
+	if closer != nil {
+		return closer()
+	}
+	return nil
+}
+
+// The queries below are answered for the text ending at the checkpoint last,
+// so that the snapshots keep seeing the text of their version. They must be
+// called with mu held.
+
+// seekRune finds the block containing the rune at runeOff, and the byte offset
+// of the rune in the block. It returns the index of the last checkpoint if
+// runeOff reaches the end of the text.
+func (s *ReadOnlySource) seekRune(last, runeOff int) (int, []byte, int) {
+	i := sort.Search(last+1, func(i int) bool {
+		return s.checkpoints[i].runes > runeOff
+	}) - 1
+	if i == last {
+		return i, nil, 0
+	}
+
+	block := s.readBlock(i)
+	off, runes := 0, s.checkpoints[i].runes
+	// Move from the last rune found in the block if it is nearer, so that
+	// reading the runes in order, in either direction, does not scan the block
+	// again and again. Decoding backwards finds the same runes as decoding
+	// forwards, as any byte other than a continuation byte starts a rune.
+	if c := s.cursor; c.block == i {
+		switch {
+		case c.runes <= runeOff:
+			off, runes = c.off, c.runes
+		case c.runes-runeOff < runeOff-runes:
+			off, runes = c.off, c.runes
+			for ; runes > runeOff; runes-- {
+				_, size := utf8.DecodeLastRune(block[:off])
+				off -= size
+			}
+		}
+	}
+	for ; runes < runeOff && off < len(block); runes++ {
+		_, size := utf8.DecodeRune(block[off:])
+		off += size
+	}
+	s.cursor.block, s.cursor.off, s.cursor.runes = i, off, runes
+	return i, block, off
+}
+
+// readBlock returns the text of the block starting at the checkpoint i, with
+// the line breaks normalized.
+func (s *ReadOnlySource) readBlock(i int) []byte {
+	if s.blockIdx == i {
+		return s.block
+	}
+
+	start, end := s.checkpoints[i].raw, s.checkpoints[i+1].raw
+	var raw []byte
+	if s.data != nil {
+		raw = s.data[start:end:end]
+	} else {
+		if cap(s.raw) < end-start {
+			s.raw = make([]byte, end-start)
+		}
+		n, _ := s.r.ReadAt(s.raw[:end-start], int64(start))
+		raw = s.raw[:n]
+	}
+	s.block = raw
+	if bytes.IndexByte(raw, '\r') >= 0 {
+		s.norm = normalizeLineBreaks(s.norm[:0], raw)
+		s.block = s.norm
+	}
+	s.blockIdx = i
+	return s.block
+}
+
+// normalizeLineBreaks appends text to dst, converting the "\r\n" and "\r" line
+// breaks to "\n".
+func normalizeLineBreaks(dst, text []byte) []byte {
+	for {
+		i := bytes.IndexByte(text, '\r')
+		if i < 0 {
+			return append(dst, text...)
+		}
+		dst = append(dst, text[:i]...)
+		dst = append(dst, lineBreak)
+		text = text[i+1:]
+		if len(text) > 0 && text[0] == '\n' {
+			text = text[1:]
+		}
+	}
+}
+
+func (s *ReadOnlySource) readAt(last int, p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, io.EOF
+	}
+
+	n, off := 0, int(offset)
+	for n < len(p) {
+		if off >= s.checkpoints[last].bytes {
+			return n, io.EOF
+		}
+		i := sort.Search(last+1, func(i int) bool {
+			return s.checkpoints[i].bytes > off
+		}) - 1
+
+		block := s.readBlock(i)
+		if off-s.checkpoints[i].bytes >= len(block) {
+			return n, io.ErrUnexpectedEOF
+		}
+		m := copy(p[n:], block[off-s.checkpoints[i].bytes:])
+		n += m
+		off += m
+	}
+	return n, nil
+}
+
+func (s *ReadOnlySource) readRuneAt(last, runeOff int) (rune, error) {
+	if runeOff < 0 {
+		return 0, io.EOF
+	}
+
+	_, block, off := s.seekRune(last, runeOff)
+	if off >= len(block) {
+		return 0, io.EOF
+	}
+
+	// Blocks end at rune boundaries, so a rune is never split between them.
+	r, size := utf8.DecodeRune(block[off:])
+	if r == utf8.RuneError && size == 1 {
+		return r, errReadRune
+	}
+	return r, nil
+}
+
+func (s *ReadOnlySource) runeOffset(last, runeOff int) int {
+	if runeOff <= 0 {
+		return 0
+	}
+
+	i, _, off := s.seekRune(last, runeOff)
+	return s.checkpoints[i].bytes + off
+}
+
+// lines counts the text after the last line break as a line if it is not
+// empty.
+func (s *ReadOnlySource) lines(last int) int {
+	if last == 0 {
+		return 0
+	}
+	cp := s.checkpoints[last]
+	if block := s.readBlock(last - 1); len(block) > 0 && block[len(block)-1] == lineBreak {
+		return cp.lines
+	}
+	return cp.lines + 1
+}
+
+func (s *ReadOnlySource) lineStart(last, line int) int {
+	if line <= 0 {
+		return 0
+	}
+
+	// The line starts after the line break of the previous line, which is in
+	// the block before the first checkpoint reaching the line.
+	i := sort.Search(last+1, func(i int) bool {
+		return s.checkpoints[i].lines >= line
+	}) - 1
+	if i == last {
+		return s.checkpoints[i].runes
+	}
+
+	cp := s.checkpoints[i]
+	block := s.readBlock(i)
+	off := 0
+	for n := line - cp.lines; n > 0; n-- {
+		idx := bytes.IndexByte(block[off:], lineBreak)
+		if idx < 0 {
+			// The block is not fully read.
+			return s.checkpoints[i+1].runes
+		}
+		off += idx + 1
+	}
+	return cp.runes + utf8.RuneCount(block[:off])
+}
+
+func (s *ReadOnlySource) lineOf(last, runeOff int) int {
+	if runeOff <= 0 {
+		return 0
+	}
+
+	i, block, off := s.seekRune(last, runeOff)
+	return s.checkpoints[i].lines + bytes.Count(block[:off], []byte{lineBreak})
+}
+
+func (s *ReadOnlySource) lineLength(last, line int) int {
+	if line < 0 {
+		return 0
+	}
+
+	return s.lineStart(last, line+1) - s.lineStart(last, line)
+}
+
+// ReadAt implements [io.ReaderAt].
+func (s *ReadOnlySource) ReadAt(p []byte, offset int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readAt(s.last, p, offset)
+}
+
+// ReadRuneAt reads the rune starting at the given rune offset, if any.
+func (s *ReadOnlySource) ReadRuneAt(runeOff int) (rune, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readRuneAt(s.last, runeOff)
+}
+
+// RuneOffset returns the byte offset for the rune at position runeOff.
+func (s *ReadOnlySource) RuneOffset(runeOff int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runeOffset(s.last, runeOff)
+}
+
+// Len returns the length of the text in runes.
+func (s *ReadOnlySource) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkpoints[s.last].runes
+}
+
+// Size returns the size of the text in bytes.
+func (s *ReadOnlySource) Size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkpoints[s.last].bytes
+}
+
+// Lines returns the number of lines of the text. The text after the last line
+// break is counted as a line if it is not empty.
+func (s *ReadOnlySource) Lines() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lines(s.last)
+}
+
+// LineStart returns the rune offset of the start of the line. Lines are
+// counted from zero, and the line after the last line break is always valid,
+// even if it is empty. Lines out of range are clamped.
+func (s *ReadOnlySource) LineStart(line int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lineStart(s.last, line)
+}
+
+// LineOf returns the line of the rune at runeOff. Lines are counted from zero.
+func (s *ReadOnlySource) LineOf(runeOff int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lineOf(s.last, runeOff)
+}
+
+// LineLength returns the length in runes of the line, including the trailing
+// line break if there is one.
+func (s *ReadOnlySource) LineLength(line int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lineLength(s.last, line)
+}
+
+// SetText does nothing, as the text is read-only.
+func (s *ReadOnlySource) SetText(text []byte) {}
+
+// ReadFrom fails with ErrReadOnly.
+func (s *ReadOnlySource) ReadFrom(r io.Reader) (int64, error) {
+	return 0, ErrReadOnly
+}
+
+// WriteTo writes the text to w, with the line breaks normalized. It
+// implements [io.WriterTo].
+func (s *ReadOnlySource) WriteTo(w io.Writer) (int64, error) {
+	text := s.Snapshot()
+	return io.Copy(w, io.NewSectionReader(text, 0, int64(text.Size())))
+}
+
+// Replace does nothing and returns false, as the text is read-only.
+func (s *ReadOnlySource) Replace(startOff, endOff int, text string) bool {
+	return false
+}
+
+// Append does nothing and returns false, as the text is read-only.
+func (s *ReadOnlySource) Append(text string) bool {
+	return false
+}
+
+// TrimLines does nothing and returns 0, as the text is read-only.
+func (s *ReadOnlySource) TrimLines(n int) int {
+	return 0
+}
+
+// CreateMarker adds a marker at runeOff, which never moves as the text is
+// never edited.
+func (s *ReadOnlySource) CreateMarker(runeOff int, bias MarkerBias) (*Marker, error) {
+	return NewMarker(max(0, min(runeOff, s.Len())), bias), nil
+}
+
+// RemoveMarker does nothing, as the markers are not tracked.
+func (s *ReadOnlySource) RemoveMarker(m *Marker) {}
+
+// Undo does nothing, as there is no change to undo.
+func (s *ReadOnlySource) Undo() ([]CursorPos, bool) {
+	return nil, false
+}
+
+// Redo does nothing, as there is no change to redo.
+func (s *ReadOnlySource) Redo() ([]CursorPos, bool) {
+	return nil, false
+}
+
+// History returns no changes.
+func (s *ReadOnlySource) History() ([]HistoryNode, int) {
+	return nil, 0
+}
+
+// GotoHistory does nothing, as there is no change in the history.
+func (s *ReadOnlySource) GotoHistory(seq int) ([]CursorPos, bool) {
+	return nil, false
+}
+
+// SaveHistory fails with ErrReadOnly.
+func (s *ReadOnlySource) SaveHistory(w io.Writer) error {
+	return ErrReadOnly
+}
+
+// LoadHistory fails with ErrReadOnly.
+func (s *ReadOnlySource) LoadHistory(r io.Reader) error {
+	return ErrReadOnly
+}
+
+func (s *ReadOnlySource) GroupOp() {}
+
+func (s *ReadOnlySource) UnGroupOp() {}
+
+// Changed always returns false, as the text is never edited.
+func (s *ReadOnlySource) Changed() bool {
+	return false
+}
+
+// Version returns the version of the text, which increases as the text grows
+// by Refresh.
+func (s *ReadOnlySource) Version() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// Snapshot returns a snapshot of the text, which shares the index and the
+// data of the source, and is not grown by Refresh.
+func (s *ReadOnlySource) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return NewSnapshot(s.last, &readOnlySnapshot{src: s, last: s.last})
+}
+
+// MarkSaved does nothing, as the text is never modified.
+func (s *ReadOnlySource) MarkSaved() {}
+
+// Modified always returns false, as the text is never modified.
+func (s *ReadOnlySource) Modified() bool {
+	return false
+}
+
+// Subscribe does nothing, as the text is never edited.
+func (s *ReadOnlySource) Subscribe(fn func(TextChange)) (cancel func()) {
+	return func() {}
+}
+
+// readOnlySnapshot is the text of a snapshot of a ReadOnlySource, which ends
+// at the checkpoint last.
+type readOnlySnapshot struct {
+	src  *ReadOnlySource
+	last int
+}
+
+func (s *readOnlySnapshot) ReadAt(p []byte, offset int64) (int, error) {
+	s.src.mu.Lock()
+	defer s.src.mu.Unlock()
+	return s.src.readAt(s.last, p, offset)
+}
+
+func (s *readOnlySnapshot) Len() int {
+	s.src.mu.Lock()
+	defer s.src.mu.Unlock()
+	return s.src.checkpoints[s.last].runes
+}
+
+func (s *readOnlySnapshot) Size() int {
+	s.src.mu.Lock()
+	defer s.src.mu.Unlock()
+	return s.src.checkpoints[s.last].bytes
+}
+
+func (s *readOnlySnapshot) ReadRuneAt(runeOff int) (rune, error) {
+	s.src.mu.Lock()
+	defer s.src.mu.Unlock()
+	return s.src.readRuneAt(s.last, runeOff)
+}
+
+func (s *readOnlySnapshot) RuneOffset(runeOff int) int {
+	s.src.mu.Lock()
+	defer s.src.mu.Unlock()
+	return s.src.runeOffset(s.last, runeOff)
+}
+
+func (s *readOnlySnapshot) Lines() int {
+	s.src.mu.Lock()
+	defer s.src.mu.Unlock()
+	return s.src.lines(s.last)
+}
+
+func (s *readOnlySnapshot) LineStart(line int) int {
+	s.src.mu.Lock()
+	defer s.src.mu.Unlock()
+	return s.src.lineStart(s.last, line)
+}
+
+func (s *readOnlySnapshot) LineOf(runeOff int) int {
+	s.src.mu.Lock()
+	defer s.src.mu.Unlock()
+	return s.src.lineOf(s.last, runeOff)
+}
+
+func (s *readOnlySnapshot) LineLength(line int) int {
+	s.src.mu.Lock()
+	defer s.src.mu.Unlock()
+	return s.src.lineLength(s.last, line)
+}
@@ -0,0 +1,170 @@
+package buffer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// randomText makes text of about size bytes, with multi-byte runes and line
+// breaks scattered over the block boundaries of the index.
+func randomText(size int) string {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"log", "世界", "😀", "\n", "\n\n", "\r\n", "\r", "é", "\xff", "line"}
+	var b strings.Builder
+	for b.Len() < size {
+		b.WriteString(words[rng.Intn(len(words))])
+	}
+	return b.String()
+}
+
+func TestReadOnlySource(t *testing.T) {
+	for _, data := range []string{"", "a", "a\n", "\n\nb", "a\r\nb\r", randomText(3*indexBlockSize + 123)} {
+		// The source reads the text with the line breaks normalized.
+		text := NormalizeLineEndings(data)
+		pt := NewPieceTable([]byte(text))
+		src := NewReadOnlySource(strings.NewReader(data), int64(len(data)))
+		<-src.Ready()
+		src.Refresh()
+		if got, want := src.LineEndings(), CountLineEndings(data); got != want {
+			t.Errorf("want line endings %v, got %v", want, got)
+		}
+
+		if src.Len() != pt.Len() || src.Size() != pt.Size() || src.Lines() != pt.Lines() {
+			t.Fatalf("want len %d, size %d and %d lines, got %d, %d and %d",
+				pt.Len(), pt.Size(), pt.Lines(), src.Len(), src.Size(), src.Lines())
+		}
+
+		for off := 0; off <= pt.Len(); off += 1 + off/50 {
+			if got, want := src.RuneOffset(off), pt.RuneOffset(off); got != want {
+				t.Fatalf("RuneOffset(%d): want %d, got %d", off, want, got)
+			}
+			if got, want := src.LineOf(off), pt.LineOf(off); got != want {
+				t.Fatalf("LineOf(%d): want %d, got %d", off, want, got)
+			}
+			if off < pt.Len() {
+				got, err1 := src.ReadRuneAt(off)
+				want, err2 := pt.ReadRuneAt(off)
+				if got != want || (err1 == nil) != (err2 == nil) {
+					t.Fatalf("ReadRuneAt(%d): want %q, %v, got %q, %v", off, want, err2, got, err1)
+				}
+			}
+		}
+		if _, err := src.ReadRuneAt(pt.Len()); err != io.EOF {
+			t.Errorf("ReadRuneAt(Len): want EOF, got %v", err)
+		}
+
+		for line := 0; line <= pt.Lines()+1; line += 1 + line/50 {
+			if got, want := src.LineStart(line), pt.LineStart(line); got != want {
+				t.Fatalf("LineStart(%d): want %d, got %d", line, want, got)
+			}
+			if got, want := src.LineLength(line), pt.LineLength(line); got != want {
+				t.Fatalf("LineLength(%d): want %d, got %d", line, want, got)
+			}
+		}
+
+		var buf bytes.Buffer
+		if _, err := src.WriteTo(&buf); err != nil || buf.String() != text {
+			t.Errorf("WriteTo: text mismatch, %v", err)
+		}
+		if s := src.Snapshot(); s.Text() != text || s.Lines() != pt.Lines() {
+			t.Error("Snapshot: text mismatch")
+		}
+	}
+}
+
+func TestReadOnlySourceFile(t *testing.T) {
+	data := randomText(2*indexBlockSize + 7)
+	text := NormalizeLineEndings(data)
+	path := filepath.Join(t.TempDir(), "log.txt")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := OpenReadOnlySource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-src.Ready()
+	if !src.Refresh() || src.Refresh() {
+		t.Error("want the text grown by the first refresh only")
+	}
+	pt := NewPieceTable([]byte(text))
+	if got, want := src.LineStart(pt.Lines()-1), pt.LineStart(pt.Lines()-1); got != want {
+		t.Errorf("LineStart: want %d, got %d", want, got)
+	}
+	if indexed, size := src.IndexProgress(); indexed != size || size != int64(len(data)) {
+		t.Errorf("want %d bytes indexed, got %d of %d", len(data), indexed, size)
+	}
+	if got := src.Snapshot().Text(); got != text {
+		t.Error("Snapshot: text mismatch")
+	}
+
+	if src.Replace(0, 1, "x") || src.Modified() {
+		t.Error("want the text not to be changed")
+	}
+	if _, err := src.ReadFrom(strings.NewReader("x")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("ReadFrom: want ErrReadOnly, got %v", err)
+	}
+	if err := src.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// gatedReader blocks reading from offset limit until gate is closed, after
+// signaling reached.
+type gatedReader struct {
+	r       io.ReaderAt
+	limit   int64
+	reached chan struct{}
+	gate    chan struct{}
+	once    sync.Once
+}
+
+func (g *gatedReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= g.limit {
+		g.once.Do(func() { close(g.reached) })
+		<-g.gate
+	}
+	return g.r.ReadAt(p, off)
+}
+
+func TestReadOnlySourceGrows(t *testing.T) {
+	text := randomText(3 * indexBlockSize)
+	g := &gatedReader{r: strings.NewReader(text), limit: 1, reached: make(chan struct{}), gate: make(chan struct{})}
+	src := NewReadOnlySource(g, int64(len(text)))
+	if src.Len() != 0 || src.Lines() != 0 {
+		t.Fatal("want no text before the source is refreshed")
+	}
+
+	// The first block is indexed, and the indexing is stalled.
+	<-g.reached
+	if !src.Refresh() {
+		t.Fatal("want the text grown by the indexed block")
+	}
+	first := src.Len()
+	if first == 0 || src.LineStart(1<<30) != first || src.Size() >= len(text) {
+		t.Fatalf("want the text of the first block, got %d runes", first)
+	}
+	snapshot := src.Snapshot()
+
+	close(g.gate)
+	<-src.Ready()
+	src.Refresh()
+	want := NewPieceTable([]byte(NormalizeLineEndings(text)))
+	if src.Len() != want.Len() || src.Lines() != want.Lines() {
+		t.Errorf("want %d runes and %d lines, got %d and %d", want.Len(), want.Lines(), src.Len(), src.Lines())
+	}
+	if snapshot.Len() != first || snapshot.Version() == src.Version() {
+		t.Errorf("want the snapshot of %d runes unchanged, got %d", first, snapshot.Len())
+	}
+	if got := snapshot.Text(); got != NormalizeLineEndings(text)[:len(got)] || len(got) != snapshot.Size() {
+		t.Error("Snapshot: text mismatch")
+	}
+}
@@ -0,0 +1,343 @@
+package buffer
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// Snapshot is an immutable view of the text of a TextSource at a version. It
+// is safe to read a snapshot from other goroutines while the source is being
+// edited.
+//
+// The snapshot of a PieceTable shares the append-only buffers of the piece
+// table, and keeps a frozen copy of the piece list, so taking a snapshot does
+// not copy the text, and reading it does not lock the piece table.
+type Snapshot struct {
+	version int
+	text    SnapshotText
+}
+
+// SnapshotText is the text of a snapshot, which must never change.
+type SnapshotText interface {
+	io.ReaderAt
+	// Len returns the length of the text in runes.
+	Len() int
+	// Size returns the size of the text in bytes.
+	Size() int
+	// ReadRuneAt reads the rune starting at the given rune offset, if any.
+	ReadRuneAt(runeOff int) (rune, error)
+	// RuneOffset returns the byte offset for the rune at position runeOff.
+	RuneOffset(runeOff int) int
+	// Lines returns the number of lines of the text.
+	Lines() int
+	// LineStart returns the rune offset of the start of the line.
+	LineStart(line int) int
+	// LineOf returns the line of the rune at runeOff.
+	LineOf(runeOff int) int
+	// LineLength returns the length in runes of the line, including the
+	// trailing line break if there is one.
+	LineLength(line int) int
+}
+
+// NewSnapshot makes a snapshot of text at the version. It is used by the text
+// sources whose text never changes, or which keep the text of the versions
+// by themselves.
+func NewSnapshot(version int, text SnapshotText) *Snapshot {
+	return &Snapshot{version: version, text: text}
+}
+
+// pieceSnapshot is the text of a snapshot of a PieceTable.
+type pieceSnapshot struct {
+	pieces []snapshotPiece
+	bufs   [2]*snapshotBuffer
+	runes  int
+	bytes  int
+	lines  int
+	// mu guards the rune offset index of the buffers, which is built lazily.
+	mu sync.Mutex
+}
+
+// snapshotPiece is a frozen piece with its position in the document.
+type snapshotPiece struct {
+	source     bufSrc
+	offset     int
+	length     int
+	byteOff    int
+	byteLength int
+	lineBreaks int
+	// position of the piece in the document.
+	pos piecePos
+}
+
+// snapshotBuffer is the part of a text buffer seen by a snapshot.
+type snapshotBuffer struct {
+	buf        []byte
+	lineBreaks []int
+	runeOffIndex
+}
+
+func newSnapshotBuffer(tb *textBuffer) *snapshotBuffer {
+	// The capacities are clipped, so that appending to the text buffer never
+	// writes to the memory seen by the snapshot, and the other way around.
+	sb := &snapshotBuffer{
+		buf:        tb.buf[:len(tb.buf):len(tb.buf)],
+		lineBreaks: tb.lineBreaks[:len(tb.lineBreaks):len(tb.lineBreaks)],
+	}
+	// The index entries already built are never changed, so they are shared
+	// until the snapshot extends the index.
+	offIndex := tb.offIndex
+	sb.runeOffIndex = runeOffIndex{src: sb, offIndex: offIndex[:len(offIndex):len(offIndex)]}
+	return sb
+}
+
+// ReadRuneAt implements [runeReader].
+func (sb *snapshotBuffer) ReadRuneAt(byteOff int64) (rune, int, error) {
+	if int(byteOff) >= len(sb.buf) {
+		return 0, 0, io.EOF
+	}
+
+	c, s := utf8.DecodeRune(sb.buf[byteOff:])
+	return c, s, nil
+}
+
+// Snapshot returns an immutable view of the current text.
+func (pt *PieceTable) Snapshot() *Snapshot {
+	// Take the write lock, as the rune offset index of the buffers may be
+	// extended by readers.
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	s := &pieceSnapshot{
+		pieces: make([]snapshotPiece, 0, pt.pieces.root.pieces()),
+		bufs:   [2]*snapshotBuffer{newSnapshotBuffer(pt.originalBuf), newSnapshotBuffer(pt.modifyBuf)},
+	}
+
+	var pos piecePos
+	for n := pt.pieces.Head(); n != pt.pieces.tail; n = n.next {
+		s.pieces = append(s.pieces, snapshotPiece{
+			source:     n.source,
+			offset:     n.offset,
+			length:     n.length,
+			byteOff:    n.byteOff,
+			byteLength: n.byteLength,
+			lineBreaks: n.lineBreaks,
+			pos:        pos,
+		})
+		pos.runes += n.length
+		pos.bytes += n.byteLength
+		pos.lines += n.lineBreaks
+	}
+	s.runes, s.bytes, s.lines = pos.runes, pos.bytes, pos.lines
+	return NewSnapshot(pt.version, s)
+}
+
+// Version returns the version of the text source when the snapshot is taken.
+// The version increases with every change of the text.
+func (s *Snapshot) Version() int {
+	return s.version
+}
+
+// Len returns the length of the text in runes.
+func (s *Snapshot) Len() int {
+	return s.text.Len()
+}
+
+// Size returns the size of the text in bytes.
+func (s *Snapshot) Size() int {
+	return s.text.Size()
+}
+
+// ReadAt implements [io.ReaderAt].
+func (s *Snapshot) ReadAt(p []byte, offset int64) (int, error) {
+	return s.text.ReadAt(p, offset)
+}
+
+// ReadRuneAt reads the rune starting at the given rune offset, if any.
+func (s *Snapshot) ReadRuneAt(runeOff int) (rune, error) {
+	return s.text.ReadRuneAt(runeOff)
+}
+
+// RuneOffset returns the byte offset for the rune at position runeOff.
+func (s *Snapshot) RuneOffset(runeOff int) int {
+	return s.text.RuneOffset(runeOff)
+}
+
+// Lines returns the number of lines of the text. The text after the last line
+// break is counted as a line if it is not empty.
+func (s *Snapshot) Lines() int {
+	return s.text.Lines()
+}
+
+// LineStart returns the rune offset of the start of the line. Lines are
+// counted from zero, and the line after the last line break is always valid,
+// even if it is empty. Lines out of range are clamped.
+func (s *Snapshot) LineStart(line int) int {
+	return s.text.LineStart(line)
+}
+
+// LineOf returns the line of the rune at runeOff. Lines are counted from zero.
+func (s *Snapshot) LineOf(runeOff int) int {
+	return s.text.LineOf(runeOff)
+}
+
+// LineLength returns the length in runes of the line, including the trailing
+// line break if there is one.
+func (s *Snapshot) LineLength(line int) int {
+	return s.text.LineLength(line)
+}
+
+// Text returns the text of the snapshot.
+func (s *Snapshot) Text() string {
+	buf := make([]byte, s.text.Size())
+	n, _ := s.text.ReadAt(buf, 0)
+	return string(buf[:n])
+}
+
+func (s *pieceSnapshot) Len() int {
+	return s.runes
+}
+
+func (s *pieceSnapshot) Size() int {
+	return s.bytes
+}
+
+// seek finds the index of the piece containing the rune at runeOff, and the
+// rune offset in it. It returns the number of pieces if runeOff reaches the
+// end of the text.
+func (s *pieceSnapshot) seek(runeOff int) (int, int) {
+	i := sort.Search(len(s.pieces), func(i int) bool {
+		p := &s.pieces[i]
+		return runeOff < p.pos.runes+p.length
+	})
+	if i == len(s.pieces) {
+		return i, 0
+	}
+	return i, runeOff - s.pieces[i].pos.runes
+}
+
+// pieceRuneOffset returns the byte offset in the buffer of the rune at off in
+// the piece.
+func (s *pieceSnapshot) pieceRuneOffset(p *snapshotPiece, off int) int {
+	if off == 0 {
+		return p.byteOff
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bufs[p.source].RuneOffset(p.offset + off)
+}
+
+// ReadAt implements [io.ReaderAt].
+func (s *pieceSnapshot) ReadAt(p []byte, offset int64) (total int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if offset < 0 || offset >= int64(s.bytes) {
+		return 0, io.EOF
+	}
+
+	i := sort.Search(len(s.pieces), func(i int) bool {
+		n := &s.pieces[i]
+		return int(offset) < n.pos.bytes+n.byteLength
+	})
+
+	bytesOff := int(offset) - s.pieces[i].pos.bytes
+	for ; i < len(s.pieces) && total < len(p); i++ {
+		n := &s.pieces[i]
+		buf := s.bufs[n.source].buf
+		total += copy(p[total:], buf[n.byteOff+bytesOff:n.byteOff+n.byteLength])
+		bytesOff = 0
+	}
+
+	if total < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+func (s *pieceSnapshot) ReadRuneAt(runeOff int) (rune, error) {
+	if runeOff < 0 {
+		return 0, io.EOF
+	}
+	i, off := s.seek(runeOff)
+	if i == len(s.pieces) {
+		return 0, io.EOF
+	}
+
+	p := &s.pieces[i]
+	r, size := utf8.DecodeRune(s.bufs[p.source].buf[s.pieceRuneOffset(p, off):])
+	if r == utf8.RuneError && size == 1 {
+		return r, errReadRune
+	}
+	return r, nil
+}
+
+func (s *pieceSnapshot) RuneOffset(runeOff int) int {
+	if runeOff <= 0 {
+		return 0
+	}
+	i, off := s.seek(runeOff)
+	if i == len(s.pieces) {
+		return s.bytes
+	}
+
+	p := &s.pieces[i]
+	return p.pos.bytes + s.pieceRuneOffset(p, off) - p.byteOff
+}
+
+func (s *pieceSnapshot) Lines() int {
+	if s.runes == 0 {
+		return 0
+	}
+
+	lines := s.lines
+	if r, _ := s.ReadRuneAt(s.runes - 1); r != lineBreak {
+		lines++
+	}
+	return lines
+}
+
+func (s *pieceSnapshot) LineStart(line int) int {
+	if line <= 0 {
+		return 0
+	}
+
+	// The line starts after the line break of the previous line.
+	i := sort.Search(len(s.pieces), func(i int) bool {
+		p := &s.pieces[i]
+		return line <= p.pos.lines+p.lineBreaks
+	})
+	if i == len(s.pieces) {
+		return s.runes
+	}
+
+	p := &s.pieces[i]
+	lineBreaks := s.bufs[p.source].lineBreaks
+	nth := line - p.pos.lines
+	lineBreakOff := lineBreaks[sort.SearchInts(lineBreaks, p.offset)+nth-1]
+	return p.pos.runes + lineBreakOff - p.offset + 1
+}
+
+func (s *pieceSnapshot) LineOf(runeOff int) int {
+	if runeOff <= 0 {
+		return 0
+	}
+	i, off := s.seek(runeOff)
+	if i == len(s.pieces) {
+		return s.lines
+	}
+
+	p := &s.pieces[i]
+	lineBreaks := s.bufs[p.source].lineBreaks
+	return p.pos.lines + sort.SearchInts(lineBreaks, p.offset+off) - sort.SearchInts(lineBreaks, p.offset)
+}
+
+func (s *pieceSnapshot) LineLength(line int) int {
+	if line < 0 {
+		return 0
+	}
+
+	return s.LineStart(line+1) - s.LineStart(line)
+}
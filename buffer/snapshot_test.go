@@ -0,0 +1,106 @@
+package buffer
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// checkSnapshot compares the snapshot with a piece table of the same text.
+func checkSnapshot(t *testing.T, s *Snapshot, want string) {
+	t.Helper()
+	pt := NewPieceTable([]byte(want))
+
+	if got := s.Text(); got != want {
+		t.Fatalf("want text %q, got %q", want, got)
+	}
+	if s.Len() != pt.Len() || s.Size() != pt.Size() || s.Lines() != pt.Lines() {
+		t.Errorf("want len %d, size %d and lines %d, got %d, %d and %d",
+			pt.Len(), pt.Size(), pt.Lines(), s.Len(), s.Size(), s.Lines())
+	}
+	if _, err := s.ReadRuneAt(pt.Len()); err != io.EOF {
+		t.Errorf("want EOF at the end, got %v", err)
+	}
+	for i := 0; i <= pt.Len(); i++ {
+		if i < pt.Len() {
+			wantRune, _ := pt.ReadRuneAt(i)
+			if r, _ := s.ReadRuneAt(i); r != wantRune {
+				t.Errorf("rune %d: want %q, got %q", i, wantRune, r)
+			}
+		}
+		if s.RuneOffset(i) != pt.RuneOffset(i) {
+			t.Errorf("rune %d: want byte offset %d, got %d", i, pt.RuneOffset(i), s.RuneOffset(i))
+		}
+		if s.LineOf(i) != pt.LineOf(i) {
+			t.Errorf("rune %d: want line %d, got %d", i, pt.LineOf(i), s.LineOf(i))
+		}
+	}
+	for line := -1; line <= pt.Lines()+1; line++ {
+		if s.LineStart(line) != pt.LineStart(line) || s.LineLength(line) != pt.LineLength(line) {
+			t.Errorf("line %d: want start %d and length %d, got %d and %d", line,
+				pt.LineStart(line), pt.LineLength(line), s.LineStart(line), s.LineLength(line))
+		}
+	}
+
+	for off := 0; off <= len(want); off++ {
+		buf := make([]byte, 3)
+		n, err := s.ReadAt(buf, int64(off))
+		end := min(off+3, len(want))
+		if string(buf[:n]) != want[off:end] || (n < 3) != (err == io.EOF) {
+			t.Errorf("read at %d: want %q, got %q, %v", off, want[off:end], buf[:n], err)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	pt := NewPieceTable([]byte("Hello\nworld"))
+	pt.Replace(5, 5, ", 世界")
+	pt.Replace(0, 1, "h")
+	pt.Replace(14, 14, "!\n")
+
+	want := readTableContent(pt)
+	s := pt.Snapshot()
+	checkSnapshot(t, s, want)
+
+	// Edits after the snapshot are not seen by it.
+	version := s.Version()
+	pt.Replace(0, 3, "")
+	pt.Replace(pt.Len(), pt.Len(), "more\ntext")
+	pt.Undo()
+	pt.SetText([]byte("reset"))
+	checkSnapshot(t, s, want)
+	if s.Version() != version {
+		t.Errorf("want version %d, got %d", version, s.Version())
+	}
+	if pt.Version() <= version {
+		t.Errorf("want the version of the piece table increased, got %d", pt.Version())
+	}
+
+	checkSnapshot(t, NewPieceTable(nil).Snapshot(), "")
+}
+
+func TestSnapshotConcurrentEdits(t *testing.T) {
+	pt := NewPieceTable([]byte(strings.Repeat("line\n", 100)))
+	s := pt.Snapshot()
+	want := readTableContent(pt)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < s.Len(); i += 7 {
+				s.ReadRuneAt(i)
+				s.LineOf(i)
+			}
+			if s.Text() != want {
+				t.Error("snapshot changed")
+			}
+		}()
+	}
+	for i := range 200 {
+		pt.Replace(i, i, "x")
+	}
+	wg.Wait()
+}
@@ -0,0 +1,123 @@
+// Package buffer provides the text storage of the editor. PieceTable is the
+// default TextSource, which supports editing with an undo history, and
+// ReadOnlySource views very large files without loading them into memory.
+package buffer
+
+import "io"
+
+// TextSource provides data for editor.
+//
+// Basic editing operations, such as insert, delete, replace,
+// undo/redo are supported. If used with GroupOp and UnGroupOp,
+// the undo and redo operations can be batched.
+//
+// A custom TextSource can be provided to the editor to store the text in other
+// ways. Rune offsets and lines are counted the same way as PieceTable, where
+// "\n" is the only line break.
+type TextSource interface {
+	io.ReaderAt
+
+	// ReadRuneAt reads the rune starting at the given rune offset, if any.
+	ReadRuneAt(runeOff int) (rune, error)
+
+	// RuneOffset returns the byte offset for the rune at position runeIndex.
+	RuneOffset(runeIndex int) int
+
+	// Lines returns the total number of lines/paragraphs of the source.
+	Lines() int
+
+	// LineStart returns the rune offset of the start of the line. Lines are
+	// counted from zero.
+	LineStart(line int) int
+
+	// LineOf returns the line of the rune at runeOff.
+	LineOf(runeOff int) int
+
+	// LineLength returns the length in runes of the line, including the line
+	// break.
+	LineLength(line int) int
+
+	// Len is the length of the editor contents, in runes.
+	Len() int
+
+	// Size returns the size of the editor content in bytes.
+	Size() int
+
+	// SetText reset the buffer and replace the content of the buffer with the provided text.
+	SetText(text []byte)
+	// ReadFrom resets the buffer with the text read from r until EOF.
+	io.ReaderFrom
+	// WriteTo writes the content of the buffer to w.
+	io.WriterTo
+
+	// Replace replace text from startOff to endOff(exclusive) with text.
+	Replace(startOff, endOff int, text string) bool
+	// Append adds text to the end without recording it in the undo history,
+	// which keeps the changes made before. It is meant for text streamed in,
+	// like the output of a build.
+	Append(text string) bool
+	// TrimLines removes the first n lines, including their line breaks, the
+	// same way as Append. The undo history is cleared if the changes in it can
+	// no longer be undone. It returns the number of runes removed.
+	TrimLines(n int) int
+
+	// CreateMarker adds a new marker at position runeOff, with the specified bais. A bais
+	// controlls how the markers move when the insertion/deletion happens at the boundary location
+	// of the marker.
+	CreateMarker(runeOff int, bais MarkerBias) (*Marker, error)
+	// RemoveMarker removes a marker from the text source.
+	RemoveMarker(m *Marker)
+
+	// Undo the last insert, erase, or replace, or a group of operations.
+	// It returns all the cursor positions after undo.
+	Undo() ([]CursorPos, bool)
+	// Redo the last insert, erase, or replace, or a group of operations.
+	// It returns all the cursor positions after undo.
+	Redo() ([]CursorPos, bool)
+
+	// History returns the changes in the undo history, ordered by the sequence
+	// numbers, and the sequence number of the current state.
+	History() ([]HistoryNode, int)
+	// GotoHistory restores the state after the change of seq, or the original
+	// state if seq is 0. It returns the cursor positions of the last undone or
+	// redone operations.
+	GotoHistory(seq int) ([]CursorPos, bool)
+	// SaveHistory writes the undo history to w.
+	SaveHistory(w io.Writer) error
+	// LoadHistory restores the undo history saved by SaveHistory. It fails
+	// with ErrHistoryMismatch if the history is not saved for the current text.
+	LoadHistory(r io.Reader) error
+
+	// Group operations such as insert, earase or replace in a batch.
+	// Nested call share the same single batch.
+	GroupOp()
+
+	// Ungroup a batch. Latter insert, earase or replace operations outside of
+	// a group is not batched.
+	UnGroupOp()
+
+	// MarkSaved marks the current state of the contents as saved.
+	MarkSaved()
+	// Modified reports whether the contents differ from the state last marked
+	// saved, even across undo and redo.
+	Modified() bool
+
+	// Changed report whether the contents have changed since the last call to Changed.
+	Changed() bool
+	// Version returns the version of the contents, which increases with every
+	// change.
+	Version() int
+	// Snapshot returns an immutable view of the current contents, which can be
+	// read from other goroutines without racing the edits.
+	Snapshot() *Snapshot
+	// Subscribe registers fn to be called with every change of the text. Call
+	// the returned function to unsubscribe.
+	Subscribe(fn func(TextChange)) (cancel func())
+}
+
+type TextReader interface {
+	io.Seeker
+	io.Reader
+	//ReadAll returns the contents of the editor.
+	ReadAll(buf []byte) []byte
+}
@@ -0,0 +1,64 @@
+package gvcode
+
+import (
+	"github.com/oligo/gvcode/buffer"
+)
+
+// TextChange describes a change of the text, which replaces the text from
+// Start to Start+RemovedRunes with Text. It carries both the rune and byte
+// offsets, and the line and column positions, so that consumers like syntax
+// highlighters or language servers can update incrementally.
+type TextChange = buffer.TextChange
+
+// TextPos is a position in the text by line and column, both counted from
+// zero. The column is measured in runes.
+type TextPos = buffer.TextPos
+
+// ChangeOrigin tells whether a change is made by an edit, an undo or a redo.
+type ChangeOrigin = buffer.ChangeOrigin
+
+const (
+	ChangeEdit = buffer.ChangeEdit
+	ChangeUndo = buffer.ChangeUndo
+	ChangeRedo = buffer.ChangeRedo
+)
+
+// Subscribe registers fn to be called with every change of the text, made by
+// the user or by the program, such as SetText and Insert. fn is called
+// synchronously after the change is made, and may read the editor but should
+// not change the text. Call the returned function to unsubscribe.
+//
+// Unlike ChangeEvent, which is returned by Update for the user changes only,
+// subscribers see all the changes, which is preferable for consumers that
+// mirror the text, such as language servers.
+func (e *Editor) Subscribe(fn func(TextChange)) (cancel func()) {
+	e.initBuffer()
+	return e.buffer.Subscribe(fn)
+}
+
+// onTextChange collects the changes to be delivered with ChangeEvent, and
+// passes them to the highlighter and the ongoing search.
+func (e *Editor) onTextChange(c TextChange) {
+	e.changes = append(e.changes, c)
+	e.updateLineEndings(c)
+	e.findOnTextChange(c)
+	if e.highlighter != nil {
+		e.highlighter.Edit(c)
+	}
+}
+
+// takeChanges returns the changes collected, and clears them.
+func (e *Editor) takeChanges() []TextChange {
+	changes := e.changes
+	e.changes = nil
+	return changes
+}
+
+// withChanges fills the changes collected to evt if it is a ChangeEvent.
+func (e *Editor) withChanges(evt EditorEvent) EditorEvent {
+	if c, ok := evt.(ChangeEvent); ok && c.Changes == nil {
+		c.Changes = e.takeChanges()
+		return c
+	}
+	return evt
+}
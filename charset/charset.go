@@ -0,0 +1,317 @@
+// Package charset detects the text encodings of documents, and transcodes them
+// from and to UTF-8, which is the only encoding used inside of the editor.
+package charset
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/transform"
+)
+
+// Encoding is the text encoding of a document.
+type Encoding struct {
+	// Name is the IANA name of the encoding, such as "UTF-8", "UTF-16LE",
+	// "Shift_JIS" or "ISO-8859-1".
+	Name string
+	// BOM reports whether the document starts with a byte order mark, which
+	// is only used by the UTF encodings.
+	BOM bool
+}
+
+// UTF8 is the UTF-8 encoding without a BOM, which is the default encoding.
+var UTF8 = Encoding{Name: "UTF-8"}
+
+func (e Encoding) String() string {
+	if e.BOM {
+		return e.Name + " with BOM"
+	}
+	return e.Name
+}
+
+// InvalidSequence is a sequence of bytes that is not valid in the encoding.
+// It is replaced by U+FFFD in the decoded text.
+type InvalidSequence struct {
+	// Offset is the byte offset of the sequence in the encoded data.
+	Offset int
+	// Rune is the rune offset of the replacement in the decoded text.
+	Rune int
+}
+
+var boms = []struct {
+	name string
+	bom  []byte
+}{
+	{"UTF-8", []byte{0xEF, 0xBB, 0xBF}},
+	{"UTF-16LE", []byte{0xFF, 0xFE}},
+	{"UTF-16BE", []byte{0xFE, 0xFF}},
+}
+
+// bom returns the byte order mark of the encoding.
+func (e Encoding) bom() []byte {
+	for _, b := range boms {
+		if b.name == e.Name {
+			return b.bom
+		}
+	}
+	return nil
+}
+
+// Lookup returns the encoding of the IANA name or alias, such as "utf-16le",
+// "sjis" or "latin1". The returned encoding has no BOM.
+func Lookup(name string) (Encoding, bool) {
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		return Encoding{}, false
+	}
+	// Prefer the MIME names, which are the ones commonly used.
+	canonical, err := ianaindex.MIME.Name(enc)
+	if err != nil {
+		if canonical, err = ianaindex.IANA.Name(enc); err != nil {
+			return Encoding{}, false
+		}
+	}
+	return Encoding{Name: canonical}, true
+}
+
+func (e Encoding) encoding() (encoding.Encoding, error) {
+	enc, err := ianaindex.IANA.Encoding(e.Name)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("charset: unsupported encoding %q", e.Name)
+	}
+	return enc, nil
+}
+
+// Detect guesses the encoding of data. A BOM is trusted if there is one.
+// Otherwise the data is sniffed for UTF-16, UTF-8 and Shift_JIS in turn, and
+// ISO-8859-1 is assumed if none of them fits, as it decodes any bytes.
+func Detect(data []byte) Encoding {
+	return detect(data, false)
+}
+
+// DetectPrefix is like Detect, but data is the first part of a document, which
+// may end in the middle of a character.
+func DetectPrefix(data []byte) Encoding {
+	return detect(data, true)
+}
+
+func detect(data []byte, partial bool) Encoding {
+	for _, b := range boms {
+		if bytes.HasPrefix(data, b.bom) {
+			return Encoding{Name: b.name, BOM: true}
+		}
+	}
+
+	if name := sniffUTF16(data); name != "" {
+		return Encoding{Name: name}
+	}
+	if utf8.Valid(data) || partial && utf8.Valid(trimPartialRune(data)) {
+		return UTF8
+	}
+	if isShiftJIS(data, partial) {
+		return Encoding{Name: "Shift_JIS"}
+	}
+	return Encoding{Name: "ISO-8859-1"}
+}
+
+// trimPartialRune trims the incomplete UTF-8 encoding of a rune at the end of
+// data, if any.
+func trimPartialRune(data []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if b := data[len(data)-i]; utf8.RuneStart(b) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// sniffUTF16 detects UTF-16 text without a BOM by the zero bytes of the ASCII
+// characters, which are mostly at odd offsets for little endian, and at even
+// offsets for big endian.
+func sniffUTF16(data []byte) string {
+	const sniffLen = 4096
+	data = data[:min(len(data), sniffLen)]
+	if len(data) < 2 || len(data)%2 != 0 {
+		return ""
+	}
+
+	var zeros [2]int
+	for i, b := range data {
+		if b == 0 {
+			zeros[i%2]++
+		}
+	}
+
+	units := len(data) / 2
+	switch {
+	case zeros[1] > units*2/5 && zeros[0] <= units/10:
+		return "UTF-16LE"
+	case zeros[0] > units*2/5 && zeros[1] <= units/10:
+		return "UTF-16BE"
+	}
+	return ""
+}
+
+// isShiftJIS reports whether data is valid Shift_JIS with double-byte
+// characters, which are unlikely to be formed by the accented letters of the
+// single-byte encodings. If data is partial, it may end with the first byte of
+// a double-byte character.
+func isShiftJIS(data []byte, partial bool) bool {
+	doubleBytes := 0
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case b < 0x80, b >= 0xA1 && b <= 0xDF:
+			// ASCII or half-width katakana.
+		case (b >= 0x81 && b <= 0x9F) || (b >= 0xE0 && b <= 0xFC):
+			if i+1 >= len(data) {
+				return partial && doubleBytes > 0
+			}
+			if t := data[i+1]; t < 0x40 || t == 0x7F || t > 0xFC {
+				return false
+			}
+			doubleBytes++
+			i++
+		default:
+			return false
+		}
+	}
+	return doubleBytes > 0
+}
+
+// Decode decodes data in the encoding to UTF-8, stripping the BOM if the
+// encoding has one. The invalid sequences are replaced by U+FFFD, and
+// reported by their positions.
+func Decode(data []byte, enc Encoding) (string, []InvalidSequence, error) {
+	offset := 0
+	if bom := enc.bom(); enc.BOM && bytes.HasPrefix(data, bom) {
+		offset = len(bom)
+		data = data[offset:]
+	}
+
+	if enc.Name == UTF8.Name {
+		if utf8.Valid(data) {
+			return string(data), nil, nil
+		}
+		return decodeUTF8(data, offset)
+	}
+
+	e, err := enc.encoding()
+	if err != nil {
+		return "", nil, err
+	}
+	decoded, _, err := transform.Bytes(e.NewDecoder(), data)
+	if err != nil {
+		return "", nil, err
+	}
+	if !bytes.ContainsRune(decoded, utf8.RuneError) {
+		return string(decoded), nil, nil
+	}
+
+	// Find out the invalid sequences, which are replaced by U+FFFD, by
+	// decoding a character at a time.
+	replacement, _ := e.NewEncoder().Bytes([]byte(string(utf8.RuneError)))
+	dec := e.NewDecoder()
+	var buf bytes.Buffer
+	var invalid []InvalidSequence
+	var dst [64]byte
+	runes := 0
+	for i := 0; i < len(data); {
+		for n := 1; ; n++ {
+			end := min(i+n, len(data))
+			nDst, nSrc, err := dec.Transform(dst[:], data[i:end], end == len(data))
+			if err == transform.ErrShortSrc && end < len(data) {
+				continue
+			}
+			if nSrc == 0 {
+				return "", nil, fmt.Errorf("charset: cannot decode %s: %v", enc.Name, err)
+			}
+
+			out := dst[:nDst]
+			if r, _ := utf8.DecodeRune(out); r == utf8.RuneError && !bytes.Equal(data[i:i+nSrc], replacement) {
+				invalid = append(invalid, InvalidSequence{Offset: offset + i, Rune: runes})
+			}
+			buf.Write(out)
+			runes += utf8.RuneCount(out)
+			i += nSrc
+			break
+		}
+	}
+	return buf.String(), invalid, nil
+}
+
+func decodeUTF8(data []byte, offset int) (string, []InvalidSequence, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	var invalid []InvalidSequence
+	runes := 0
+	for i := 0; i < len(data); runes++ {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			invalid = append(invalid, InvalidSequence{Offset: offset + i, Rune: runes})
+		}
+		buf.WriteRune(r)
+		i += size
+	}
+	return buf.String(), invalid, nil
+}
+
+// Encode encodes UTF-8 text in the encoding, prefixed with the BOM if the
+// encoding has one. It fails if any rune of text is not supported by the
+// encoding.
+func Encode(text string, enc Encoding) ([]byte, error) {
+	var out []byte
+	if enc.BOM {
+		out = append(out, enc.bom()...)
+	}
+	if enc.Name == UTF8.Name {
+		return append(out, text...), nil
+	}
+
+	e, err := enc.encoding()
+	if err != nil {
+		return nil, err
+	}
+	encoded, n, err := transform.Bytes(e.NewEncoder(), []byte(text))
+	if err != nil {
+		return nil, fmt.Errorf("charset: cannot encode the rune at byte %d in %s: %v", n, enc.Name, err)
+	}
+	return append(out, encoded...), nil
+}
+
+// NewWriter returns a writer that encodes the UTF-8 text written to it in enc,
+// and writes it to w. The BOM is written first if the encoding has one. Close
+// must be called after the text is written to flush the encoder, but it does
+// not close w.
+func NewWriter(w io.Writer, enc Encoding) (io.WriteCloser, error) {
+	var e encoding.Encoding
+	if enc.Name != UTF8.Name {
+		var err error
+		if e, err = enc.encoding(); err != nil {
+			return nil, err
+		}
+	}
+
+	if enc.BOM {
+		if _, err := w.Write(enc.bom()); err != nil {
+			return nil, err
+		}
+	}
+	if e == nil {
+		return nopCloser{w}, nil
+	}
+	return transform.NewWriter(w, e.NewEncoder()), nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
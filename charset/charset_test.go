@@ -0,0 +1,154 @@
+package charset
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want Encoding
+	}{
+		{"empty", nil, UTF8},
+		{"ascii", []byte("hello"), UTF8},
+		{"utf-8", []byte("héllo 世界"), UTF8},
+		{"utf-8 bom", []byte("\xEF\xBB\xBFhello"), Encoding{Name: "UTF-8", BOM: true}},
+		{"utf-16le bom", []byte("\xFF\xFEh\x00i\x00"), Encoding{Name: "UTF-16LE", BOM: true}},
+		{"utf-16be bom", []byte("\xFE\xFF\x00h\x00i"), Encoding{Name: "UTF-16BE", BOM: true}},
+		{"utf-16le", []byte("k\x00e\x00y\x00=\x001\x00"), Encoding{Name: "UTF-16LE"}},
+		{"utf-16be", []byte("\x00k\x00e\x00y\x00=\x001"), Encoding{Name: "UTF-16BE"}},
+		// "設定=1" in Shift_JIS.
+		{"shift_jis", []byte("\x90\xdd\x92\xe8=1"), Encoding{Name: "Shift_JIS"}},
+		// "Über café" in Latin-1.
+		{"latin-1", []byte("\xdcber caf\xe9"), Encoding{Name: "ISO-8859-1"}},
+	}
+
+	for _, c := range cases {
+		if got := Detect(c.data); got != c.want {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestDetectPrefix(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want Encoding
+	}{
+		// "héllo 世" cut in the middle of "世".
+		{"utf-8", []byte("héllo \xE4\xB8"), UTF8},
+		// "設定" cut after the first byte of "定".
+		{"shift_jis", []byte("\x90\xdd\x92"), Encoding{Name: "Shift_JIS"}},
+		{"latin-1", []byte("caf\xe9 \xE4\xB8"), Encoding{Name: "ISO-8859-1"}},
+	}
+
+	for _, c := range cases {
+		if got := DetectPrefix(c.data); got != c.want {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+	if got := Detect([]byte("héllo \xE4\xB8")); got == UTF8 {
+		t.Error("want a complete document with a partial rune not to be UTF-8")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		data []byte
+		text string
+	}{
+		{[]byte("\xEF\xBB\xBFhi"), "hi"},
+		{[]byte("\xFF\xFEh\x00i\x00"), "hi"},
+		{[]byte("\x00k\x00e\x00y"), "key"},
+		{[]byte("\x90\xdd\x92\xe8=1"), "設定=1"},
+		{[]byte("\xdcber caf\xe9"), "Über café"},
+	}
+
+	for _, c := range cases {
+		enc := Detect(c.data)
+		text, invalid, err := Decode(c.data, enc)
+		if err != nil || text != c.text || len(invalid) != 0 {
+			t.Errorf("%v: want %q, got %q, %v, %v", enc, c.text, text, invalid, err)
+			continue
+		}
+		data, err := Encode(text, enc)
+		if err != nil || !bytes.Equal(data, c.data) {
+			t.Errorf("%v: want % x, got % x, %v", enc, c.data, data, err)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	text, invalid, err := Decode([]byte("a\xffb\xfe"), UTF8)
+	if err != nil || text != "a�b�" {
+		t.Fatalf("got %q, %v", text, err)
+	}
+	want := []InvalidSequence{{Offset: 1, Rune: 1}, {Offset: 3, Rune: 3}}
+	if len(invalid) != len(want) || invalid[0] != want[0] || invalid[1] != want[1] {
+		t.Errorf("want %v, got %v", want, invalid)
+	}
+
+	// A valid lead byte followed by an invalid trail byte in Shift_JIS.
+	sjis := Encoding{Name: "Shift_JIS"}
+	text, invalid, err = Decode([]byte("\x90\xdd\x81\x20x"), sjis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invalid) != 1 || invalid[0].Offset != 2 || invalid[0].Rune != 1 {
+		t.Errorf("want the invalid sequence at 2, got %v in %q", invalid, text)
+	}
+
+	// U+FFFD encoded in UTF-16 is valid.
+	_, invalid, _ = Decode([]byte("\xFD\xFFa\x00"), Encoding{Name: "UTF-16LE"})
+	if len(invalid) != 0 {
+		t.Errorf("want no invalid sequences, got %v", invalid)
+	}
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	if _, err := Encode("世界", Encoding{Name: "ISO-8859-1"}); err == nil {
+		t.Error("want an error for the runes not supported")
+	}
+	if enc, ok := Lookup("latin1"); !ok || enc.Name != "ISO-8859-1" {
+		t.Errorf("want ISO-8859-1, got %v", enc)
+	}
+	if _, ok := Lookup("no-such-encoding"); ok {
+		t.Error("want unknown encodings rejected")
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	cases := []struct {
+		enc  Encoding
+		text string
+		want []byte
+	}{
+		{UTF8, "hi", []byte("hi")},
+		{Encoding{Name: "UTF-8", BOM: true}, "hi", []byte("\xEF\xBB\xBFhi")},
+		{Encoding{Name: "UTF-16LE", BOM: true}, "hi", []byte("\xFF\xFEh\x00i\x00")},
+		{Encoding{Name: "Shift_JIS"}, "設定", []byte("\x90\xdd\x92\xe8")},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, c.enc)
+		if err != nil {
+			t.Errorf("%v: %v", c.enc, err)
+			continue
+		}
+		// Write a rune at a time to check the encoder keeps the state.
+		for _, r := range c.text {
+			w.Write([]byte(string(r)))
+		}
+		if err := w.Close(); err != nil || !bytes.Equal(buf.Bytes(), c.want) {
+			t.Errorf("%v: want % x, got % x, %v", c.enc, c.want, buf.Bytes(), err)
+		}
+	}
+
+	if _, err := NewWriter(&bytes.Buffer{}, Encoding{Name: "no-such-encoding"}); err == nil {
+		t.Error("want an error for an unknown encoding")
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"image/color"
-	"slices"
 	"strconv"
 	"strings"
 
@@ -105,6 +104,13 @@ func parseHexByte(hexStr string) (uint8, error) {
 	return uint8(val), nil
 }
 
+// MaxColors is the number of colors a palette can hold.
+const MaxColors = 1 << 20
+
+// ErrPaletteFull is returned when adding a color to a palette holding
+// MaxColors colors.
+var ErrPaletteFull = errors.New("color: the palette is full")
+
 // ColorPalette manages used color of TextPainter. Color is added and referenced by its
 // ID(index) in the palette.
 type ColorPalette struct {
@@ -120,6 +126,8 @@ type ColorPalette struct {
 	LineNumberColor Color
 	// Other colors.
 	colors []Color
+	// ids maps the colors to their IDs.
+	ids map[uint32]int
 }
 
 // GetColor retrieves a Color by its ID. ID can be acquired when adding the color to
@@ -132,17 +140,34 @@ func (p *ColorPalette) GetColor(id int) Color {
 	return p.colors[id]
 }
 
-// AddColor adds a color to the palette and return its id(index).
-func (p *ColorPalette) AddColor(cl Color) int {
-	if idx := slices.IndexFunc(p.colors, func(c Color) bool { return c.val == cl.val }); idx >= 0 {
-		return idx
+// AddColor adds a color to the palette and return its id(index). A color
+// already in the palette keeps its id. It returns ErrPaletteFull if the
+// palette already holds MaxColors colors.
+func (p *ColorPalette) AddColor(cl Color) (int, error) {
+	// The palette may be copied, sharing the ids with the copy, so the id is
+	// checked against the colors.
+	if idx, ok := p.ids[cl.val]; ok && idx < len(p.colors) && p.colors[idx].val == cl.val {
+		return idx, nil
+	}
+	if len(p.colors) >= MaxColors {
+		return -1, ErrPaletteFull
 	}
 
+	if p.ids == nil || len(p.ids) > len(p.colors) {
+		p.ids = make(map[uint32]int, len(p.colors)+1)
+		for idx, c := range p.colors {
+			if _, ok := p.ids[c.val]; !ok {
+				p.ids[c.val] = idx
+			}
+		}
+	}
 	p.colors = append(p.colors, cl)
-	return len(p.colors) - 1
+	p.ids[cl.val] = len(p.colors) - 1
+	return len(p.colors) - 1, nil
 }
 
 // Clear clear all added colors.
 func (p *ColorPalette) Clear() {
 	p.colors = p.colors[:0]
+	p.ids = nil
 }
@@ -3,11 +3,10 @@ package gvcode
 import (
 	"slices"
 
-	"gioui.org/io/clipboard"
 	"gioui.org/io/key"
-	"gioui.org/io/system"
 	"gioui.org/layout"
-	"github.com/oligo/gvcode/textview"
+	"gioui.org/op"
+	"github.com/oligo/gvcode/keymap"
 )
 
 // CommandHandler defines a callback function for the specific key event. It returns
@@ -65,293 +64,107 @@ func (e *Editor) RemoveCommands(tag any) {
 	}
 }
 
+// buildBuiltinCommands registers the key bindings of the keymap as the builtin
+// commands, which come before the commands of any tag.
 func (e *Editor) buildBuiltinCommands() {
 	if e.commands == nil {
 		e.commands = make(map[key.Name][]keyCommand)
 	}
-	clear(e.commands)
-
-	registerCommand := func(filter key.Filter, handler CommandHandler) {
-		filter.Focus = e
-		e.commands[filter.Name] = append(e.commands[filter.Name], keyCommand{filter: filter, handler: handler})
+	// Remove the builtin commands of the previous keymap.
+	for name, cmds := range e.commands {
+		e.commands[name] = slices.DeleteFunc(cmds, func(cmd keyCommand) bool { return cmd.tag == nil })
+		if len(e.commands[name]) == 0 {
+			delete(e.commands, name)
+		}
 	}
 
-	registerCommand(key.Filter{Focus: e, Name: key.NameEnter, Optional: key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			return e.onInsertLineBreak(evt)
-		},
-	)
-
-	registerCommand(key.Filter{Focus: e, Name: key.NameReturn, Optional: key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			return e.onInsertLineBreak(evt)
-		},
-	)
-
-	registerCommand(key.Filter{Focus: e, Name: "C", Required: key.ModShortcut},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			return e.onCopyCut(gtx, evt)
-		},
-	)
-
-	// Initiate a paste operation, by requesting the clipboard contents; other
-	// half is in Editor.processKey() under clipboard.Event.
-	registerCommand(key.Filter{Focus: e, Name: "V", Required: key.ModShortcut},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			if e.mode != ModeReadOnly {
-				gtx.Execute(clipboard.ReadCmd{Tag: e})
-			}
-			return nil
-		})
-
-	registerCommand(key.Filter{Focus: e, Name: "X", Required: key.ModShortcut},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			return e.onCopyCut(gtx, evt)
-		})
-
-	registerCommand(key.Filter{Focus: e, Name: "Z", Required: key.ModShortcut, Optional: key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			if e.mode != ModeReadOnly {
-				if evt.Modifiers.Contain(key.ModShift) {
-					if ev, ok := e.redo(); ok {
-						return ev
-					}
-				} else {
-					if ev, ok := e.undo(); ok {
-						return ev
-					}
-				}
-			}
-			return nil
-		})
-
-	registerCommand(key.Filter{Focus: e, Name: "A", Required: key.ModShortcut},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			e.text.SetCaret(0, e.text.Len())
-			return nil
-		})
-
-	registerCommand(key.Filter{Focus: e, Name: key.NameHome, Optional: key.ModShortcut | key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			selAct := textview.SelectionClear
-			if evt.Modifiers.Contain(key.ModShift) {
-				selAct = textview.SelectionExtend
-			}
-
-			if evt.Modifiers.Contain(key.ModShortcut) {
-				e.text.MoveTextStart(selAct)
-			} else {
-				e.text.MoveLineStart(selAct)
-			}
-			return nil
-		})
-
-	registerCommand(key.Filter{Focus: e, Name: key.NameEnd, Optional: key.ModShortcut | key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			selAct := textview.SelectionClear
-			if evt.Modifiers.Contain(key.ModShift) {
-				selAct = textview.SelectionExtend
-			}
-			if evt.Modifiers.Contain(key.ModShortcut) {
-				e.text.MoveTextEnd(selAct)
-			} else {
-				e.text.MoveLineEnd(selAct)
-			}
-			return nil
-		})
-
-	registerCommand(key.Filter{Focus: e, Name: key.NameTab, Optional: key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			return e.onTab(evt)
-		})
-
-	registerCommand(key.Filter{Focus: e, Name: key.NameDeleteBackward, Optional: key.ModShortcutAlt | key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			if e.mode != ModeReadOnly {
-				moveByWord := evt.Modifiers.Contain(key.ModShortcutAlt)
-
-				if moveByWord {
-					if e.deleteWord(-1) != 0 {
-						return ChangeEvent{}
-					}
-				} else {
-					if e.Delete(-1) != 0 {
-						return ChangeEvent{}
-					}
-				}
-			}
-			return nil
-		})
+	km := e.Keymap()
+	e.keymapRevision = km.Revision()
+	e.chord = e.chord[:0]
+	for _, filter := range km.Filters(e) {
+		cmd := keyCommand{filter: filter, handler: e.onKeyStroke}
+		e.commands[filter.Name] = slices.Insert(e.commands[filter.Name], 0, cmd)
+	}
+	e.chordCommands = e.chordCommands[:0]
+	for _, filter := range km.ChordFilters(e) {
+		e.chordCommands = append(e.chordCommands, keyCommand{filter: filter, handler: e.onKeyStroke})
+	}
+}
 
-	registerCommand(key.Filter{Focus: e, Name: key.NameDeleteForward, Optional: key.ModShortcutAlt | key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			if e.mode != ModeReadOnly {
-				moveByWord := evt.Modifiers.Contain(key.ModShortcutAlt)
-				if moveByWord {
-					if e.deleteWord(1) != 0 {
-						return ChangeEvent{}
-					}
-				} else {
-					if e.Delete(1) != 0 {
-						return ChangeEvent{}
-					}
-				}
-			}
-			return nil
-		})
+// onKeyStroke runs the named command bound to the chord ending with the key stroke of
+// evt. If the chord starts longer chords, it waits for the next stroke.
+func (e *Editor) onKeyStroke(gtx layout.Context, evt key.Event) EditorEvent {
+	chord := append(e.chord, keymap.Stroke{Name: evt.Name, Modifiers: evt.Modifiers})
+	action, more := e.Keymap().Lookup(chord)
+	if more {
+		e.chord = chord
+		return nil
+	}
 
-	registerCommand(key.Filter{Focus: e, Name: key.NamePageDown, Optional: key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			selAct := textview.SelectionClear
-			if evt.Modifiers.Contain(key.ModShift) {
-				selAct = textview.SelectionExtend
-			}
-			e.text.MovePages(+1, selAct)
-			return nil
-		})
+	if len(e.chord) > 0 {
+		// Stop receiving the strokes following the first ones in the next
+		// frame, so that they type text again.
+		gtx.Execute(op.InvalidateCmd{})
+		e.chord = e.chord[:0]
+	}
+	if action == "" {
+		return nil
+	}
+	return e.runCommand(gtx, action)
+}
 
-	registerCommand(key.Filter{Focus: e, Name: key.NamePageUp, Optional: key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			selAct := textview.SelectionClear
-			if evt.Modifiers.Contain(key.ModShift) {
-				selAct = textview.SelectionExtend
-			}
-			e.text.MovePages(-1, selAct)
-			return nil
-		})
+func (e *Editor) processCommands(gtx layout.Context) EditorEvent {
+	if len(e.commands) == 0 || e.Keymap().Revision() != e.keymapRevision {
+		e.buildBuiltinCommands()
+	}
 
-	checkPos := func(gtx layout.Context) (bool, bool) {
-		caret, _ := e.text.Selection()
-		atBeginning := caret == 0
-		atEnd := caret == e.text.Len()
-		if gtx.Locale.Direction.Progression() != system.FromOrigin {
-			atEnd, atBeginning = atBeginning, atEnd
+	for _, cmds := range e.commands {
+		if evt := e.processCommand(gtx, cmds[len(cmds)-1]); evt != nil {
+			return evt
 		}
-		return atBeginning, atEnd
 	}
 
-	registerCommand(key.Filter{Focus: e, Name: key.NameLeftArrow, Optional: key.ModShortcutAlt | key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			atBeginning, _ := checkPos(gtx)
-			if atBeginning {
-				return nil
-			}
-
-			moveByWord := evt.Modifiers.Contain(key.ModShortcutAlt)
-			direction := 1
-			if gtx.Locale.Direction.Progression() == system.TowardOrigin {
-				direction = -1
-			}
-			selAct := textview.SelectionClear
-			if evt.Modifiers.Contain(key.ModShift) {
-				selAct = textview.SelectionExtend
-			}
-
-			if moveByWord {
-				e.text.MoveWords(-1*direction, selAct)
-			} else {
-				if selAct == textview.SelectionClear {
-					e.text.ClearSelection()
-				}
-				e.text.MoveCaret(-1*direction, -1*direction*int(selAct))
-			}
-			return nil
-		})
-
-	registerCommand(key.Filter{Focus: e, Name: key.NameUpArrow, Optional: key.ModShortcutAlt | key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			atBeginning, _ := checkPos(gtx)
-			if atBeginning {
-				return nil
-			}
-
-			selAct := textview.SelectionClear
-			if evt.Modifiers.Contain(key.ModShift) {
-				selAct = textview.SelectionExtend
-			}
-			e.text.MoveLines(-1, selAct)
-			return nil
-		})
-
-	registerCommand(key.Filter{Focus: e, Name: key.NameRightArrow, Optional: key.ModShortcutAlt | key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			_, atEnd := checkPos(gtx)
-			if atEnd {
-				return nil
+	// The strokes following the first stroke of a chord are received only
+	// while the chord is pending, as they may type text otherwise.
+	if len(e.chord) > 0 {
+		for _, cmd := range e.chordCommands {
+			if evt := e.processCommand(gtx, cmd); evt != nil {
+				return evt
 			}
+		}
+	}
 
-			moveByWord := evt.Modifiers.Contain(key.ModShortcutAlt)
-			direction := 1
-			if gtx.Locale.Direction.Progression() == system.TowardOrigin {
-				direction = -1
-			}
-			selAct := textview.SelectionClear
-			if evt.Modifiers.Contain(key.ModShift) {
-				selAct = textview.SelectionExtend
-			}
+	return nil
+}
 
-			if moveByWord {
-				e.text.MoveWords(1*direction, selAct)
-			} else {
-				if selAct == textview.SelectionClear {
-					e.text.ClearSelection()
-				}
-				e.text.MoveCaret(1*direction, int(selAct)*direction)
-			}
-			return nil
-		})
+// processCommand runs the handler of cmd for the key events matching its
+// filter.
+func (e *Editor) processCommand(gtx layout.Context, cmd keyCommand) EditorEvent {
+	for {
+		ke, ok := gtx.Event(cmd.filter)
+		if !ok {
+			break
+		}
 
-	registerCommand(key.Filter{Focus: e, Name: key.NameDownArrow, Optional: key.ModShortcutAlt | key.ModShift},
-		func(gtx layout.Context, evt key.Event) EditorEvent {
-			_, atEnd := checkPos(gtx)
-			if atEnd {
-				return nil
+		e.blinkStart = gtx.Now
+		if ke, ok := ke.(key.Event); ok {
+			if !gtx.Focused(e) || ke.State != key.Press {
+				break
 			}
-
-			selAct := textview.SelectionClear
-			if evt.Modifiers.Contain(key.ModShift) {
-				selAct = textview.SelectionExtend
+			e.scrollCaret = true
+			e.scroller.Stop()
+			if cmd.tag == nil || cmd.tag == e {
+				e.cancelCompletor()
 			}
-			e.text.MoveLines(+1, selAct)
-			return nil
-		})
-
-}
-
-func (e *Editor) processCommands(gtx layout.Context) EditorEvent {
-	if len(e.commands) == 0 {
-		e.buildBuiltinCommands()
-	}
 
-	for _, cmds := range e.commands {
-		cmd := cmds[len(cmds)-1]
-		for {
-			ke, ok := gtx.Event(cmd.filter)
-			if !ok {
+			if !ke.Modifiers.Contain(cmd.filter.Required) {
 				break
 			}
 
-			e.blinkStart = gtx.Now
-			if ke, ok := ke.(key.Event); ok {
-				if !gtx.Focused(e) || ke.State != key.Press {
-					break
-				}
-				e.scrollCaret = true
-				e.scroller.Stop()
-				if cmd.tag == nil || cmd.tag == e {
-					e.cancelCompletor()
-				}
-
-				if !ke.Modifiers.Contain(cmd.filter.Required) {
-					break
-				}
-
-				if evt := cmd.handler(gtx, ke); evt != nil {
-					return evt
-				}
+			if evt := cmd.handler(gtx, ke); evt != nil {
+				return evt
 			}
 		}
 	}
-
 	return nil
 }
@@ -0,0 +1,83 @@
+package gvcode
+
+import (
+	"image"
+	"testing"
+
+	"gioui.org/font/gofont"
+	"gioui.org/io/input"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/text"
+	"github.com/oligo/gvcode/keymap"
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+func TestChordKeepsTyping(t *testing.T) {
+	km := keymap.Default()
+	if err := km.Bind("Ctrl+K X", "selection.all"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Editor{}
+	e.WithOptions(WithColorScheme(syntax.ColorScheme{}), WithKeymap(km))
+	e.SetText("ab")
+
+	router := new(input.Router)
+	shaper := text.NewShaper(text.WithCollection(gofont.Collection()))
+	frame := func(fn func(gtx layout.Context)) {
+		gtx := layout.Context{
+			Ops:         new(op.Ops),
+			Constraints: layout.Exact(image.Pt(800, 600)),
+			Source:      router.Source(),
+		}
+		if fn != nil {
+			fn(gtx)
+		}
+		e.Layout(gtx, shaper)
+		router.Frame(gtx.Ops)
+		// Redraw right away when asked to, like a window.
+		if t, ok := router.WakeupTime(); ok && t.IsZero() {
+			gtx.Ops.Reset()
+			e.Layout(gtx, shaper)
+			router.Frame(gtx.Ops)
+			router.WakeupTime()
+		}
+	}
+	// press presses the key, and types its text unless the key event is
+	// handled, as the platforms do.
+	press := func(name key.Name, mods key.Modifiers, text string) {
+		router.Queue(key.Event{Name: name, Modifiers: mods, State: key.Press})
+		if _, handled := router.WakeupTime(); !handled && text != "" {
+			start, end := e.Selection()
+			router.Queue(key.EditEvent{Range: key.Range{Start: min(start, end), End: max(start, end)}, Text: text})
+		}
+		router.Queue(key.Event{Name: name, Modifiers: mods, State: key.Release})
+		frame(nil)
+	}
+
+	frame(func(gtx layout.Context) {
+		gtx.Execute(key.FocusCmd{Tag: e})
+	})
+	frame(nil)
+	e.SetCaret(2, 2)
+
+	// X types text unless it follows Ctrl+K.
+	press("X", 0, "x")
+	if got := e.Text(); got != "abx" {
+		t.Fatalf("want %q, got %q", "abx", got)
+	}
+
+	press("K", key.ModCtrl, "")
+	press("X", 0, "x")
+	if start, end := e.Selection(); e.Text() != "abx" || min(start, end) != 0 || max(start, end) != 3 {
+		t.Errorf("want all of %q selected by the chord, got %q selected %d-%d", "abx", e.Text(), start, end)
+	}
+
+	e.SetCaret(3, 3)
+	press("X", 0, "x")
+	if got := e.Text(); got != "abxx" {
+		t.Errorf("want %q after the chord, got %q", "abxx", got)
+	}
+}
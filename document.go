@@ -0,0 +1,279 @@
+package gvcode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"unicode/utf8"
+
+	"github.com/oligo/gvcode/charset"
+)
+
+// loadChunkSize is the size of the chunks a document is read by, the first of
+// which is also used to detect the encoding and the indentation.
+const loadChunkSize = 64 * 1024
+
+// Load sets the text of the editor from the encoded data of a document, like
+// the content of a file. The encoding is detected from the BOM or by sniffing
+// the data, and is kept to write the text back with WriteTo. The bytes that
+// can not be decoded are replaced by U+FFFD, and reported by InvalidSequences.
+func (e *Editor) Load(data []byte) error {
+	_, err := e.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// ReadFrom is like Load, but reads the document from r until EOF. UTF-8
+// documents are read into the text buffer directly, normalizing the line
+// endings on the fly. The buffer is allocated at the size of r if it is known,
+// like for an [os.File] or a [bytes.Reader], so that very large files are
+// loaded without copying them. The documents in other encodings are read
+// at once and decoded. The progress is reported to the function set by
+// WithLoadProgress. The text is left untouched if reading fails. It
+// implements [io.ReaderFrom].
+func (e *Editor) ReadFrom(r io.Reader) (int64, error) {
+	e.initBuffer()
+
+	pr := &progressReader{r: r, total: readerSize(r), fn: e.loadProgress}
+	br := bufio.NewReaderSize(pr, loadChunkSize)
+
+	prefix, err := br.Peek(loadChunkSize)
+	var enc charset.Encoding
+	switch {
+	case err == nil || errors.Is(err, bufio.ErrBufferFull):
+		enc = charset.DetectPrefix(prefix)
+	case err == io.EOF:
+		enc = charset.Detect(prefix)
+	default:
+		return pr.read, err
+	}
+
+	if enc.Name != charset.UTF8.Name {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return pr.read, err
+		}
+		return pr.read, e.LoadWithEncoding(data, enc)
+	}
+
+	// The first chunk is copied, as the reader reuses the memory.
+	sample := string(prefix)
+	lr := &loadReader{r: br, size: pr.total}
+	if enc.BOM {
+		lr.offset, _ = br.Discard(len("\uFEFF"))
+		lr.size -= int64(lr.offset)
+	}
+	if _, err := e.text.ReadFrom(lr); err != nil {
+		return pr.read, err
+	}
+
+	e.guessIndentation(sample)
+	e.lineEnding, e.mixedBreaks = lr.lineEndings.mixed()
+	e.resetText()
+	e.SetCaret(0, 0)
+	e.encoding = enc
+	e.invalidSequences = lr.invalid
+	return pr.read, nil
+}
+
+// WriteTo writes the text of the editor to w, in the line ending style and the
+// encoding of the document, and marks the text saved if it succeeds. The text
+// is streamed from the text buffer, so w may be written partially if an error
+// occurs, like a rune that is not supported by the encoding. It implements
+// [io.WriterTo].
+func (e *Editor) WriteTo(w io.Writer) (int64, error) {
+	e.initBuffer()
+	cw := &countingWriter{w: w}
+	ew, err := charset.NewWriter(cw, e.Encoding())
+	if err != nil {
+		return cw.n, err
+	}
+
+	bw := bufio.NewWriterSize(ew, loadChunkSize)
+	var dst io.Writer = bw
+	if e.lineEnding != LF {
+		dst = &lineEndingWriter{w: bw, seq: []byte(e.lineEnding.Sequence())}
+	}
+
+	if _, err := e.buffer.WriteTo(dst); err != nil {
+		return cw.n, err
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	if err := ew.Close(); err != nil {
+		return cw.n, err
+	}
+
+	e.MarkSaved()
+	return cw.n, nil
+}
+
+// MarkSaved marks the current text as saved, which is done by WriteTo. Call it
+// after the document is saved in other ways.
+func (e *Editor) MarkSaved() {
+	e.initBuffer()
+	e.buffer.MarkSaved()
+	e.formatChanged = false
+}
+
+// Modified reports whether the document differs from the one last saved, or
+// the one set by SetText, ReadFrom or Load if it is never saved. Undoing and
+// redoing the edits back to the saved text makes it unmodified again, while
+// changing the line ending style or the encoding makes it modified.
+func (e *Editor) Modified() bool {
+	e.initBuffer()
+	return e.formatChanged || e.buffer.Modified()
+}
+
+// readerSize returns the size of the content of r, or -1 if it is unknown.
+func readerSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size()
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if fi, err := r.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+// progressReader reports the number of bytes read from r to fn.
+type progressReader struct {
+	r     io.Reader
+	read  int64
+	total int64
+	fn    func(read, total int64)
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.read += int64(n)
+	if pr.fn != nil && n > 0 {
+		pr.fn(pr.read, pr.total)
+	}
+	return n, err
+}
+
+// loadReader reads UTF-8 text from r, converting the line breaks to "\n" and
+// recording their styles. The invalid bytes are replaced by U+FFFD, and
+// recorded with their positions.
+type loadReader struct {
+	r           *bufio.Reader
+	lineEndings lineEndingScanner
+	invalid     []charset.InvalidSequence
+	// offset is the byte offset in the document, and runes is the number of
+	// runes read.
+	offset int
+	runes  int
+	// size is the size of the document after the BOM, or negative if it is
+	// unknown.
+	size int64
+}
+
+// Size returns the size of the document, as the size of the text to be read,
+// which is less if it has "\r\n" line breaks, or more if invalid bytes are
+// replaced by U+FFFD. It returns 0 if the size is unknown.
+func (lr *loadReader) Size() int64 {
+	return max(lr.size, 0)
+}
+
+func (lr *loadReader) Read(p []byte) (int, error) {
+	if len(p) < utf8.UTFMax {
+		return 0, io.ErrShortBuffer
+	}
+
+	n := 0
+	for n+utf8.UTFMax <= len(p) {
+		// Make sure a rune or a "\r\n" is not split between the chunks, unless
+		// it is the end of the text.
+		_, err := lr.r.Peek(utf8.UTFMax)
+		chunk, _ := lr.r.Peek(lr.r.Buffered())
+		if len(chunk) == 0 {
+			return n, err
+		}
+		atEOF := err != nil
+
+		i := 0
+		for i < len(chunk) && n+utf8.UTFMax <= len(p) && (atEOF || len(chunk)-i >= utf8.UTFMax) {
+			switch b := chunk[i]; {
+			case b == '\n':
+				lr.lineEndings.add(LF)
+				p[n] = '\n'
+				n++
+				i++
+			case b == '\r':
+				if i+1 < len(chunk) && chunk[i+1] == '\n' {
+					lr.lineEndings.add(CRLF)
+					i += 2
+				} else {
+					lr.lineEndings.add(CR)
+					i++
+				}
+				p[n] = '\n'
+				n++
+			case b < utf8.RuneSelf:
+				p[n] = b
+				n++
+				i++
+			default:
+				r, size := utf8.DecodeRune(chunk[i:])
+				if r == utf8.RuneError && size == 1 {
+					lr.invalid = append(lr.invalid, charset.InvalidSequence{Offset: lr.offset + i, Rune: lr.runes})
+					n += utf8.EncodeRune(p[n:], r)
+				} else {
+					n += copy(p[n:], chunk[i:i+size])
+				}
+				i += size
+			}
+			lr.runes++
+		}
+		lr.offset += i
+		lr.r.Discard(i)
+	}
+	return n, nil
+}
+
+// lineEndingWriter converts the line breaks of the text written to it from
+// "\n" to seq.
+type lineEndingWriter struct {
+	w   io.Writer
+	seq []byte
+}
+
+func (lw *lineEndingWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			m, err := lw.w.Write(p)
+			return n + m, err
+		}
+
+		m, err := lw.w.Write(p[:i])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if _, err := lw.w.Write(lw.seq); err != nil {
+			return n, err
+		}
+		n++
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
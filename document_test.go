@@ -0,0 +1,193 @@
+package gvcode
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"gioui.org/font/gofont"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/text"
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+func TestReadFromChunkBoundaries(t *testing.T) {
+	pad := strings.Repeat("a", loadChunkSize-1)
+	cases := []struct {
+		name  string
+		data  string
+		want  string
+		le    LineEnding
+		mixed int
+	}{
+		{
+			name: "crlf across chunks",
+			data: pad + "\r\nb\r\n",
+			want: pad + "\nb\n",
+			le:   CRLF,
+		},
+		{
+			name: "cr at the end of a chunk",
+			data: pad + "\rb\n",
+			want: pad + "\nb\n",
+			le:   LF, mixed: 1,
+		},
+		{
+			name: "rune across chunks",
+			data: pad + "世界\n",
+			want: pad + "世界\n",
+			le:   LF,
+		},
+		{
+			name: "rune and crlf across chunks",
+			data: pad[:len(pad)-1] + "é\r\n" + pad + "😀\r\n",
+			want: pad[:len(pad)-1] + "é\n" + pad + "😀\n",
+			le:   CRLF,
+		},
+	}
+
+	readers := []struct {
+		name string
+		new  func(data string) io.Reader
+	}{
+		{"sized", func(data string) io.Reader { return strings.NewReader(data) }},
+		{"half", func(data string) io.Reader { return iotest.HalfReader(strings.NewReader(data)) }},
+		{"one byte", func(data string) io.Reader { return iotest.OneByteReader(strings.NewReader(data)) }},
+	}
+
+	for _, c := range cases {
+		for _, r := range readers {
+			e := &Editor{}
+			n, err := e.ReadFrom(r.new(c.data))
+			if err != nil || n != int64(len(c.data)) {
+				t.Fatalf("%s, %s: want %d bytes read, got %d, %v", c.name, r.name, len(c.data), n, err)
+			}
+			if e.Text() != c.want {
+				t.Errorf("%s, %s: text mismatch", c.name, r.name)
+			}
+			if e.LineEnding() != c.le || e.MixedLineEndings() != c.mixed {
+				t.Errorf("%s, %s: want %v with %d mixed, got %v with %d",
+					c.name, r.name, c.le, c.mixed, e.LineEnding(), e.MixedLineEndings())
+			}
+		}
+	}
+}
+
+func TestReadFromWriteToRoundTrip(t *testing.T) {
+	pad := strings.Repeat("x", loadChunkSize-2)
+	cases := []string{
+		"",
+		"\uFEFFa\r\nb\r\n世界\r\n",
+		"\uFEFF" + pad + "\r\n" + pad + "é\r\nend",
+		"a\nb\n",
+	}
+	for _, data := range cases {
+		e := &Editor{}
+		if _, err := e.ReadFrom(strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(e.Text(), "\r") || strings.HasPrefix(e.Text(), "\uFEFF") {
+			t.Errorf("%.20q: want the text normalized", data)
+		}
+
+		var buf bytes.Buffer
+		n, err := e.WriteTo(&buf)
+		if err != nil || n != int64(len(data)) {
+			t.Fatalf("%.20q: want %d bytes written, got %d, %v", data, len(data), n, err)
+		}
+		if buf.String() != data {
+			t.Errorf("%.20q: round trip mismatch", data)
+		}
+	}
+}
+
+func TestLineEndingWriter(t *testing.T) {
+	cases := []struct {
+		chunks []string
+		seq    string
+		want   string
+	}{
+		{[]string{"a\nb"}, "\r\n", "a\r\nb"},
+		{[]string{"a\n", "\nb\n"}, "\r\n", "a\r\n\r\nb\r\n"},
+		{[]string{"\n", "", "a"}, "\r", "\ra"},
+		{[]string{"no line breaks"}, "\r\n", "no line breaks"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		lw := &lineEndingWriter{w: &buf, seq: []byte(c.seq)}
+		for _, chunk := range c.chunks {
+			// The bytes written are counted before the conversion.
+			if n, err := lw.Write([]byte(chunk)); err != nil || n != len(chunk) {
+				t.Errorf("%q: want %d bytes written, got %d, %v", c.chunks, len(chunk), n, err)
+			}
+		}
+		if buf.String() != c.want {
+			t.Errorf("%q: want %q, got %q", c.chunks, c.want, buf.String())
+		}
+	}
+}
+
+func TestModifiedAfterSave(t *testing.T) {
+	e := &Editor{}
+	if _, err := e.ReadFrom(strings.NewReader("a\r\nb\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if e.Modified() {
+		t.Fatal("want unmodified after loading")
+	}
+
+	steps := []struct {
+		name     string
+		do       func()
+		modified bool
+	}{
+		{"insert", func() { e.Insert("x") }, true},
+		{"save", func() { e.MarkSaved() }, false},
+		{"insert after saving", func() { e.Insert("y") }, true},
+		{"undo to the saved text", func() { e.undo() }, false},
+		{"undo the saved change", func() { e.undo() }, true},
+		{"redo to the saved text", func() { e.redo() }, false},
+		{"change the line endings", func() { e.SetLineEnding(LF) }, true},
+		{"insert and undo", func() { e.Insert("z"); e.undo() }, true},
+		{"save the line endings", func() { e.MarkSaved() }, false},
+		{"keep the line endings", func() { e.SetLineEnding(LF) }, false},
+		{"append", func() { e.Append("log\n") }, false},
+		{"undo after appending", func() { e.undo() }, true},
+	}
+	for _, s := range steps {
+		s.do()
+		if got := e.Modified(); got != s.modified {
+			t.Errorf("%s: want modified %v, got %v", s.name, s.modified, got)
+		}
+	}
+}
+
+func TestTypeWhileAppending(t *testing.T) {
+	shaper := text.NewShaper(text.WithCollection(gofont.Collection()))
+	e := &Editor{}
+	e.WithOptions(WithColorScheme(syntax.ColorScheme{}))
+	// The carets are placed by the layout of the text.
+	typeText := func(s string) {
+		gtx := layout.Context{Ops: new(op.Ops), Constraints: layout.Exact(image.Pt(800, 600))}
+		e.Layout(gtx, shaper)
+		for _, r := range s {
+			e.Insert(string(r))
+		}
+	}
+
+	typeText("ab")
+	e.Append("LOG\n")
+	typeText("c")
+	e.Append("世界\n")
+	typeText("d")
+	if want := "abcdLOG\n世界\n"; e.Text() != want {
+		t.Errorf("want %q, got %q", want, e.Text())
+	}
+	if start, end := e.Selection(); start != 4 || end != 4 {
+		t.Errorf("want the caret at 4, got %d-%d", start, end)
+	}
+}
@@ -18,9 +18,12 @@ import (
 	"gioui.org/op/paint"
 	"gioui.org/text"
 	"gioui.org/unit"
+	"github.com/oligo/gvcode/buffer"
+	"github.com/oligo/gvcode/charset"
 	"github.com/oligo/gvcode/color"
-	"github.com/oligo/gvcode/internal/buffer"
 	gestureExt "github.com/oligo/gvcode/internal/gesture"
+	"github.com/oligo/gvcode/keymap"
+	"github.com/oligo/gvcode/textstyle/syntax"
 	"github.com/oligo/gvcode/textview"
 )
 
@@ -40,6 +43,9 @@ type Editor struct {
 	// editor text area.
 	lineNumberGutterGap unit.Dp
 	showLineNumber      bool
+	// folding enables the fold gutter and the folding commands.
+	folding     bool
+	foldClicker gesture.Click
 	// hooks
 	onPaste   BeforePasteHook
 	completor Completion
@@ -57,7 +63,9 @@ type Editor struct {
 		scratch []byte
 	}
 
-	dragging    bool
+	dragging bool
+	// dragColumn is set when dragging to make a rectangular selection.
+	dragColumn  bool
 	dragger     gesture.Drag
 	scroller    gestureExt.Scroll
 	hover       gestureExt.Hover
@@ -65,10 +73,48 @@ type Editor struct {
 	showCaret   bool
 	clicker     gesture.Click
 	pending     []EditorEvent
+	// changes are the text changes to be delivered with ChangeEvent.
+	changes []TextChange
+	// unsubscribe stops receiving the changes of the text source.
+	unsubscribe func()
+	// lineEnding is the line ending style of the document, and mixedBreaks
+	// are the line breaks of the other styles, ordered by their lines.
+	lineEnding  LineEnding
+	mixedBreaks []mixedLineBreak
+	// encoding is the encoding of the document, and invalidSequences are the
+	// bytes of the document that can not be decoded.
+	encoding         charset.Encoding
+	invalidSequences []charset.InvalidSequence
+	// formatChanged is set when the line ending style or the encoding is
+	// changed since the document is last saved.
+	formatChanged bool
+	// loadProgress is called with the progress of ReadFrom.
+	loadProgress func(read, total int64)
+	// tail tracks the text appended by Append.
+	tail tailState
+	// maxLines is the number of lines kept by Append, or 0 if unlimited.
+	maxLines int
 	// commands is a registry of key commands.
 	commands map[key.Name][]keyCommand
+	// keymap binds the key chords to the actions of the editor.
+	keymap *keymap.Keymap
+	// keymapRevision is the revision of the keymap the commands are built from.
+	keymapRevision int
+	// chord holds the strokes typed so far of a multi-stroke chord.
+	chord keymap.Chord
+	// chordCommands receive the strokes following the first stroke of the
+	// chords, while a chord is pending.
+	chordCommands []keyCommand
+	// namedCommands holds the named commands by their IDs.
+	namedCommands map[string]Command
 	// autoInsertions tracks recently inserted closing brackets or quotes.
 	autoInsertions map[int]rune
+	// finder holds the state of the ongoing search.
+	finder *findState
+	// highlighter tokenizes the text incrementally if a tokenizer is set.
+	highlighter *syntax.Highlighter
+	// vim holds the state of the Vim mode.
+	vim vimState
 	// gutterWidth can be used to guide to set the horizontal offset when
 	// laying out a horizontal scrollbar.
 	gutterWidth int
@@ -88,7 +134,12 @@ type EditorEvent interface {
 }
 
 // A ChangeEvent is generated for every user change to the text.
-type ChangeEvent struct{}
+type ChangeEvent struct {
+	// Changes are the deltas of the text made by the user action, in the
+	// order they are made. It is empty if the action does not change the
+	// text after all.
+	Changes []TextChange
+}
 
 // A SelectEvent is generated when the user selects some text, or changes the
 // selection (e.g. with a shift-click), including if they remove the
@@ -118,6 +169,7 @@ func (e *Editor) initBuffer() {
 	if e.buffer == nil {
 		e.text = textview.NewTextView()
 		e.buffer = e.text.Source()
+		e.unsubscribe = e.buffer.Subscribe(e.onTextChange)
 	}
 
 	e.text.CaretWidth = unit.Dp(1)
@@ -129,7 +181,12 @@ func (e *Editor) initBuffer() {
 // false.
 func (e *Editor) Update(gtx layout.Context) (EditorEvent, bool) {
 	e.initBuffer()
+	// Changes made by the program between the updates are not user changes.
+	e.changes = nil
 	event, ok := e.processEvents(gtx)
+	if ok {
+		event = e.withChanges(event)
+	}
 	// Notify IME of selection if it changed.
 	newSel := e.ime.selection
 	start, end := e.text.Selection()
@@ -160,13 +217,24 @@ func (e *Editor) Layout(gtx layout.Context, lt *text.Shaper) layout.Dimensions {
 		}
 	}
 
+	// Keep the match highlights in sync with the document.
+	e.refreshFind()
+	e.highlight(gtx)
+
 	// Adjust scrolling for new viewport and layout.
 	e.text.ScrollRel(0, 0)
 
 	if e.scrollCaret {
 		e.scrollCaret = false
+		e.tail.appended = false
 		e.text.ScrollToCaret()
+	} else if e.tail.appended {
+		e.tail.appended = false
+		if e.tail.following {
+			e.text.ScrollToEnd()
+		}
 	}
+	defer e.trackTail()
 
 	defer clip.Rect(image.Rectangle{Max: gtx.Constraints.Max}).Push(gtx.Ops).Pop()
 	e.scroller.Add(gtx.Ops)
@@ -175,6 +243,12 @@ func (e *Editor) Layout(gtx layout.Context, lt *text.Shaper) layout.Dimensions {
 		paint.PaintOp{}.Add(gtx.Ops)
 	}
 
+	lineNumberColor := color.Color{}.MulAlpha(255)
+	if e.colorPalette != nil && e.colorPalette.LineNumberColor.IsSet() {
+		lineNumberColor = e.colorPalette.LineNumberColor
+	}
+
+	e.gutterWidth = 0
 	return layout.Flex{
 		Axis: layout.Horizontal,
 	}.Layout(gtx,
@@ -185,15 +259,21 @@ func (e *Editor) Layout(gtx layout.Context, lt *text.Shaper) layout.Dimensions {
 
 			dims := layout.Inset{Right: max(0, e.lineNumberGutterGap)}.Layout(gtx,
 				func(gtx layout.Context) layout.Dimensions {
-					var lineNumberColor color.Color
-					if e.colorPalette.LineNumberColor.IsSet() {
-						lineNumberColor = e.colorPalette.LineNumberColor
-					} else {
-						lineNumberColor = color.Color{}.MulAlpha(255)
-					}
 					return e.text.PaintLineNumber(gtx, lt, lineNumberColor.Op(gtx.Ops))
 				})
-			e.gutterWidth = dims.Size.X
+			e.gutterWidth += dims.Size.X
+			return dims
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if !e.folding {
+				return layout.Dimensions{}
+			}
+
+			dims := e.text.PaintFoldGutter(gtx, lineNumberColor.Op(gtx.Ops))
+			defer clip.Rect(image.Rectangle{Max: dims.Size}).Push(gtx.Ops).Pop()
+			pointer.CursorPointer.Add(gtx.Ops)
+			e.foldClicker.Add(gtx.Ops)
+			e.gutterWidth += dims.Size.X
 			return dims
 		}),
 		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
@@ -258,6 +338,8 @@ func (e *Editor) layout(gtx layout.Context) layout.Dimensions {
 		e.paintLineHighlight(gtx, lineColor)
 		e.text.HighlightMatchingBrackets(gtx, selectColor.Op(gtx.Ops))
 		e.paintText(gtx, textMaterial)
+		foldMarkerColor := textMaterial.MulAlpha(0x90)
+		e.text.PaintFoldMarkers(gtx, foldMarkerColor.Op(gtx.Ops))
 	}
 	if gtx.Enabled() {
 		e.paintCaret(gtx, textMaterial)
@@ -292,6 +374,10 @@ func (e *Editor) paintCaret(gtx layout.Context, material color.Color) {
 	if !e.showCaret || e.mode == ModeReadOnly {
 		return
 	}
+	if e.text.BlockCaret {
+		// Keep the text under the block visible.
+		material = material.MulAlpha(0x80)
+	}
 	e.text.PaintCaret(gtx, material.Op(gtx.Ops))
 }
 
@@ -306,9 +392,10 @@ func (e *Editor) Len() int {
 	return e.buffer.Len()
 }
 
-// Text returns the contents of the editor. This method is not concurrent safe,
-// and you should use the Reader returned from GetReader to read from multiple
-// goroutines.
+// Text returns the contents of the editor, with the lines separated by "\n".
+// Use TextWithLineEnding to get the text in the line ending style of the
+// document. This method is not concurrent safe, and you should use the Reader
+// returned from GetReader to read from multiple goroutines.
 func (e *Editor) Text() string {
 	e.initBuffer()
 
@@ -326,16 +413,53 @@ func (e *Editor) GetReader() io.ReadSeeker {
 
 func (e *Editor) SetText(s string) {
 	e.initBuffer()
+	e.guessIndentation(s)
+	var ls lineEndingScanner
+	ls.scan(s)
+	e.text.SetText(buffer.NormalizeLineEndings(s))
+	e.lineEnding, e.mixedBreaks = ls.mixed()
+	e.resetText()
+	// Reset xoff and move the caret to the beginning.
+	e.SetCaret(0, 0)
+}
+
+// setSource makes the editor use src as the text source, whose text becomes
+// the text of the editor.
+func (e *Editor) setSource(src buffer.TextSource) {
+	e.unsubscribe()
+	e.text.SetSource(src)
+	e.buffer = src
+	e.unsubscribe = src.Subscribe(e.onTextChange)
+	if e.highlighter != nil {
+		// None of the lines tokenized are from the new text.
+		e.highlighter.Invalidate()
+	}
+
+	e.encoding = charset.UTF8
+	e.lineEnding, e.mixedBreaks = LF, nil
+	e.resetText()
+	// The caret is moved to the beginning by the text view, without laying
+	// out the text, which may be too large to lay out before VirtualLayout
+	// takes effect.
+	e.scrollCaret = true
+}
 
-	indent, _, size := GuessIndentation(s)
+// guessIndentation configures the tab style and width from the indentation of
+// text.
+func (e *Editor) guessIndentation(text string) {
+	indent, _, size := GuessIndentation(text)
 	e.text.SoftTab = indent == Spaces
 	e.text.TabWidth = size
+}
 
-	e.text.SetText(s)
+// resetText resets the states bound to the old text after a new text is set.
+func (e *Editor) resetText() {
+	e.invalidSequences = nil
+	e.formatChanged = false
 	e.ime.start = 0
 	e.ime.end = 0
-	// Reset xoff and move the caret to the beginning.
-	e.SetCaret(0, 0)
+	e.tail.cr = false
+	e.invalidateFind()
 }
 
 // CaretPos returns the line & column numbers of the caret.
@@ -383,20 +507,37 @@ func (e *Editor) Delete(graphemeClusters int) (deletedRunes int) {
 		return 0
 	}
 
+	e.editEachCaret(func() {
+		deletedRunes += e.deleteAtCaret(graphemeClusters)
+	})
+	return deletedRunes
+}
+
+// deleteAtCaret deletes runes from the position of the primary caret.
+func (e *Editor) deleteAtCaret(graphemeClusters int) int {
 	if graphemeClusters < 0 {
 		// update selection based on some rules.
 		e.onDeleteBackward()
 	}
 
 	start, end := e.text.Selection()
-	if start != end {
+	hasSelection := start != end
+	if hasSelection {
 		graphemeClusters -= sign(graphemeClusters)
 	}
 
 	// Move caret by the target quantity of clusters.
 	e.text.MoveCaret(0, graphemeClusters)
 	// Get the new rune offsets of the selection.
+	caretStart, caretEnd := start, end
 	start, end = e.text.Selection()
+	if !hasSelection && e.text.UnfoldRange(start, end) {
+		// Deleting across a folded range would remove the hidden lines, so
+		// unfold it and delete from the revealed text instead.
+		e.text.SetCaret(caretStart, caretEnd)
+		e.text.MoveCaret(0, graphemeClusters)
+		start, end = e.text.Selection()
+	}
 	e.replace(start, end, "")
 	// Reset xoff.
 	e.text.MoveCaret(0, 0)
@@ -483,13 +624,7 @@ func (e *Editor) undo() (EditorEvent, bool) {
 		return nil, false
 	}
 
-	var start, end int
-	for _, pos := range positions {
-		start = pos.Start
-		end = pos.End
-	}
-
-	e.SetCaret(end, start)
+	e.restoreCarets(positions)
 	return ChangeEvent{}, true
 }
 
@@ -502,16 +637,18 @@ func (e *Editor) redo() (EditorEvent, bool) {
 		return nil, false
 	}
 
-	var start, end int
-	for _, pos := range positions {
-		start = pos.Start
-		end = pos.End
-	}
-
-	e.SetCaret(end, start)
+	e.restoreCarets(positions)
 	return ChangeEvent{}, true
 }
 
+// restoreCarets places the carets at the cursor positions recorded by the
+// undo/redo operation(s).
+func (e *Editor) restoreCarets(positions []buffer.CursorPos) {
+	e.text.RestoreCarets(positions)
+	e.scrollCaret = true
+	e.scroller.Stop()
+}
+
 // replace the text between start and end with s. Indices are in runes.
 // It returns the number of runes inserted.
 func (e *Editor) replace(start, end int, s string) int {
@@ -522,7 +659,7 @@ func (e *Editor) replace(start, end int, s string) int {
 	start = min(start, length)
 	end = min(end, length)
 
-	sc := e.text.Replace(start, end, s)
+	sc := e.text.Replace(start, end, buffer.NormalizeLineEndings(s))
 	newEnd := start + sc
 	adjust := func(pos int) int {
 		switch {
@@ -536,9 +673,30 @@ func (e *Editor) replace(start, end int, s string) int {
 	}
 	e.ime.start = adjust(e.ime.start)
 	e.ime.end = adjust(e.ime.end)
+	e.adjustAutoInsertions(start, end, newEnd)
 	return sc
 }
 
+// adjustAutoInsertions keeps the tracked auto-inserted runes in place after the
+// text between start and end was replaced by text ending at newEnd. Insertions
+// inside of the replaced range are dropped.
+func (e *Editor) adjustAutoInsertions(start, end, newEnd int) {
+	if len(e.autoInsertions) == 0 {
+		return
+	}
+
+	adjusted := make(map[int]rune, len(e.autoInsertions))
+	for pos, r := range e.autoInsertions {
+		switch {
+		case pos < start:
+			adjusted[pos] = r
+		case pos >= end:
+			adjusted[pos+newEnd-end] = r
+		}
+	}
+	e.autoInsertions = adjusted
+}
+
 // ReplaceAll replaces all texts specifed in TextRange with newStr.
 // It returns the number of occurrences replaced.
 func (e *Editor) ReplaceAll(texts []TextRange, newStr string) int {
@@ -581,9 +739,17 @@ func (e *Editor) deleteWord(distance int) (deletedRunes int) {
 		return
 	}
 
+	e.editEachCaret(func() {
+		deletedRunes += e.deleteWordAtCaret(distance)
+	})
+	return deletedRunes
+}
+
+// deleteWordAtCaret deletes words from the position of the primary caret.
+func (e *Editor) deleteWordAtCaret(distance int) (deletedRunes int) {
 	start, end := e.text.Selection()
 	if start != end {
-		deletedRunes = e.Delete(1)
+		deletedRunes = e.deleteAtCaret(1)
 		distance -= sign(distance)
 	}
 	if distance == 0 {
@@ -627,7 +793,7 @@ func (e *Editor) deleteWord(distance int) (deletedRunes int) {
 			runes += 1
 		}
 	}
-	deletedRunes += e.Delete(runes * direction)
+	deletedRunes += e.deleteAtCaret(runes * direction)
 	return deletedRunes
 }
 
@@ -654,6 +820,47 @@ func (e *Editor) SetCaret(start, end int) {
 	e.scroller.Stop()
 }
 
+// AddCaret adds a caret at start, with the selection end set to end, to edit
+// at multiple places at once. The new caret becomes the primary caret, which
+// is the one reported by Selection and CaretPos. start and end are in runes.
+func (e *Editor) AddCaret(start, end int) {
+	e.initBuffer()
+	e.text.AddCaret(start, end)
+	e.scrollCaret = true
+	e.scroller.Stop()
+}
+
+// Carets returns the selections of all the carets, starting with the primary
+// caret. Start of each range is the caret position, so Start can be > End.
+func (e *Editor) Carets() []TextRange {
+	e.initBuffer()
+	positions := e.text.Carets()
+	ranges := make([]TextRange, 0, len(positions))
+	for _, pos := range positions {
+		ranges = append(ranges, TextRange{Start: pos.Start, End: pos.End})
+	}
+	return ranges
+}
+
+// ClearCarets removes all the carets but the primary one.
+func (e *Editor) ClearCarets() {
+	e.initBuffer()
+	e.text.ClearCarets()
+}
+
+// editEachCaret applies fn at every caret. When there are multiple carets, the
+// edits made by fn are grouped into a single undo operation.
+func (e *Editor) editEachCaret(fn func()) {
+	if e.text.CaretCount() <= 1 {
+		fn()
+		return
+	}
+
+	e.buffer.GroupOp()
+	defer e.buffer.UnGroupOp()
+	e.text.EachCaret(fn)
+}
+
 // SelectedText returns the currently selected text (if any) from the editor.
 func (e *Editor) SelectedText() string {
 	e.initBuffer()
@@ -690,6 +897,9 @@ func (e *Editor) Scroll(gtx layout.Context, xRatio, yRatio float32) {
 	xRatio = max(xRatio, -1.0)
 	yRatio = min(1.0, yRatio)
 	yRatio = max(yRatio, -1.0)
+	if yRatio < 0 {
+		e.tail.following = false
+	}
 
 	e.text.ScrollRel(int(float32(textDims.X)*xRatio), int(float32(textDims.Y)*yRatio))
 }
@@ -0,0 +1,68 @@
+package gvcode
+
+import (
+	"strings"
+
+	"github.com/oligo/gvcode/charset"
+)
+
+// LoadWithEncoding is like Load, but decodes the data with enc, which is useful
+// to reopen a document whose encoding is not detected correctly.
+func (e *Editor) LoadWithEncoding(data []byte, enc charset.Encoding) error {
+	text, invalid, err := charset.Decode(data, enc)
+	if err != nil {
+		return err
+	}
+
+	e.SetText(text)
+	e.encoding = enc
+	e.invalidSequences = normalizeInvalid(text, invalid)
+	return nil
+}
+
+// normalizeInvalid moves the rune offsets of the invalid sequences in text to
+// the ones after the line endings are normalized, where a "\r\n" becomes one
+// rune.
+func normalizeInvalid(text string, invalid []charset.InvalidSequence) []charset.InvalidSequence {
+	if len(invalid) == 0 || !strings.Contains(text, "\r\n") {
+		return invalid
+	}
+
+	runes, removed := 0, 0
+	next := 0
+	for i, r := range text {
+		for next < len(invalid) && invalid[next].Rune == runes {
+			invalid[next].Rune -= removed
+			next++
+		}
+		if r == '\r' && i+1 < len(text) && text[i+1] == '\n' {
+			removed++
+		}
+		runes++
+	}
+	return invalid
+}
+
+// Encoding returns the encoding of the document, which is used by WriteTo.
+func (e *Editor) Encoding() charset.Encoding {
+	if e.encoding.Name == "" {
+		return charset.UTF8
+	}
+	return e.encoding
+}
+
+// SetEncoding changes the encoding used to write the document with WriteTo.
+// The document is reported as modified by Modified until it is saved.
+func (e *Editor) SetEncoding(enc charset.Encoding) {
+	if enc != e.Encoding() {
+		e.formatChanged = true
+	}
+	e.encoding = enc
+}
+
+// InvalidSequences returns the byte sequences of the document loaded by Load
+// that can not be decoded, with their offsets in the document and the rune
+// offsets of the replacements in the text.
+func (e *Editor) InvalidSequences() []charset.InvalidSequence {
+	return e.invalidSequences
+}
@@ -4,6 +4,7 @@ import (
 	"image"
 	"io"
 	"math"
+	"slices"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -71,6 +72,9 @@ func (e *Editor) processPointer(gtx layout.Context) (EditorEvent, bool) {
 		smin, smax = sbounds.Min.X, sbounds.Max.X
 	} else {
 		e.text.ScrollRel(0, sdist)
+		if sdist < 0 {
+			e.tail.following = false
+		}
 		soff = e.text.ScrollOff().Y
 		smin, smax = sbounds.Min.Y, sbounds.Max.Y
 	}
@@ -85,6 +89,15 @@ func (e *Editor) processPointer(gtx layout.Context) (EditorEvent, bool) {
 			return ev, ok
 		}
 	}
+	for {
+		evt, ok := e.foldClicker.Update(gtx.Source)
+		if !ok {
+			break
+		}
+		if evt.Kind == gesture.KindClick {
+			e.text.ToggleFoldAt(int(math.Round(float64(evt.Position.Y))))
+		}
+	}
 	for {
 		evt, ok := e.dragger.Update(gtx.Metric, gtx.Source, gesture.Both)
 		if !ok {
@@ -125,10 +138,27 @@ func (e *Editor) processPointerEvent(gtx layout.Context, ev event.Event) (Editor
 			evt.Kind == gesture.KindClick && evt.Source != pointer.Mouse:
 			prevCaretPos, _ := e.text.Selection()
 			e.blinkStart = gtx.Now
-			e.text.MoveCoord(image.Point{
+			clickPos := image.Point{
 				X: int(math.Round(float64(evt.Position.X))),
 				Y: int(math.Round(float64(evt.Position.Y))),
-			})
+			}
+			if evt.Modifiers == key.ModAlt|key.ModShift && evt.Source == pointer.Mouse {
+				// Alt+Shift+drag makes a rectangular selection.
+				e.text.StartColumnSelection(clickPos)
+				gtx.Execute(key.FocusCmd{Tag: e})
+				e.dragging = true
+				e.dragColumn = true
+				return nil, false
+			}
+
+			e.dragColumn = false
+			if evt.Modifiers == key.ModAlt && evt.NumClicks == 1 {
+				// Alt+click adds a caret.
+				e.text.AddCaretCoord(clickPos)
+			} else {
+				e.text.ClearCarets()
+				e.text.MoveCoord(clickPos)
+			}
 			gtx.Execute(key.FocusCmd{Tag: e})
 			if e.mode != ModeReadOnly {
 				gtx.Execute(key.SoftKeyboardCmd{Show: true})
@@ -178,10 +208,15 @@ func (e *Editor) processPointerEvent(gtx layout.Context, ev event.Event) (Editor
 		case evt.Kind == pointer.Drag && evt.Source == pointer.Mouse:
 			if e.dragging {
 				e.blinkStart = gtx.Now
-				e.text.MoveCoord(image.Point{
+				dragPos := image.Point{
 					X: int(math.Round(float64(evt.Position.X))),
 					Y: int(math.Round(float64(evt.Position.Y))),
-				})
+				}
+				if e.dragColumn {
+					e.text.ExtendColumnSelection(dragPos)
+				} else {
+					e.text.MoveCoord(dragPos)
+				}
 				e.scrollCaret = true
 
 				if release {
@@ -237,6 +272,10 @@ func (e *Editor) processEditEvents(gtx layout.Context) EditorEvent {
 		case key.SnippetEvent:
 			e.updateSnippet(gtx, ke.Start, ke.End)
 		case key.EditEvent:
+			if e.vim.enabled && e.vim.state != VimInsert {
+				e.vimInput(gtx, ke.Text)
+				break
+			}
 			e.onTextInput(ke)
 		case key.SelectionEvent:
 			e.scrollCaret = true
@@ -301,7 +340,13 @@ func (e *Editor) updateSnippet(gtx layout.Context, start, end int) {
 	gtx.Execute(key.SnippetCmd{Tag: e, Snippet: newSnip})
 }
 
-func (e *Editor) onCopyCut(gtx layout.Context, k key.Event) EditorEvent {
+// onCopyCut copies the selected text, or the line of the caret if there is no
+// selection, to the clipboard, and deletes it if cut is true.
+func (e *Editor) onCopyCut(gtx layout.Context, cut bool) EditorEvent {
+	if e.text.CaretCount() > 1 {
+		return e.onCopyCutCarets(gtx, cut)
+	}
+
 	lineOp := false
 	if e.text.SelectionLen() == 0 {
 		lineOp = true
@@ -315,7 +360,7 @@ func (e *Editor) onCopyCut(gtx layout.Context, k key.Event) EditorEvent {
 
 	if text := string(e.scratch); text != "" {
 		gtx.Execute(clipboard.WriteCmd{Type: "application/text", Data: io.NopCloser(strings.NewReader(text))})
-		if k.Name == "X" && e.mode != ModeReadOnly {
+		if cut && e.mode != ModeReadOnly {
 			if !lineOp {
 				if e.Delete(1) != 0 {
 					return ChangeEvent{}
@@ -331,18 +376,62 @@ func (e *Editor) onCopyCut(gtx layout.Context, k key.Event) EditorEvent {
 	return nil
 }
 
+// onCopyCutCarets copies the selected text of every caret, one per line in
+// document order. This is also how a rectangular selection is copied.
+func (e *Editor) onCopyCutCarets(gtx layout.Context, cut bool) EditorEvent {
+	carets := e.Carets()
+	slices.SortFunc(carets, func(a, b TextRange) int {
+		return min(a.Start, a.End) - min(b.Start, b.End)
+	})
+
+	parts := make([]string, 0, len(carets))
+	empty := true
+	for _, c := range carets {
+		start, end := min(c.Start, c.End), max(c.Start, c.End)
+		e.scratch = e.scratch[:0]
+		if start != end {
+			empty = false
+			startOff, endOff := e.buffer.RuneOffset(start), e.buffer.RuneOffset(end)
+			e.scratch = slices.Grow(e.scratch, endOff-startOff)[:endOff-startOff]
+			n, _ := e.buffer.ReadAt(e.scratch, int64(startOff))
+			e.scratch = e.scratch[:n]
+		}
+		parts = append(parts, string(e.scratch))
+	}
+	if empty {
+		return nil
+	}
+
+	gtx.Execute(clipboard.WriteCmd{Type: "application/text", Data: io.NopCloser(strings.NewReader(strings.Join(parts, "\n")))})
+	if cut && e.mode != ModeReadOnly {
+		deleted := 0
+		e.editEachCaret(func() {
+			start, end := e.text.Selection()
+			if start != end {
+				deleted += abs(end - start)
+				e.replace(start, end, "")
+				e.text.MoveCaret(0, 0)
+				e.text.ClearSelection()
+			}
+		})
+		if deleted != 0 {
+			return ChangeEvent{}
+		}
+	}
+
+	return nil
+}
+
 // onTab handles tab key event. If there is no selection of lines, intert a tab character
-// at position of the cursor, else indent or unindent the selected lines, depending on if
-// the event contains the shift modifier.
-func (e *Editor) onTab(k key.Event) EditorEvent {
+// at position of the cursor, else indent or unindent the selected lines, depending on
+// dedent.
+func (e *Editor) onTab(dedent bool) EditorEvent {
 	if e.mode == ModeReadOnly {
 		return nil
 	}
 
-	shiftPressed := k.Modifiers.Contain(key.ModShift)
-
 	if e.mode == ModeSnippet {
-		if shiftPressed {
+		if dedent {
 			e.snippetCtx.PrevTabStop()
 		} else {
 			e.snippetCtx.NextTabStop()
@@ -350,9 +439,16 @@ func (e *Editor) onTab(k key.Event) EditorEvent {
 		return nil
 	}
 
-	if e.text.IndentLines(shiftPressed) > 0 {
-		// Reset xoff.
-		e.text.MoveCaret(0, 0)
+	moves := 0
+	e.editEachCaret(func() {
+		if n := e.text.IndentLines(dedent); n > 0 {
+			moves += n
+			// Reset xoff.
+			e.text.MoveCaret(0, 0)
+		}
+	})
+
+	if moves > 0 {
 		e.scrollCaret = true
 		return ChangeEvent{}
 	}
@@ -370,6 +466,37 @@ func (e *Editor) onTextInput(ke key.EditEvent) {
 		e.autoInsertions = make(map[int]rune)
 	}
 
+	// The edit range is reported relative to the primary caret. Apply the same
+	// relative range at every caret.
+	selStart, selEnd := e.text.Selection()
+	startDelta := ke.Range.Start - min(selStart, selEnd)
+	endDelta := ke.Range.End - max(selStart, selEnd)
+
+	e.editEachCaret(func() {
+		start, end := e.text.Selection()
+		e.insertAtCaret(key.EditEvent{
+			Range: key.Range{Start: min(start, end) + startDelta, End: max(start, end) + endDelta},
+			Text:  ke.Text,
+		})
+		// Reset caret xoff.
+		e.text.MoveCaret(0, 0)
+	})
+
+	e.scrollCaret = true
+	e.scroller.Stop()
+	// record lastInput for auto-complete.
+	e.lastInput = &ke
+
+	// If there is an ongoing snippet context, check if the edit is inside of
+	// a tabstop.
+	finalStart, finalEnd := e.Selection()
+	e.snippetCtx.OnInsertAt(finalStart, finalEnd)
+
+}
+
+// insertAtCaret inserts the text of ke at the primary caret, auto-inserting
+// or skipping over the counterpart of brackets and quotes.
+func (e *Editor) insertAtCaret(ke key.EditEvent) {
 	// check if the input character is a bracket or a quote.
 	r := []rune(ke.Text)[0]
 	counterpart, isOpening := e.text.BracketsQuotes.GetCounterpart(r)
@@ -422,19 +549,6 @@ func (e *Editor) onTextInput(ke key.EditEvent) {
 		delete(e.autoInsertions, ke.Range.Start)
 		e.replace(ke.Range.Start, ke.Range.End, ke.Text)
 	}
-
-	e.scrollCaret = true
-	e.scroller.Stop()
-	// Reset caret xoff.
-	e.text.MoveCaret(0, 0)
-	// record lastInput for auto-complete.
-	e.lastInput = &ke
-
-	// If there is an ongoing snippet context, check if the edit is inside of
-	// a tabstop.
-	finalStart, finalEnd := e.Selection()
-	e.snippetCtx.OnInsertAt(finalStart, finalEnd)
-
 }
 
 func (e *Editor) isNearWordChar(runeOff int, backward bool) bool {
@@ -515,6 +629,13 @@ func (e *Editor) onPasteEvent(ke transfer.DataEvent) EditorEvent {
 		text = e.onPaste(text)
 	}
 
+	if e.text.CaretCount() > 1 {
+		if e.insertAtCarets(text) != 0 {
+			return ChangeEvent{}
+		}
+		return nil
+	}
+
 	runes := 0
 	if isSingleLine(text) {
 		runes = e.InsertLine(text)
@@ -529,16 +650,48 @@ func (e *Editor) onPasteEvent(ke transfer.DataEvent) EditorEvent {
 	return nil
 }
 
-func (e *Editor) onInsertLineBreak(ke key.Event) EditorEvent {
+// insertAtCarets inserts text at every caret. If the lines of text match the
+// number of carets, as when pasting a copied rectangular selection, each caret
+// gets one line in document order.
+func (e *Editor) insertAtCarets(text string) (insertedRunes int) {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	count := e.text.CaretCount()
+	if len(lines) != count {
+		lines = nil
+	}
+
+	// EachCaret visits the carets from the end of the document to the start.
+	idx := count
+	e.editEachCaret(func() {
+		idx--
+		s := text
+		if lines != nil {
+			s = lines[idx]
+		}
+		start, end := e.text.Selection()
+		moves := e.replace(start, end, s)
+		start = min(start, end) + moves
+		e.text.SetCaret(start, start)
+		// Reset xoff.
+		e.text.MoveCaret(0, 0)
+		insertedRunes += moves
+	})
+	e.scrollCaret = true
+	return insertedRunes
+}
+
+func (e *Editor) onInsertLineBreak() EditorEvent {
 	if e.mode == ModeReadOnly {
 		return nil
 	}
 
-	e.text.IndentOnBreak("\n")
-	// Reset xoff.
+	e.editEachCaret(func() {
+		e.text.IndentOnBreak("\n")
+		// Reset xoff.
+		e.text.MoveCaret(0, 0)
+	})
 	e.scrollCaret = true
 	e.scroller.Stop()
-	e.text.MoveCaret(0, 0)
 	return ChangeEvent{}
 }
 
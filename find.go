@@ -0,0 +1,428 @@
+package gvcode
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/oligo/gvcode/buffer"
+	"github.com/oligo/gvcode/color"
+	"github.com/oligo/gvcode/search"
+	"github.com/oligo/gvcode/textstyle/decoration"
+)
+
+const (
+	// decoration sources of the search matches.
+	matchDecorationSource        = "gvcode.search"
+	currentMatchDecorationSource = "gvcode.search.current"
+)
+
+// findState holds the state of an ongoing search.
+type findState struct {
+	searcher *search.Searcher
+	matches  []search.Match
+	// current is the index of the current match, or -1 if the selection is
+	// not on a match.
+	current int
+	// dirty is set when the document is replaced and the search has to be
+	// run again.
+	dirty bool
+	// stale are the rune ranges changed since the last search, whose lines
+	// are to be searched again. The matches out of them are kept up to date
+	// with the changes.
+	stale []TextRange
+}
+
+// Find searches the document for the query, and highlights all the matches.
+// The match at the selection, if any, becomes the current match. Matches are
+// updated when the document changes, until ClearFind is called. It returns the
+// number of matches.
+func (e *Editor) Find(query search.Query) (int, error) {
+	e.initBuffer()
+
+	searcher, err := search.Compile(query)
+	if err != nil {
+		return 0, err
+	}
+
+	e.finder = &findState{searcher: searcher, current: -1}
+	if err := e.runSearch(); err != nil {
+		return 0, err
+	}
+	return len(e.finder.matches), nil
+}
+
+// ClearFind stops the ongoing search and removes the match highlights.
+func (e *Editor) ClearFind() {
+	e.initBuffer()
+	if e.finder == nil {
+		return
+	}
+
+	e.finder = nil
+	e.text.ClearDecorations(matchDecorationSource)
+	e.text.ClearDecorations(currentMatchDecorationSource)
+}
+
+// Matches returns the ranges of all the matches of the ongoing search.
+func (e *Editor) Matches() []TextRange {
+	if e.refreshFind() != nil {
+		return nil
+	}
+
+	ranges := make([]TextRange, 0, len(e.finder.matches))
+	for _, m := range e.finder.matches {
+		ranges = append(ranges, TextRange{Start: m.Start, End: m.End})
+	}
+	return ranges
+}
+
+// MatchIndex returns the 1-based index of the current match and the total
+// number of matches, as in "3 of 17". current is 0 if the selection is not on a
+// match.
+func (e *Editor) MatchIndex() (current, total int) {
+	if e.refreshFind() != nil {
+		return 0, 0
+	}
+
+	return e.finder.current + 1, len(e.finder.matches)
+}
+
+// FindNext selects the first match after the caret, wrapping around at the end
+// of the document. It returns the range of the selected match, and false if
+// there is no match.
+func (e *Editor) FindNext() (TextRange, bool) {
+	if e.refreshFind() != nil || len(e.finder.matches) == 0 {
+		return TextRange{}, false
+	}
+
+	start, end := e.text.Selection()
+	caret := max(start, end)
+	idx, _ := slices.BinarySearchFunc(e.finder.matches, caret, func(m search.Match, off int) int {
+		return m.Start - off
+	})
+	if idx >= len(e.finder.matches) {
+		idx = 0
+	}
+
+	return e.selectMatch(idx), true
+}
+
+// FindPrev selects the last match before the caret, wrapping around at the
+// start of the document. It returns the range of the selected match, and
+// false if there is no match.
+func (e *Editor) FindPrev() (TextRange, bool) {
+	if e.refreshFind() != nil || len(e.finder.matches) == 0 {
+		return TextRange{}, false
+	}
+
+	start, end := e.text.Selection()
+	caret := min(start, end)
+	idx, _ := slices.BinarySearchFunc(e.finder.matches, caret, func(m search.Match, off int) int {
+		return m.End - off
+	})
+	// idx is the first match ending at or after the caret.
+	idx--
+	if idx < 0 {
+		idx = len(e.finder.matches) - 1
+	}
+
+	return e.selectMatch(idx), true
+}
+
+// ReplaceMatch replaces the current match with the expansion of template, and
+// selects the next match. If the query is a regular expression, template can
+// refer to the capture groups using the syntax of regexp.Regexp.Expand, such as
+// $1 or ${name}. If the selection is not on a match, the next match is selected
+// without replacing anything. It reports whether a match is replaced.
+func (e *Editor) ReplaceMatch(template string) bool {
+	if e.mode == ModeReadOnly || e.refreshFind() != nil {
+		return false
+	}
+
+	if e.finder.current < 0 {
+		e.FindNext()
+		return false
+	}
+
+	m := e.finder.matches[e.finder.current]
+	replacement, ok := e.expandMatch(m, template)
+	if !ok {
+		return false
+	}
+
+	end := m.Start + e.replace(m.Start, m.End, replacement)
+	e.text.ClearCarets()
+	e.SetCaret(end, end)
+	e.FindNext()
+	return true
+}
+
+// ReplaceAllMatches replaces every match with the expansion of template in a
+// single undoable operation. See ReplaceMatch for the syntax of template. It
+// returns the number of matches replaced.
+func (e *Editor) ReplaceAllMatches(template string) int {
+	if e.mode == ModeReadOnly || e.refreshFind() != nil || len(e.finder.matches) == 0 {
+		return 0
+	}
+
+	// Expand all the replacements before changing the document.
+	replacements := make([]string, len(e.finder.matches))
+	for i, m := range e.finder.matches {
+		replacement, ok := e.expandMatch(m, template)
+		if !ok {
+			return 0
+		}
+		replacements[i] = replacement
+	}
+
+	// Traverse in reverse order to prevent match offsets from changing after
+	// each replace.
+	e.buffer.GroupOp()
+	for idx := len(e.finder.matches) - 1; idx >= 0; idx-- {
+		m := e.finder.matches[idx]
+		e.replace(m.Start, m.End, replacements[idx])
+	}
+	e.buffer.UnGroupOp()
+
+	replaced := len(e.finder.matches)
+	first := e.finder.matches[0].Start
+	e.text.ClearCarets()
+	e.SetCaret(first, first)
+	return replaced
+}
+
+// invalidateFind marks the matches of the ongoing search as outdated after the
+// document is replaced.
+func (e *Editor) invalidateFind() {
+	if e.finder != nil {
+		e.finder.dirty = true
+	}
+}
+
+// findOnTextChange shifts the matches after the change c, and drops the ones
+// overlapping it. The changed range is searched again by refreshFind.
+func (e *Editor) findOnTextChange(c TextChange) {
+	f := e.finder
+	if f == nil || f.dirty {
+		return
+	}
+
+	start, oldEnd := c.Start, c.Start+c.RemovedRunes
+	newEnd := start + utf8.RuneCountInString(c.Text)
+	delta := newEnd - oldEnd
+
+	i, _ := slices.BinarySearchFunc(f.matches, start, func(m search.Match, off int) int {
+		return m.End - off
+	})
+	j := i
+	for j < len(f.matches) && f.matches[j].Start <= oldEnd {
+		j++
+	}
+	for k := j; k < len(f.matches); k++ {
+		f.matches[k].Start += delta
+		f.matches[k].End += delta
+		if f.matches[k].LineStart >= oldEnd {
+			f.matches[k].LineStart += delta
+		}
+	}
+	f.matches = slices.Delete(f.matches, i, j)
+	f.current = -1
+
+	changed := TextRange{Start: start, End: newEnd}
+	stale := f.stale[:0]
+	for _, r := range f.stale {
+		switch {
+		case r.Start > oldEnd:
+			r.Start += delta
+			r.End += delta
+		case r.End >= start:
+			// Merge the range touching the change.
+			if r.End >= oldEnd {
+				r.End += delta
+			} else {
+				r.End = newEnd
+			}
+			changed.Start = min(changed.Start, r.Start)
+			changed.End = max(changed.End, r.End)
+			continue
+		}
+		stale = append(stale, r)
+	}
+	f.stale = append(stale, changed)
+}
+
+// refreshFind runs the search again if the document has changed, in the lines
+// changed only, unless the document is replaced. It returns io.EOF if there is
+// no ongoing search.
+func (e *Editor) refreshFind() error {
+	e.initBuffer()
+	if e.finder == nil {
+		return io.EOF
+	}
+	if e.finder.dirty {
+		return e.runSearch()
+	}
+	if len(e.finder.stale) == 0 {
+		return nil
+	}
+
+	stale := e.finder.stale
+	e.finder.stale = nil
+	slices.SortFunc(stale, func(a, b TextRange) int { return a.Start - b.Start })
+	for i := 0; i < len(stale); {
+		// Search the whole lines of the ranges, merging the ranges sharing a
+		// line.
+		start := e.buffer.LineStart(e.buffer.LineOf(stale[i].Start))
+		end := stale[i].End
+		for i++; i < len(stale) && e.buffer.LineOf(stale[i].Start) <= e.buffer.LineOf(end); i++ {
+			end = max(end, stale[i].End)
+		}
+		if line := e.buffer.LineOf(end); line+1 < e.buffer.Lines() {
+			end = e.buffer.LineStart(line + 1)
+		} else {
+			end = e.buffer.Len()
+		}
+
+		if err := e.searchRange(start, end); err != nil {
+			return err
+		}
+	}
+
+	start, end := e.text.Selection()
+	e.setCurrentMatch(slices.IndexFunc(e.finder.matches, func(m search.Match) bool {
+		return m.Start == min(start, end) && m.End == max(start, end)
+	}))
+	return nil
+}
+
+// searchRange replaces the matches in the lines of the range [start, end) of
+// rune offsets by the ones searched again.
+func (e *Editor) searchRange(start, end int) error {
+	byteStart := e.buffer.RuneOffset(start)
+	r := io.NewSectionReader(e.buffer, int64(byteStart), int64(e.buffer.RuneOffset(end)-byteStart))
+	var found []search.Match
+	err := e.finder.searcher.FindFunc(r, func(m search.Match) bool {
+		m.Start += start
+		m.End += start
+		m.LineStart += start
+		found = append(found, m)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	matches := e.finder.matches
+	i, _ := slices.BinarySearchFunc(matches, start, func(m search.Match, off int) int {
+		return m.Start - off
+	})
+	j := i
+	for j < len(matches) && matches[j].Start < end {
+		j++
+	}
+	e.finder.matches = slices.Replace(matches, i, j, found...)
+
+	if err := e.text.ClearDecorationsInRange(matchDecorationSource, start, end); err != nil {
+		return err
+	}
+	return e.text.AddDecorations(e.matchDecorations(found)...)
+}
+
+func (e *Editor) runSearch() error {
+	matches, err := e.finder.searcher.FindAll(buffer.NewReader(e.buffer))
+	if err != nil {
+		return err
+	}
+
+	e.finder.matches = matches
+	e.finder.dirty = false
+	e.finder.stale = nil
+
+	e.text.ClearDecorations(matchDecorationSource)
+	if err := e.text.AddDecorations(e.matchDecorations(matches)...); err != nil {
+		return err
+	}
+
+	start, end := e.text.Selection()
+	e.setCurrentMatch(slices.IndexFunc(matches, func(m search.Match) bool {
+		return m.Start == min(start, end) && m.End == max(start, end)
+	}))
+	return nil
+}
+
+// matchDecorations returns the decorations highlighting the matches.
+func (e *Editor) matchDecorations(matches []search.Match) []decoration.Decoration {
+	decos := make([]decoration.Decoration, 0, len(matches))
+	bg := &decoration.Background{Color: e.matchColor()}
+	for _, m := range matches {
+		decos = append(decos, decoration.Decoration{
+			Source:     matchDecorationSource,
+			Start:      m.Start,
+			End:        m.End,
+			Background: bg,
+		})
+	}
+	return decos
+}
+
+// selectMatch selects the match at idx, and makes it the current match.
+func (e *Editor) selectMatch(idx int) TextRange {
+	m := e.finder.matches[idx]
+	e.text.ClearCarets()
+	// Reveal the match if it is folded.
+	e.text.UnfoldRange(m.Start, m.End)
+	e.SetCaret(m.End, m.Start)
+	e.setCurrentMatch(idx)
+	return TextRange{Start: m.Start, End: m.End}
+}
+
+func (e *Editor) setCurrentMatch(idx int) {
+	e.finder.current = idx
+	e.text.ClearDecorations(currentMatchDecorationSource)
+	if idx < 0 {
+		return
+	}
+
+	m := e.finder.matches[idx]
+	var border color.Color
+	if e.colorPalette != nil {
+		border = e.colorPalette.Foreground
+	}
+	e.text.AddDecorations(decoration.Decoration{
+		Source:     currentMatchDecorationSource,
+		Priority:   1,
+		Start:      m.Start,
+		End:        m.End,
+		Background: &decoration.Background{Color: e.matchColor()},
+		Border:     &decoration.Border{Color: border},
+	})
+}
+
+// matchColor returns the background color of the matches.
+func (e *Editor) matchColor() color.Color {
+	if e.colorPalette == nil {
+		return color.Color{}
+	}
+	if e.colorPalette.SelectColor.IsSet() {
+		return e.colorPalette.SelectColor.MulAlpha(0x80)
+	}
+	return e.colorPalette.Foreground.MulAlpha(0x30)
+}
+
+// expandMatch returns the replacement of the match m.
+func (e *Editor) expandMatch(m search.Match, template string) (string, bool) {
+	if !e.finder.searcher.Query().Regex {
+		return template, true
+	}
+
+	reader := buffer.NewReader(e.buffer)
+	reader.Seek(int64(e.buffer.RuneOffset(m.LineStart)), io.SeekStart)
+	line, err := bufio.NewReader(reader).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return "", false
+	}
+
+	return e.finder.searcher.Expand(line, m.Start-m.LineStart, template)
+}
@@ -0,0 +1,61 @@
+package gvcode
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/oligo/gvcode/search"
+)
+
+// wantMatches returns the ranges of the matches of a full search of the text.
+func wantMatches(t *testing.T, e *Editor, query search.Query) []TextRange {
+	t.Helper()
+	searcher, err := search.Compile(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches, err := searcher.FindAll(strings.NewReader(e.Text()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := []TextRange{}
+	for _, m := range matches {
+		ranges = append(ranges, TextRange{Start: m.Start, End: m.End})
+	}
+	return ranges
+}
+
+func TestFindFollowsEdits(t *testing.T) {
+	query := search.Query{Pattern: "foo", WholeWord: true}
+	e := &Editor{}
+	e.SetText(strings.Repeat("foo bar\nbaz foo foo\n", 20))
+	if _, err := e.Find(query); err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	inserts := []string{"foo", "f", "o", " ", "\n", "x", "foo\nfoo", ""}
+	for i := 0; i < 500; i++ {
+		n := e.Len()
+		start := r.Intn(n + 1)
+		end := min(start+r.Intn(5), n)
+		e.SetCaret(start, end)
+		e.Insert(inserts[r.Intn(len(inserts))])
+
+		// Several edits may be made between the refreshes.
+		if i%3 != 0 {
+			continue
+		}
+		want := wantMatches(t, e, query)
+		if got := append([]TextRange{}, e.Matches()...); !slices.Equal(got, want) {
+			t.Fatalf("edit %d: want matches %v, got %v", i, want, got)
+		}
+	}
+
+	e.SetText("no match")
+	if got := e.Matches(); len(got) != 0 {
+		t.Fatalf("want no match after SetText, got %v", got)
+	}
+}
@@ -0,0 +1,58 @@
+package gvcode
+
+import (
+	"github.com/oligo/gvcode/textview"
+)
+
+// FoldRange is a range of lines that can be folded. When folded, StartLine
+// stays visible with a "⋯" marker, and the lines after it, up to and including
+// EndLine, are hidden. Lines are counted from zero.
+type FoldRange = textview.FoldRange
+
+// FoldProvider computes the fold ranges of the document. Providers backed by a
+// language server may return the ranges received most recently, and call
+// RefreshFoldRanges when new ranges arrive.
+type FoldProvider = textview.FoldProvider
+
+// IndentFoldProvider is the default fold provider, which folds the lines by
+// their indentation.
+type IndentFoldProvider = textview.IndentFoldProvider
+
+// FoldRanges returns the fold ranges of the document, sorted by the start line.
+// It returns nil if folding is not enabled.
+func (e *Editor) FoldRanges() []FoldRange {
+	e.initBuffer()
+	return e.text.FoldRanges()
+}
+
+// FoldedRanges returns the line ranges currently folded.
+func (e *Editor) FoldedRanges() []FoldRange {
+	e.initBuffer()
+	return e.text.FoldedRanges()
+}
+
+// RefreshFoldRanges requests the fold ranges from the fold provider again.
+func (e *Editor) RefreshFoldRanges() {
+	e.initBuffer()
+	e.text.InvalidateFoldRanges()
+}
+
+// ToggleFold folds or unfolds the fold range starting at line. It reports
+// whether the folding state is changed.
+func (e *Editor) ToggleFold(line int) bool {
+	e.initBuffer()
+	return e.text.ToggleFold(line)
+}
+
+// Fold hides the lines of the range after its start line. It reports whether
+// the range is folded.
+func (e *Editor) Fold(rng FoldRange) bool {
+	e.initBuffer()
+	return e.text.Fold(rng)
+}
+
+// UnfoldAll unfolds all the folded ranges.
+func (e *Editor) UnfoldAll() {
+	e.initBuffer()
+	e.text.UnfoldAll()
+}
@@ -5,15 +5,16 @@ go 1.23
 require (
 	gioui.org v0.8.0
 	github.com/andybalholm/stroke v0.0.0-20230904101225-24ef450bc62c
+	github.com/dlclark/regexp2 v1.11.5
 	github.com/go-text/typesetting v0.3.0
 	github.com/rdleal/intervalst v1.4.1
 	golang.org/x/exp v0.0.0-20240707233637-46b078467d37
 	golang.org/x/image v0.23.0
+	golang.org/x/text v0.21.0
 )
 
 require (
 	gioui.org/shader v1.0.8 // indirect
 	golang.org/x/exp/shiny v0.0.0-20240707233637-46b078467d37 // indirect
 	golang.org/x/sys v0.25.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
 )
@@ -0,0 +1,86 @@
+package gvcode
+
+import (
+	"io"
+	"time"
+
+	"github.com/oligo/gvcode/buffer"
+)
+
+// HistoryNode is a change in the undo history of the editor. The history is
+// a tree: making a change after undoing others starts a new branch, and the
+// undone changes are kept in the old branch, so that they can be restored
+// with GotoHistory, or by stepping back and forth in time with Earlier and
+// Later.
+type HistoryNode struct {
+	// Seq is the sequence number of the change, counting from 1 in the order
+	// the changes are made. 0 is the state before any change.
+	Seq int
+	// Parent is the Seq of the change this one is made upon.
+	Parent int
+	// Time is when the change is made, or last extended for changes of
+	// continuous typing.
+	Time time.Time
+}
+
+// History returns all the changes in the undo history, ordered by the
+// sequence numbers, and the sequence number of the current state.
+func (e *Editor) History() (nodes []HistoryNode, current int) {
+	e.initBuffer()
+	changes, current := e.buffer.History()
+	nodes = make([]HistoryNode, len(changes))
+	for i, c := range changes {
+		nodes[i] = HistoryNode{Seq: c.Seq, Parent: c.Parent, Time: c.Time}
+	}
+	return nodes, current
+}
+
+// GotoHistory restores the text to the state after the change of seq, or to
+// the original state if seq is 0. It reports whether the text is changed.
+func (e *Editor) GotoHistory(seq int) bool {
+	e.initBuffer()
+	positions, ok := e.text.GotoHistory(seq)
+	if !ok {
+		return false
+	}
+
+	e.restoreCarets(positions)
+	return true
+}
+
+// Earlier steps back in time by the number of changes, regardless of the
+// branches of the undo history, like the g- command of Vim. It reports
+// whether the text is changed.
+func (e *Editor) Earlier(steps int) bool {
+	_, current := e.History()
+	return e.GotoHistory(max(0, current-steps))
+}
+
+// Later steps forward in time by the number of changes, regardless of the
+// branches of the undo history, like the g+ command of Vim. It reports
+// whether the text is changed.
+func (e *Editor) Later(steps int) bool {
+	nodes, current := e.History()
+	return e.GotoHistory(min(len(nodes), current+steps))
+}
+
+// ErrHistoryMismatch is returned by LoadHistory if the undo history is saved
+// for a text different from the current one.
+var ErrHistoryMismatch = buffer.ErrHistoryMismatch
+
+// SaveHistory writes the undo history to w in a versioned binary format, so
+// that it survives the editor, for example when the file is closed and opened
+// again. The saved history includes the text, which is needed to undo and
+// redo the changes.
+func (e *Editor) SaveHistory(w io.Writer) error {
+	e.initBuffer()
+	return e.buffer.SaveHistory(w)
+}
+
+// LoadHistory restores the undo history saved by SaveHistory. The history is
+// accepted only if it is saved for the current text of the editor, which is
+// usually set by SetText beforehand, otherwise ErrHistoryMismatch is returned.
+func (e *Editor) LoadHistory(r io.Reader) error {
+	e.initBuffer()
+	return e.buffer.LoadHistory(r)
+}
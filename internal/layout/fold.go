@@ -0,0 +1,9 @@
+package layout
+
+// Fold is a range of whole paragraphs hidden from the layout. Start is the
+// rune offset of the first hidden paragraph, and End is the rune offset after
+// the last one, including its line break. The paragraph before Start stays
+// visible as the placeholder of the fold.
+type Fold struct {
+	Start, End int
+}
@@ -0,0 +1,150 @@
+package layout
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"gioui.org/text"
+	"github.com/oligo/gvcode/buffer"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestLayoutFolds(t *testing.T) {
+	shaper := text.NewShaper()
+	params := &text.Parameters{PxPerEm: fixed.I(14)}
+
+	src := buffer.NewTextSource()
+	src.SetText([]byte("func a() {\n\tb\n\tc\n}\n"))
+	layouter := NewTextLayout(src)
+	layouter.Folds = []Fold{{Start: src.LineStart(1), End: src.LineStart(3)}}
+	layouter.Layout(shaper, params, 4, false)
+
+	if len(layouter.Lines) != 3 {
+		t.Fatalf("want 3 lines, got %d", len(layouter.Lines))
+	}
+	if got := layouter.ParagraphCount(); got != 5 {
+		t.Fatalf("want 5 paragraphs, got %d", got)
+	}
+	if layouter.Lines[1].RuneOff != src.LineStart(3) {
+		t.Fatalf("want the line after the fold at %d, got %d", src.LineStart(3), layouter.Lines[1].RuneOff)
+	}
+
+	hidden := func(runeOff int) bool { return runeOff >= src.LineStart(1) && runeOff < src.LineStart(3) }
+	for _, pos := range layouter.Positions {
+		if hidden(pos.Runes) {
+			t.Fatalf("position in folded text: %v", pos)
+		}
+	}
+	for _, g := range layouter.Graphemes {
+		if hidden(g) {
+			t.Fatalf("grapheme boundary in folded text: %d", g)
+		}
+	}
+
+	// The line after the fold comes right after the placeholder line.
+	header, _ := layouter.ClosestToRune(src.LineStart(1) - 1)
+	next, _ := layouter.ClosestToRune(src.LineStart(3))
+	if next.LineCol.Line != header.LineCol.Line+1 {
+		t.Fatalf("want line %d after the fold, got %d", header.LineCol.Line+1, next.LineCol.Line)
+	}
+}
+
+func TestLayoutWindowFolds(t *testing.T) {
+	shaper := text.NewShaper()
+	params := &text.Parameters{PxPerEm: fixed.I(14)}
+
+	src := buffer.NewTextSource()
+	src.SetText([]byte(strings.Repeat("Hello, world\n", 1000)))
+	folds := []Fold{
+		{Start: src.LineStart(11), End: src.LineStart(21)},
+		{Start: src.LineStart(100), End: src.LineStart(500)},
+	}
+
+	full := NewTextLayout(src)
+	full.Folds = folds
+	full.Layout(shaper, params, 4, false)
+
+	for _, center := range []int{0, 50, 300, 999} {
+		win := NewTextLayout(src)
+		win.Folds = folds
+		win.LayoutWindow(shaper, params, 4, false, center, 200)
+
+		if win.ParagraphCount() != full.ParagraphCount() {
+			t.Fatalf("center %d: want %d paragraphs, got %d", center, full.ParagraphCount(), win.ParagraphCount())
+		}
+		for _, line := range win.Lines {
+			for _, f := range folds {
+				if line.RuneOff >= f.Start && line.RuneOff < f.End {
+					t.Fatalf("center %d: line in folded text: %v", center, line)
+				}
+			}
+		}
+	}
+}
+
+func TestUpdateLayoutFolds(t *testing.T) {
+	words := []string{"a", "\n", "foo\nbar", "\t", "line\n\n"}
+	shaper := text.NewShaper()
+	params := &text.Parameters{PxPerEm: fixed.I(14), MaxWidth: 200}
+
+	rnd := rand.New(rand.NewPCG(3, 4))
+	src := buffer.NewTextSource()
+	src.SetText([]byte(strings.Repeat("Hello, world\n", 30)))
+
+	// Anchor the folds with markers, as the text view does.
+	type anchor struct{ start, end *buffer.Marker }
+	var anchors []anchor
+	for _, lines := range [][2]int{{4, 10}, {19, 24}} {
+		start, _ := src.CreateMarker(src.LineStart(lines[0]), buffer.BiasForward)
+		end, _ := src.CreateMarker(src.LineStart(lines[1]+1)-1, buffer.BiasBackward)
+		anchors = append(anchors, anchor{start, end})
+	}
+	folds := func() []Fold {
+		var folds []Fold
+		for _, a := range anchors {
+			startLine, endLine := src.LineOf(a.start.Offset()), src.LineOf(a.end.Offset())
+			f := Fold{Start: src.LineStart(startLine + 1), End: src.LineStart(endLine + 1)}
+			if endLine <= startLine || (len(folds) > 0 && f.Start <= folds[len(folds)-1].End) {
+				continue
+			}
+			folds = append(folds, f)
+		}
+		return folds
+	}
+	layouter := NewTextLayout(src)
+	layouter.Folds = folds()
+	layouter.Layout(shaper, params, 4, true)
+
+	for i := 0; i < 200; i++ {
+		length := src.Len()
+		start := rnd.IntN(length + 1)
+		end := min(length, start+rnd.IntN(2)*rnd.IntN(6))
+		insert := ""
+		if rnd.IntN(3) > 0 {
+			insert = words[rnd.IntN(len(words))]
+		}
+		src.Replace(start, end, insert)
+
+		layouter.Folds = folds()
+		dims := layouter.Update(shaper, params, 4, true, start, end, start+utf8.RuneCountInString(insert))
+		want := NewTextLayout(src)
+		want.Folds = folds()
+		wantDims := want.Layout(shaper, params, 4, true)
+
+		if dims != wantDims {
+			t.Fatalf("#%d: want dims %v, got %v", i, wantDims, dims)
+		}
+		if !slices.Equal(layouter.Positions, want.Positions) {
+			t.Fatalf("#%d: positions mismatch", i)
+		}
+		if !slices.Equal(layouter.Graphemes, want.Graphemes) {
+			t.Fatalf("#%d: want graphemes %v, got %v", i, want.Graphemes, layouter.Graphemes)
+		}
+		if layouter.ParagraphCount() != want.ParagraphCount() {
+			t.Fatalf("#%d: want %d paragraphs, got %d", i, want.ParagraphCount(), layouter.ParagraphCount())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package layout
+
+import (
+	"image"
+	"slices"
+	"sort"
+
+	"gioui.org/layout"
+	"gioui.org/text"
+)
+
+// Update updates the layout after the text in the rune range [start, oldEnd)
+// is replaced by the text now in [start, newEnd). Only the paragraphs touched
+// by the edit are shaped again, the lines, positions and graphemes of the
+// following paragraphs are shifted. The layout must be done by Layout with the
+// same parameters, otherwise the whole text is laid out again.
+func (tl *TextLayout) Update(shaper *text.Shaper, params *text.Parameters, tabWidth int, wrapLine bool, start, oldEnd, newEnd int) layout.Dimensions {
+	if shaper == nil || tl.win.enabled || len(tl.Paragraphs) == 0 || len(tl.Graphemes) == 0 ||
+		tl.params != *params || tl.tabWidth != tabWidth || tl.wrapLine != wrapLine || tl.src.Len() == 0 {
+		return tl.Layout(shaper, params, tabWidth, wrapLine)
+	}
+
+	// Find the paragraphs overlapping the edit. The paragraphs to the end are
+	// always shaped again if the last one is touched, as the empty line after
+	// a trailing line break depends on the last paragraph, which may also be
+	// the one before the edit after a deletion.
+	findParagraph := func(runeOff int) int {
+		idx := sort.Search(len(tl.Paragraphs), func(i int) bool {
+			p := tl.Paragraphs[i]
+			return p.RuneOff+p.Runes > runeOff
+		})
+		return min(idx, len(tl.Paragraphs)-1)
+	}
+	first, last := findParagraph(start), findParagraph(oldEnd)
+	toEnd := last >= len(tl.Paragraphs)-2
+	if toEnd {
+		first = max(0, first-1)
+	}
+
+	delta := newEnd - oldEnd
+	runeStart := tl.Paragraphs[first].RuneOff
+	oldRuneEnd := tl.Paragraphs[last].RuneOff + tl.Paragraphs[last].Runes
+	if toEnd {
+		oldRuneEnd = tl.src.Len() - delta
+	}
+	newRuneEnd := oldRuneEnd + delta
+
+	// Folds touching the edit or the reshaped paragraphs change what is hidden,
+	// so the whole text is laid out again. An edit in folded text is not in
+	// any of the paragraphs.
+	for _, fold := range tl.Folds {
+		if fold.End >= min(start, runeStart) && (toEnd || fold.Start <= max(newEnd, newRuneEnd)) {
+			return tl.Layout(shaper, params, tabWidth, wrapLine)
+		}
+	}
+
+	lineStart := sort.Search(len(tl.Lines), func(i int) bool { return tl.Lines[i].RuneOff >= runeStart })
+	lineEnd := len(tl.Lines)
+	if !toEnd {
+		lineEnd = sort.Search(len(tl.Lines), func(i int) bool { return tl.Lines[i].RuneOff >= oldRuneEnd })
+	}
+
+	// Shape the new paragraphs to a separate set of lines.
+	lines, graphemes, paragraphs := tl.Lines, tl.Graphemes, tl.Paragraphs
+	tl.Lines, tl.Graphemes = nil, []int{runeStart}
+	runeEnd := newRuneEnd
+	if toEnd {
+		runeEnd = tl.src.Len()
+	}
+	tl.layoutParagraphs(shaper, runeStart, runeEnd, tabWidth, wrapLine, nil)
+	newLines, newGraphemes := tl.Lines, tl.Graphemes[1:]
+	tl.Lines, tl.Graphemes = lines, graphemes
+
+	// Lines are placed one line height apart, starting from the ascent of the
+	// first line.
+	lineHeight := tl.calcLineHeight(&tl.params).Round()
+	lineDelta := len(newLines) - (lineEnd - lineStart)
+	oldFirstY, firstY := tl.Lines[0].YOff, tl.Lines[0].YOff
+	if lineStart == 0 && len(newLines) > 0 {
+		firstY = newLines[0].Ascent.Ceil()
+	}
+	yDelta := firstY - oldFirstY + lineDelta*lineHeight
+
+	for i := range newLines {
+		line := &newLines[i]
+		alignOff := tl.params.Alignment.Align(tl.params.Locale.Direction, line.Width, tl.params.MaxWidth)
+		line.recompute(alignOff, line.RuneOff)
+		line.adjustYOff(firstY + (lineStart+i)*lineHeight)
+	}
+
+	// Shift the lines after the edit.
+	for i := lineEnd; i < len(tl.Lines); i++ {
+		line := &tl.Lines[i]
+		line.RuneOff += delta
+		if yDelta != 0 {
+			line.adjustYOff(line.YOff + yDelta)
+		}
+	}
+
+	// Replace the positions of the old lines, and shift the rest.
+	posStart := sort.Search(len(tl.Positions), func(i int) bool { return tl.Positions[i].LineCol.Line >= lineStart })
+	posEnd := sort.Search(len(tl.Positions), func(i int) bool { return tl.Positions[i].LineCol.Line >= lineEnd })
+	for i := posEnd; i < len(tl.Positions); i++ {
+		pos := &tl.Positions[i]
+		pos.Runes += delta
+		pos.Y += yDelta
+		pos.LineCol.Line += lineDelta
+	}
+	positions := tl.Positions
+	tl.Positions = nil
+	for i, line := range newLines {
+		tl.indexGlyphs(lineStart+i, line)
+	}
+	tl.Positions = slices.Replace(positions, posStart, posEnd, tl.Positions...)
+
+	// Replace the grapheme boundaries of the old paragraphs, and shift the rest.
+	graphemeStart := sort.SearchInts(tl.Graphemes, runeStart+1)
+	graphemeEnd := len(tl.Graphemes)
+	if !toEnd {
+		graphemeEnd = sort.SearchInts(tl.Graphemes, oldRuneEnd+1)
+	}
+	for i := graphemeEnd; i < len(tl.Graphemes); i++ {
+		tl.Graphemes[i] += delta
+	}
+	tl.Graphemes = slices.Replace(tl.Graphemes, graphemeStart, graphemeEnd, newGraphemes...)
+
+	tl.Lines = slices.Replace(tl.Lines, lineStart, lineEnd, newLines...)
+
+	// Paragraphs are cheap to track, so just track them again from the lines.
+	tl.Paragraphs = paragraphs[:0]
+	tl.trackLines(tl.Lines)
+
+	tl.bounds = image.Rectangle{}
+	for _, line := range tl.Lines {
+		tl.updateBounds(line)
+	}
+
+	dims := layout.Dimensions{Size: tl.bounds.Size()}
+	dims.Baseline = dims.Size.Y - tl.baseline
+	return dims
+}
@@ -0,0 +1,79 @@
+package layout
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"gioui.org/text"
+	"github.com/oligo/gvcode/buffer"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestUpdateLayout(t *testing.T) {
+	words := []string{"a", "你好 ", "\n", "foo\nbar", "\t", "line\n\n", "a fox jumps over the lazy dog "}
+	shaper := text.NewShaper()
+	params := &text.Parameters{PxPerEm: fixed.I(14), MaxWidth: 200}
+
+	for _, wrapLine := range []bool{false, true} {
+		rnd := rand.New(rand.NewPCG(1, 2))
+		src := buffer.NewTextSource()
+		src.SetText([]byte(strings.Repeat("Hello, world\n", 20)))
+		layouter := NewTextLayout(src)
+		layouter.Layout(shaper, params, 4, wrapLine)
+
+		for i := 0; i < 300; i++ {
+			length := src.Len()
+			start := rnd.IntN(length + 1)
+			end := min(length, start+rnd.IntN(2)*rnd.IntN(6))
+			insert := ""
+			if rnd.IntN(3) > 0 {
+				insert = words[rnd.IntN(len(words))]
+			}
+			src.Replace(start, end, insert)
+
+			dims := layouter.Update(shaper, params, 4, wrapLine, start, end, start+utf8.RuneCountInString(insert))
+			want := NewTextLayout(src)
+			wantDims := want.Layout(shaper, params, 4, wrapLine)
+
+			if dims != wantDims {
+				t.Fatalf("#%d: want dims %v, got %v", i, wantDims, dims)
+			}
+			if !slices.Equal(layouter.Positions, want.Positions) {
+				t.Fatalf("#%d: positions mismatch", i)
+			}
+			if !slices.Equal(layouter.Graphemes, want.Graphemes) {
+				t.Fatalf("#%d: want graphemes %v, got %v", i, want.Graphemes, layouter.Graphemes)
+			}
+			if !slices.Equal(layouter.Paragraphs, want.Paragraphs) {
+				t.Fatalf("#%d: want paragraphs %v, got %v", i, want.Paragraphs, layouter.Paragraphs)
+			}
+			if !slices.EqualFunc(layouter.Lines, want.Lines, func(a, b Line) bool {
+				return a.String() == b.String() && slices.EqualFunc(a.Glyphs, b.Glyphs, func(x, y *text.Glyph) bool {
+					return *x == *y
+				})
+			}) {
+				t.Fatalf("#%d: lines mismatch", i)
+			}
+		}
+	}
+}
+
+func BenchmarkUpdateLayout(b *testing.B) {
+	src := buffer.NewTextSource()
+	src.SetText([]byte(strings.Repeat("a fox jumps over the lazy dog\n", 20000)))
+	shaper := text.NewShaper()
+	params := &text.Parameters{PxPerEm: fixed.I(14)}
+
+	layouter := NewTextLayout(src)
+	layouter.Layout(shaper, params, 4, false)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pos := src.LineStart(10000) + 3
+		src.Replace(pos, pos, "x")
+		layouter.Update(shaper, params, 4, false, pos, pos, pos+1)
+	}
+}
@@ -12,7 +12,7 @@ import (
 	"gioui.org/layout"
 	"gioui.org/text"
 	"github.com/go-text/typesetting/segmenter"
-	"github.com/oligo/gvcode/internal/buffer"
+	"github.com/oligo/gvcode/buffer"
 	"golang.org/x/image/math/fixed"
 )
 
@@ -37,6 +37,17 @@ type TextLayout struct {
 	bounds image.Rectangle
 	// baseline tracks the location of the first line's baseline.
 	baseline int
+	// win tracks the shaped paragraphs of a virtualized layout.
+	win window
+	// tab width and line wrapping used by the last layout.
+	tabWidth int
+	wrapLine bool
+
+	// Folds are the ranges of text hidden from the layout. They must be sorted
+	// and not overlap each other.
+	Folds []Fold
+	// hidden is the number of paragraphs hidden by the folds.
+	hidden int
 }
 
 func NewTextLayout(src buffer.TextSource) TextLayout {
@@ -46,6 +57,12 @@ func NewTextLayout(src buffer.TextSource) TextLayout {
 	}
 }
 
+// SpaceWidth returns the advance of the space glyph, which is also the width of
+// a column when the tabs are expanded to tab stops.
+func (tl *TextLayout) SpaceWidth() fixed.Int26_6 {
+	return tl.spaceGlyph.Advance
+}
+
 // Calculate line height. Maybe there's a better way?
 func (tl *TextLayout) calcLineHeight(params *text.Parameters) fixed.Int26_6 {
 	lineHeight := params.LineHeight
@@ -71,11 +88,14 @@ func (tl *TextLayout) reset() {
 	tl.Graphemes = tl.Graphemes[:0]
 	tl.bounds = image.Rectangle{}
 	tl.baseline = 0
+	tl.win = window{paragraphLines: tl.win.paragraphLines[:0]}
+	tl.hidden = 0
 }
 
 func (tl *TextLayout) Layout(shaper *text.Shaper, params *text.Parameters, tabWidth int, wrapLine bool) layout.Dimensions {
 	tl.reset()
 	tl.params = *params
+	tl.tabWidth, tl.wrapLine = tabWidth, wrapLine
 	paragraphCount := tl.src.Lines()
 
 	if shaper == nil {
@@ -83,27 +103,9 @@ func (tl *TextLayout) Layout(shaper *text.Shaper, params *text.Parameters, tabWi
 	} else {
 		tl.spaceGlyph, _ = tl.shapeRune(shaper, tl.params, '\u0020')
 		if paragraphCount > 0 {
-			runeOffset := 0
-			currentIdx := 0
-
-			for {
-				text, readErr := tl.reader.ReadString('\n')
-				// the last line returned by ReadBytes returns EOF and may have remaining bytes to process.
-				if len(text) > 0 {
-					tl.layoutNextParagraph(shaper, text, paragraphCount-1 == currentIdx, tabWidth, wrapLine)
-
-					paragraphRunes := []rune(text)
-					tl.indexGraphemeClusters(paragraphRunes, runeOffset)
-					runeOffset += len(paragraphRunes)
-					currentIdx++
-				}
-
-				if readErr != nil {
-					break
-				}
-			}
+			tl.layoutParagraphs(shaper, 0, tl.src.Len(), tabWidth, wrapLine, nil)
 		} else {
-			tl.layoutNextParagraph(shaper, "", true, tabWidth, wrapLine)
+			tl.layoutNextParagraph(shaper, "", 0, true, tabWidth, wrapLine)
 		}
 
 		tl.calculateXOffsets()
@@ -125,7 +127,63 @@ func (tl *TextLayout) Layout(shaper *text.Shaper, params *text.Parameters, tabWi
 	return dims
 }
 
-func (tl *TextLayout) layoutNextParagraph(shaper *text.Shaper, paragraph string, isLastParagrah bool, tabWidth int, wrapLine bool) {
+// layoutParagraphs shapes the paragraphs in the rune range [runeStart, runeEnd),
+// skipping the folded ones. runeStart must be the start of a paragraph. If fn
+// is not nil, it is called before shaping each paragraph, with the number of
+// paragraphs skipped since the last one. It returns the rune offset where the
+// shaping stops.
+func (tl *TextLayout) layoutParagraphs(shaper *text.Shaper, runeStart, runeEnd int, tabWidth int, wrapLine bool, fn func(skipped int)) int {
+	reader := buffer.NewReader(tl.src)
+	seek := func(runeOff int) {
+		reader.Seek(int64(tl.src.RuneOffset(runeOff)), io.SeekStart)
+		tl.reader.Reset(reader)
+	}
+	seek(runeStart)
+
+	docEnd := tl.src.Len()
+	foldIdx := sort.Search(len(tl.Folds), func(i int) bool { return tl.Folds[i].End > runeStart })
+	runeOffset, skipped := runeStart, 0
+	for runeOffset < runeEnd {
+		if foldIdx < len(tl.Folds) && tl.Folds[foldIdx].Start <= runeOffset {
+			fold := tl.Folds[foldIdx]
+			foldIdx++
+			hidden := tl.src.LineOf(fold.End-1) - tl.src.LineOf(runeOffset) + 1
+			skipped += hidden
+			tl.hidden += hidden
+			// The start of the next visible paragraph takes the place of the
+			// hidden one as a grapheme cluster boundary.
+			if n := len(tl.Graphemes); n > 0 && tl.Graphemes[n-1] == runeOffset {
+				tl.Graphemes[n-1] = fold.End
+			}
+			runeOffset = fold.End
+			seek(runeOffset)
+			continue
+		}
+
+		// the last line returned by ReadBytes returns EOF and may have remaining bytes to process.
+		text, readErr := tl.reader.ReadString('\n')
+		if len(text) > 0 {
+			if fn != nil {
+				fn(skipped)
+			}
+			skipped = 0
+
+			paragraphRunes := []rune(text)
+			isLast := runeOffset+len(paragraphRunes) == docEnd
+			tl.layoutNextParagraph(shaper, text, runeOffset, isLast, tabWidth, wrapLine)
+			tl.indexGraphemeClusters(paragraphRunes, runeOffset)
+			runeOffset += len(paragraphRunes)
+		}
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	return runeOffset
+}
+
+func (tl *TextLayout) layoutNextParagraph(shaper *text.Shaper, paragraph string, runeOffset int, isLastParagrah bool, tabWidth int, wrapLine bool) {
 	params := tl.params
 	maxWidth := params.MaxWidth
 	params.MaxWidth = 1e6
@@ -139,6 +197,10 @@ func (tl *TextLayout) layoutNextParagraph(shaper *text.Shaper, paragraph string,
 		lines = lines[:len(lines)-1]
 	}
 
+	for i := range lines {
+		lines[i].RuneOff = runeOffset
+		runeOffset += lines[i].Runes
+	}
 	tl.Lines = append(tl.Lines, lines...)
 }
 
@@ -176,7 +238,7 @@ func (tl *TextLayout) calculateYOffsets() {
 	lineHeight := tl.calcLineHeight(&tl.params)
 	// Ceil the first value to ensure that we don't baseline it too close to the top of the
 	// viewport and cut off the top pixel.
-	currentY := tl.Lines[0].Ascent.Ceil()
+	currentY := tl.Lines[0].Ascent.Ceil() + tl.win.firstLine*lineHeight.Round()
 	for i := range tl.Lines {
 		if i > 0 {
 			currentY += lineHeight.Round()
@@ -186,11 +248,9 @@ func (tl *TextLayout) calculateYOffsets() {
 }
 
 func (tl *TextLayout) calculateXOffsets() {
-	runeOff := 0
 	for i, line := range tl.Lines {
 		alignOff := tl.params.Alignment.Align(tl.params.Locale.Direction, line.Width, tl.params.MaxWidth)
-		tl.Lines[i].recompute(alignOff, runeOff)
-		runeOff += line.Runes
+		tl.Lines[i].recompute(alignOff, line.RuneOff)
 	}
 }
 
@@ -466,10 +526,15 @@ func (tl *TextLayout) Locate(viewport image.Rectangle, startRune, endRune int, r
 		startRune, endRune = endRune, startRune
 	}
 	rects = rects[:0]
+	if tl.win.enabled && (endRune < tl.win.runeStart || !tl.RuneInWindow(max(startRune, tl.win.runeStart))) {
+		// The range is not shaped, so it can not be visible.
+		return rects
+	}
 	caretStart, _ := tl.ClosestToRune(startRune)
 	caretEnd, _ := tl.ClosestToRune(endRune)
 
-	for lineIdx := caretStart.LineCol.Line; lineIdx < len(tl.Lines); lineIdx++ {
+	firstLine := tl.win.firstLine
+	for lineIdx := caretStart.LineCol.Line; lineIdx < firstLine+len(tl.Lines); lineIdx++ {
 		if lineIdx > caretEnd.LineCol.Line {
 			break
 		}
@@ -480,7 +545,7 @@ func (tl *TextLayout) Locate(viewport image.Rectangle, startRune, endRune int, r
 		if int(pos.Y)-pos.Ascent.Ceil() > viewport.Max.Y {
 			break
 		}
-		line := tl.Lines[lineIdx]
+		line := tl.Lines[lineIdx-firstLine]
 		if lineIdx > caretStart.LineCol.Line && lineIdx < caretEnd.LineCol.Line {
 			startX := line.XOff
 			endX := startX + line.Width
@@ -4,7 +4,7 @@ import (
 	"testing"
 
 	"gioui.org/text"
-	"github.com/oligo/gvcode/internal/buffer"
+	"github.com/oligo/gvcode/buffer"
 )
 
 func BenchmarkLayout(b *testing.B) {
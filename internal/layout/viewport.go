@@ -0,0 +1,260 @@
+package layout
+
+import (
+	"image"
+	"sort"
+
+	"gioui.org/layout"
+	"gioui.org/text"
+)
+
+// minWindowMargin is the minimum number of paragraphs shaped on each side of
+// the center paragraph of a virtualized layout.
+const minWindowMargin = 64
+
+// window tracks the paragraphs shaped by the virtualized layout. Paragraphs
+// outside of the window are not shaped, and their heights are estimated from
+// the shaped ones. Lines in the window have their line numbers and Y offsets
+// in the coordinate space of the whole document, so positions returned by the
+// layout can be used as if all the text were shaped.
+type window struct {
+	enabled bool
+	// first and last are the range of shaped paragraphs, [first, last).
+	first, last int
+	// total is the number of paragraphs in the document, excluding the empty
+	// line after a trailing line break.
+	total int
+	// trailing is set if the document ends with a line break.
+	trailing bool
+	// runeStart and runeEnd are the rune range of the shaped paragraphs.
+	runeStart, runeEnd int
+	// firstLine is the index of the first shaped line in the document.
+	firstLine int
+	// lineCount is the estimated number of lines of the whole document.
+	lineCount int
+	// estLines is the estimated number of lines of a paragraph not shaped.
+	estLines int
+	// paragraphLines holds the index of the first line of each shaped
+	// paragraph, relative to firstLine. The extra last entry is the number of
+	// shaped lines.
+	paragraphLines []int
+	lineHeight     int
+	// width is the widest line seen so far, so the horizontal scroll range
+	// does not shrink while scrolling around.
+	width int
+}
+
+// LayoutWindow is like Layout, but only shapes the paragraphs around the
+// center paragraph, enough to cover several viewports of viewHeight on each
+// side of it. The size of the rest of the document is estimated.
+func (tl *TextLayout) LayoutWindow(shaper *text.Shaper, params *text.Parameters, tabWidth int, wrapLine bool, center int, viewHeight int) layout.Dimensions {
+	width := tl.win.width
+	if tl.win.lineHeight != tl.calcLineHeight(params).Round() {
+		width = 0
+	}
+
+	tl.reset()
+	tl.params = *params
+	tl.spaceGlyph, _ = tl.shapeRune(shaper, tl.params, ' ')
+
+	w := &tl.win
+	w.enabled = true
+	w.lineHeight = max(1, tl.calcLineHeight(params).Round())
+	w.total = tl.src.Lines()
+	w.trailing = w.total > 0 && tl.src.LineOf(tl.src.Len()) == w.total
+
+	margin := max(2*(viewHeight/w.lineHeight+1), minWindowMargin)
+	w.first = max(0, min(center, w.total)-margin)
+	// Start from the placeholder line if the first paragraph is folded.
+	runeStart := tl.src.LineStart(w.first)
+	if idx := sort.Search(len(tl.Folds), func(i int) bool { return tl.Folds[i].End > runeStart }); idx < len(tl.Folds) && tl.Folds[idx].Start <= runeStart {
+		w.first = tl.src.LineOf(tl.Folds[idx].Start) - 1
+	}
+	w.last = min(w.total, w.first+2*margin+1)
+	w.runeStart = tl.src.LineStart(w.first)
+	w.runeEnd = tl.src.LineStart(w.last)
+
+	if w.total > 0 {
+		// Hidden paragraphs have no lines. A fold may extend the window.
+		runeEnd := tl.layoutParagraphs(shaper, w.runeStart, w.runeEnd, tabWidth, wrapLine, func(skipped int) {
+			for i := 0; i <= skipped; i++ {
+				w.paragraphLines = append(w.paragraphLines, len(tl.Lines))
+			}
+		})
+		count := tl.src.LineOf(runeEnd) - w.first
+		if runeEnd >= tl.src.Len() {
+			count = w.total - w.first
+		}
+		for len(w.paragraphLines) < count {
+			w.paragraphLines = append(w.paragraphLines, len(tl.Lines))
+		}
+		w.last = w.first + len(w.paragraphLines)
+		w.runeEnd = tl.src.LineStart(w.last)
+	} else {
+		w.paragraphLines = append(w.paragraphLines, 0)
+		tl.layoutNextParagraph(shaper, "", 0, true, tabWidth, wrapLine)
+	}
+	w.paragraphLines = append(w.paragraphLines, len(tl.Lines))
+
+	w.estLines = 1
+	if shaped := len(w.paragraphLines) - 1; wrapLine && shaped > 0 {
+		w.estLines = max(1, (len(tl.Lines)+shaped/2)/shaped)
+	}
+	w.firstLine = w.first * w.estLines
+	w.lineCount = w.firstLine + len(tl.Lines) + (w.total-w.last)*w.estLines
+	if w.trailing && w.last < w.total {
+		w.lineCount++
+	}
+
+	tl.calculateXOffsets()
+	tl.calculateYOffsets()
+	for idx, line := range tl.Lines {
+		tl.indexGlyphs(w.firstLine+idx, line)
+		tl.updateBounds(line)
+	}
+	tl.trackLines(tl.Lines)
+
+	dims := layout.Dimensions{}
+	if len(tl.Lines) > 0 {
+		line := tl.Lines[0]
+		ascent, descent := line.Ascent.Ceil(), line.Descent.Ceil()
+		w.width = max(width, tl.bounds.Max.X)
+		dims.Size = image.Pt(w.width, ascent+(w.lineCount-1)*w.lineHeight+descent)
+		dims.Baseline = dims.Size.Y - ascent
+	}
+	return dims
+}
+
+// Virtualized reports whether the text is laid out by LayoutWindow.
+func (tl *TextLayout) Virtualized() bool {
+	return tl.win.enabled
+}
+
+// LineCount returns the number of lines of the whole document. It is an
+// estimation if the layout is virtualized.
+func (tl *TextLayout) LineCount() int {
+	if !tl.win.enabled {
+		return len(tl.Lines)
+	}
+	return tl.win.lineCount
+}
+
+// FirstParagraph returns the index of the first shaped paragraph.
+func (tl *TextLayout) FirstParagraph() int {
+	if !tl.win.enabled {
+		return 0
+	}
+	return tl.win.first
+}
+
+// RuneInWindow reports whether the rune offset is in the shaped paragraphs.
+func (tl *TextLayout) RuneInWindow(runeOff int) bool {
+	w := &tl.win
+	if !w.enabled {
+		return true
+	}
+	if runeOff < w.runeStart {
+		return false
+	}
+	return runeOff < w.runeEnd || w.last == w.total
+}
+
+// LineInWindow reports whether the line is shaped.
+func (tl *TextLayout) LineInWindow(line int) bool {
+	w := &tl.win
+	if !w.enabled {
+		return true
+	}
+	line = max(0, min(line, w.lineCount-1))
+	if line < w.firstLine {
+		return false
+	}
+	return line < w.firstLine+len(tl.Lines) || w.last == w.total
+}
+
+// YInWindow reports whether the line at the Y offset is shaped.
+func (tl *TextLayout) YInWindow(y int) bool {
+	if !tl.win.enabled {
+		return true
+	}
+	return tl.LineInWindow(y / tl.win.lineHeight)
+}
+
+// ParagraphLine returns the index of the first line of the paragraph.
+func (tl *TextLayout) ParagraphLine(paragraph int) int {
+	w := &tl.win
+	switch {
+	case !w.enabled || w.lineCount == 0:
+		return 0
+	case paragraph >= w.total:
+		return w.lineCount - 1
+	case paragraph <= w.first:
+		return max(0, paragraph) * w.estLines
+	case paragraph < w.last:
+		return w.firstLine + w.paragraphLines[paragraph-w.first]
+	default:
+		shaped := w.paragraphLines[len(w.paragraphLines)-1]
+		return w.firstLine + shaped + (paragraph-w.last)*w.estLines
+	}
+}
+
+// ParagraphAtLine returns the paragraph of the line, and the index of the
+// line in the paragraph.
+func (tl *TextLayout) ParagraphAtLine(line int) (paragraph, within int) {
+	w := &tl.win
+	if !w.enabled || w.lineCount == 0 {
+		return 0, 0
+	}
+
+	line = max(0, min(line, w.lineCount-1))
+	if line < w.firstLine {
+		return line / w.estLines, line % w.estLines
+	}
+
+	local := line - w.firstLine
+	shaped := w.paragraphLines[len(w.paragraphLines)-1]
+	if local < shaped {
+		idx := sort.Search(len(w.paragraphLines)-1, func(i int) bool {
+			return w.paragraphLines[i+1] > local
+		})
+		return w.first + idx, local - w.paragraphLines[idx]
+	}
+
+	if w.last == w.total {
+		// the line after a trailing line break.
+		return w.total, 0
+	}
+	rest := local - shaped
+	return min(w.last+rest/w.estLines, w.total), rest % w.estLines
+}
+
+// ParagraphY returns the Y offset of the top of the paragraph.
+func (tl *TextLayout) ParagraphY(paragraph int) int {
+	return tl.ParagraphLine(paragraph) * tl.win.lineHeight
+}
+
+// ParagraphAtY returns the paragraph at the Y offset, and the distance from
+// the top of the paragraph to y.
+func (tl *TextLayout) ParagraphAtY(y int) (paragraph, offset int) {
+	w := &tl.win
+	if !w.enabled || w.lineCount == 0 {
+		return 0, y
+	}
+
+	paragraph, _ = tl.ParagraphAtLine(y / w.lineHeight)
+	return paragraph, y - tl.ParagraphY(paragraph)
+}
+
+// ParagraphCount returns the number of paragraphs of the whole document.
+func (tl *TextLayout) ParagraphCount() int {
+	w := &tl.win
+	if !w.enabled {
+		return len(tl.Paragraphs) + tl.hidden
+	}
+
+	count := w.first + len(tl.Paragraphs) + tl.hidden + w.total - w.last
+	if w.trailing && w.last < w.total {
+		count++
+	}
+	return count
+}
@@ -0,0 +1,372 @@
+package vim
+
+import (
+	"unicode"
+)
+
+// Text is the text the motions work on. Offsets are in runes.
+type Text interface {
+	ReadRuneAt(runeOff int) (rune, error)
+	Len() int
+	// Lines returns the number of lines.
+	Lines() int
+	// LineStart returns the offset of the start of the line.
+	LineStart(line int) int
+	// LineOf returns the line of the rune at the offset.
+	LineOf(runeOff int) int
+}
+
+func runeAt(t Text, off int) rune {
+	if off < 0 || off >= t.Len() {
+		return 0
+	}
+	r, err := t.ReadRuneAt(off)
+	if err != nil {
+		return 0
+	}
+	return r
+}
+
+// LineEnd returns the offset of the line break of the line, or the end of the
+// text for the last line.
+func LineEnd(t Text, line int) int {
+	end := t.LineStart(line + 1)
+	if end > t.LineStart(line) && runeAt(t, end-1) == '\n' {
+		end--
+	}
+	return end
+}
+
+// FirstNonBlank returns the offset of the first non-blank character of the
+// line, or the line end if the line is blank.
+func FirstNonBlank(t Text, line int) int {
+	off, end := t.LineStart(line), LineEnd(t, line)
+	for off < end && isBlank(runeAt(t, off)) {
+		off++
+	}
+	return off
+}
+
+func isBlank(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// class returns the character class used by the word motions: 0 for white
+// space, 1 for punctuation and 2 for word characters. All the non-blank
+// characters are of the same class if bigWord is set.
+func class(r rune, bigWord bool) int {
+	switch {
+	case r == 0 || unicode.IsSpace(r):
+		return 0
+	case bigWord:
+		return 2
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// isEmptyLine reports whether off is at the start of an empty line.
+func isEmptyLine(t Text, off int) bool {
+	return runeAt(t, off) == '\n' && (off == 0 || runeAt(t, off-1) == '\n')
+}
+
+// WordStart returns the offset of the start of the next word after off, as by
+// the w motion. Empty lines count as words.
+func WordStart(t Text, off int, bigWord bool) int {
+	n := t.Len()
+	if off >= n {
+		return n
+	}
+	if c := class(runeAt(t, off), bigWord); c != 0 {
+		for off < n && class(runeAt(t, off), bigWord) == c {
+			off++
+		}
+	}
+	for off < n && class(runeAt(t, off), bigWord) == 0 {
+		if runeAt(t, off) == '\n' && isEmptyLine(t, off+1) {
+			return off + 1
+		}
+		off++
+	}
+	return off
+}
+
+// WordBackward returns the offset of the start of the word before off, as by
+// the b motion.
+func WordBackward(t Text, off int, bigWord bool) int {
+	if off <= 0 {
+		return 0
+	}
+	off--
+	for off > 0 && class(runeAt(t, off), bigWord) == 0 && !isEmptyLine(t, off) {
+		off--
+	}
+	c := class(runeAt(t, off), bigWord)
+	for c != 0 && off > 0 && class(runeAt(t, off-1), bigWord) == c {
+		off--
+	}
+	return off
+}
+
+// WordEnd returns the offset of the last character of the word after off, as
+// by the e motion.
+func WordEnd(t Text, off int, bigWord bool) int {
+	n := t.Len()
+	if off >= n-1 {
+		return max(0, n-1)
+	}
+	off++
+	for off < n-1 && class(runeAt(t, off), bigWord) == 0 {
+		off++
+	}
+	c := class(runeAt(t, off), bigWord)
+	for off < n-1 && class(runeAt(t, off+1), bigWord) == c {
+		off++
+	}
+	return off
+}
+
+// WordEndBackward returns the offset of the last character of the word before
+// off, as by the ge motion.
+func WordEndBackward(t Text, off int, bigWord bool) int {
+	if off <= 0 {
+		return 0
+	}
+	c := class(runeAt(t, off), bigWord)
+	for off > 0 && c != 0 && class(runeAt(t, off), bigWord) == c {
+		off--
+	}
+	for off > 0 && class(runeAt(t, off), bigWord) == 0 && !isEmptyLine(t, off) {
+		off--
+	}
+	return off
+}
+
+// FindChar returns the offset of the count-th occurrence of ch in the line of
+// off, as by the f, F, t and T motions. Searching is forward unless backward
+// is set, and till stops before the character.
+func FindChar(t Text, off int, ch rune, backward, till bool, count int) (int, bool) {
+	line := t.LineOf(off)
+	start, end := t.LineStart(line), LineEnd(t, line)
+	step := 1
+	if backward {
+		step = -1
+	}
+
+	pos := off
+	for ; count > 0; count-- {
+		for pos += step; pos >= start && pos < end && runeAt(t, pos) != ch; pos += step {
+		}
+		if pos < start || pos >= end {
+			return off, false
+		}
+	}
+	if till {
+		pos -= step
+	}
+	return pos, true
+}
+
+var pairs = map[rune]rune{'(': ')', '[': ']', '{': '}', '<': '>'}
+
+// MatchPair returns the offset of the bracket matching the first bracket at or
+// after off in its line, as by the % motion.
+func MatchPair(t Text, off int) (int, bool) {
+	end := LineEnd(t, t.LineOf(off))
+	for ; off < end; off++ {
+		r := runeAt(t, off)
+		if r == '<' || r == '>' {
+			continue
+		}
+		if closing, ok := pairs[r]; ok {
+			return findClosing(t, off+1, r, closing)
+		}
+		for opening, closing := range pairs {
+			if r == closing && opening != '<' {
+				return findOpening(t, off-1, opening, closing)
+			}
+		}
+	}
+	return 0, false
+}
+
+// findClosing returns the offset of the closing bracket balancing the brackets
+// from off.
+func findClosing(t Text, off int, opening, closing rune) (int, bool) {
+	depth := 0
+	for n := t.Len(); off < n; off++ {
+		switch runeAt(t, off) {
+		case opening:
+			depth++
+		case closing:
+			if depth == 0 {
+				return off, true
+			}
+			depth--
+		}
+	}
+	return 0, false
+}
+
+// findOpening returns the offset of the opening bracket balancing the brackets
+// backward from off.
+func findOpening(t Text, off int, opening, closing rune) (int, bool) {
+	depth := 0
+	for ; off >= 0; off-- {
+		switch runeAt(t, off) {
+		case closing:
+			depth++
+		case opening:
+			if depth == 0 {
+				return off, true
+			}
+			depth--
+		}
+	}
+	return 0, false
+}
+
+// TextObject returns the range [start, end) of the text object around off.
+// obj is the object key of the text object, e.g. w, (, or ", and inner
+// selects the inner object instead of the whole one.
+func TextObject(t Text, off int, obj rune, inner bool) (start, end int, ok bool) {
+	switch obj {
+	case 'w', 'W':
+		start, end = wordObject(t, off, obj == 'W', inner)
+		return start, end, end > start
+	case 'b', '(', ')':
+		return pairObject(t, off, '(', ')', inner)
+	case '[', ']':
+		return pairObject(t, off, '[', ']', inner)
+	case 'B', '{', '}':
+		return pairObject(t, off, '{', '}', inner)
+	case '<', '>':
+		return pairObject(t, off, '<', '>', inner)
+	case '"', '\'', '`':
+		return quoteObject(t, off, obj, inner)
+	}
+	return 0, 0, false
+}
+
+func wordObject(t Text, off int, bigWord, inner bool) (start, end int) {
+	line := t.LineOf(off)
+	lineStart, lineEnd := t.LineStart(line), LineEnd(t, line)
+	if off >= lineEnd {
+		return off, off
+	}
+
+	c := class(runeAt(t, off), bigWord)
+	sameClass := func(r rune) bool {
+		if c == 0 {
+			return isBlank(r)
+		}
+		return class(r, bigWord) == c
+	}
+	start, end = off, off+1
+	for start > lineStart && sameClass(runeAt(t, start-1)) {
+		start--
+	}
+	for end < lineEnd && sameClass(runeAt(t, end)) {
+		end++
+	}
+	if inner {
+		return start, end
+	}
+
+	// The whole word includes the white space after it, or before it if there
+	// is none after it. On white space, it is the white space and the word
+	// after it.
+	if c == 0 {
+		if end < lineEnd {
+			next := class(runeAt(t, end), bigWord)
+			for end < lineEnd && class(runeAt(t, end), bigWord) == next {
+				end++
+			}
+		}
+		return start, end
+	}
+	trailing := end
+	for trailing < lineEnd && isBlank(runeAt(t, trailing)) {
+		trailing++
+	}
+	if trailing > end {
+		return start, trailing
+	}
+	for start > lineStart && isBlank(runeAt(t, start-1)) {
+		start--
+	}
+	return start, end
+}
+
+// pairObject returns the range of the innermost bracket pair around off. The
+// whole object includes the brackets.
+func pairObject(t Text, off int, opening, closing rune, inner bool) (start, end int, ok bool) {
+	if runeAt(t, off) == opening {
+		start = off
+	} else {
+		if start, ok = findOpening(t, off-1, opening, closing); !ok {
+			return 0, 0, false
+		}
+	}
+	if end, ok = findClosing(t, start+1, opening, closing); !ok {
+		return 0, 0, false
+	}
+	if !inner {
+		return start, end + 1, true
+	}
+
+	start++
+	// Like Vim, the inner object of a block leaves out the line breaks after
+	// the opening bracket and before the closing one.
+	if runeAt(t, start) == '\n' {
+		start++
+		if lineStart := t.LineStart(t.LineOf(end)); start <= lineStart && FirstNonBlank(t, t.LineOf(end)) == end {
+			end = lineStart
+		}
+	}
+	return start, max(start, end), true
+}
+
+// quoteObject returns the range of the quoted string around off, in the line
+// of off. The whole object includes the quotes and the white space after them.
+func quoteObject(t Text, off int, quote rune, inner bool) (start, end int, ok bool) {
+	line := t.LineOf(off)
+	lineStart, lineEnd := t.LineStart(line), LineEnd(t, line)
+
+	// Pair the quotes from the line start, so that the quote under the cursor
+	// is known to be opening or closing.
+	var quotes []int
+	for i := lineStart; i < lineEnd; i++ {
+		switch runeAt(t, i) {
+		case '\\':
+			i++
+		case quote:
+			quotes = append(quotes, i)
+		}
+	}
+	for i := 0; i+1 < len(quotes); i += 2 {
+		if off <= quotes[i+1] && (off >= quotes[i] || i == 0 || off > quotes[i-1]) {
+			start, end = quotes[i], quotes[i+1]+1
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return 0, 0, false
+	}
+	if inner {
+		return start + 1, end - 1, true
+	}
+	trailing := end
+	for trailing < lineEnd && isBlank(runeAt(t, trailing)) {
+		trailing++
+	}
+	if trailing == end {
+		for start > lineStart && isBlank(runeAt(t, start-1)) {
+			start--
+		}
+	}
+	return start, trailing, true
+}
@@ -0,0 +1,169 @@
+package vim
+
+import (
+	"testing"
+
+	"github.com/oligo/gvcode/buffer"
+)
+
+func newText(s string) Text {
+	src := buffer.NewTextSource()
+	src.SetText([]byte(s))
+	return src
+}
+
+func TestWordMotions(t *testing.T) {
+	//        0123456789012345678901234
+	input := "foo.bar baz\n\n  qux(x)"
+	text := newText(input)
+
+	cases := []struct {
+		name string
+		fn   func(Text, int, bool) int
+		off  int
+		big  bool
+		want int
+	}{
+		{"w", WordStart, 0, false, 3},
+		{"w", WordStart, 3, false, 4},
+		{"W", WordStart, 0, true, 8},
+		{"w stops at empty line", WordStart, 8, false, 12},
+		{"w from empty line", WordStart, 12, false, 15},
+		{"w at end", WordStart, 20, false, 21},
+		{"b", WordBackward, 8, false, 4},
+		{"B", WordBackward, 8, true, 0},
+		{"b to empty line", WordBackward, 15, false, 12},
+		{"b at start", WordBackward, 0, false, 0},
+		{"e", WordEnd, 0, false, 2},
+		{"e", WordEnd, 2, false, 3},
+		{"E", WordEnd, 0, true, 6},
+		{"e across lines", WordEnd, 10, false, 17},
+		{"ge", WordEndBackward, 8, false, 6},
+		{"gE", WordEndBackward, 8, true, 6},
+		{"ge", WordEndBackward, 4, false, 3},
+	}
+
+	for _, tc := range cases {
+		if got := tc.fn(text, tc.off, tc.big); got != tc.want {
+			t.Errorf("%s from %d: want %d, got %d", tc.name, tc.off, tc.want, got)
+		}
+	}
+}
+
+func TestLineMotions(t *testing.T) {
+	text := newText("  foo\n\nbar")
+	if got := FirstNonBlank(text, 0); got != 2 {
+		t.Errorf("want first non-blank at 2, got %d", got)
+	}
+	if got := FirstNonBlank(text, 1); got != 6 {
+		t.Errorf("want first non-blank of empty line at 6, got %d", got)
+	}
+	if got := LineEnd(text, 0); got != 5 {
+		t.Errorf("want line end at 5, got %d", got)
+	}
+	if got := LineEnd(text, 2); got != 10 {
+		t.Errorf("want line end of last line at 10, got %d", got)
+	}
+}
+
+func TestFindChar(t *testing.T) {
+	//              0123456789
+	text := newText("a,b,c,d\nx,")
+	cases := []struct {
+		off      int
+		backward bool
+		till     bool
+		count    int
+		want     int
+		ok       bool
+	}{
+		{0, false, false, 1, 1, true},
+		{0, false, false, 3, 5, true},
+		// t right before the character does not move.
+		{0, false, true, 1, 0, true},
+		{0, false, true, 2, 2, true},
+		{1, false, true, 1, 2, true},
+		{6, true, false, 1, 5, true},
+		{6, true, true, 1, 6, true},
+		{0, false, false, 4, 0, false},
+		{6, false, false, 1, 6, false},
+	}
+	for i, tc := range cases {
+		got, ok := FindChar(text, tc.off, ',', tc.backward, tc.till, tc.count)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("#%d: want %d %v, got %d %v", i, tc.want, tc.ok, got, ok)
+		}
+	}
+}
+
+func TestMatchPair(t *testing.T) {
+	//              0123456789012
+	text := newText("f(a[1], b) {\n}")
+	cases := []struct {
+		off, want int
+		ok        bool
+	}{
+		{0, 9, true},
+		{1, 9, true},
+		{3, 5, true},
+		{9, 1, true},
+		{10, 13, true},
+		{13, 11, true},
+	}
+	for _, tc := range cases {
+		got, ok := MatchPair(text, tc.off)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%d: want %d %v, got %d %v", tc.off, tc.want, tc.ok, got, ok)
+		}
+	}
+}
+
+func TestTextObject(t *testing.T) {
+	cases := []struct {
+		input  string
+		off    int
+		obj    rune
+		inner  bool
+		want   string
+		wantOk bool
+	}{
+		{"foo bar baz", 5, 'w', true, "bar", true},
+		{"foo bar baz", 5, 'w', false, "bar ", true},
+		{"foo bar", 5, 'w', false, " bar", true},
+		{"foo  bar", 3, 'w', true, "  ", true},
+		{"foo  bar", 3, 'w', false, "  bar", true},
+		{"a.b-c d", 2, 'W', true, "a.b-c", true},
+		{"f(a, (b))", 3, '(', true, "a, (b)", true},
+		{"f(a, (b))", 3, 'b', false, "(a, (b))", true},
+		{"f(a, (b))", 6, ')', true, "b", true},
+		{"f(a, (b))", 1, '(', true, "a, (b)", true},
+		{"f(a)", 0, '(', true, "", false},
+		{"if x {\n\ty\n}", 8, '{', true, "\ty\n", true},
+		{"if x {\n\ty\n}", 8, 'B', false, "{\n\ty\n}", true},
+		{"a[1]", 2, '[', true, "1", true},
+		{`x := "a \"b\" c"`, 8, '"', true, `a \"b\" c`, true},
+		{`f("a", "b")`, 3, '"', true, "a", true},
+		// Quotes are paired from the line start, so the text between two
+		// strings is not a string.
+		{`f("a", "b")`, 5, '"', true, "b", true},
+		{`f("a", "b")`, 0, '"', true, "a", true},
+		{`f("a", "b")`, 8, '"', false, ` "b"`, true},
+		{`say 'hi' now`, 5, '\'', false, "'hi' ", true},
+		{`no quotes`, 2, '"', true, "", false},
+	}
+
+	for i, tc := range cases {
+		text := newText(tc.input)
+		start, end, ok := TextObject(text, tc.off, tc.obj, tc.inner)
+		if ok != tc.wantOk {
+			t.Errorf("#%d: want ok %v, got %v", i, tc.wantOk, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got := string([]rune(tc.input)[start:end]); got != tc.want {
+			t.Errorf("#%d: want %q, got %q", i, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package vim implements the key parsing and the text motions of the Vim
+// emulation of the editor. It knows nothing about the editor itself: keys are
+// parsed to commands, and motions and text objects are computed on a Text.
+package vim
+
+import (
+	"strings"
+)
+
+// Status is the result of parsing the keys typed so far.
+type Status uint8
+
+const (
+	// Pending means more keys are needed to complete the command.
+	Pending Status = iota
+	// Complete means the keys form a command.
+	Complete
+	// Invalid means the keys do not form a command, and should be dropped.
+	Invalid
+)
+
+// Command is a command typed in the normal or visual state, in the form of
+//
+//	["x][count](action | [operator][count](motion | text object))
+//
+// An operator applied to itself, like dd, has the operator as the motion.
+type Command struct {
+	// Register is the register named by a "x prefix, or 0.
+	Register rune
+	// Count is the count before the command, or 0 if not given.
+	Count int
+	// Operator is one of d, c, y, > and <, or 0.
+	Operator rune
+	// MotionCount is the count between the operator and the motion, or 0 if not
+	// given.
+	MotionCount int
+	// Motion is the motion or text object, e.g. "w", "gg", "iw" or "a(".
+	Motion string
+	// Action is a command which is neither a motion nor an operator, e.g. "i",
+	// "p" or "x".
+	Action string
+	// Char is the character argument of f, t, F, T and r.
+	Char rune
+}
+
+// Counts returns the total count of the command, which is at least 1.
+func (c Command) Counts() int {
+	return max(1, c.Count) * max(1, c.MotionCount)
+}
+
+// IsLineOperator reports whether the operator is applied to itself, like dd.
+func (c Command) IsLineOperator() bool {
+	return c.Operator != 0 && c.Motion == string(c.Operator)
+}
+
+const (
+	operators = "dcy<>"
+	// motions of a single key.
+	motions = "hjklwWbBeE0^$G%;,+-_|"
+	// motions followed by a character.
+	charMotions = "fFtT"
+	// actions of a single key in the normal state.
+	normalActions = "iaIAoOxXsSDCYpPuJvV:.~"
+	// actions of a single key in the visual state.
+	visualActions = "oxXsSDCYpPJvV:~uU"
+	// objects of the text objects, after i or a.
+	textObjects = "wW()b[]{}B<>\"'`"
+)
+
+// Parse parses the keys typed in the normal state, or in the visual state if
+// visual is set. In the visual state, operators apply to the selection, so they
+// complete a command without a motion.
+func Parse(keys []rune, visual bool) (Command, Status) {
+	var cmd Command
+	p := parser{keys: keys}
+
+	if p.peek() == '"' {
+		p.next()
+		r, ok := p.next()
+		if !ok {
+			return cmd, Pending
+		}
+		if !isRegister(r) {
+			return cmd, Invalid
+		}
+		cmd.Register = r
+	}
+
+	cmd.Count = p.count()
+	r, ok := p.next()
+	if !ok {
+		return cmd, Pending
+	}
+
+	switch {
+	case strings.ContainsRune(operators, r):
+		cmd.Operator = r
+		if visual {
+			return cmd, Complete
+		}
+	case r == 'r':
+		cmd.Action = "r"
+		if cmd.Char, ok = p.next(); !ok {
+			return cmd, Pending
+		}
+		return cmd, Complete
+	case r == 'g':
+		g, ok := p.next()
+		if !ok {
+			return cmd, Pending
+		}
+		switch g {
+		case 'g', 'e', 'E', '_':
+			cmd.Motion = "g" + string(g)
+		case 'J':
+			cmd.Action = "gJ"
+		case '-', '+':
+			if visual {
+				return cmd, Invalid
+			}
+			cmd.Action = "g" + string(g)
+		default:
+			return cmd, Invalid
+		}
+		return cmd, Complete
+	case visual && (r == 'i' || r == 'a'):
+		return p.textObject(cmd, r)
+	case visual && strings.ContainsRune(visualActions, r),
+		!visual && strings.ContainsRune(normalActions, r):
+		cmd.Action = string(r)
+		return cmd, Complete
+	default:
+		p.unread()
+		return p.motion(cmd)
+	}
+
+	// Parse the motion of the operator.
+	cmd.MotionCount = p.count()
+	r, ok = p.next()
+	switch {
+	case !ok:
+		return cmd, Pending
+	case r == cmd.Operator:
+		cmd.Motion = string(r)
+		return cmd, Complete
+	case r == 'i' || r == 'a':
+		return p.textObject(cmd, r)
+	case r == 'g':
+		g, ok := p.next()
+		if !ok {
+			return cmd, Pending
+		}
+		if g != 'g' && g != 'e' && g != 'E' && g != '_' {
+			return cmd, Invalid
+		}
+		cmd.Motion = "g" + string(g)
+		return cmd, Complete
+	default:
+		p.unread()
+		return p.motion(cmd)
+	}
+}
+
+func isRegister(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		strings.ContainsRune("\"_+*-", r)
+}
+
+type parser struct {
+	keys []rune
+	pos  int
+}
+
+func (p *parser) peek() rune {
+	if p.pos >= len(p.keys) {
+		return 0
+	}
+	return p.keys[p.pos]
+}
+
+func (p *parser) next() (rune, bool) {
+	if p.pos >= len(p.keys) {
+		return 0, false
+	}
+	p.pos++
+	return p.keys[p.pos-1], true
+}
+
+func (p *parser) unread() {
+	p.pos--
+}
+
+// count parses a count. A leading 0 is the motion to the line start, not a
+// count.
+func (p *parser) count() int {
+	n := 0
+	for r := p.peek(); r >= '1' && r <= '9' || (n > 0 && r == '0'); r = p.peek() {
+		n = n*10 + int(r-'0')
+		p.pos++
+	}
+	return n
+}
+
+func (p *parser) motion(cmd Command) (Command, Status) {
+	r, ok := p.next()
+	switch {
+	case !ok:
+		return cmd, Pending
+	case strings.ContainsRune(motions, r):
+		cmd.Motion = string(r)
+	case strings.ContainsRune(charMotions, r):
+		cmd.Motion = string(r)
+		if cmd.Char, ok = p.next(); !ok {
+			return cmd, Pending
+		}
+	default:
+		return cmd, Invalid
+	}
+	return cmd, Complete
+}
+
+func (p *parser) textObject(cmd Command, kind rune) (Command, Status) {
+	r, ok := p.next()
+	if !ok {
+		return cmd, Pending
+	}
+	if !strings.ContainsRune(textObjects, r) {
+		return cmd, Invalid
+	}
+	cmd.Motion = string(kind) + string(r)
+	return cmd, Complete
+}
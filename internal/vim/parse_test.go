@@ -0,0 +1,68 @@
+package vim
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		keys   string
+		visual bool
+		want   Command
+		status Status
+	}{
+		{keys: "", status: Pending},
+		{keys: "w", want: Command{Motion: "w"}, status: Complete},
+		{keys: "0", want: Command{Motion: "0"}, status: Complete},
+		{keys: "10j", want: Command{Count: 10, Motion: "j"}, status: Complete},
+		{keys: "d", want: Command{Operator: 'd'}, status: Pending},
+		{keys: "dd", want: Command{Operator: 'd', Motion: "d"}, status: Complete},
+		{keys: "2d3w", want: Command{Count: 2, Operator: 'd', MotionCount: 3, Motion: "w"}, status: Complete},
+		{keys: "ci(", want: Command{Operator: 'c', Motion: "i("}, status: Complete},
+		{keys: "ya\"", want: Command{Operator: 'y', Motion: "a\""}, status: Complete},
+		{keys: "df", want: Command{Operator: 'd', Motion: "f"}, status: Pending},
+		{keys: "dtx", want: Command{Operator: 'd', Motion: "t", Char: 'x'}, status: Complete},
+		{keys: "dgg", want: Command{Operator: 'd', Motion: "gg"}, status: Complete},
+		{keys: "\"a", want: Command{Register: 'a'}, status: Pending},
+		{keys: "\"ayy", want: Command{Register: 'a', Operator: 'y', Motion: "y"}, status: Complete},
+		{keys: "\"+p", want: Command{Register: '+', Action: "p"}, status: Complete},
+		{keys: "\"!", status: Invalid},
+		{keys: "3x", want: Command{Count: 3, Action: "x"}, status: Complete},
+		{keys: "rx", want: Command{Action: "r", Char: 'x'}, status: Complete},
+		{keys: "gJ", want: Command{Action: "gJ"}, status: Complete},
+		{keys: "3g-", want: Command{Count: 3, Action: "g-"}, status: Complete},
+		{keys: "g+", want: Command{Action: "g+"}, status: Complete},
+		{keys: "gq", status: Invalid},
+		{keys: "dq", want: Command{Operator: 'd'}, status: Invalid},
+		{keys: "Q", status: Invalid},
+		{keys: "d", visual: true, want: Command{Operator: 'd'}, status: Complete},
+		{keys: "iw", visual: true, want: Command{Motion: "iw"}, status: Complete},
+		{keys: "o", visual: true, want: Command{Action: "o"}, status: Complete},
+		{keys: "U", visual: true, want: Command{Action: "U"}, status: Complete},
+	}
+
+	for _, tc := range cases {
+		cmd, status := Parse([]rune(tc.keys), tc.visual)
+		if status != tc.status {
+			t.Errorf("%q: want status %d, got %d", tc.keys, tc.status, status)
+			continue
+		}
+		if status != Invalid && cmd != tc.want {
+			t.Errorf("%q: want %+v, got %+v", tc.keys, tc.want, cmd)
+		}
+	}
+}
+
+func TestCommandCounts(t *testing.T) {
+	cmd, _ := Parse([]rune("2d3w"), false)
+	if cmd.Counts() != 6 {
+		t.Errorf("want 6, got %d", cmd.Counts())
+	}
+	cmd, _ = Parse([]rune("dw"), false)
+	if cmd.Counts() != 1 {
+		t.Errorf("want 1, got %d", cmd.Counts())
+	}
+	if cmd.IsLineOperator() {
+		t.Errorf("dw is not a line operator")
+	}
+}
@@ -0,0 +1,91 @@
+package keymap
+
+import (
+	"gioui.org/io/key"
+)
+
+// defaultBindings are the key bindings of the editor by default.
+var defaultBindings = []Binding{
+	{Key: "Enter", Action: "edit.newLine"},
+	{Key: "Shift+Enter", Action: "edit.newLine"},
+	{Key: "Return", Action: "edit.newLine"},
+	{Key: "Shift+Return", Action: "edit.newLine"},
+	{Key: "Tab", Action: "edit.indent"},
+	{Key: "Shift+Tab", Action: "edit.outdent"},
+	{Key: "Backspace", Action: "edit.deleteLeft"},
+	{Key: "Shift+Backspace", Action: "edit.deleteLeft"},
+	{Key: "ShortcutAlt+Backspace", Action: "edit.deleteWordLeft"},
+	{Key: "ShortcutAlt+Shift+Backspace", Action: "edit.deleteWordLeft"},
+	{Key: "Delete", Action: "edit.deleteRight"},
+	{Key: "Shift+Delete", Action: "edit.deleteRight"},
+	{Key: "ShortcutAlt+Delete", Action: "edit.deleteWordRight"},
+	{Key: "ShortcutAlt+Shift+Delete", Action: "edit.deleteWordRight"},
+	{Key: "Shortcut+C", Action: "edit.copy"},
+	{Key: "Shortcut+X", Action: "edit.cut"},
+	{Key: "Shortcut+V", Action: "edit.paste"},
+	{Key: "Shortcut+Z", Action: "edit.undo"},
+	{Key: "Shortcut+Shift+Z", Action: "edit.redo"},
+
+	{Key: "Shortcut+A", Action: "selection.all"},
+	{Key: "Shortcut+D", Action: "selection.addNextOccurrence"},
+	{Key: "Escape", Action: "selection.clearCarets"},
+
+	{Key: "Left", Action: "cursor.left"},
+	{Key: "Shift+Left", Action: "cursor.leftSelect"},
+	{Key: "ShortcutAlt+Left", Action: "cursor.wordLeft"},
+	{Key: "ShortcutAlt+Shift+Left", Action: "cursor.wordLeftSelect"},
+	{Key: "Right", Action: "cursor.right"},
+	{Key: "Shift+Right", Action: "cursor.rightSelect"},
+	{Key: "ShortcutAlt+Right", Action: "cursor.wordRight"},
+	{Key: "ShortcutAlt+Shift+Right", Action: "cursor.wordRightSelect"},
+	{Key: "Up", Action: "cursor.up"},
+	{Key: "Shift+Up", Action: "cursor.upSelect"},
+	{Key: "ShortcutAlt+Up", Action: "cursor.up"},
+	{Key: "ShortcutAlt+Shift+Up", Action: "cursor.upSelect"},
+	{Key: "Shortcut+Alt+Up", Action: "cursor.addCaretAbove"},
+	{Key: "Down", Action: "cursor.down"},
+	{Key: "Shift+Down", Action: "cursor.downSelect"},
+	{Key: "ShortcutAlt+Down", Action: "cursor.down"},
+	{Key: "ShortcutAlt+Shift+Down", Action: "cursor.downSelect"},
+	{Key: "Shortcut+Alt+Down", Action: "cursor.addCaretBelow"},
+	{Key: "Home", Action: "cursor.lineStart"},
+	{Key: "Shift+Home", Action: "cursor.lineStartSelect"},
+	{Key: "Shortcut+Home", Action: "cursor.textStart"},
+	{Key: "Shortcut+Shift+Home", Action: "cursor.textStartSelect"},
+	{Key: "End", Action: "cursor.lineEnd"},
+	{Key: "Shift+End", Action: "cursor.lineEndSelect"},
+	{Key: "Shortcut+End", Action: "cursor.textEnd"},
+	{Key: "Shortcut+Shift+End", Action: "cursor.textEndSelect"},
+	{Key: "PageUp", Action: "cursor.pageUp"},
+	{Key: "Shift+PageUp", Action: "cursor.pageUpSelect"},
+	{Key: "PageDown", Action: "cursor.pageDown"},
+	{Key: "Shift+PageDown", Action: "cursor.pageDownSelect"},
+}
+
+// columnSelectBindings makes rectangular selections with Alt+Shift and the
+// arrow keys, or with Cmd+Alt+Shift on macOS, where Alt+Shift selects by words.
+var columnSelectBindings = map[string]string{
+	"Left":  "selection.columnLeft",
+	"Right": "selection.columnRight",
+	"Up":    "selection.columnUp",
+	"Down":  "selection.columnDown",
+}
+
+// Default returns a new keymap with the default key bindings of the editor.
+func Default() *Keymap {
+	k, err := New(defaultBindings...)
+	if err != nil {
+		panic(err)
+	}
+
+	prefix := "Alt+Shift+"
+	if key.ModShortcutAlt == key.ModAlt {
+		prefix = "Shortcut+Alt+Shift+"
+	}
+	for name, action := range columnSelectBindings {
+		if err := k.Bind(prefix+name, action); err != nil {
+			panic(err)
+		}
+	}
+	return k
+}
@@ -0,0 +1,406 @@
+// Package keymap maps key chords to the named actions of the editor. A chord
+// is one or more key strokes typed in sequence, like "Ctrl+K Ctrl+C". Keymaps
+// can be loaded from a JSON description, and changed with Bind and Unbind.
+package keymap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"gioui.org/io/event"
+	"gioui.org/io/key"
+)
+
+// Stroke is a key pressed with modifiers, e.g. Ctrl+K.
+type Stroke struct {
+	Name      key.Name
+	Modifiers key.Modifiers
+}
+
+// Chord is a sequence of key strokes.
+type Chord []Stroke
+
+// Binding binds a key chord to an action.
+type Binding struct {
+	// Key is the chord, with the strokes separated by spaces, e.g.
+	// "Ctrl+K Ctrl+C".
+	Key string `json:"key"`
+	// Action is the ID of the action, e.g. "edit.undo". When loading a
+	// keymap, an empty action unbinds the chord, and an action prefixed by
+	// '-' unbinds the chord only if it is bound to that action.
+	Action string `json:"action"`
+}
+
+// Keymap maps key chords to action IDs. The zero value is an empty keymap.
+type Keymap struct {
+	// bindings maps the canonical form of the chords to the actions.
+	bindings map[string]string
+	// prefixes counts the chords which each chord is a proper prefix of.
+	prefixes map[string]int
+	revision int
+}
+
+// New returns a keymap with the bindings.
+func New(bindings ...Binding) (*Keymap, error) {
+	k := &Keymap{}
+	for _, b := range bindings {
+		if err := k.Bind(b.Key, b.Action); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Load reads the bindings of a JSON array of Binding objects from r and
+// applies them to the keymap, overriding the existing bindings of the same
+// chords. For example:
+//
+//	[
+//		{"key": "Ctrl+Y", "action": "edit.redo"},
+//		{"key": "Ctrl+K Ctrl+C", "action": "edit.copy"},
+//		{"key": "Ctrl+D", "action": ""}
+//	]
+func (k *Keymap) Load(r io.Reader) error {
+	var bindings []Binding
+	if err := json.NewDecoder(r).Decode(&bindings); err != nil {
+		return fmt.Errorf("keymap: %w", err)
+	}
+
+	for _, b := range bindings {
+		var err error
+		switch {
+		case b.Action == "":
+			err = k.Unbind(b.Key)
+		case strings.HasPrefix(b.Action, "-"):
+			var chord Chord
+			if chord, err = ParseChord(b.Key); err == nil && k.bindings[chord.String()] == b.Action[1:] {
+				err = k.Unbind(b.Key)
+			}
+		default:
+			err = k.Bind(b.Key, b.Action)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bind binds the chord to the action, replacing the existing binding of the
+// chord.
+func (k *Keymap) Bind(chord string, action string) error {
+	if action == "" {
+		return errors.New("keymap: empty action")
+	}
+	c, err := ParseChord(chord)
+	if err != nil {
+		return err
+	}
+
+	if k.bindings == nil {
+		k.bindings = make(map[string]string)
+		k.prefixes = make(map[string]int)
+	}
+	id := c.String()
+	if _, ok := k.bindings[id]; !ok {
+		for i := 1; i < len(c); i++ {
+			k.prefixes[c[:i].String()]++
+		}
+	}
+	k.bindings[id] = action
+	k.revision++
+	return nil
+}
+
+// Unbind removes the binding of the chord, if there is one.
+func (k *Keymap) Unbind(chord string) error {
+	c, err := ParseChord(chord)
+	if err != nil {
+		return err
+	}
+
+	id := c.String()
+	if _, ok := k.bindings[id]; !ok {
+		return nil
+	}
+	delete(k.bindings, id)
+	for i := 1; i < len(c); i++ {
+		prefix := c[:i].String()
+		if k.prefixes[prefix]--; k.prefixes[prefix] <= 0 {
+			delete(k.prefixes, prefix)
+		}
+	}
+	k.revision++
+	return nil
+}
+
+// Lookup returns the action bound to the chord, and whether the chord is the
+// start of longer chords. A chord starting longer chords should wait for the
+// next stroke, even if it is bound to an action.
+func (k *Keymap) Lookup(chord Chord) (action string, more bool) {
+	id := chord.String()
+	return k.bindings[id], k.prefixes[id] > 0
+}
+
+// KeysFor returns the chords bound to the action, sorted.
+func (k *Keymap) KeysFor(action string) []string {
+	var keys []string
+	for chord, a := range k.bindings {
+		if a == action {
+			keys = append(keys, chord)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Bindings returns all the bindings, sorted by the chords. They can be
+// marshalled to JSON, and loaded back with Load.
+func (k *Keymap) Bindings() []Binding {
+	bindings := make([]Binding, 0, len(k.bindings))
+	for chord, action := range k.bindings {
+		bindings = append(bindings, Binding{Key: chord, Action: action})
+	}
+	slices.SortFunc(bindings, func(a, b Binding) int { return strings.Compare(a.Key, b.Key) })
+	return bindings
+}
+
+// Clone returns a copy of the keymap, which can be changed independently.
+func (k *Keymap) Clone() *Keymap {
+	c := &Keymap{revision: k.revision}
+	if k.bindings != nil {
+		c.bindings = make(map[string]string, len(k.bindings))
+		c.prefixes = make(map[string]int, len(k.prefixes))
+		for chord, action := range k.bindings {
+			c.bindings[chord] = action
+		}
+		for chord, n := range k.prefixes {
+			c.prefixes[chord] = n
+		}
+	}
+	return c
+}
+
+// Revision is incremented by every change of the keymap, so that the state
+// derived from the keymap can be updated.
+func (k *Keymap) Revision() int {
+	return k.revision
+}
+
+// Filters returns the key filters for the first strokes of all the chords,
+// one per key name, to receive the key events of the keymap targeted at focus.
+func (k *Keymap) Filters(focus event.Tag) []key.Filter {
+	return k.filters(focus, func(c Chord) Chord { return c[:1] })
+}
+
+// ChordFilters returns the key filters for the strokes following the first
+// strokes of the chords, one per key name. They are meant to be matched only
+// while a chord is pending, so that a stroke which types text, like the X of
+// "Ctrl+K X", is left to the text input otherwise.
+func (k *Keymap) ChordFilters(focus event.Tag) []key.Filter {
+	return k.filters(focus, func(c Chord) Chord { return c[1:] })
+}
+
+// filters returns the key filters for the strokes selected by strokes from
+// each chord, one per key name.
+func (k *Keymap) filters(focus event.Tag, strokes func(Chord) Chord) []key.Filter {
+	type mods struct {
+		required, optional key.Modifiers
+		seen               bool
+	}
+	names := make(map[key.Name]*mods)
+	for id := range k.bindings {
+		// The chords are always valid, as they are formatted by Chord.String.
+		chord, _ := ParseChord(id)
+		for _, s := range strokes(chord) {
+			m := names[s.Name]
+			if m == nil {
+				m = &mods{}
+				names[s.Name] = m
+			}
+			if !m.seen {
+				m.required, m.seen = s.Modifiers, true
+			}
+			m.required &= s.Modifiers
+			m.optional |= s.Modifiers
+		}
+	}
+
+	filters := make([]key.Filter, 0, len(names))
+	for name, m := range names {
+		filters = append(filters, key.Filter{Focus: focus, Name: name, Required: m.required, Optional: m.optional &^ m.required})
+	}
+	slices.SortFunc(filters, func(a, b key.Filter) int { return strings.Compare(string(a.Name), string(b.Name)) })
+	return filters
+}
+
+// keyNames maps the names usable in chords to the key names of Gio. The
+// first name of a key is used to format it.
+var keyNames = []struct {
+	name string
+	key  key.Name
+}{
+	{"Left", key.NameLeftArrow},
+	{"Right", key.NameRightArrow},
+	{"Up", key.NameUpArrow},
+	{"Down", key.NameDownArrow},
+	{"Enter", key.NameEnter},
+	{"Return", key.NameReturn},
+	{"Escape", key.NameEscape},
+	{"Esc", key.NameEscape},
+	{"Home", key.NameHome},
+	{"End", key.NameEnd},
+	{"Backspace", key.NameDeleteBackward},
+	{"Delete", key.NameDeleteForward},
+	{"Del", key.NameDeleteForward},
+	{"PageUp", key.NamePageUp},
+	{"PageDown", key.NamePageDown},
+	{"Tab", key.NameTab},
+	{"Space", key.NameSpace},
+}
+
+var modifierNames = []struct {
+	name string
+	mod  key.Modifiers
+}{
+	{"Ctrl", key.ModCtrl},
+	{"Control", key.ModCtrl},
+	{"Cmd", key.ModCommand},
+	{"Command", key.ModCommand},
+	{"Alt", key.ModAlt},
+	{"Option", key.ModAlt},
+	{"Super", key.ModSuper},
+	{"Meta", key.ModSuper},
+	{"Shift", key.ModShift},
+}
+
+// ParseStroke parses a key stroke, which is a key name after zero or more
+// modifiers joined by '+', e.g. "Ctrl+Shift+Z". Names are case-insensitive.
+//
+// Modifiers are Ctrl, Cmd, Alt, Super and Shift. Shortcut stands for the
+// modifier of the shortcuts of the platform, Cmd on macOS and Ctrl elsewhere,
+// and ShortcutAlt for the modifier of the alternative shortcuts, like moving
+// by words, which is Alt on macOS and Ctrl elsewhere.
+//
+// Keys are letters, digits, punctuation, function keys like F1, and Left,
+// Right, Up, Down, Enter, Return, Escape, Home, End, Backspace, Delete,
+// PageUp, PageDown, Tab and Space.
+func ParseStroke(s string) (Stroke, error) {
+	var stroke Stroke
+	parts := strings.Split(s, "+")
+	if strings.HasSuffix(s, "++") || s == "+" {
+		// The key is '+' itself.
+		parts = append(parts[:len(parts)-2], "+")
+	}
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return Stroke{}, fmt.Errorf("keymap: invalid key stroke %q", s)
+		}
+		if i == len(parts)-1 {
+			name, ok := parseKeyName(part)
+			if !ok {
+				return Stroke{}, fmt.Errorf("keymap: unknown key %q in %q", part, s)
+			}
+			stroke.Name = name
+			break
+		}
+
+		mod, ok := parseModifier(part)
+		if !ok {
+			return Stroke{}, fmt.Errorf("keymap: unknown modifier %q in %q", part, s)
+		}
+		stroke.Modifiers |= mod
+	}
+	return stroke, nil
+}
+
+func parseModifier(s string) (key.Modifiers, bool) {
+	switch strings.ToLower(s) {
+	case "shortcut", "mod":
+		return key.ModShortcut, true
+	case "shortcutalt":
+		return key.ModShortcutAlt, true
+	}
+	for _, m := range modifierNames {
+		if strings.EqualFold(m.name, s) {
+			return m.mod, true
+		}
+	}
+	return 0, false
+}
+
+func parseKeyName(s string) (key.Name, bool) {
+	for _, k := range keyNames {
+		if strings.EqualFold(k.name, s) || string(k.key) == s {
+			return k.key, true
+		}
+	}
+	if utf8.RuneCountInString(s) == 1 {
+		return key.Name(strings.ToUpper(s)), true
+	}
+	if n := strings.ToUpper(s); n[0] == 'F' && len(n) <= 3 && strings.Trim(n[1:], "0123456789") == "" {
+		return key.Name(n), true
+	}
+	return "", false
+}
+
+// String formats the stroke in the form parsed by ParseStroke.
+func (s Stroke) String() string {
+	var b strings.Builder
+	for _, m := range []key.Modifiers{key.ModCtrl, key.ModCommand, key.ModAlt, key.ModSuper, key.ModShift} {
+		if s.Modifiers.Contain(m) {
+			for _, n := range modifierNames {
+				if n.mod == m {
+					b.WriteString(n.name)
+					b.WriteByte('+')
+					break
+				}
+			}
+		}
+	}
+
+	name := string(s.Name)
+	for _, k := range keyNames {
+		if k.key == s.Name {
+			name = k.name
+			break
+		}
+	}
+	b.WriteString(name)
+	return b.String()
+}
+
+// ParseChord parses a chord of key strokes separated by spaces, e.g.
+// "Ctrl+K Ctrl+C". See ParseStroke for the form of the strokes.
+func ParseChord(s string) (Chord, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, errors.New("keymap: empty key chord")
+	}
+
+	chord := make(Chord, 0, len(fields))
+	for _, f := range fields {
+		stroke, err := ParseStroke(f)
+		if err != nil {
+			return nil, err
+		}
+		chord = append(chord, stroke)
+	}
+	return chord, nil
+}
+
+// String formats the chord in the form parsed by ParseChord.
+func (c Chord) String() string {
+	strokes := make([]string, len(c))
+	for i, s := range c {
+		strokes[i] = s.String()
+	}
+	return strings.Join(strokes, " ")
+}
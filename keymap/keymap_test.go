@@ -0,0 +1,233 @@
+package keymap
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"gioui.org/io/key"
+)
+
+func TestParseStroke(t *testing.T) {
+	cases := []struct {
+		input string
+		want  Stroke
+		str   string
+	}{
+		{"Ctrl+K", Stroke{Name: "K", Modifiers: key.ModCtrl}, "Ctrl+K"},
+		{"shift+ctrl+left", Stroke{Name: key.NameLeftArrow, Modifiers: key.ModCtrl | key.ModShift}, "Ctrl+Shift+Left"},
+		{"Esc", Stroke{Name: key.NameEscape}, "Escape"},
+		{"Alt+f12", Stroke{Name: "F12", Modifiers: key.ModAlt}, "Alt+F12"},
+		{"Ctrl++", Stroke{Name: "+", Modifiers: key.ModCtrl}, "Ctrl++"},
+		{"+", Stroke{Name: "+"}, "+"},
+		{"Cmd+Super+[", Stroke{Name: "[", Modifiers: key.ModCommand | key.ModSuper}, "Cmd+Super+["},
+		{"Shortcut+Z", Stroke{Name: "Z", Modifiers: key.ModShortcut}, ""},
+	}
+	for _, tc := range cases {
+		got, err := ParseStroke(tc.input)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: want %v, got %v", tc.input, tc.want, got)
+		}
+		if tc.str != "" && got.String() != tc.str {
+			t.Errorf("%q: want string %q, got %q", tc.input, tc.str, got.String())
+		}
+	}
+
+	for _, input := range []string{"", "Ctrl+", "Hyper+K", "Ctrl+Foo", "Ctrl++K"} {
+		if _, err := ParseStroke(input); err == nil {
+			t.Errorf("%q: want error", input)
+		}
+	}
+}
+
+func TestParseChord(t *testing.T) {
+	chord, err := ParseChord(" ctrl+k   ctrl+c ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Chord{{Name: "K", Modifiers: key.ModCtrl}, {Name: "C", Modifiers: key.ModCtrl}}
+	if !slices.Equal(chord, want) {
+		t.Fatalf("want %v, got %v", want, chord)
+	}
+	if chord.String() != "Ctrl+K Ctrl+C" {
+		t.Fatalf("want Ctrl+K Ctrl+C, got %s", chord.String())
+	}
+	if _, err := ParseChord("  "); err == nil {
+		t.Fatal("want error for empty chord")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	k, err := New(
+		Binding{Key: "Ctrl+K Ctrl+C", Action: "comment"},
+		Binding{Key: "Ctrl+K Ctrl+U", Action: "uncomment"},
+		Binding{Key: "Ctrl+Z", Action: "undo"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(s string) (string, bool) {
+		chord, err := ParseChord(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k.Lookup(chord)
+	}
+
+	if action, more := lookup("Ctrl+K"); action != "" || !more {
+		t.Errorf("Ctrl+K: want a prefix, got %q %v", action, more)
+	}
+	if action, more := lookup("Ctrl+K Ctrl+C"); action != "comment" || more {
+		t.Errorf("Ctrl+K Ctrl+C: want comment, got %q %v", action, more)
+	}
+	if action, _ := lookup("control+z"); action != "undo" {
+		t.Errorf("Ctrl+Z: want undo, got %q", action)
+	}
+	if action, more := lookup("Ctrl+K Ctrl+X"); action != "" || more {
+		t.Errorf("Ctrl+K Ctrl+X: want nothing, got %q %v", action, more)
+	}
+
+	// The prefix is kept until all the chords starting with it are unbound.
+	k.Unbind("Ctrl+K Ctrl+C")
+	if _, more := lookup("Ctrl+K"); !more {
+		t.Errorf("Ctrl+K: want a prefix after unbinding one chord")
+	}
+	k.Unbind("Ctrl+K Ctrl+U")
+	if _, more := lookup("Ctrl+K"); more {
+		t.Errorf("Ctrl+K: want no prefix after unbinding all chords")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	k := Default()
+	rev := k.Revision()
+	err := k.Load(strings.NewReader(`[
+		{"key": "Ctrl+Y", "action": "edit.redo"},
+		{"key": "Shortcut+D", "action": ""},
+		{"key": "Shortcut+A", "action": "-edit.undo"},
+		{"key": "Shortcut+Z", "action": "-edit.undo"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Revision() == rev {
+		t.Error("revision not updated")
+	}
+
+	chord := func(s string) Chord {
+		c, _ := ParseChord(s)
+		return c
+	}
+	if action, _ := k.Lookup(chord("Ctrl+Y")); action != "edit.redo" {
+		t.Errorf("Ctrl+Y: want edit.redo, got %q", action)
+	}
+	if action, _ := k.Lookup(chord("Shortcut+D")); action != "" {
+		t.Errorf("Shortcut+D: want unbound, got %q", action)
+	}
+	// Only the binding to the named action is removed.
+	if action, _ := k.Lookup(chord("Shortcut+A")); action != "selection.all" {
+		t.Errorf("Shortcut+A: want selection.all, got %q", action)
+	}
+	if action, _ := k.Lookup(chord("Shortcut+Z")); action != "" {
+		t.Errorf("Shortcut+Z: want unbound, got %q", action)
+	}
+	if got := k.KeysFor("edit.redo"); len(got) != 2 {
+		t.Errorf("want 2 keys for edit.redo, got %v", got)
+	}
+
+	if err := k.Load(strings.NewReader(`[{"key": "Ctrl+Foo", "action": "x"}]`)); err == nil {
+		t.Error("want error for unknown key")
+	}
+	if err := k.Load(strings.NewReader(`{}`)); err == nil {
+		t.Error("want error for invalid JSON")
+	}
+
+	// Bindings round-trip through JSON.
+	data, err := json.Marshal(k.Bindings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &Keymap{}
+	if err := loaded.Load(strings.NewReader(string(data))); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(loaded.Bindings(), k.Bindings()) {
+		t.Error("bindings mismatch after round-trip")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	k, _ := New(
+		Binding{Key: "Left", Action: "left"},
+		Binding{Key: "Shift+Left", Action: "leftSelect"},
+		Binding{Key: "Ctrl+K Ctrl+Left", Action: "x"},
+		Binding{Key: "Ctrl+Shift+K", Action: "y"},
+		Binding{Key: "Ctrl+K X", Action: "z"},
+	)
+	tag := new(int)
+	want := []key.Filter{
+		{Focus: tag, Name: "K", Required: key.ModCtrl, Optional: key.ModShift},
+		{Focus: tag, Name: key.NameLeftArrow, Optional: key.ModShift},
+	}
+	if got := k.Filters(tag); !slices.Equal(got, want) {
+		t.Errorf("want filters %v, got %v", want, got)
+	}
+	// The strokes after the first are matched only while a chord is pending.
+	want = []key.Filter{
+		{Focus: tag, Name: "X"},
+		{Focus: tag, Name: key.NameLeftArrow, Required: key.ModCtrl},
+	}
+	if got := k.ChordFilters(tag); !slices.Equal(got, want) {
+		t.Errorf("want chord filters %v, got %v", want, got)
+	}
+
+	clone := k.Clone()
+	clone.Unbind("Left")
+	if action, _ := k.Lookup(Chord{{Name: key.NameLeftArrow}}); action != "left" {
+		t.Error("changing the clone changed the keymap")
+	}
+}
+
+func TestDefaultKeepsBaselineKeys(t *testing.T) {
+	// The key filters the editor registered before the keymap.
+	baseline := []key.Filter{
+		{Name: key.NameEnter, Optional: key.ModShift},
+		{Name: key.NameReturn, Optional: key.ModShift},
+		{Name: "C", Required: key.ModShortcut},
+		{Name: "V", Required: key.ModShortcut},
+		{Name: "X", Required: key.ModShortcut},
+		{Name: "Z", Required: key.ModShortcut, Optional: key.ModShift},
+		{Name: "A", Required: key.ModShortcut},
+		{Name: key.NameHome, Optional: key.ModShortcut | key.ModShift},
+		{Name: key.NameEnd, Optional: key.ModShortcut | key.ModShift},
+		{Name: key.NameTab, Optional: key.ModShift},
+		{Name: key.NameDeleteBackward, Optional: key.ModShortcutAlt | key.ModShift},
+		{Name: key.NameDeleteForward, Optional: key.ModShortcutAlt | key.ModShift},
+		{Name: key.NamePageDown, Optional: key.ModShift},
+		{Name: key.NamePageUp, Optional: key.ModShift},
+		{Name: key.NameLeftArrow, Optional: key.ModShortcutAlt | key.ModShift},
+		{Name: key.NameUpArrow, Optional: key.ModShortcutAlt | key.ModShift},
+		{Name: key.NameRightArrow, Optional: key.ModShortcutAlt | key.ModShift},
+		{Name: key.NameDownArrow, Optional: key.ModShortcutAlt | key.ModShift},
+	}
+
+	k := Default()
+	for _, f := range baseline {
+		// Every combination of the optional modifiers is matched.
+		for mods := key.Modifiers(0); mods <= f.Optional; mods++ {
+			if mods&^f.Optional != 0 {
+				continue
+			}
+			stroke := Stroke{Name: f.Name, Modifiers: f.Required | mods}
+			if action, _ := k.Lookup(Chord{stroke}); action == "" {
+				t.Errorf("%v is not bound", stroke)
+			}
+		}
+	}
+}
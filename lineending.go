@@ -0,0 +1,152 @@
+package gvcode
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/oligo/gvcode/buffer"
+)
+
+// LineEnding is the style of the line breaks of a text.
+//
+// The editor always separates the lines with "\n" internally, so a "\r\n" is a
+// single line break for the line numbers, the layout and the caret movement.
+// The style of the text set by SetText is detected and kept, so that the text
+// can be saved in the same style with LineEnding.Convert.
+type LineEnding = buffer.LineEnding
+
+const (
+	LF   = buffer.LF
+	CRLF = buffer.CRLF
+	CR   = buffer.CR
+)
+
+// LineEnding returns the line ending style of the text, which is the dominant
+// style of the text set by SetText, or the one set by SetLineEnding.
+func (e *Editor) LineEnding() LineEnding {
+	return e.lineEnding
+}
+
+// MixedLineEndings returns the number of line breaks of the text whose style is
+// different from LineEnding. They are the line breaks of the text set by
+// SetText that are not removed by the edits since, and are converted to
+// LineEnding when the text is saved. The line breaks inserted by the edits, or
+// restored by undoing them, are in the style of LineEnding.
+func (e *Editor) MixedLineEndings() int {
+	return len(e.mixedBreaks)
+}
+
+// SetLineEnding changes the line ending style of the text, which converts all
+// the line breaks to le when the text is saved. It returns the number of line
+// breaks of the text whose style is changed by the conversion. The document is
+// reported as modified by Modified until it is saved.
+func (e *Editor) SetLineEnding(le LineEnding) (converted int) {
+	e.initBuffer()
+	// The text has as many line breaks as the line of its end.
+	total := e.buffer.LineOf(e.buffer.Len())
+	converted = len(e.mixedBreaks)
+	if le != e.lineEnding {
+		converted = total
+		for _, lb := range e.mixedBreaks {
+			if lb.style == le {
+				converted--
+			}
+		}
+	}
+	if le != e.lineEnding || converted > 0 {
+		e.formatChanged = true
+	}
+	e.lineEnding = le
+	e.mixedBreaks = nil
+	return converted
+}
+
+// TextWithLineEnding returns the contents of the editor, with the line breaks
+// converted to LineEnding.
+func (e *Editor) TextWithLineEnding() string {
+	return e.LineEnding().Convert(e.Text())
+}
+
+// updateLineEndings moves the mixed line breaks by the lines inserted or
+// removed by the change, and drops the ones removed.
+func (e *Editor) updateLineEndings(c TextChange) {
+	if len(e.mixedBreaks) == 0 {
+		return
+	}
+
+	// The line break ending a line is removed if the line is in the removed
+	// text, but is not its last line.
+	lineAt := func(line int) int {
+		return sort.Search(len(e.mixedBreaks), func(i int) bool {
+			return e.mixedBreaks[i].line >= line
+		})
+	}
+	i, j := lineAt(c.StartPos.Line), lineAt(c.OldEndPos.Line)
+	e.mixedBreaks = slices.Delete(e.mixedBreaks, i, j)
+	for k := i; k < len(e.mixedBreaks); k++ {
+		e.mixedBreaks[k].line += c.NewEndPos.Line - c.OldEndPos.Line
+	}
+}
+
+// mixedLineBreak is a line break whose style is different from the style of
+// the document, by the line it ends.
+type mixedLineBreak struct {
+	line  int
+	style LineEnding
+}
+
+// lineEndingScanner records the styles of the line breaks of a text in order,
+// as runs of the line breaks of the same style.
+type lineEndingScanner struct {
+	counts buffer.LineEndingCounts
+	runs   []lineEndingRun
+}
+
+type lineEndingRun struct {
+	style LineEnding
+	n     int
+}
+
+// add records the next line break of the text.
+func (ls *lineEndingScanner) add(le LineEnding) {
+	ls.counts[le]++
+	if n := len(ls.runs); n > 0 && ls.runs[n-1].style == le {
+		ls.runs[n-1].n++
+		return
+	}
+	ls.runs = append(ls.runs, lineEndingRun{style: le, n: 1})
+}
+
+// scan records the line breaks of text. A "\r\n" is a single line break.
+func (ls *lineEndingScanner) scan(text string) {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			ls.add(LF)
+		case '\r':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				ls.add(CRLF)
+				i++
+			} else {
+				ls.add(CR)
+			}
+		}
+	}
+}
+
+// mixed returns the dominant style of the line breaks recorded, and the line
+// breaks of the other styles.
+func (ls *lineEndingScanner) mixed() (LineEnding, []mixedLineBreak) {
+	dominant := ls.counts.Dominant()
+	var breaks []mixedLineBreak
+	line := 0
+	for _, run := range ls.runs {
+		if run.style != dominant {
+			for i := range run.n {
+				breaks = append(breaks, mixedLineBreak{line: line + i, style: run.style})
+			}
+		}
+		line += run.n
+	}
+	return dominant, breaks
+}
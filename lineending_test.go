@@ -0,0 +1,56 @@
+package gvcode
+
+import (
+	"image"
+	"testing"
+
+	"gioui.org/font/gofont"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/text"
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+func TestMixedLineEndingsFollowEdits(t *testing.T) {
+	shaper := text.NewShaper(text.WithCollection(gofont.Collection()))
+	e := &Editor{}
+	e.WithOptions(WithColorScheme(syntax.ColorScheme{}))
+	// The carets are placed by the layout of the text.
+	layoutText := func() {
+		gtx := layout.Context{Ops: new(op.Ops), Constraints: layout.Exact(image.Pt(800, 600))}
+		e.Layout(gtx, shaper)
+	}
+	e.SetText("a\r\nb\nc\rd\ne\n")
+	if e.LineEnding() != LF || e.MixedLineEndings() != 2 {
+		t.Fatalf("want LF with 2 mixed line endings, got %v with %d", e.LineEnding(), e.MixedLineEndings())
+	}
+
+	// The inserted line breaks are in the style of the document.
+	e.SetCaret(0, 0)
+	e.Insert("x\ny\n")
+	if got := e.MixedLineEndings(); got != 2 {
+		t.Errorf("want 2 mixed line endings after inserting lines, got %d", got)
+	}
+
+	// Replace the line ended by "\r".
+	layoutText()
+	e.SetCaret(10, 8)
+	e.Insert("z")
+	if got := e.Text(); got != "x\ny\na\nb\nzd\ne\n" {
+		t.Fatalf("unexpected text %q", got)
+	}
+	if got := e.MixedLineEndings(); got != 1 {
+		t.Errorf("want 1 mixed line ending after replacing a line, got %d", got)
+	}
+
+	// Only the line break kept in "\r\n" is not converted.
+	if got := e.SetLineEnding(CRLF); got != 5 {
+		t.Errorf("want 5 line breaks converted, got %d", got)
+	}
+	if e.MixedLineEndings() != 0 || !e.Modified() {
+		t.Errorf("want no mixed line endings and the document modified")
+	}
+	if got := e.TextWithLineEnding(); got != "x\r\ny\r\na\r\nb\r\nzd\r\ne\r\n" {
+		t.Errorf("unexpected text %q", got)
+	}
+}
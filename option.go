@@ -4,6 +4,8 @@ import (
 	"gioui.org/font"
 	"gioui.org/text"
 	"gioui.org/unit"
+	"github.com/oligo/gvcode/buffer"
+	"github.com/oligo/gvcode/keymap"
 	"github.com/oligo/gvcode/textstyle/syntax"
 )
 
@@ -131,6 +133,56 @@ func WrapLine(enabled bool) EditorOption {
 	}
 }
 
+// VirtualLayout configures whether to shape only the text near the viewport
+// rather than the whole document. This is useful for very large files, such as
+// logs. When lines are wrapped, the scroll bounds are estimated from the shaped
+// text.
+func VirtualLayout(enabled bool) EditorOption {
+	return func(e *Editor) {
+		e.initBuffer()
+		e.text.SetVirtualLayout(enabled)
+	}
+}
+
+// WithMaxLines sets the maximum number of lines kept by Append, beyond which
+// the oldest lines are trimmed, so that a growing log does not take more and
+// more memory. n <= 0 means no limit, which is the default.
+func WithMaxLines(n int) EditorOption {
+	return func(e *Editor) {
+		e.maxLines = max(n, 0)
+	}
+}
+
+// WithTextSource makes the editor store the text in src, instead of the
+// default piece table, which is replaced along with its text and undo history.
+// If src is a [buffer.ReadOnlySource], the editor is also switched to
+// ModeReadOnly, and shows the text as it is indexed. Use it with VirtualLayout
+// to view files too large to be laid out at once.
+//
+// The line breaks of the text of src must be "\n", as the editor does not
+// convert them. ReadOnlySource reads the other line breaks as "\n". The line
+// ending style of the editor is LF for a new source, so that the line breaks
+// are not converted when the text is saved.
+func WithTextSource(src buffer.TextSource) EditorOption {
+	return func(e *Editor) {
+		e.initBuffer()
+		e.setSource(src)
+		if _, ok := src.(*buffer.ReadOnlySource); ok {
+			e.setMode(ModeReadOnly)
+		}
+	}
+}
+
+// WithLoadProgress sets a function to be called with the progress of loading
+// a document by ReadFrom or Load, which is useful for very large files. read
+// is the number of bytes read so far, and total is the size of the document,
+// or -1 if it is unknown. fn is called in the goroutine loading the document.
+func WithLoadProgress(fn func(read, total int64)) EditorOption {
+	return func(e *Editor) {
+		e.loadProgress = fn
+	}
+}
+
 // WithLineNumber configures whether to show line number or not.
 func WithLineNumber(enabled bool) EditorOption {
 	return func(e *Editor) {
@@ -139,6 +191,32 @@ func WithLineNumber(enabled bool) EditorOption {
 	}
 }
 
+// WithFolding configures whether to enable code folding. Folding shows a gutter
+// with clickable chevrons next to the fold ranges. Fold ranges are computed from
+// the indentation of lines, unless a fold provider is set by WithFoldProvider.
+func WithFolding(enabled bool) EditorOption {
+	return func(e *Editor) {
+		e.initBuffer()
+		e.folding = enabled
+		switch {
+		case !enabled:
+			e.text.SetFoldProvider(nil)
+		case e.text.FoldProvider() == nil:
+			e.text.SetFoldProvider(&IndentFoldProvider{})
+		}
+	}
+}
+
+// WithFoldProvider enables code folding, with the fold ranges computed by the
+// provider, which can be backed by a language server.
+func WithFoldProvider(provider FoldProvider) EditorOption {
+	return func(e *Editor) {
+		e.initBuffer()
+		e.folding = provider != nil
+		e.text.SetFoldProvider(provider)
+	}
+}
+
 func WithLineNumberGutterGap(gap unit.Dp) EditorOption {
 	return func(e *Editor) {
 		e.initBuffer()
@@ -164,6 +242,19 @@ func WithColorScheme(scheme syntax.ColorScheme) EditorOption {
 	}
 }
 
+// WithTokenizer sets the tokenizer to highlight the syntax of the text, which
+// is tokenized incrementally as the text is changed, in bounded time slices
+// per frame. The tokens replace those set by SetSyntaxTokens. A color scheme
+// must be set for the tokens to be styled. Pass nil to stop highlighting.
+func WithTokenizer(tokenizer syntax.Tokenizer) EditorOption {
+	return func(e *Editor) {
+		e.highlighter = nil
+		if tokenizer != nil {
+			e.highlighter = syntax.NewHighlighter(tokenizer)
+		}
+	}
+}
+
 // BeforePasteHook defines a hook to be called before pasting text to transform the text.
 type BeforePasteHook func(text string) string
 
@@ -172,3 +263,14 @@ func AddBeforePasteHook(hook BeforePasteHook) EditorOption {
 		ed.onPaste = hook
 	}
 }
+
+// WithKeymap sets the keymap binding the key chords to the actions of the
+// editor. The keymap is used as is, so it can be shared by editors; use
+// Keymap.Clone to customize it for one editor only.
+func WithKeymap(km *keymap.Keymap) EditorOption {
+	return func(e *Editor) {
+		e.keymap = km
+		// Rebuild the commands even if the revision happens to be the same.
+		e.keymapRevision = -1
+	}
+}
@@ -0,0 +1,216 @@
+// Package search implements the text searching used by the editor's find and
+// replace feature. The document is scanned line by line from an io.Reader, so
+// there is no need to copy the whole document to search in it.
+package search
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Query describes what to search for in the document.
+type Query struct {
+	// Pattern is the text to search for. If Regex is set, it is a regular
+	// expression using the syntax accepted by package regexp.
+	Pattern string
+	// Regex treats Pattern as a regular expression.
+	Regex bool
+	// IgnoreCase makes the search case-insensitive.
+	IgnoreCase bool
+	// WholeWord only accepts matches which are not surrounded by word
+	// characters, that is, letters, digits and underscores.
+	WholeWord bool
+}
+
+// Match is a range of text matching the query.
+type Match struct {
+	// Start and End are rune offsets of the match in the document.
+	Start, End int
+	// LineStart is the rune offset of the start of the line containing the
+	// match.
+	LineStart int
+}
+
+// Searcher finds the matches of a compiled query.
+type Searcher struct {
+	query Query
+	re    *regexp.Regexp
+	// line is reused to read lines from the document.
+	line []byte
+}
+
+// Compile compiles the query to a Searcher. It returns an error if the pattern
+// is empty or if it is not a valid regular expression.
+func Compile(query Query) (*Searcher, error) {
+	if query.Pattern == "" {
+		return nil, errors.New("empty search pattern")
+	}
+
+	pattern := query.Pattern
+	if !query.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if query.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Searcher{query: query, re: re}, nil
+}
+
+// Query returns the query the Searcher is compiled from.
+func (s *Searcher) Query() Query {
+	return s.query
+}
+
+// FindAll scans all the text from r and returns the matches. Lines are
+// searched one by one with the line break stripped, so a match never spans
+// multiple lines, and the ^ and $ anchors match at the line boundaries.
+// Empty matches are ignored.
+func (s *Searcher) FindAll(r io.Reader) ([]Match, error) {
+	var matches []Match
+	err := s.scan(r, func(line []byte, lineStart int) bool {
+		matches = s.appendLineMatches(matches, line, lineStart)
+		return true
+	})
+	return matches, err
+}
+
+// FindFunc scans all the text from r, calling fn for every match until fn
+// returns false.
+func (s *Searcher) FindFunc(r io.Reader, fn func(m Match) bool) error {
+	var matches []Match
+	return s.scan(r, func(line []byte, lineStart int) bool {
+		matches = s.appendLineMatches(matches[:0], line, lineStart)
+		for _, m := range matches {
+			if !fn(m) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Expand returns the replacement of the match starting at column col of line,
+// where col is in runes. If the query is a regular expression, template can
+// refer to the capture groups of the match using the syntax of
+// regexp.Regexp.Expand, such as $1 or ${name}. Otherwise template is returned
+// literally. It reports false if there is no such match in line.
+func (s *Searcher) Expand(line []byte, col int, template string) (string, bool) {
+	line = trimLineBreak(line)
+	byteCol := 0
+	for i := 0; i < col && byteCol < len(line); i++ {
+		_, size := utf8.DecodeRune(line[byteCol:])
+		byteCol += size
+	}
+
+	for _, loc := range s.re.FindAllSubmatchIndex(line, -1) {
+		if loc[0] != byteCol {
+			continue
+		}
+		if !s.query.Regex {
+			return template, true
+		}
+		return string(s.re.Expand(nil, []byte(template), line, loc)), true
+	}
+
+	return "", false
+}
+
+// scan reads r line by line, calling fn with the content of the line, without
+// the line break, and the rune offset of the line start.
+func (s *Searcher) scan(r io.Reader, fn func(line []byte, lineStart int) bool) error {
+	br := bufio.NewReader(r)
+	lineStart := 0
+	for {
+		line, err := s.readLine(br)
+		if len(line) > 0 {
+			if !fn(trimLineBreak(line), lineStart) {
+				return nil
+			}
+			lineStart += utf8.RuneCount(line)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads the next line, including the line break, reusing the line
+// buffer of the Searcher.
+func (s *Searcher) readLine(br *bufio.Reader) ([]byte, error) {
+	s.line = s.line[:0]
+	for {
+		chunk, err := br.ReadSlice('\n')
+		s.line = append(s.line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return s.line, err
+		}
+	}
+}
+
+func (s *Searcher) appendLineMatches(matches []Match, line []byte, lineStart int) []Match {
+	// byteOff and runeOff tracks the rune offset of the last match to avoid
+	// recounting from the start of the line.
+	byteOff, runeOff := 0, 0
+	for _, loc := range s.re.FindAllIndex(line, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		if s.query.WholeWord && !isWholeWord(line, loc[0], loc[1]) {
+			continue
+		}
+
+		runeOff += utf8.RuneCount(line[byteOff:loc[0]])
+		start := runeOff
+		runeOff += utf8.RuneCount(line[loc[0]:loc[1]])
+		byteOff = loc[1]
+		matches = append(matches, Match{Start: lineStart + start, End: lineStart + runeOff, LineStart: lineStart})
+	}
+
+	return matches
+}
+
+// isWholeWord checks that the text in line[start:end] is not adjacent to
+// other word characters.
+func isWholeWord(line []byte, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRune(line[:start])
+		if isWordChar(r) {
+			return false
+		}
+	}
+	if end < len(line) {
+		r, _ := utf8.DecodeRune(line[end:])
+		if isWordChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func trimLineBreak(line []byte) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line
+}
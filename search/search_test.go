@@ -0,0 +1,130 @@
+package search
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFindAll(t *testing.T) {
+	doc := "foo Foo food\n你好 foo_bar foo\r\nbar(foo)"
+
+	cases := []struct {
+		query Query
+		want  []Match
+	}{
+		{
+			query: Query{Pattern: "foo"},
+			want:  []Match{{0, 3, 0}, {8, 11, 0}, {16, 19, 13}, {24, 27, 13}, {33, 36, 29}},
+		},
+		{
+			query: Query{Pattern: "foo", IgnoreCase: true},
+			want:  []Match{{0, 3, 0}, {4, 7, 0}, {8, 11, 0}, {16, 19, 13}, {24, 27, 13}, {33, 36, 29}},
+		},
+		{
+			query: Query{Pattern: "foo", WholeWord: true},
+			want:  []Match{{0, 3, 0}, {24, 27, 13}, {33, 36, 29}},
+		},
+		{
+			query: Query{Pattern: `fo+\b`, Regex: true},
+			want:  []Match{{0, 3, 0}, {24, 27, 13}, {33, 36, 29}},
+		},
+		{
+			query: Query{Pattern: `foo$`, Regex: true},
+			want:  []Match{{24, 27, 13}},
+		},
+		{
+			query: Query{Pattern: `^\w+`, Regex: true},
+			want:  []Match{{0, 3, 0}, {29, 32, 29}},
+		},
+		{
+			query: Query{Pattern: `x*`, Regex: true},
+			want:  nil,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.query.Pattern), func(t *testing.T) {
+			s, err := Compile(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matches, err := s.FindAll(strings.NewReader(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(matches, tc.want) {
+				t.Logf("want: %v, actual: %v", tc.want, matches)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestFindAllLongLine(t *testing.T) {
+	doc := strings.Repeat("a", 10000) + "needle\nneedle"
+	s, _ := Compile(Query{Pattern: "needle"})
+	matches, _ := s.FindAll(strings.NewReader(doc))
+	want := []Match{{10000, 10006, 0}, {10007, 10013, 10007}}
+	if !slices.Equal(matches, want) {
+		t.Fatalf("want: %v, actual: %v", want, matches)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	cases := []struct {
+		query    Query
+		line     string
+		col      int
+		template string
+		want     string
+		ok       bool
+	}{
+		{
+			query:    Query{Pattern: `(\w+)=(\w+)`, Regex: true},
+			line:     "你 a=b c=d\n",
+			col:      6,
+			template: "$2=$1",
+			want:     "d=c",
+			ok:       true,
+		},
+		{
+			query:    Query{Pattern: `(?P<key>\w+):`, Regex: true},
+			line:     "key: value",
+			col:      0,
+			template: "${key} =",
+			want:     "key =",
+			ok:       true,
+		},
+		{
+			query:    Query{Pattern: `$1`},
+			line:     "a $1 b",
+			col:      2,
+			template: "$2",
+			want:     "$2",
+			ok:       true,
+		},
+		{
+			query:    Query{Pattern: `b`},
+			line:     "a b",
+			col:      0,
+			template: "c",
+			ok:       false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.query.Pattern), func(t *testing.T) {
+			s, err := Compile(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := s.Expand([]byte(tc.line), tc.col, tc.template)
+			if got != tc.want || ok != tc.ok {
+				t.Logf("want: %q, %v, actual: %q, %v", tc.want, tc.ok, got, ok)
+				t.Fail()
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package gvcode
+
+import (
+	"github.com/oligo/gvcode/buffer"
+)
+
+// Snapshot is an immutable view of the text at a version. Taking a snapshot
+// does not copy the text, and reading it does not lock the editor, so it can
+// be handed to background goroutines, such as linters and highlighters, while
+// the user keeps editing.
+type Snapshot = buffer.Snapshot
+
+// Snapshot returns an immutable view of the current text.
+func (e *Editor) Snapshot() *Snapshot {
+	e.initBuffer()
+	return e.buffer.Snapshot()
+}
+
+// Version returns the version of the text, which increases with every change.
+// A snapshot is outdated if its version is different from the one of the
+// editor.
+func (e *Editor) Version() int {
+	e.initBuffer()
+	return e.buffer.Version()
+}
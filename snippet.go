@@ -6,7 +6,7 @@ import (
 
 	"gioui.org/io/key"
 	"gioui.org/layout"
-	"github.com/oligo/gvcode/internal/buffer"
+	"github.com/oligo/gvcode/buffer"
 	"github.com/oligo/gvcode/snippet"
 	"github.com/oligo/gvcode/textstyle/decoration"
 )
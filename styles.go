@@ -2,11 +2,17 @@ package gvcode
 
 import (
 	"log/slog"
+	"time"
 
+	"gioui.org/layout"
+	"gioui.org/op"
 	"github.com/oligo/gvcode/textstyle/decoration"
 	"github.com/oligo/gvcode/textstyle/syntax"
 )
 
+// highlightBudget is the time spent on tokenizing the text per frame.
+const highlightBudget = 4 * time.Millisecond
+
 // TextRange contains the range of text of interest in the document. It can used for
 // search, styling text, or any other purposes.
 type TextRange struct {
@@ -34,3 +40,15 @@ func (e *Editor) SetSyntaxTokens(tokens ...syntax.Token) {
 	}
 	e.text.SetSyntaxTokens(tokens...)
 }
+
+// highlight tokenizes the changed text with the tokenizer set by WithTokenizer,
+// and asks for another frame if it is not done within the time budget.
+func (e *Editor) highlight(gtx layout.Context) {
+	tokens := e.text.SyntaxTokens()
+	if e.highlighter == nil || tokens == nil {
+		return
+	}
+	if !e.highlighter.Update(e.buffer, tokens, time.Now().Add(highlightBudget)) {
+		gtx.Execute(op.InvalidateCmd{})
+	}
+}
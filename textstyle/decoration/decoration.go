@@ -5,8 +5,8 @@ import (
 	"errors"
 	"slices"
 
+	"github.com/oligo/gvcode/buffer"
 	"github.com/oligo/gvcode/color"
-	"github.com/oligo/gvcode/internal/buffer"
 	"github.com/oligo/gvcode/internal/layout"
 	"github.com/oligo/gvcode/internal/painter"
 	"github.com/rdleal/intervalst/interval"
@@ -211,11 +211,21 @@ func (d *DecorationTree) RemoveBySource(source string) error {
 	all := d.getAllNodes()
 	for _, deco := range all {
 		if deco.Source == source {
+			// Delete removes all the values of the interval, so decorations
+			// from other sources sharing the interval have to be added back.
+			vals, _ := d.tree.Find(deco.Start, deco.End)
 			err := d.tree.Delete(deco.Start, deco.End)
 			if err != nil {
 				return err
 			}
 			deco.clear(d.src)
+
+			vals = slices.DeleteFunc(slices.Clone(vals), func(v Decoration) bool { return v.Source == source })
+			if len(vals) > 0 {
+				if err := d.tree.Insert(deco.Start, deco.End, vals...); err != nil {
+					return err
+				}
+			}
 		}
 	}
 
@@ -230,6 +240,54 @@ func (d *DecorationTree) RemoveBySource(source string) error {
 	return nil
 }
 
+// RemoveRange removes the decorations of source overlapping the range
+// [start, end), or the rune at start if the range is empty. The ranges of
+// the decorations are refreshed first, so that they are compared with the
+// current offsets.
+func (d *DecorationTree) RemoveRange(source string, start, end int) error {
+	d.Refresh()
+
+	end = max(end, start+1)
+	overlaps := func(deco Decoration) bool {
+		return deco.Source == source && deco.Start < end && max(deco.End, deco.Start+1) > start
+	}
+	for _, deco := range d.QueryRange(start, end) {
+		if deco.Start == deco.End || !overlaps(deco) {
+			continue
+		}
+		// The interval may be deleted with an earlier decoration sharing it.
+		vals, found := d.tree.Find(deco.Start, deco.End)
+		if !found {
+			continue
+		}
+		if err := d.tree.Delete(deco.Start, deco.End); err != nil {
+			return err
+		}
+
+		vals = slices.DeleteFunc(slices.Clone(vals), func(v Decoration) bool {
+			if overlaps(v) {
+				v.clear(d.src)
+				return true
+			}
+			return false
+		})
+		if len(vals) > 0 {
+			if err := d.tree.Insert(deco.Start, deco.End, vals...); err != nil {
+				return err
+			}
+		}
+	}
+
+	d.emptyDecos = slices.DeleteFunc(d.emptyDecos, func(deco Decoration) bool {
+		if overlaps(deco) {
+			deco.clear(d.src)
+			return true
+		}
+		return false
+	})
+	return nil
+}
+
 func (d *DecorationTree) RemoveAll() error {
 	all := d.getAllNodes()
 	for _, deco := range all {
@@ -1,9 +1,11 @@
 package decoration
 
 import (
+	"fmt"
+	"slices"
 	"testing"
 
-	"github.com/oligo/gvcode/internal/buffer"
+	"github.com/oligo/gvcode/buffer"
 )
 
 func TestInsertDecoration(t *testing.T) {
@@ -57,3 +59,47 @@ func TestRemoveDecorationBySource(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRemoveDecorationBySourceSharedRange(t *testing.T) {
+	d := NewDecorationTree(buffer.NewTextSource())
+
+	d.Insert(Decoration{Source: "a", Start: 0, End: 5, Bold: true})
+	d.Insert(Decoration{Source: "b", Start: 0, End: 5, Italic: true})
+
+	d.RemoveBySource("b")
+	v := d.QueryRange(0, 5)
+	if len(v) != 1 || v[0].Source != "a" {
+		t.Fatalf("unexpected decorations: %v", v)
+	}
+}
+
+func TestRemoveDecorationRange(t *testing.T) {
+	src := buffer.NewTextSource()
+	src.SetText([]byte("foo bar foo baz foo"))
+	d := NewDecorationTree(src)
+
+	for _, start := range []int{0, 8, 16} {
+		d.Insert(Decoration{Source: "match", Start: start, End: start + 3})
+	}
+	d.Insert(Decoration{Source: "other", Start: 8, End: 11})
+	d.Insert(Decoration{Source: "match", Start: 12, End: 12})
+
+	// The decorations follow the edit before they are removed.
+	src.Replace(4, 4, "xx")
+	if err := d.RemoveRange("match", 10, 15); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, deco := range d.QueryRange(0, src.Len()) {
+		got = append(got, fmt.Sprintf("%s:%d-%d", deco.Source, deco.Start, deco.End))
+	}
+	slices.Sort(got)
+	want := []string{"match:0-3", "match:18-21", "other:10-13"}
+	if !slices.Equal(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if len(d.emptyDecos) != 0 {
+		t.Errorf("want the empty decoration removed, got %v", d.emptyDecos)
+	}
+}
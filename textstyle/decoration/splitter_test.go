@@ -7,7 +7,7 @@ import (
 
 	"gioui.org/layout"
 	"gioui.org/text"
-	"github.com/oligo/gvcode/internal/buffer"
+	"github.com/oligo/gvcode/buffer"
 	lt "github.com/oligo/gvcode/internal/layout"
 	"github.com/oligo/gvcode/internal/painter"
 	"golang.org/x/image/math/fixed"
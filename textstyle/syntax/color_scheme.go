@@ -1,7 +1,7 @@
 package syntax
 
 import (
-	"slices"
+	"errors"
 	"strings"
 
 	"github.com/oligo/gvcode/color"
@@ -67,6 +67,10 @@ const (
 	defaultScope = StyleScope("_default_")
 )
 
+// ErrTooManyScopes is returned when adding a style to a color scheme which
+// already has MaxTokenTypes style scopes.
+var ErrTooManyScopes = errors.New("syntax: too many style scopes in the color scheme")
+
 // ColorScheme defines the token types and their styles used for syntax highlighting.
 type ColorScheme struct {
 	// Name is the name of the color scheme.
@@ -75,6 +79,8 @@ type ColorScheme struct {
 	// scopes are registered style scopes for the color scheme.
 	// It can be mapped to captures for Tree-Sitter, and TokenType of Chroma.
 	scopes []StyleScope
+	// scopeIDs maps the scopes to their indices.
+	scopeIDs map[StyleScope]int
 
 	// styles maps style scope index to non-packed scope style.
 	styles map[int]*scopeStyleRaw
@@ -85,21 +91,38 @@ type scopeStyleRaw struct {
 	fg, bg    int
 }
 
-func (cs *ColorScheme) addScope(scope StyleScope) int {
+func (cs *ColorScheme) addScope(scope StyleScope) (int, error) {
 	if !scope.IsValid() {
 		panic("invalid style scope: " + scope)
 	}
 
-	if idx := slices.Index(cs.scopes, scope); idx >= 0 {
-		return idx
+	if idx := cs.scopeIndex(scope); idx >= 0 {
+		return idx, nil
+	}
+	if len(cs.scopes) >= MaxTokenTypes {
+		return -1, ErrTooManyScopes
 	}
 
+	if cs.scopeIDs == nil {
+		cs.scopeIDs = make(map[StyleScope]int)
+	}
 	cs.scopes = append(cs.scopes, scope)
-	return len(cs.scopes) - 1
+	cs.scopeIDs[scope] = len(cs.scopes) - 1
+	return len(cs.scopes) - 1, nil
+}
+
+// scopeIndex returns the index of scope, or -1 if it is not registered.
+func (cs *ColorScheme) scopeIndex(scope StyleScope) int {
+	// The scheme may be copied, sharing the map with the copy, so the index is
+	// checked against the scopes.
+	if idx, ok := cs.scopeIDs[scope]; ok && idx < len(cs.scopes) && cs.scopes[idx] == scope {
+		return idx
+	}
+	return -1
 }
 
 func (cs *ColorScheme) getTokenStyle(scope StyleScope) (*scopeStyleRaw, int) {
-	idx := slices.Index(cs.scopes, scope)
+	idx := cs.scopeIndex(scope)
 	if idx < 0 {
 		return nil, idx
 	}
@@ -111,18 +134,32 @@ func (cs *ColorScheme) getTokenStyle(scope StyleScope) (*scopeStyleRaw, int) {
 	}
 }
 
-func (cs *ColorScheme) AddStyle(scope StyleScope, textStyle TextStyle, fg, bg color.Color) {
-	if !slices.Contains(cs.scopes, defaultScope) {
-		cs.addStyle(defaultScope, 0, cs.Foreground, color.Color{})
+// AddStyle adds the style of scope, replacing its previous style. It returns
+// ErrTooManyScopes if the scheme has no room for the scope, or
+// color.ErrPaletteFull if the palette has no room for the colors.
+func (cs *ColorScheme) AddStyle(scope StyleScope, textStyle TextStyle, fg, bg color.Color) error {
+	if cs.scopeIndex(defaultScope) < 0 {
+		if err := cs.addStyle(defaultScope, 0, cs.Foreground, color.Color{}); err != nil {
+			return err
+		}
 	}
 
-	cs.addStyle(scope, textStyle, fg, bg)
+	return cs.addStyle(scope, textStyle, fg, bg)
 }
 
-func (cs *ColorScheme) addStyle(scope StyleScope, textStyle TextStyle, fg, bg color.Color) {
-	tokenTypeID := cs.addScope(scope)
-	fgID := cs.AddColor(fg)
-	bgID := cs.AddColor(bg)
+func (cs *ColorScheme) addStyle(scope StyleScope, textStyle TextStyle, fg, bg color.Color) error {
+	tokenTypeID, err := cs.addScope(scope)
+	if err != nil {
+		return err
+	}
+	fgID, err := cs.AddColor(fg)
+	if err != nil {
+		return err
+	}
+	bgID, err := cs.AddColor(bg)
+	if err != nil {
+		return err
+	}
 
 	if cs.styles == nil {
 		cs.styles = make(map[int]*scopeStyleRaw)
@@ -133,6 +170,7 @@ func (cs *ColorScheme) addStyle(scope StyleScope, textStyle TextStyle, fg, bg co
 		fg:        fgID,
 		bg:        bgID,
 	}
+	return nil
 }
 
 func (cs *ColorScheme) GetStyleByID(scopeID int) StyleMeta {
@@ -146,34 +184,39 @@ func (cs *ColorScheme) GetStyleByID(scopeID int) StyleMeta {
 
 // GetTokenStyle finds a proper StyleMeta for the requested scope.
 // When the scope has no registered style, search upwards using
-// the parent scope. If everything has tried but still failed, it
+// the parent scope. The scope can also be a TextMate scope path, which lists
+// the scopes from the outermost to the innermost separated by spaces, e.g.,
+// 'source.go string.quoted.double.go', in which case the innermost scope
+// with a style wins. If everything has tried but still failed, it
 // returns an empty style.
 func (cs *ColorScheme) GetTokenStyle(scope StyleScope) StyleMeta {
-	var style *scopeStyleRaw
-	var scopeID int = -1
-	for {
-		if !scope.IsValid() {
-			break
-		}
-
-		style, scopeID = cs.getTokenStyle(scope)
-		if scopeID < 0 {
-			scope = scope.Parent()
-			continue
-		}
-		if style != nil {
-			break
+	path := string(scope)
+	for path != "" {
+		idx := strings.LastIndexByte(path, ' ')
+		if style, scopeID := cs.findStyle(StyleScope(path[idx+1:])); style != nil {
+			return packTokenStyle(scopeID, style.fg, style.bg, style.textStyle)
 		}
+		path = path[:max(idx, 0)]
 	}
 
-	if scopeID < 0 || style == nil {
-		style, scopeID := cs.getTokenStyle(defaultScope)
-		return packTokenStyle(scopeID, style.fg, style.bg, style.textStyle)
+	style, scopeID := cs.getTokenStyle(defaultScope)
+	if style == nil {
+		return StyleMeta(0)
 	}
-
 	return packTokenStyle(scopeID, style.fg, style.bg, style.textStyle)
 }
 
+// findStyle finds the style of scope, or of its nearest parent with a style.
+func (cs *ColorScheme) findStyle(scope StyleScope) (*scopeStyleRaw, int) {
+	for scope.IsValid() {
+		if style, scopeID := cs.getTokenStyle(scope); style != nil {
+			return style, scopeID
+		}
+		scope = scope.Parent()
+	}
+	return nil, -1
+}
+
 // Scopes returns all the registered style scopes.
 func (cs *ColorScheme) Scopes() []StyleScope {
 	return cs.scopes
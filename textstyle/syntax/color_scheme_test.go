@@ -2,6 +2,7 @@ package syntax
 
 import (
 	"fmt"
+	stdcolor "image/color"
 	"testing"
 
 	"github.com/oligo/gvcode/color"
@@ -78,6 +79,18 @@ func TestGetTokenStyle(t *testing.T) {
 			value:    "keyword.controlx",
 			expected: false,
 		},
+		{
+			value:    "source.go keyword.control.if",
+			expected: true,
+		},
+		{
+			value:    "source.go keyword.control.if meta.block",
+			expected: true,
+		},
+		{
+			value:    "source.go keyword",
+			expected: false,
+		},
 	}
 
 	for idx, c := range cases {
@@ -90,3 +103,50 @@ func TestGetTokenStyle(t *testing.T) {
 		})
 	}
 }
+
+func TestManyScopesAndColors(t *testing.T) {
+	scheme := &ColorScheme{}
+	const n = 1000
+	for i := 0; i < n; i++ {
+		fg := color.MakeColor(stdcolor.NRGBA{R: uint8(i), G: uint8(i >> 8), A: 0xff})
+		bg := color.MakeColor(stdcolor.NRGBA{B: uint8(i), G: uint8(i >> 8), A: 0xff})
+		if err := scheme.AddStyle(StyleScope(fmt.Sprintf("scope%d", i)), Bold|Strikethrough, fg, bg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		style := scheme.GetTokenStyle(StyleScope(fmt.Sprintf("scope%d.child", i)))
+		if got := scheme.Scopes()[style.TokenType()]; got != StyleScope(fmt.Sprintf("scope%d", i)) {
+			t.Fatalf("scope%d: got token type of %s", i, got)
+		}
+		fg := scheme.GetColor(style.Foreground()).NRGBA()
+		bg := scheme.GetColor(style.Background()).NRGBA()
+		if fg.R != uint8(i) || fg.G != uint8(i>>8) || bg.B != uint8(i) || bg.G != uint8(i>>8) {
+			t.Fatalf("scope%d: got colors %v and %v", i, fg, bg)
+		}
+		if style.TextStyle() != Bold|Strikethrough {
+			t.Fatalf("scope%d: got text style %v", i, style.TextStyle())
+		}
+	}
+}
+
+func TestTooManyScopes(t *testing.T) {
+	scheme := &ColorScheme{}
+	// The default scope takes a slot.
+	for i := 0; i < MaxTokenTypes-1; i++ {
+		if err := scheme.AddStyle(StyleScope(fmt.Sprintf("scope%d", i)), 0, color.Color{}, color.Color{}); err != nil {
+			t.Fatalf("scope%d: %v", i, err)
+		}
+	}
+
+	if err := scheme.AddStyle("scope0", Bold, color.Color{}, color.Color{}); err != nil {
+		t.Errorf("want a registered scope updated, got %v", err)
+	}
+	if err := scheme.AddStyle("overflow", 0, color.Color{}, color.Color{}); err != ErrTooManyScopes {
+		t.Errorf("want ErrTooManyScopes, got %v", err)
+	}
+	if style := scheme.GetTokenStyle("overflow"); style.TokenType() != 0 {
+		t.Errorf("want the default style for the overflowing scope, got %v", style)
+	}
+}
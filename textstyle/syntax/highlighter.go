@@ -0,0 +1,162 @@
+package syntax
+
+import (
+	"slices"
+	"time"
+
+	"github.com/oligo/gvcode/buffer"
+)
+
+// lineState is the state of a line tracked by the Highlighter.
+type lineState struct {
+	// end is the state of the tokenizer at the end of the line, or nil if the
+	// line is never tokenized.
+	end State
+	// dirty is set if the line needs to be tokenized again.
+	dirty bool
+}
+
+// Highlighter drives a Tokenizer to tokenize a document incrementally. It
+// caches the state at the end of every line, so that after an edit only the
+// lines from the first changed one are tokenized again, until the state at
+// the end of a line is the same as before. The work is done by Update in
+// bounded time slices, so that highlighting a large document does not block
+// the UI.
+type Highlighter struct {
+	tokenizer Tokenizer
+	lines     []lineState
+	// next is the first line that may be dirty.
+	next int
+	// tokens are the text tokens updated by the last Update.
+	tokens *TextTokens
+	// buf holds the text of the line being tokenized.
+	buf []byte
+	// batch holds the tokens of the consecutive lines tokenized, which replace
+	// the tokens in [batchStart, batchEnd) at once.
+	batch                []Token
+	batchStart, batchEnd int
+}
+
+// NewHighlighter creates a Highlighter driving tokenizer.
+func NewHighlighter(tokenizer Tokenizer) *Highlighter {
+	return &Highlighter{tokenizer: tokenizer}
+}
+
+// Invalidate marks all the lines to be tokenized again.
+func (h *Highlighter) Invalidate() {
+	for i := range h.lines {
+		h.lines[i].dirty = true
+	}
+	h.next = 0
+}
+
+// Edit updates the lines after the text is changed by c, which are tokenized
+// again by the next Update. The tokens are expected to be adjusted to the
+// change by TextTokens.Edit, so that they stay in place until then.
+func (h *Highlighter) Edit(c buffer.TextChange) {
+	start := c.StartPos.Line
+	h.next = min(h.next, start)
+	if start >= len(h.lines) {
+		// The new lines are found by Update.
+		return
+	}
+
+	// The lines from start to the old end are replaced by the lines from start
+	// to the new end. The new end line keeps the state of the old end line,
+	// as they end the same, so the lines after it are not tokenized again
+	// unless its state is changed.
+	oldEnd := min(c.OldEndPos.Line, len(h.lines)-1)
+	lines := make([]lineState, c.NewEndPos.Line-start+1)
+	lines[len(lines)-1] = h.lines[oldEnd]
+	for i := range lines {
+		lines[i].dirty = true
+	}
+	h.lines = slices.Replace(h.lines, start, oldEnd+1, lines...)
+}
+
+// Update tokenizes the dirty lines of src, and replaces their tokens in tokens.
+// It returns whether all the lines are tokenized, or false if it stops at the
+// deadline, in which case the next call goes on from there. At least one line
+// is tokenized by every call. If tokens are not the ones last updated, like
+// after the color scheme is changed, all the lines are tokenized again.
+func (h *Highlighter) Update(src buffer.TextSource, tokens *TextTokens, deadline time.Time) bool {
+	if tokens != h.tokens {
+		h.tokens = tokens
+		h.Invalidate()
+	}
+
+	lines := src.Lines()
+	if len(h.lines) > lines {
+		h.lines = h.lines[:lines]
+	}
+	for len(h.lines) < lines {
+		h.next = min(h.next, len(h.lines))
+		h.lines = append(h.lines, lineState{dirty: true})
+	}
+
+	defer h.flush()
+	tokenized := 0
+	for i := h.next; i < lines; i++ {
+		if !h.lines[i].dirty {
+			continue
+		}
+		if tokenized > 0 && time.Now().After(deadline) {
+			h.next = i
+			return false
+		}
+		h.tokenizeLine(src, i)
+		tokenized++
+	}
+
+	h.next = lines
+	return true
+}
+
+// tokenizeLine tokenizes the line from the state at the end of the previous
+// line, and marks the next line dirty if the state at the end of the line is
+// changed.
+func (h *Highlighter) tokenizeLine(src buffer.TextSource, line int) {
+	start := src.LineStart(line)
+	end := start + src.LineLength(line)
+	byteStart, byteEnd := src.RuneOffset(start), src.RuneOffset(end)
+	h.buf = slices.Grow(h.buf[:0], byteEnd-byteStart)[:byteEnd-byteStart]
+	n, _ := src.ReadAt(h.buf, int64(byteStart))
+
+	state := h.tokenizer.InitialState()
+	if line > 0 && h.lines[line-1].end != nil {
+		state = h.lines[line-1].end
+	}
+	tokens, state := h.tokenizer.Tokenize(string(h.buf[:n]), state)
+
+	ls := &h.lines[line]
+	changed := ls.end == nil || !ls.end.Equal(state)
+	ls.end = state
+	ls.dirty = false
+	if changed && line+1 < len(h.lines) {
+		h.lines[line+1].dirty = true
+	}
+
+	if h.batchStart < h.batchEnd && h.batchEnd != start {
+		h.flush()
+	}
+	if h.batchStart == h.batchEnd {
+		h.batchStart = start
+	}
+	for _, token := range tokens {
+		token.Start = min(start+token.Start, end)
+		token.End = min(start+token.End, end)
+		if token.Start < token.End {
+			h.batch = append(h.batch, token)
+		}
+	}
+	h.batchEnd = end
+}
+
+// flush replaces the tokens of the lines in the batch.
+func (h *Highlighter) flush() {
+	if h.batchStart < h.batchEnd {
+		h.tokens.replace(h.batchStart, h.batchEnd, h.batch...)
+	}
+	h.batch = h.batch[:0]
+	h.batchStart, h.batchEnd = 0, 0
+}
@@ -0,0 +1,141 @@
+package syntax
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/oligo/gvcode/buffer"
+	"github.com/oligo/gvcode/color"
+)
+
+// commentState tells whether a line ends in a block comment.
+type commentState bool
+
+func (s commentState) Equal(other State) bool {
+	return s == other
+}
+
+// commentTokenizer tokenizes the block comments, and counts the lines
+// tokenized.
+type commentTokenizer struct {
+	lines int
+}
+
+func (t *commentTokenizer) InitialState() State {
+	return commentState(false)
+}
+
+func (t *commentTokenizer) Tokenize(line string, state State) ([]Token, State) {
+	t.lines++
+	var tokens []Token
+	inComment := bool(state.(commentState))
+	start := 0
+	for off, runeOff := 0, 0; off < len(line); runeOff++ {
+		switch {
+		case !inComment && strings.HasPrefix(line[off:], "/*"):
+			inComment, start = true, runeOff
+			off, runeOff = off+2, runeOff+1
+			continue
+		case inComment && strings.HasPrefix(line[off:], "*/"):
+			inComment = false
+			tokens = append(tokens, Token{Scope: "comment", Start: start, End: runeOff + 2})
+			off, runeOff = off+2, runeOff+1
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(line[off:])
+		off += size
+	}
+	if inComment {
+		tokens = append(tokens, Token{Scope: "comment", Start: start, End: utf8.RuneCountInString(line)})
+	}
+	return tokens, commentState(inComment)
+}
+
+func TestHighlighter(t *testing.T) {
+	scheme := &ColorScheme{}
+	scheme.AddStyle("comment", Italic, color.Color{}, color.Color{})
+
+	// highlight tokenizes src from scratch.
+	highlight := func(src buffer.TextSource) []TokenStyle {
+		tokens := NewTextTokens(scheme)
+		NewHighlighter(&commentTokenizer{}).Update(src, tokens, time.Now().Add(time.Hour))
+		return tokens.QueryRange(0, src.Len())
+	}
+	equal := func(a, b []TokenStyle) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	var sb strings.Builder
+	for i := 0; i < 100; i++ {
+		sb.WriteString("code /* comment */ code\n")
+	}
+	src := buffer.NewTextSource()
+	src.SetText([]byte(sb.String()))
+
+	tokenizer := &commentTokenizer{}
+	h := NewHighlighter(tokenizer)
+	tokens := NewTextTokens(scheme)
+	src.Subscribe(func(c buffer.TextChange) {
+		tokens.Edit(c.Start, c.Start+c.RemovedRunes, c.Start+utf8.RuneCountInString(c.Text))
+		h.Edit(c)
+	})
+	if !h.Update(src, tokens, time.Now().Add(time.Hour)) {
+		t.Fatal("want all the lines tokenized")
+	}
+	if len(tokens.tokens) != 100 {
+		t.Fatalf("want 100 tokens, got %d", len(tokens.tokens))
+	}
+
+	edits := []struct {
+		name       string
+		start, end int
+		text       string
+		// maxLines is the maximum number of lines tokenized again.
+		maxLines int
+	}{
+		{name: "edit in a line", start: 2, end: 3, text: "DE", maxLines: 1},
+		{name: "open a comment", start: 25 * 10, end: 25 * 10, text: "/*", maxLines: 100},
+		{name: "close the comment", start: 25 * 20, end: 25 * 20, text: "*/", maxLines: 100},
+		{name: "insert lines", start: 25 * 30, end: 25 * 30, text: "a\nb\nc\n", maxLines: 4},
+		{name: "remove lines", start: 25 * 40, end: 25 * 50, text: "", maxLines: 1},
+		{name: "remove an open comment", start: 25*10 - 2, end: 25*10 + 4, text: "", maxLines: 100},
+	}
+
+	for _, edit := range edits {
+		tokenizer.lines = 0
+		src.Replace(edit.start, edit.end, edit.text)
+		if !h.Update(src, tokens, time.Now().Add(time.Hour)) {
+			t.Fatalf("%s: want all the lines tokenized", edit.name)
+		}
+		if !equal(tokens.QueryRange(0, src.Len()), highlight(src)) {
+			t.Errorf("%s: the tokens differ from tokenizing the text from scratch", edit.name)
+		}
+		if tokenizer.lines == 0 || tokenizer.lines > edit.maxLines {
+			t.Errorf("%s: want at most %d lines tokenized, got %d", edit.name, edit.maxLines, tokenizer.lines)
+		}
+	}
+
+	// A deadline passed stops the tokenization after a line.
+	h.Invalidate()
+	tokenizer.lines = 0
+	calls := 0
+	for !h.Update(src, tokens, time.Now().Add(-time.Second)) {
+		calls++
+	}
+	if lines := src.Lines(); calls+1 != lines || tokenizer.lines != lines {
+		t.Errorf("want %d lines tokenized in %d calls, got %d lines in %d calls", lines, lines, tokenizer.lines, calls+1)
+	}
+	if !equal(tokens.QueryRange(0, src.Len()), highlight(src)) {
+		t.Error("the tokens differ from tokenizing the text from scratch")
+	}
+}
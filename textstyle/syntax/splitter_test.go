@@ -7,8 +7,8 @@ import (
 
 	"gioui.org/layout"
 	"gioui.org/text"
+	"github.com/oligo/gvcode/buffer"
 	"github.com/oligo/gvcode/color"
-	"github.com/oligo/gvcode/internal/buffer"
 	lt "github.com/oligo/gvcode/internal/layout"
 	"github.com/oligo/gvcode/internal/painter"
 
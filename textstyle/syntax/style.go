@@ -2,6 +2,8 @@ package syntax
 
 import (
 	"fmt"
+
+	"github.com/oligo/gvcode/color"
 )
 
 type TextStyle uint8
@@ -21,61 +23,65 @@ func (s TextStyle) HasStyle(mask TextStyle) bool {
 
 const (
 	textStyleOffset  = 0
-	backgroundOffset = 6
-	foregroundOffset = 14
-	tokenTypeOffset  = 22
-
-	tokenTypeMask  = 0b00011111_11000000_00000000_00000000
-	foregroundMask = 0b00000000_00011111_11000000_00000000
-	backgroundMask = 0b00000000_00000000_00111111_11000000
-	textStyleMask  = 0b00000000_00000000_00000000_00111111
+	backgroundOffset = 8
+	foregroundOffset = 28
+	tokenTypeOffset  = 48
+
+	textStyleMask = 1<<8 - 1
+	colorIDMask   = color.MaxColors - 1
+	tokenTypeMask = 1<<16 - 1
 )
 
+// MaxTokenTypes is the number of style scopes a color scheme can have.
+const MaxTokenTypes = tokenTypeMask + 1
+
 // StyleMeta applies a bit packed binary format to encode tokens from
 // syntax lexer, to be used as styles for text rendering. This is like
 // TokenMetadata in Monaco/vscode.
 //
-// It uses 4 bytes to hold the metadata, and the layout is as follows:
-// Bits:  31   ...   0
-// [3][7][8][8][6] = 32
-// |  |  |  |  |
-// |  |  |  |  └── Text style flags (6bits, bold, italic, underline, Squiggle, strikethrough, border)
-// |  |  |  └───── Background color ID (8bits, 0–255)
-// |  |  └──────── Foreground color ID (8bits, 0–255)
-// |  └─────────── Token type (7bits, 0–127)
-// └────────────── reserved bits (3bits)
+// It uses 8 bytes to hold the metadata, and the layout is as follows:
+// Bits:  63   ...   0
+// [16][20][20][8] = 64
+// |   |   |   |
+// |   |   |   └── Text style flags (8bits, bold, italic, underline, Squiggle, strikethrough, border)
+// |   |   └────── Background color ID (20bits, 0–1048575)
+// |   └────────── Foreground color ID (20bits, 0–1048575)
+// └────────────── Token type (16bits, 0–65535)
 //
-// The color IDs are mapped to indices of color palette.
-type StyleMeta uint32
+// The color IDs are mapped to indices of color palette, whose capacity
+// matches the color ID bits.
+type StyleMeta uint64
 
 func (t StyleMeta) TokenType() int {
-	return int(t & tokenTypeMask >> tokenTypeOffset)
+	return int(t >> tokenTypeOffset & tokenTypeMask)
 }
 
 func (t StyleMeta) Foreground() int {
-	return int(t & foregroundMask >> foregroundOffset)
+	return int(t >> foregroundOffset & colorIDMask)
 }
 
 func (t StyleMeta) Background() int {
-	return int(t & backgroundMask >> backgroundOffset)
+	return int(t >> backgroundOffset & colorIDMask)
 }
 
 func (t StyleMeta) TextStyle() TextStyle {
-	return TextStyle(t & textStyleMask >> textStyleOffset)
+	return TextStyle(t >> textStyleOffset & textStyleMask)
 }
 
 func (t StyleMeta) String() string {
-	return fmt.Sprintf("Type=%d FG=%d BG=%d Style=%04b",
+	return fmt.Sprintf("Type=%d FG=%d BG=%d Style=%06b",
 		t.TokenType(), t.Foreground(), t.Background(), t.TextStyle())
 }
 
+// packTokenStyle packs the style. The IDs are bounded by the color scheme, so
+// they fit in their bits.
 func packTokenStyle(tokenType int, fg, bg int, textStyles TextStyle) StyleMeta {
 	s := StyleMeta(0)
 
-	s |= StyleMeta(tokenType << tokenTypeOffset)
-	s |= StyleMeta(fg << foregroundOffset)
-	s |= StyleMeta(bg << backgroundOffset)
-	s |= StyleMeta(textStyles << textStyleOffset)
+	s |= StyleMeta(tokenType&tokenTypeMask) << tokenTypeOffset
+	s |= StyleMeta(fg&colorIDMask) << foregroundOffset
+	s |= StyleMeta(bg&colorIDMask) << backgroundOffset
+	s |= StyleMeta(textStyles) << textStyleOffset
 	return s
 }
 
@@ -1,6 +1,7 @@
 package syntax
 
 import (
+	"slices"
 	"sort"
 
 	"github.com/oligo/gvcode/color"
@@ -16,9 +17,17 @@ type Token struct {
 }
 
 type TextTokens struct {
-	tokens      []TokenStyle
+	tokens []TokenStyle
+	// The offsets of the tokens from gap on are yet to be shifted by delta.
+	// The edits are usually made near each other, so shifting the tokens
+	// lazily only moves the tokens between the edits.
+	gap, delta  int
 	colorScheme *ColorScheme
 	splitter    lineSplitter
+	// scratch holds the tokens changed by an edit.
+	scratch []TokenStyle
+	// styles caches the styles of the scopes looked up.
+	styles map[StyleScope]StyleMeta
 }
 
 func NewTextTokens(scheme *ColorScheme) *TextTokens {
@@ -30,6 +39,7 @@ func NewTextTokens(scheme *ColorScheme) *TextTokens {
 // Clear the tokens for reuse.
 func (t *TextTokens) Clear() {
 	t.tokens = t.tokens[:0]
+	t.gap, t.delta = 0, 0
 }
 
 // Set adds all the tokens, replacing the existing ones.
@@ -41,8 +51,115 @@ func (t *TextTokens) Set(tokens ...Token) {
 	}
 }
 
+// Edit adjusts the tokens after the text in [start, oldEnd) is replaced by the
+// text now in [start, newEnd), so that they stay on the same text until they
+// are set again. The tokens after the change are shifted, and the ones
+// overlapping the removed text are trimmed. Text inserted inside a token
+// extends it, unless it replaces some text of the token, in which case the
+// token is split around the new text.
+func (t *TextTokens) Edit(start, oldEnd, newEnd int) {
+	delta := newEnd - oldEnd
+	i := t.search(func(token TokenStyle) bool { return token.End > start })
+	j := max(i, t.search(func(token TokenStyle) bool { return token.Start >= oldEnd }))
+	t.moveGap(j)
+
+	t.scratch = t.scratch[:0]
+	for _, token := range t.tokens[i:j] {
+		head := TokenStyle{Start: token.Start, End: start, Style: token.Style}
+		tail := TokenStyle{Start: newEnd, End: token.End + delta, Style: token.Style}
+		switch {
+		case token.Start < start && token.End > oldEnd && (start == oldEnd || start == newEnd):
+			// Text is inserted or removed inside the token.
+			t.scratch = append(t.scratch, TokenStyle{Start: token.Start, End: token.End + delta, Style: token.Style})
+			continue
+		case token.Start < start && token.End > oldEnd:
+			t.scratch = append(t.scratch, head, tail)
+			continue
+		case token.Start < start:
+			t.scratch = append(t.scratch, head)
+		case token.End > oldEnd:
+			t.scratch = append(t.scratch, tail)
+		}
+	}
+
+	t.delta += delta
+	t.splice(i, j)
+}
+
+// at returns the token at index i with its offsets shifted.
+func (t *TextTokens) at(i int) TokenStyle {
+	token := t.tokens[i]
+	if i >= t.gap {
+		token.Start += t.delta
+		token.End += t.delta
+	}
+	return token
+}
+
+// search returns the index of the first token for which f is true, which must
+// be false for the tokens before it.
+func (t *TextTokens) search(f func(token TokenStyle) bool) int {
+	return sort.Search(len(t.tokens), func(i int) bool { return f(t.at(i)) })
+}
+
+// moveGap shifts the tokens between the gap and idx, and moves the gap to
+// idx.
+func (t *TextTokens) moveGap(idx int) {
+	for ; t.gap < idx; t.gap++ {
+		t.tokens[t.gap].Start += t.delta
+		t.tokens[t.gap].End += t.delta
+	}
+	for ; t.gap > idx; t.gap-- {
+		t.tokens[t.gap-1].Start -= t.delta
+		t.tokens[t.gap-1].End -= t.delta
+	}
+	if t.gap == len(t.tokens) {
+		t.delta = 0
+	}
+}
+
+// splice replaces the tokens in [i, j), which must be before the gap, with the
+// scratch tokens, and moves the gap after them.
+func (t *TextTokens) splice(i, j int) {
+	t.tokens = slices.Replace(t.tokens, i, j, t.scratch...)
+	t.gap = i + len(t.scratch)
+	if t.gap == len(t.tokens) {
+		t.delta = 0
+	}
+}
+
+// replace replaces the tokens overlapping the rune range [start, end) with
+// tokens, which must be sorted and in the range.
+func (t *TextTokens) replace(start, end int, tokens ...Token) {
+	i := t.search(func(token TokenStyle) bool { return token.End > start })
+	j := max(i, t.search(func(token TokenStyle) bool { return token.Start >= end }))
+	t.moveGap(j)
+
+	t.scratch = t.scratch[:0]
+	for _, token := range tokens {
+		if style := t.style(token.Scope); style != 0 {
+			t.scratch = append(t.scratch, TokenStyle{Start: token.Start, End: token.End, Style: style})
+		}
+	}
+	t.splice(i, j)
+}
+
+// style returns the style of scope, which is looked up in the color scheme
+// once, as the tokens of a tokenizer share a few scope paths.
+func (t *TextTokens) style(scope StyleScope) StyleMeta {
+	style, ok := t.styles[scope]
+	if !ok {
+		if t.styles == nil {
+			t.styles = make(map[StyleScope]StyleMeta)
+		}
+		style = t.colorScheme.GetTokenStyle(scope)
+		t.styles[scope] = style
+	}
+	return style
+}
+
 func (t *TextTokens) add(scope StyleScope, start, end int) {
-	style := t.colorScheme.GetTokenStyle(scope)
+	style := t.style(scope)
 	if style == 0 {
 		return
 	}
@@ -68,8 +185,8 @@ func (t *TextTokens) QueryRange(start, end int) []TokenStyle {
 
 	// Find the index of the first token whose End is greater than start.
 	// Tokens before this index cannot overlap because they end too early.
-	firstIdx := sort.Search(len(t.tokens), func(i int) bool {
-		return t.tokens[i].End > start
+	firstIdx := t.search(func(token TokenStyle) bool {
+		return token.End > start
 	})
 
 	if firstIdx == len(t.tokens) {
@@ -79,7 +196,7 @@ func (t *TextTokens) QueryRange(start, end int) []TokenStyle {
 
 	var result []TokenStyle
 	for i := firstIdx; i < len(t.tokens); i++ {
-		token := t.tokens[i]
+		token := t.at(i)
 		if token.Start < end {
 			result = append(result, token)
 		} else {
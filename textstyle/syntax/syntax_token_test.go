@@ -0,0 +1,130 @@
+package syntax
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/oligo/gvcode/color"
+)
+
+func TestTextTokensEdit(t *testing.T) {
+	scheme := &ColorScheme{}
+	scheme.AddStyle("t1", Bold, color.Color{}, color.Color{})
+	scheme.AddStyle("t2", Italic, color.Color{}, color.Color{})
+	t1, t2 := scheme.GetTokenStyle("t1"), scheme.GetTokenStyle("t2")
+
+	// The tokens are [2, 5) and [8, 12).
+	initial := []Token{{Scope: "t1", Start: 2, End: 5}, {Scope: "t2", Start: 8, End: 12}}
+
+	testcases := []struct {
+		name                  string
+		start, oldEnd, newEnd int
+		want                  []TokenStyle
+	}{
+		{
+			name:  "insert before the tokens",
+			start: 0, oldEnd: 0, newEnd: 3,
+			want: []TokenStyle{{Start: 5, End: 8, Style: t1}, {Start: 11, End: 15, Style: t2}},
+		},
+		{
+			name:  "insert at the start of a token",
+			start: 8, oldEnd: 8, newEnd: 10,
+			want: []TokenStyle{{Start: 2, End: 5, Style: t1}, {Start: 10, End: 14, Style: t2}},
+		},
+		{
+			name:  "insert inside a token",
+			start: 3, oldEnd: 3, newEnd: 5,
+			want: []TokenStyle{{Start: 2, End: 7, Style: t1}, {Start: 10, End: 14, Style: t2}},
+		},
+		{
+			name:  "remove inside a token",
+			start: 9, oldEnd: 11, newEnd: 9,
+			want: []TokenStyle{{Start: 2, End: 5, Style: t1}, {Start: 8, End: 10, Style: t2}},
+		},
+		{
+			name:  "replace inside a token",
+			start: 3, oldEnd: 4, newEnd: 6,
+			want: []TokenStyle{{Start: 2, End: 3, Style: t1}, {Start: 6, End: 7, Style: t1}, {Start: 10, End: 14, Style: t2}},
+		},
+		{
+			name:  "remove across the tokens",
+			start: 4, oldEnd: 10, newEnd: 4,
+			want: []TokenStyle{{Start: 2, End: 4, Style: t1}, {Start: 4, End: 6, Style: t2}},
+		},
+		{
+			name:  "replace a whole token",
+			start: 1, oldEnd: 6, newEnd: 2,
+			want: []TokenStyle{{Start: 4, End: 8, Style: t2}},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens := NewTextTokens(scheme)
+			tokens.Set(initial...)
+			tokens.Edit(tc.start, tc.oldEnd, tc.newEnd)
+			if got := tokens.QueryRange(0, 100); !slices.Equal(got, tc.want) {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTextTokensEditSequence(t *testing.T) {
+	scheme := &ColorScheme{}
+	scheme.AddStyle("t1", Bold, color.Color{}, color.Color{})
+	style := scheme.GetTokenStyle("t1")
+
+	// edit adjusts the tokens eagerly, as a reference of the lazy shifting.
+	edit := func(tokens []TokenStyle, start, oldEnd, newEnd int) []TokenStyle {
+		delta := newEnd - oldEnd
+		var result []TokenStyle
+		for _, token := range tokens {
+			switch {
+			case token.End <= start:
+				result = append(result, token)
+			case token.Start >= oldEnd:
+				result = append(result, TokenStyle{Start: token.Start + delta, End: token.End + delta, Style: token.Style})
+			case token.Start < start && token.End > oldEnd && (start == oldEnd || start == newEnd):
+				result = append(result, TokenStyle{Start: token.Start, End: token.End + delta, Style: token.Style})
+			default:
+				if token.Start < start {
+					result = append(result, TokenStyle{Start: token.Start, End: start, Style: token.Style})
+				}
+				if token.End > oldEnd {
+					result = append(result, TokenStyle{Start: newEnd, End: token.End + delta, Style: token.Style})
+				}
+			}
+		}
+		return result
+	}
+
+	var initial []Token
+	var want []TokenStyle
+	for i := 0; i < 1000; i++ {
+		initial = append(initial, Token{Scope: "t1", Start: i * 10, End: i*10 + 5})
+		want = append(want, TokenStyle{Start: i * 10, End: i*10 + 5, Style: style})
+	}
+	tokens := NewTextTokens(scheme)
+	tokens.Set(initial...)
+
+	length := 10000
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		start := r.Intn(length)
+		oldEnd := min(start+r.Intn(20), length)
+		newEnd := start + r.Intn(20)
+		if i%2 == 0 {
+			// Typing near the last edit.
+			oldEnd, newEnd = start, start+1
+		}
+		length += newEnd - oldEnd
+
+		tokens.Edit(start, oldEnd, newEnd)
+		want = edit(want, start, oldEnd, newEnd)
+		if got := tokens.QueryRange(0, length); !slices.Equal(got, want) {
+			t.Fatalf("edit %d [%d, %d) -> [%d, %d): the tokens differ from the reference", i, start, oldEnd, start, newEnd)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// Package textmate implements the TextMate grammars, which tokenize the text
+// of most languages for syntax highlighting, like in VS Code. A Grammar
+// loaded from a .tmLanguage.json file is a syntax.Tokenizer, producing the
+// tokens with their full TextMate scope paths, such as
+// "source.go string.quoted.double.go", which are styled by the most specific
+// scopes of the color scheme.
+//
+// The patterns of the grammars use the syntax of Oniguruma, which is
+// translated to the one of regexp2. Injections are not supported.
+package textmate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+// flag is a boolean of a grammar, which is also written as 0 or 1.
+type flag bool
+
+func (f *flag) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	*f = flag(s != "false" && s != "0" && s != "null")
+	return nil
+}
+
+// rawRule is a rule of a grammar as it is written.
+type rawRule struct {
+	Include             string              `json:"include"`
+	Name                string              `json:"name"`
+	ContentName         string              `json:"contentName"`
+	Match               string              `json:"match"`
+	Begin               string              `json:"begin"`
+	End                 string              `json:"end"`
+	While               string              `json:"while"`
+	Captures            map[string]*rawRule `json:"captures"`
+	BeginCaptures       map[string]*rawRule `json:"beginCaptures"`
+	EndCaptures         map[string]*rawRule `json:"endCaptures"`
+	WhileCaptures       map[string]*rawRule `json:"whileCaptures"`
+	Patterns            []*rawRule          `json:"patterns"`
+	Repository          map[string]*rawRule `json:"repository"`
+	ApplyEndPatternLast flag                `json:"applyEndPatternLast"`
+	Disabled            flag                `json:"disabled"`
+}
+
+// rawGrammar is a grammar as it is written.
+type rawGrammar struct {
+	ScopeName  string              `json:"scopeName"`
+	Name       string              `json:"name"`
+	FileTypes  []string            `json:"fileTypes"`
+	Patterns   []*rawRule          `json:"patterns"`
+	Repository map[string]*rawRule `json:"repository"`
+	// root holds the patterns and the repository of the grammar as a rule.
+	root *rawRule
+}
+
+// repoScope is the repository of a rule, where the includes of the rule and
+// of its nested rules look up the rules by their names, then in the
+// repositories of the enclosing rules.
+type repoScope struct {
+	rules  map[string]*rawRule
+	parent *repoScope
+}
+
+// lookup returns the rule named name, and the scope where it is defined.
+func (s *repoScope) lookup(name string) (*rawRule, *repoScope) {
+	for ; s != nil; s = s.parent {
+		if r, ok := s.rules[name]; ok {
+			return r, s
+		}
+	}
+	return nil, nil
+}
+
+// rule is a compiled rule, which matches a single pattern, or a begin pattern
+// and an end or while pattern, or has patterns only.
+type rule struct {
+	name, contentName        string
+	match, begin, end, while *regex
+	// The captures are indexed by the group numbers.
+	captures, beginCaptures, endCaptures, whileCaptures []*capture
+	applyEndPatternLast                                 bool
+	// endBackRefs is set if the end or while pattern refers to the groups of
+	// the begin pattern.
+	endBackRefs bool
+
+	// patterns are resolved to the candidates when the rule is first used.
+	patterns []*rawRule
+	scope    *repoScope
+	self     *rawGrammar
+	// candidates are the match and begin rules included by the patterns.
+	candidates []*rule
+	resolved   bool
+}
+
+// capture is the scope given to a group of a match.
+type capture struct {
+	name, contentName string
+	// rule tokenizes the text of the group again with the patterns of the
+	// capture, or is nil.
+	rule *rule
+}
+
+// Grammar is a TextMate grammar, which tokenizes the text of a language as a
+// syntax.Tokenizer. A grammar can be used by several editors.
+type Grammar struct {
+	// ScopeName is the scope name of the language, such as "source.go".
+	ScopeName string
+	// Name is the name of the language.
+	Name string
+	// FileTypes are the file extensions of the language, without the dot.
+	FileTypes []string
+
+	registry *Registry
+	raw      *rawGrammar
+
+	// mu guards the rules, which are compiled as they are used, and the
+	// tokenization.
+	mu    sync.Mutex
+	rules map[*rawRule]*rule
+	// resolved caches the end and while patterns whose back references are
+	// resolved.
+	resolved map[string]*regex
+	// gen counts the lines tokenized.
+	gen uint64
+}
+
+// Registry holds the grammars by their scope names, so that the grammars can
+// include the rules of the grammars of the embedded languages, such as the
+// CSS and JavaScript in HTML. The grammars included should be loaded before
+// the text is tokenized.
+type Registry struct {
+	mu       sync.Mutex
+	grammars map[string]*Grammar
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{grammars: make(map[string]*Grammar)}
+}
+
+// LoadGrammar loads a grammar from a .tmLanguage.json file, which includes
+// no other grammar.
+func LoadGrammar(r io.Reader) (*Grammar, error) {
+	return NewRegistry().Load(r)
+}
+
+// Load loads a grammar from a .tmLanguage.json file, and adds it to the
+// registry, replacing the grammar of the same scope name.
+func (reg *Registry) Load(r io.Reader) (*Grammar, error) {
+	raw := &rawGrammar{}
+	if err := json.NewDecoder(r).Decode(raw); err != nil {
+		return nil, fmt.Errorf("textmate: invalid grammar: %w", err)
+	}
+	if raw.ScopeName == "" {
+		return nil, errors.New("textmate: grammar without scope name")
+	}
+	raw.root = &rawRule{Patterns: raw.Patterns, Repository: raw.Repository}
+
+	g := &Grammar{
+		ScopeName: raw.ScopeName,
+		Name:      raw.Name,
+		FileTypes: raw.FileTypes,
+		registry:  reg,
+		raw:       raw,
+		rules:     make(map[*rawRule]*rule),
+		resolved:  make(map[string]*regex),
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.grammars[g.ScopeName] = g
+	return g, nil
+}
+
+// Grammar returns the grammar of the scope name, or nil if it is not loaded.
+func (reg *Registry) Grammar(scopeName string) *Grammar {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.grammars[scopeName]
+}
+
+// rootRule returns the rule holding the patterns of the grammar.
+func (g *Grammar) rootRule() *rule {
+	return g.compile(g.raw.root, nil, g.raw)
+}
+
+// compile compiles raw, which is defined in scope of grammar self.
+func (g *Grammar) compile(raw *rawRule, scope *repoScope, self *rawGrammar) *rule {
+	if r, ok := g.rules[raw]; ok {
+		return r
+	}
+
+	if len(raw.Repository) > 0 {
+		scope = &repoScope{rules: raw.Repository, parent: scope}
+	}
+	r := &rule{
+		name:                raw.Name,
+		contentName:         raw.ContentName,
+		applyEndPatternLast: bool(raw.ApplyEndPatternLast),
+		patterns:            raw.Patterns,
+		scope:               scope,
+		self:                self,
+	}
+	g.rules[raw] = r
+
+	switch {
+	case raw.Match != "":
+		r.match = newRegex(raw.Match)
+		r.captures = g.compileCaptures(raw.Captures, scope, self)
+	case raw.Begin != "":
+		r.begin = newRegex(raw.Begin)
+		r.beginCaptures = g.compileCaptures(raw.BeginCaptures, scope, self)
+		if raw.BeginCaptures == nil {
+			r.beginCaptures = g.compileCaptures(raw.Captures, scope, self)
+		}
+		pattern, captures := raw.End, raw.EndCaptures
+		if raw.While != "" {
+			pattern, captures = raw.While, raw.WhileCaptures
+		}
+		if captures == nil {
+			captures = raw.Captures
+		}
+		if pattern == "" {
+			// A rule without end never ends.
+			pattern = `(?!)`
+		}
+		if raw.While != "" {
+			r.while = newRegex(pattern)
+			r.whileCaptures = g.compileCaptures(captures, scope, self)
+		} else {
+			r.end = newRegex(pattern)
+			r.endCaptures = g.compileCaptures(captures, scope, self)
+		}
+		r.endBackRefs = hasBackRefs(pattern)
+	}
+	return r
+}
+
+func (g *Grammar) compileCaptures(raw map[string]*rawRule, scope *repoScope, self *rawGrammar) []*capture {
+	var captures []*capture
+	for key, c := range raw {
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || c == nil {
+			continue
+		}
+		for len(captures) <= i {
+			captures = append(captures, nil)
+		}
+		captures[i] = &capture{name: c.Name, contentName: c.ContentName}
+		if len(c.Patterns) > 0 {
+			captures[i].rule = g.compile(c, scope, self)
+		}
+	}
+	return captures
+}
+
+// candidatesOf returns the match and begin rules tried in the text enclosed
+// by r, in the order of its patterns.
+func (g *Grammar) candidatesOf(r *rule) []*rule {
+	if !r.resolved {
+		r.resolved = true
+		r.candidates = g.collect(r, make(map[*rule]bool), nil)
+	}
+	return r.candidates
+}
+
+// collect appends the match and begin rules of the patterns of r to out.
+func (g *Grammar) collect(r *rule, visited map[*rule]bool, out []*rule) []*rule {
+	if visited[r] {
+		return out
+	}
+	visited[r] = true
+
+	for _, raw := range r.patterns {
+		if raw == nil || raw.Disabled {
+			continue
+		}
+		target := g.include(raw, r.scope, r.self)
+		switch {
+		case target == nil:
+		case target.match != nil || target.begin != nil:
+			if !visited[target] {
+				visited[target] = true
+				out = append(out, target)
+			}
+		default:
+			out = g.collect(target, visited, out)
+		}
+	}
+	return out
+}
+
+// include returns the rule of the pattern raw in scope of grammar self,
+// resolving the included rule, or nil if it is not found.
+func (g *Grammar) include(raw *rawRule, scope *repoScope, self *rawGrammar) *rule {
+	ref := raw.Include
+	switch {
+	case ref == "":
+		return g.compile(raw, scope, self)
+	case ref == "$self":
+		return g.compile(self.root, nil, self)
+	case ref == "$base":
+		return g.rootRule()
+	case strings.HasPrefix(ref, "#"):
+		target, defined := scope.lookup(ref[1:])
+		if target == nil {
+			return nil
+		}
+		return g.compile(target, defined, self)
+	}
+
+	// A reference to another grammar, or to a rule of its repository.
+	scopeName, name, _ := strings.Cut(ref, "#")
+	other := g.raw
+	if scopeName != g.ScopeName {
+		if og := g.registry.Grammar(scopeName); og != nil {
+			other = og.raw
+		} else {
+			return nil
+		}
+	}
+	if name == "" {
+		return g.compile(other.root, nil, other)
+	}
+	otherScope := &repoScope{rules: other.Repository}
+	target, defined := otherScope.lookup(name)
+	if target == nil {
+		return nil
+	}
+	return g.compile(target, defined, other)
+}
+
+// hasBackRefs reports whether pattern refers to the groups of another pattern.
+func hasBackRefs(pattern string) bool {
+	for i := 0; i < len(pattern)-1; i++ {
+		if pattern[i] == '\\' {
+			if c := pattern[i+1]; c >= '0' && c <= '9' {
+				return true
+			}
+			i++
+		}
+	}
+	return false
+}
+
+// resolveBackRefs returns the pattern re whose back references are replaced
+// by the text of the groups of m.
+func (g *Grammar) resolveBackRefs(re *regex, groups func(i int) (string, bool)) *regex {
+	var sb strings.Builder
+	src := re.source
+	for i := 0; i < len(src); i++ {
+		if src[i] != '\\' || i+1 == len(src) {
+			sb.WriteByte(src[i])
+			continue
+		}
+		j := i + 1
+		for j < len(src) && src[j] >= '0' && src[j] <= '9' {
+			j++
+		}
+		if j == i+1 {
+			sb.WriteString(src[i : i+2])
+			i++
+			continue
+		}
+		n, _ := strconv.Atoi(src[i+1 : j])
+		text, _ := groups(n)
+		sb.WriteString(escapeText(text))
+		i = j - 1
+	}
+
+	source := sb.String()
+	if r, ok := g.resolved[source]; ok {
+		return r
+	}
+	if len(g.resolved) >= maxResolved {
+		clear(g.resolved)
+	}
+	r := newRegex(source)
+	g.resolved[source] = r
+	return r
+}
+
+// maxResolved is the number of patterns with resolved back references cached.
+const maxResolved = 1024
+
+var _ syntax.Tokenizer = (*Grammar)(nil)
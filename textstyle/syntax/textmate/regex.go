@@ -0,0 +1,460 @@
+package textmate
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dlclark/regexp2"
+)
+
+// matchTimeout stops a pattern backtracking catastrophically, which is then
+// taken as not matched.
+const matchTimeout = 100 * time.Millisecond
+
+// regex is a pattern of a grammar, which is compiled on its first use. The
+// patterns using \A or \G are compiled in up to four variants, as TextMate
+// only lets \A match in the first line, and \G match at the end of the last
+// begin or while match. Each variant is compiled once, so that the pattern
+// can be used by the tokenizers running concurrently.
+type regex struct {
+	source     string
+	hasA, hasG bool
+	variants   [4]regexVariant
+}
+
+// regexVariant is a variant of a pattern, compiled once. re is nil if the
+// pattern is invalid.
+type regexVariant struct {
+	once sync.Once
+	re   *regexp2.Regexp
+}
+
+func newRegex(source string) *regex {
+	r := &regex{source: source}
+	for i := 0; i < len(source)-1; i++ {
+		if source[i] != '\\' {
+			continue
+		}
+		i++
+		switch source[i] {
+		case 'A':
+			r.hasA = true
+		case 'G':
+			r.hasG = true
+		}
+	}
+	return r
+}
+
+// get returns the compiled pattern, or nil if it is invalid, in which case it
+// never matches. allowA and allowG tell whether \A and \G can match.
+func (r *regex) get(allowA, allowG bool) *regexp2.Regexp {
+	allowA = allowA && r.hasA
+	allowG = allowG && r.hasG
+	k := 0
+	if allowA {
+		k |= 1
+	}
+	if allowG {
+		k |= 2
+	}
+
+	v := &r.variants[k]
+	v.once.Do(func() {
+		re, err := regexp2.Compile(translate(r.source, allowA, allowG), regexp2.None)
+		if err != nil {
+			return
+		}
+		re.MatchTimeout = matchTimeout
+		v.re = re
+	})
+	return v.re
+}
+
+// find returns the first match of the pattern in text from pos, or nil.
+func find(re *regexp2.Regexp, text []rune, pos int) *regexp2.Match {
+	if re == nil || pos > len(text) {
+		return nil
+	}
+	m, err := re.FindRunesMatchStartingAt(text, pos)
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+// posixClasses maps the names of the POSIX bracket expressions and of the
+// properties of Oniguruma to the character classes of regexp2, in and out of
+// a bracket expression, and negated.
+var posixClasses = map[string][2]string{
+	"alnum":  {`\p{L}\p{M}\p{Nd}`, `[^\p{L}\p{M}\p{Nd}]`},
+	"alpha":  {`\p{L}\p{M}`, `[^\p{L}\p{M}]`},
+	"ascii":  {`\x00-\x7F`, `[^\x00-\x7F]`},
+	"blank":  {` \t`, `[^ \t]`},
+	"cntrl":  {`\p{Cc}`, `\P{Cc}`},
+	"digit":  {`\p{Nd}`, `\P{Nd}`},
+	"graph":  {`^\s\p{C}`, `[\s\p{C}]`},
+	"lower":  {`\p{Ll}`, `\P{Ll}`},
+	"print":  {`\P{C}`, `\p{C}`},
+	"punct":  {`\p{P}`, `\P{P}`},
+	"space":  {`\s`, `\S`},
+	"upper":  {`\p{Lu}`, `\P{Lu}`},
+	"word":   {`\w`, `\W`},
+	"xdigit": {`0-9A-Fa-f`, `[^0-9A-Fa-f]`},
+}
+
+// translator converts a pattern from the syntax of Oniguruma, the regular
+// expression engine of TextMate, to the syntax of regexp2.
+type translator struct {
+	src            []rune
+	out            []byte
+	allowA, allowG bool
+	// extended is set in the free-spacing mode, where the white spaces are
+	// ignored and # starts a comment.
+	extended bool
+	// groups are the offsets in out of the open groups.
+	groups []int
+	// atom is the offset in out of the last atom, or -1.
+	atom int
+	// captures is the number of capture groups so far, and names maps the
+	// names of the named groups to their numbers.
+	captures int
+	names    map[string]int
+}
+
+// translate converts pattern from the syntax of Oniguruma to the syntax of
+// regexp2. The named groups are converted to numbered ones, as Oniguruma
+// numbers all the groups from left to right while regexp2 numbers the named
+// groups last. The possessive quantifiers are converted to atomic groups,
+// and the POSIX bracket expressions to Unicode categories. \A and \G are
+// replaced by a pattern never matching unless allowed.
+func translate(pattern string, allowA, allowG bool) string {
+	t := translator{src: []rune(pattern), allowA: allowA, allowG: allowG, atom: -1}
+	t.out = make([]byte, 0, len(pattern)+8)
+	for i := 0; i < len(t.src); {
+		i = t.next(i)
+	}
+	return string(t.out)
+}
+
+func (t *translator) peek(i int) rune {
+	if i < len(t.src) {
+		return t.src[i]
+	}
+	return 0
+}
+
+func (t *translator) write(s string) {
+	t.out = append(t.out, s...)
+}
+
+// next translates the token at i, and returns the offset of the next token.
+func (t *translator) next(i int) int {
+	c := t.src[i]
+	switch {
+	case t.extended && c == '#':
+		for i < len(t.src) && t.src[i] != '\n' {
+			i++
+		}
+		return i
+	case t.extended && unicode.IsSpace(c):
+		return i + 1
+	case c == '\\':
+		t.atom = len(t.out)
+		return t.escape(i, false)
+	case c == '[':
+		t.atom = len(t.out)
+		return t.class(i)
+	case c == '(':
+		return t.group(i)
+	case c == ')':
+		t.atom = len(t.out)
+		if n := len(t.groups); n > 0 {
+			t.atom = t.groups[n-1]
+			t.groups = t.groups[:n-1]
+		}
+		t.write(")")
+		return i + 1
+	case c == '|':
+		t.atom = -1
+		t.write("|")
+		return i + 1
+	case c == '*' || c == '+' || c == '?':
+		t.write(string(c))
+		return t.quantified(i + 1)
+	case c == '{':
+		if q, n := t.bound(i); n > i {
+			t.write(q)
+			return t.quantified(n)
+		}
+		t.atom = len(t.out)
+		t.write(`\{`)
+		return i + 1
+	default:
+		t.atom = len(t.out)
+		t.out = utf8.AppendRune(t.out, c)
+		return i + 1
+	}
+}
+
+// bound parses the bounded quantifier at i, and returns it with the offset
+// after it, or i if it is not a quantifier. Oniguruma takes {,n} as {0,n}.
+func (t *translator) bound(i int) (string, int) {
+	j := i + 1
+	for j < len(t.src) && (t.src[j] >= '0' && t.src[j] <= '9' || t.src[j] == ',') {
+		j++
+	}
+	if j == i+1 || t.peek(j) != '}' || strings.Count(string(t.src[i+1:j]), ",") > 1 || string(t.src[i+1:j]) == "," {
+		return "", i
+	}
+	q := string(t.src[i : j+1])
+	if t.src[i+1] == ',' {
+		q = "{0" + q[1:]
+	}
+	return q, j + 1
+}
+
+// quantified handles the lazy and possessive suffixes of the quantifier
+// ending before i.
+func (t *translator) quantified(i int) int {
+	switch t.peek(i) {
+	case '?':
+		t.write("?")
+		return i + 1
+	case '+':
+		if t.atom >= 0 {
+			quantified := string(t.out[t.atom:])
+			t.out = append(t.out[:t.atom], "(?>"+quantified+")"...)
+		}
+		return i + 1
+	}
+	return i
+}
+
+// group translates the group opened at i.
+func (t *translator) group(i int) int {
+	t.groups = append(t.groups, len(t.out))
+	if t.peek(i+1) != '?' {
+		t.captures++
+		t.write("(")
+		return i + 1
+	}
+
+	switch c := t.peek(i + 2); {
+	case c == '<' && t.peek(i+3) != '=' && t.peek(i+3) != '!', c == '\'':
+		// A named group.
+		closing := '>'
+		if c == '\'' {
+			closing = '\''
+		}
+		j := i + 3
+		for j < len(t.src) && t.src[j] != closing {
+			j++
+		}
+		t.captures++
+		if t.names == nil {
+			t.names = make(map[string]int)
+		}
+		t.names[string(t.src[i+3:j])] = t.captures
+		t.write("(")
+		return j + 1
+	case c == '#':
+		// A comment.
+		j := i + 2
+		for j < len(t.src) && t.src[j] != ')' {
+			j++
+		}
+		t.groups = t.groups[:len(t.groups)-1]
+		return j + 1
+	case c == '-' || unicode.IsLetter(c):
+		// Options, applied to the rest of the enclosing group, or to the
+		// group if followed by ':'.
+		j := i + 2
+		t.write("(?")
+		negated := false
+		for ; j < len(t.src) && t.src[j] != ':' && t.src[j] != ')'; j++ {
+			switch t.src[j] {
+			case '-':
+				negated = true
+				t.write("-")
+			case 'm':
+				// The multiline option of Oniguruma lets the dot match
+				// line breaks, like the singleline option of regexp2.
+				t.write("s")
+			case 'i', 'x':
+				if t.src[j] == 'x' {
+					t.extended = !negated
+				}
+				t.out = utf8.AppendRune(t.out, t.src[j])
+			}
+		}
+		if t.peek(j) == ')' {
+			t.groups = t.groups[:len(t.groups)-1]
+		}
+		t.out = utf8.AppendRune(t.out, t.peek(j))
+		return j + 1
+	case c == '<':
+		// A lookbehind.
+		t.write(string(t.src[i : i+4]))
+		return i + 4
+	default:
+		t.write(string(t.src[i : i+3]))
+		return i + 3
+	}
+}
+
+// escape translates the escape sequence at i.
+func (t *translator) escape(i int, inClass bool) int {
+	c := t.peek(i + 1)
+	switch c {
+	case 0:
+		t.write(`\\`)
+		return i + 1
+	case 'h':
+		if inClass {
+			t.write(`0-9A-Fa-f`)
+		} else {
+			t.write(`[0-9A-Fa-f]`)
+		}
+	case 'H':
+		t.write(`[^0-9A-Fa-f]`)
+	case 'A':
+		if t.allowA {
+			t.write(`\A`)
+		} else {
+			t.write(`(?!)`)
+		}
+	case 'G':
+		if t.allowG {
+			t.write(`\G`)
+		} else {
+			t.write(`(?!)`)
+		}
+	case 'R':
+		t.write(`(?:\r\n|[\n\v\f\r\x85\u2028\u2029])`)
+	case 'p', 'P':
+		if t.peek(i+2) != '{' {
+			break
+		}
+		j := i + 3
+		for j < len(t.src) && t.src[j] != '}' {
+			j++
+		}
+		name := string(t.src[i+3 : j])
+		negated := c == 'P'
+		if strings.HasPrefix(name, "^") {
+			name, negated = name[1:], !negated
+		}
+		t.property(name, negated, inClass)
+		return j + 1
+	case 'k':
+		// A back reference to a named group.
+		if open := t.peek(i + 2); open == '<' || open == '\'' {
+			j := i + 3
+			for j < len(t.src) && t.src[j] != '>' && t.src[j] != '\'' {
+				j++
+			}
+			if n, ok := t.names[string(t.src[i+3:j])]; ok {
+				t.write(`\` + strconv.Itoa(n))
+				return j + 1
+			}
+		}
+	}
+
+	switch c {
+	case 'h', 'H', 'A', 'G', 'R':
+		return i + 2
+	}
+	t.write(`\`)
+	t.out = utf8.AppendRune(t.out, c)
+	return i + 2
+}
+
+// property translates the property name, in or out of a bracket expression.
+func (t *translator) property(name string, negated, inClass bool) {
+	key := strings.ToLower(strings.NewReplacer(" ", "", "_", "", "-", "").Replace(name))
+	if class, ok := posixClasses[key]; ok {
+		switch {
+		case negated:
+			t.write(class[1])
+		case inClass:
+			t.write(class[0])
+		default:
+			t.write("[" + class[0] + "]")
+		}
+		return
+	}
+	if negated {
+		t.write(`\P{` + name + `}`)
+	} else {
+		t.write(`\p{` + name + `}`)
+	}
+}
+
+// class translates the bracket expression at i. The nested bracket
+// expressions are merged into the enclosing one.
+func (t *translator) class(i int) int {
+	t.write("[")
+	i++
+	if t.peek(i) == '^' {
+		t.write("^")
+		i++
+	}
+	if t.peek(i) == ']' {
+		t.write(`\]`)
+		i++
+	}
+	return t.classBody(i)
+}
+
+// classBody translates the bracket expression from i to its closing bracket.
+func (t *translator) classBody(i int) int {
+	for i < len(t.src) {
+		switch c := t.src[i]; {
+		case c == ']':
+			t.write("]")
+			return i + 1
+		case c == '\\':
+			i = t.escape(i, true)
+		case c == '[' && t.peek(i+1) == ':':
+			j := i + 2
+			for j < len(t.src) && t.src[j] != ':' && t.src[j] != ']' {
+				j++
+			}
+			if t.peek(j) != ':' || t.peek(j+1) != ']' {
+				t.write(`\[`)
+				i++
+				continue
+			}
+			name := string(t.src[i+2 : j])
+			negated := strings.HasPrefix(name, "^")
+			t.property(strings.TrimPrefix(name, "^"), negated, true)
+			i = j + 2
+		case c == '[':
+			// A nested bracket expression, merged into this one.
+			i = t.classBody(i + 1)
+			t.out = t.out[:len(t.out)-1]
+		default:
+			t.out = utf8.AppendRune(t.out, c)
+			i++
+		}
+	}
+	return i
+}
+
+// escapeText escapes the special characters of text to be matched literally
+// in a pattern.
+func escapeText(text string) string {
+	var sb strings.Builder
+	for _, c := range text {
+		if strings.ContainsRune(`-\{}*+?|^$.,[]()#/`, c) || unicode.IsSpace(c) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
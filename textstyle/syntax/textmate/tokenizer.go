@@ -0,0 +1,461 @@
+package textmate
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+// maxLineLength is the length in runes of the longest line tokenized. The
+// longer lines, usually of minified code, are left unstyled.
+const maxLineLength = 20000
+
+// frame is a rule entered by a begin match, which is ended by its end match
+// or when its while pattern does not match. The frames are immutable once
+// the line is tokenized, so that the states share them.
+type frame struct {
+	parent *frame
+	rule   *rule
+	// end is the end or while pattern with its back references resolved, or
+	// nil to use the one of the rule.
+	end *regex
+	// path is the scope path of the frame, and content is the one of the text
+	// between the begin and end matches, which has the content name.
+	path, content string
+	// capturedEOL is set if the begin match includes the line break.
+	capturedEOL bool
+
+	// gen is the line which enters the frame. enterPos is the position where
+	// the frame is entered, and anchor is the \G anchor before that, which
+	// are only valid in the line.
+	gen              uint64
+	enterPos, anchor int
+}
+
+// ruleEnd returns the end or while pattern of the frame, or nil.
+func (f *frame) ruleEnd() *regex {
+	if f.end != nil {
+		return f.end
+	}
+	if f.rule.while != nil {
+		return f.rule.while
+	}
+	return f.rule.end
+}
+
+// state is the state of the grammar at the end of a line.
+type state struct {
+	top *frame
+	// first is set before the first line, where \A can match.
+	first bool
+}
+
+// Equal reports whether the states have the same rules entered with the same
+// scopes.
+func (s *state) Equal(other syntax.State) bool {
+	o, ok := other.(*state)
+	if !ok || s.first != o.first {
+		return false
+	}
+
+	a, b := s.top, o.top
+	for a != nil && b != nil {
+		if a == b {
+			return true
+		}
+		if a.rule != b.rule || a.path != b.path || a.content != b.content || a.capturedEOL != b.capturedEOL {
+			return false
+		}
+		if ea, eb := a.ruleEnd(), b.ruleEnd(); ea != eb && (ea == nil || eb == nil || ea.source != eb.source) {
+			return false
+		}
+		a, b = a.parent, b.parent
+	}
+	return a == b
+}
+
+// InitialState implements syntax.Tokenizer.
+func (g *Grammar) InitialState() syntax.State {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return &state{
+		top:   &frame{rule: g.rootRule(), path: g.ScopeName, content: g.ScopeName},
+		first: true,
+	}
+}
+
+// Tokenize implements syntax.Tokenizer. The scope of a token is the scope path
+// of its text, listing the scopes from the outermost to the innermost
+// separated by spaces.
+func (g *Grammar) Tokenize(line string, st syntax.State) ([]syntax.Token, syntax.State) {
+	s, ok := st.(*state)
+	if !ok || s == nil {
+		s = g.InitialState().(*state)
+	}
+
+	text := []rune(line)
+	if len(text) > maxLineLength {
+		return nil, &state{top: s.top}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gen++
+	t := &lineTokenizer{g: g, text: text, gen: g.gen}
+	top := t.scan(s.top, text, 0, s.first, true)
+	return t.tokens, &state{top: top}
+}
+
+// cachedMatch is the first match of a pattern from a position of the line.
+type cachedMatch struct {
+	from int
+	m    *regexp2.Match
+}
+
+// lineTokenizer tokenizes a line.
+type lineTokenizer struct {
+	g    *Grammar
+	text []rune
+	gen  uint64
+	// tokens are produced up to pos.
+	tokens []syntax.Token
+	pos    int
+	// matches caches the matches of the patterns of the line, which are valid
+	// until a scan passes their start.
+	matches map[matchKey]cachedMatch
+}
+
+type matchKey struct {
+	re  *regexp2.Regexp
+	end int
+}
+
+// produce ends the last token at end, with the scope path.
+func (t *lineTokenizer) produce(path string, end int) {
+	if end <= t.pos {
+		return
+	}
+	if n := len(t.tokens); n > 0 && string(t.tokens[n-1].Scope) == path {
+		t.tokens[n-1].End = end
+	} else {
+		t.tokens = append(t.tokens, syntax.Token{Start: t.pos, End: end, Scope: syntax.StyleScope(path)})
+	}
+	t.pos = end
+}
+
+// find returns the first match of re in text from pos, using the match of a
+// previous scan if it is still the first one. The patterns anchored by \G
+// depend on the position scanned from, and are not cached.
+func (t *lineTokenizer) find(re *regexp2.Regexp, text []rune, pos int, anchored bool) *regexp2.Match {
+	if re == nil {
+		return nil
+	}
+	if anchored {
+		return find(re, text, pos)
+	}
+
+	key := matchKey{re, len(text)}
+	if c, ok := t.matches[key]; ok && c.from <= pos && (c.m == nil || c.m.Index >= pos) {
+		return c.m
+	}
+	m := find(re, text, pos)
+	if t.matches == nil {
+		t.matches = make(map[matchKey]cachedMatch)
+	}
+	t.matches[key] = cachedMatch{from: pos, m: m}
+	return m
+}
+
+// enterPos returns where f is entered in the line, or -1 if it is entered in
+// a previous line.
+func (t *lineTokenizer) enterPos(f *frame) int {
+	if f == nil || f.gen != t.gen {
+		return -1
+	}
+	return f.enterPos
+}
+
+// scan tokenizes text from pos with the rules entered in stack, and returns
+// the rules entered at the end of text.
+func (t *lineTokenizer) scan(stack *frame, text []rune, pos int, first, checkWhile bool) *frame {
+	anchor := -1
+	if checkWhile {
+		stack, pos, anchor, first = t.checkWhile(stack, text, pos, first)
+	}
+
+	for {
+		m, matched, isEnd := t.next(stack, text, pos, first, anchor)
+		if m == nil {
+			t.produce(stack.content, len(text))
+			return stack
+		}
+
+		start, end := m.Index, m.Index+m.Length
+		advanced := end > pos
+		if isEnd {
+			popped := stack
+			t.produce(popped.content, start)
+			t.captures(popped.path, popped.rule.endCaptures, m, text, first)
+			t.produce(popped.path, end)
+			stack = popped.parent
+			anchor = -1
+			if popped.gen == t.gen {
+				anchor = popped.anchor
+			}
+			if !advanced && t.enterPos(popped) == pos {
+				// The rule is entered and ended without advancing, which
+				// would loop forever.
+				t.produce(popped.content, len(text))
+				return popped
+			}
+		} else {
+			t.produce(stack.content, start)
+			before := stack
+			path := join(stack.content, substitute(matched.name, m))
+			f := &frame{
+				parent:      stack,
+				rule:        matched,
+				path:        path,
+				content:     path,
+				capturedEOL: end == len(text),
+				gen:         t.gen,
+				enterPos:    pos,
+				anchor:      anchor,
+			}
+
+			if matched.begin == nil {
+				t.captures(path, matched.captures, m, text, first)
+				t.produce(path, end)
+				if !advanced {
+					// The rule matches nothing, which would loop forever.
+					if before.parent != nil {
+						before = before.parent
+					}
+					t.produce(before.content, len(text))
+					return before
+				}
+			} else {
+				t.captures(path, matched.beginCaptures, m, text, first)
+				t.produce(path, end)
+				anchor = end
+				f.content = join(path, substitute(matched.contentName, m))
+				if matched.endBackRefs {
+					pattern := matched.end
+					if matched.while != nil {
+						pattern = matched.while
+					}
+					f.end = t.g.resolveBackRefs(pattern, groupText(m))
+				}
+				stack = f
+				if !advanced && t.hasSameRule(before, f) {
+					// The rule is entered again without advancing, which
+					// would loop forever.
+					t.produce(before.content, len(text))
+					return before
+				}
+			}
+		}
+
+		if advanced {
+			pos = end
+			first = false
+		}
+	}
+}
+
+// hasSameRule reports whether the rule of f is entered at the same position
+// by before or its parents.
+func (t *lineTokenizer) hasSameRule(before, f *frame) bool {
+	for el := before; el != nil && t.enterPos(el) == f.enterPos; el = el.parent {
+		if el.rule == f.rule {
+			return true
+		}
+	}
+	return false
+}
+
+// next finds the first match from pos of the end pattern of the top rule of
+// stack, and of the rules it includes. The ties are won by the pattern
+// listed first, where the end pattern comes first unless the rule applies
+// it last.
+func (t *lineTokenizer) next(stack *frame, text []rune, pos int, first bool, anchor int) (best *regexp2.Match, matched *rule, isEnd bool) {
+	allowG := pos == anchor
+	try := func(re *regex, r *rule, end bool) {
+		m := t.find(re.get(first, allowG), text, pos, allowG && re.hasG)
+		if m != nil && (best == nil || m.Index < best.Index) {
+			best, matched, isEnd = m, r, end
+		}
+	}
+
+	r := stack.rule
+	var end *regex
+	if r.while == nil {
+		end = stack.ruleEnd()
+	}
+	if end != nil && !r.applyEndPatternLast {
+		try(end, nil, true)
+	}
+	for _, c := range t.g.candidatesOf(r) {
+		if best != nil && best.Index == pos {
+			break
+		}
+		if c.match != nil {
+			try(c.match, c, false)
+		} else {
+			try(c.begin, c, false)
+		}
+	}
+	if end != nil && r.applyEndPatternLast {
+		try(end, nil, true)
+	}
+	return best, matched, isEnd
+}
+
+// checkWhile checks the while patterns of the rules entered at the start of a
+// line, from the outermost, and ends the rules whose while pattern does not
+// match with the rules entered after them.
+func (t *lineTokenizer) checkWhile(stack *frame, text []rune, pos int, first bool) (*frame, int, int, bool) {
+	anchor := -1
+	if stack.capturedEOL {
+		anchor = 0
+	}
+
+	var whiles []*frame
+	for f := stack; f != nil; f = f.parent {
+		if f.rule.while != nil {
+			whiles = append(whiles, f)
+		}
+	}
+
+	for i := len(whiles) - 1; i >= 0; i-- {
+		f := whiles[i]
+		re := f.ruleEnd()
+		m := find(re.get(first, pos == anchor), text, pos)
+		if m == nil {
+			return f.parent, pos, anchor, first
+		}
+
+		t.produce(f.content, m.Index)
+		t.captures(f.content, f.rule.whileCaptures, m, text, first)
+		t.produce(f.content, m.Index+m.Length)
+		anchor = m.Index + m.Length
+		if anchor > pos {
+			pos = anchor
+			first = false
+		}
+	}
+	return stack, pos, anchor, first
+}
+
+// captures produces the tokens of the groups of m with the scopes of the
+// captures, nested in path.
+func (t *lineTokenizer) captures(path string, captures []*capture, m *regexp2.Match, text []rune, first bool) {
+	if len(captures) == 0 {
+		return
+	}
+
+	type local struct {
+		path string
+		end  int
+	}
+	var locals []local
+	maxEnd := m.Index + m.Length
+	for i, c := range captures {
+		if c == nil {
+			continue
+		}
+		group := m.GroupByNumber(i)
+		if group == nil || len(group.Captures) == 0 || group.Length == 0 {
+			continue
+		}
+		if group.Index > maxEnd {
+			break
+		}
+
+		for len(locals) > 0 && locals[len(locals)-1].end <= group.Index {
+			last := locals[len(locals)-1]
+			t.produce(last.path, last.end)
+			locals = locals[:len(locals)-1]
+		}
+		base := path
+		if len(locals) > 0 {
+			base = locals[len(locals)-1].path
+		}
+		t.produce(base, group.Index)
+
+		name := join(base, substitute(c.name, m))
+		if c.rule != nil {
+			// Tokenize the text of the group with the patterns of the
+			// capture.
+			f := &frame{
+				rule:     c.rule,
+				path:     name,
+				content:  join(name, substitute(c.contentName, m)),
+				gen:      t.gen,
+				enterPos: group.Index,
+				anchor:   -1,
+			}
+			end := group.Index + group.Length
+			t.scan(f, text[:end], group.Index, first && group.Index == 0, false)
+			continue
+		}
+		if name != base {
+			locals = append(locals, local{path: name, end: group.Index + group.Length})
+		}
+	}
+
+	for i := len(locals) - 1; i >= 0; i-- {
+		t.produce(locals[i].path, locals[i].end)
+	}
+}
+
+// join appends scope to the scope path.
+func join(path, scope string) string {
+	if scope == "" {
+		return path
+	}
+	return path + " " + scope
+}
+
+// groupText returns a function to get the text of the groups of m.
+func groupText(m *regexp2.Match) func(i int) (string, bool) {
+	return func(i int) (string, bool) {
+		group := m.GroupByNumber(i)
+		if group == nil || len(group.Captures) == 0 {
+			return "", false
+		}
+		return group.String(), true
+	}
+}
+
+// captureRef matches the references to the groups in the scope names, like
+// "$1" or "${1:/downcase}".
+var captureRef = regexp.MustCompile(`\$(\d+)|\$\{(\d+):/(downcase|upcase)\}`)
+
+// substitute replaces the references to the groups of m in the scope name.
+func substitute(name string, m *regexp2.Match) string {
+	if !strings.Contains(name, "$") {
+		return name
+	}
+
+	text := groupText(m)
+	return captureRef.ReplaceAllStringFunc(name, func(ref string) string {
+		sub := captureRef.FindStringSubmatch(ref)
+		n, _ := strconv.Atoi(sub[1] + sub[2])
+		s, ok := text(n)
+		if !ok {
+			return ref
+		}
+		s = strings.TrimLeft(s, ".")
+		switch sub[3] {
+		case "downcase":
+			s = strings.ToLower(s)
+		case "upcase":
+			s = strings.ToUpper(s)
+		}
+		return s
+	})
+}
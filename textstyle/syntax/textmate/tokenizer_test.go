@@ -0,0 +1,296 @@
+package textmate
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+func loadToy(t *testing.T) *Grammar {
+	t.Helper()
+	f, err := os.Open("testdata/toy.tmLanguage.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, err := LoadGrammar(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+type span struct {
+	text, scope string
+}
+
+// tokenize tokenizes text line by line, and returns the text and the scope
+// path of the tokens.
+func tokenize(t *testing.T, g *Grammar, text string) []span {
+	t.Helper()
+	var spans []span
+	state := g.InitialState()
+	for _, line := range strings.SplitAfter(text, "\n") {
+		var tokens []syntax.Token
+		tokens, state = g.Tokenize(line, state)
+		runes := []rune(line)
+		pos := 0
+		for _, token := range tokens {
+			if token.Start != pos {
+				t.Fatalf("%q: tokens are not contiguous: %v", line, tokens)
+			}
+			pos = token.End
+			spans = append(spans, span{string(runes[token.Start:token.End]), string(token.Scope)})
+		}
+		if pos != len(runes) {
+			t.Fatalf("%q: tokens do not cover the line: %v", line, tokens)
+		}
+	}
+	return spans
+}
+
+func checkSpans(t *testing.T, got []span, want ...span) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("want %d tokens, got %d: %q", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("token %d: want %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	g := loadToy(t)
+	if g.ScopeName != "source.toy" || g.Name != "Toy" {
+		t.Fatalf("unexpected grammar %q %q", g.ScopeName, g.Name)
+	}
+
+	const (
+		toy     = "source.toy"
+		comment = toy + " comment.line.double-slash.toy"
+		params  = toy + " meta.parameters.toy"
+		block   = toy + " meta.block.toy"
+		str     = block + " string.quoted.double.toy"
+		quote   = toy + " markup.quote.toy"
+	)
+
+	cases := []struct {
+		name string
+		text string
+		want []span
+	}{
+		{
+			name: "keywords and comments",
+			text: "if x return 42 // done\n",
+			want: []span{
+				{"if", toy + " keyword.control.if.toy"},
+				{" x ", toy},
+				{"return", toy + " keyword.control.return.toy"},
+				{" ", toy},
+				{"42", toy + " constant.numeric.toy"},
+				{" ", toy},
+				{"//", comment + " punctuation.definition.comment.toy"},
+				{" done\n", comment},
+			},
+		},
+		{
+			name: "block comment",
+			text: "/* a\nb */ else",
+			want: []span{
+				{"/* a\n", toy + " comment.block.toy"},
+				{"b */", toy + " comment.block.toy"},
+				{" ", toy},
+				{"else", toy + " keyword.control.else.toy"},
+			},
+		},
+		{
+			name: "captures",
+			text: `func add(a, b) { "a\"b" }`,
+			want: []span{
+				{"func", toy + " storage.type.function.toy"},
+				{" ", toy},
+				{"add", toy + " entity.name.function.toy"},
+				{"(", params},
+				{"a", params + " variable.parameter.toy"},
+				{", ", params},
+				{"b", params + " variable.parameter.toy"},
+				{")", params},
+				{" ", toy},
+				{"{ ", block},
+				{`"`, str + " punctuation.definition.string.begin.toy"},
+				{"a", str},
+				{`\"`, str + " constant.character.escape.toy"},
+				{"b", str},
+				{`"`, str + " punctuation.definition.string.end.toy"},
+				{" }", block},
+			},
+		},
+		{
+			name: "back references",
+			text: "cat <<EOF\nif\nEOF\n",
+			want: []span{
+				{"cat <<", toy},
+				{"EOF", toy + " keyword.operator.heredoc.toy"},
+				{"\n", toy + " string.unquoted.heredoc.toy"},
+				{"if\n", toy + " string.unquoted.heredoc.toy"},
+				{"EOF\n", toy},
+			},
+		},
+		{
+			name: "while",
+			text: "> quoted\n> quote // note\nreturn",
+			want: []span{
+				{"> quoted\n", quote},
+				{"> quote ", quote},
+				{"//", quote + " comment.line.double-slash.toy punctuation.definition.comment.toy"},
+				{" note\n", quote + " comment.line.double-slash.toy"},
+				{"return", toy + " keyword.control.return.toy"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			checkSpans(t, tokenize(t, g, tc.text), tc.want...)
+		})
+	}
+}
+
+func TestTokenizeConcurrently(t *testing.T) {
+	text := "if x return 42 // done\n/* a\nb */ else\n" +
+		`func add(a, b) { "a\"b" }` + "\ncat <<EOF\nif\nEOF\n> quoted\n> quote // note\nreturn"
+	want := tokenize(t, loadToy(t), text)
+
+	// The editors share the grammar, whose patterns are compiled as the
+	// lines are tokenized.
+	g := loadToy(t)
+	var wg sync.WaitGroup
+	results := make([][]span, 8)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state := g.InitialState()
+			for _, line := range strings.SplitAfter(text, "\n") {
+				var tokens []syntax.Token
+				tokens, state = g.Tokenize(line, state)
+				runes := []rune(line)
+				for _, token := range tokens {
+					results[i] = append(results[i], span{string(runes[token.Start:token.End]), string(token.Scope)})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, got := range results {
+		checkSpans(t, got, want...)
+	}
+}
+
+func TestTokenizeState(t *testing.T) {
+	g := loadToy(t)
+
+	tokenize := func(lines ...string) syntax.State {
+		state := g.InitialState()
+		for _, line := range lines {
+			_, state = g.Tokenize(line, state)
+		}
+		return state
+	}
+
+	// The states converge after the block comment ends.
+	a := tokenize("/* a\n", "b */\n", "x\n")
+	b := tokenize("/* c\n", "d */\n", "y\n")
+	if !a.Equal(b) {
+		t.Error("want the states equal out of the comments")
+	}
+	if c := tokenize("/* c\n", "d\n"); a.Equal(c) {
+		t.Error("want the states differ in a comment")
+	}
+
+	// The heredoc ends with its own delimiter only.
+	if !tokenize("<<A\n", "B\n").Equal(tokenize("<<A\n", "C\n")) {
+		t.Error("want the states equal in a heredoc")
+	}
+	if tokenize("<<A\n", "A\n").Equal(tokenize("<<B\n", "A\n")) {
+		t.Error("want the heredoc of A ended")
+	}
+
+	// A quote ends at the first line not starting with ">".
+	if !tokenize("> a\n", "b\n").Equal(tokenize("c\n")) {
+		t.Error("want the quote ended")
+	}
+}
+
+func TestInclude(t *testing.T) {
+	reg := NewRegistry()
+	outer := `{
+		"scopeName": "text.outer",
+		"patterns": [
+			{ "begin": "<toy>", "end": "</toy>", "name": "meta.embedded.toy", "patterns": [{ "include": "source.toy" }] },
+			{ "include": "source.toy#strings" },
+			{ "include": "source.missing" }
+		]
+	}`
+	g, err := reg.Load(strings.NewReader(outer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("testdata/toy.tmLanguage.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := reg.Load(f); err != nil {
+		t.Fatal(err)
+	}
+
+	const str = "text.outer string.quoted.double.toy"
+	checkSpans(t, tokenize(t, g, `"s" <toy>if</toy> if`),
+		span{`"`, str + " punctuation.definition.string.begin.toy"},
+		span{"s", str},
+		span{`"`, str + " punctuation.definition.string.end.toy"},
+		span{" ", "text.outer"},
+		span{"<toy>", "text.outer meta.embedded.toy"},
+		span{"if", "text.outer meta.embedded.toy keyword.control.if.toy"},
+		span{"</toy>", "text.outer meta.embedded.toy"},
+		span{" if", "text.outer"},
+	)
+}
+
+func TestEndlessLoop(t *testing.T) {
+	g, err := LoadGrammar(strings.NewReader(`{
+		"scopeName": "source.loop",
+		"patterns": [
+			{ "match": "(?=x)", "name": "empty" },
+			{ "begin": "(?=y)", "end": "(?=y)", "name": "meta.y" },
+			{ "begin": "\\b", "end": "\\b", "name": "meta.word" }
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"x x\n", "y y\n", "a b\n"} {
+		tokens, _ := g.Tokenize(line, g.InitialState())
+		if len(tokens) == 0 || tokens[len(tokens)-1].End != len(line) {
+			t.Errorf("%q: want the line tokenized, got %v", line, tokens)
+		}
+	}
+}
+
+func TestLoadGrammarErrors(t *testing.T) {
+	if _, err := LoadGrammar(strings.NewReader(`{"patterns": []}`)); err == nil {
+		t.Error("want an error without scope name")
+	}
+	if _, err := LoadGrammar(strings.NewReader(`{`)); err == nil {
+		t.Error("want an error for invalid JSON")
+	}
+}
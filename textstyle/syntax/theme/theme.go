@@ -0,0 +1,135 @@
+// Package theme imports the color themes of VS Code and TextMate as
+// syntax.ColorScheme, so that the themes in use by other editors can style
+// the tokens of the TextMate grammars.
+//
+// The rules of a theme are added as the styles of their scopes, and the
+// workbench colors fill the color palette. A scope selector listing several
+// scopes separated by commas styles every scope listed. As the styles are
+// looked up by a scope and its parents only, the selectors matching by the
+// ancestors of a scope, such as "meta.tag string", or excluding scopes, such
+// as "string - string.regexp", are skipped.
+package theme
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oligo/gvcode/color"
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+// settings is the style of a theme rule, where the fields left empty are not
+// set by the rule.
+type settings struct {
+	foreground, background string
+	fontStyle              *string
+}
+
+// merge overrides the fields of s set by other.
+func (s *settings) merge(other settings) {
+	if other.foreground != "" {
+		s.foreground = other.foreground
+	}
+	if other.background != "" {
+		s.background = other.background
+	}
+	if other.fontStyle != nil {
+		s.fontStyle = other.fontStyle
+	}
+}
+
+// builder collects the rules of a theme, merging the settings of the rules of
+// the same scope, as a later rule only overrides the fields it sets.
+type builder struct {
+	scopes []syntax.StyleScope
+	rules  map[syntax.StyleScope]*settings
+}
+
+// add adds a rule of the scope selector.
+func (b *builder) add(selector string, s settings) {
+	for _, scope := range splitSelector(selector) {
+		if rule, ok := b.rules[scope]; ok {
+			rule.merge(s)
+			continue
+		}
+		if b.rules == nil {
+			b.rules = make(map[syntax.StyleScope]*settings)
+		}
+		rule := s
+		b.rules[scope] = &rule
+		b.scopes = append(b.scopes, scope)
+	}
+}
+
+// build adds the styles of the rules to scheme, whose palette is to be set.
+// It fails if the scheme runs out of room for the scopes or the colors.
+func (b *builder) build(scheme *syntax.ColorScheme) error {
+	for _, scope := range b.scopes {
+		rule := b.rules[scope]
+		var textStyle syntax.TextStyle
+		if rule.fontStyle != nil {
+			textStyle = parseFontStyle(*rule.fontStyle)
+		}
+		err := scheme.AddStyle(scope, textStyle, parseColor(rule.foreground), parseColor(rule.background))
+		if err != nil {
+			return fmt.Errorf("theme: %w", err)
+		}
+	}
+	return nil
+}
+
+// splitSelector returns the scopes of a scope selector, which lists the scopes
+// separated by commas. The selectors of several scopes, and the invalid ones,
+// are skipped.
+func splitSelector(selector string) []syntax.StyleScope {
+	var scopes []syntax.StyleScope
+	for _, s := range strings.Split(selector, ",") {
+		s = strings.TrimSpace(s)
+		if strings.ContainsAny(s, " \t()|&") {
+			continue
+		}
+		if scope := syntax.StyleScope(s); scope.IsValid() {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// parseFontStyle converts the font style of a rule, which lists the styles
+// separated by spaces, like "bold italic".
+func parseFontStyle(fontStyle string) syntax.TextStyle {
+	var style syntax.TextStyle
+	for _, s := range strings.Fields(fontStyle) {
+		switch s {
+		case "bold":
+			style |= syntax.Bold
+		case "italic":
+			style |= syntax.Italic
+		case "underline":
+			style |= syntax.Underline
+		case "strikethrough":
+			style |= syntax.Strikethrough
+		}
+	}
+	return style
+}
+
+// parseColor converts a color of a theme, which is "#RGB", "#RGBA", "#RRGGBB"
+// or "#RRGGBBAA". An invalid color, which some themes have, is left unset.
+func parseColor(s string) color.Color {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) == 3 || len(s) == 4 {
+		var sb strings.Builder
+		for _, c := range s {
+			sb.WriteRune(c)
+			sb.WriteRune(c)
+		}
+		s = sb.String()
+	}
+
+	c, err := color.Hex2Color(s)
+	if err != nil {
+		return color.Color{}
+	}
+	return c
+}
@@ -0,0 +1,151 @@
+package theme
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/oligo/gvcode/color"
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+func loadFile(t *testing.T, name string, load func(r io.Reader) (*syntax.ColorScheme, error)) *syntax.ColorScheme {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scheme, err := load(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func hex(t *testing.T, s string) color.Color {
+	t.Helper()
+	c := parseColor(s)
+	if !c.IsSet() {
+		t.Fatalf("invalid color %q", s)
+	}
+	return c
+}
+
+type styleCase struct {
+	scope     string
+	fg, bg    string
+	textStyle syntax.TextStyle
+}
+
+func checkStyles(t *testing.T, scheme *syntax.ColorScheme, cases []styleCase) {
+	t.Helper()
+	for _, c := range cases {
+		style := scheme.GetTokenStyle(syntax.StyleScope(c.scope))
+		if style == 0 {
+			t.Errorf("%s: no style", c.scope)
+			continue
+		}
+
+		var fg, bg color.Color
+		if c.fg != "" {
+			fg = hex(t, c.fg)
+		}
+		if c.bg != "" {
+			bg = hex(t, c.bg)
+		}
+		if got := scheme.GetColor(style.Foreground()); got != fg {
+			t.Errorf("%s: want foreground %v, got %v", c.scope, fg, got)
+		}
+		if got := scheme.GetColor(style.Background()); got != bg {
+			t.Errorf("%s: want background %v, got %v", c.scope, bg, got)
+		}
+		if got := style.TextStyle(); got != c.textStyle {
+			t.Errorf("%s: want text style %04b, got %04b", c.scope, c.textStyle, got)
+		}
+	}
+}
+
+func TestLoadVSCode(t *testing.T) {
+	scheme := loadFile(t, "testdata/dark.json", LoadVSCode)
+
+	if scheme.Name != "Dark Test" {
+		t.Errorf("want name %q, got %q", "Dark Test", scheme.Name)
+	}
+	palette := []struct {
+		name      string
+		got, want color.Color
+	}{
+		{"foreground", scheme.Foreground, hex(t, "#d4d4d4")},
+		{"background", scheme.Background, hex(t, "#1e1e1e")},
+		{"selection", scheme.SelectColor, hex(t, "#264f78")},
+		{"line", scheme.LineColor, hex(t, "#ffffff0f")},
+		{"line number", scheme.LineNumberColor, hex(t, "#858585")},
+	}
+	for _, c := range palette {
+		if c.got != c.want {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, c.got)
+		}
+	}
+
+	checkStyles(t, scheme, []styleCase{
+		{scope: "comment.line.double-slash.go", fg: "#6a9955", textStyle: syntax.Italic},
+		{scope: "keyword.control.if", fg: "#c586c0", textStyle: syntax.Strikethrough},
+		{scope: "storage.type", fg: "#c586c0", textStyle: syntax.Bold | syntax.Underline},
+		{scope: "storage.modifier", fg: "#c586c0", textStyle: syntax.Bold | syntax.Underline},
+		{scope: "source.go string.quoted.double.go", fg: "#ce9178"},
+		{scope: "url.link"},
+	})
+
+	for _, scope := range scheme.Scopes() {
+		if strings.Contains(string(scope), " ") {
+			t.Errorf("selector %q is added as a scope", scope)
+		}
+	}
+}
+
+func TestLoadTMTheme(t *testing.T) {
+	scheme := loadFile(t, "testdata/light.tmTheme", LoadTMTheme)
+
+	if scheme.Name != "Light Test" {
+		t.Errorf("want name %q, got %q", "Light Test", scheme.Name)
+	}
+	palette := []struct {
+		name      string
+		got, want color.Color
+	}{
+		{"foreground", scheme.Foreground, hex(t, "#000000")},
+		{"background", scheme.Background, hex(t, "#FFFFFF")},
+		{"selection", scheme.SelectColor, hex(t, "#ADD6FF")},
+		{"line", scheme.LineColor, hex(t, "#EEEEEE")},
+		{"line number", scheme.LineNumberColor, hex(t, "#237893")},
+	}
+	for _, c := range palette {
+		if c.got != c.want {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, c.got)
+		}
+	}
+
+	checkStyles(t, scheme, []styleCase{
+		{scope: "comment.block", fg: "#008000", textStyle: syntax.Italic},
+		{scope: "punctuation.definition.comment.go", fg: "#008000", textStyle: syntax.Italic},
+		{scope: "invalid.illegal", fg: "#FFFFFF88", bg: "#FF0000"},
+	})
+}
+
+func TestLoadErrors(t *testing.T) {
+	if _, err := LoadVSCode(strings.NewReader(`{"tokenColors": [1]}`)); err == nil {
+		t.Error("want error for invalid token colors")
+	}
+	if _, err := LoadVSCode(strings.NewReader(`{"tokenColors": "./theme.tmTheme"}`)); err != nil {
+		t.Errorf("want token colors of a file skipped, got %v", err)
+	}
+	if _, err := LoadTMTheme(strings.NewReader(`<plist><array></array></plist>`)); err == nil {
+		t.Error("want error for a tmTheme without dictionary")
+	}
+	if _, err := LoadTMTheme(strings.NewReader(`<plist><dict><key>name</key>`)); err == nil {
+		t.Error("want error for a truncated tmTheme")
+	}
+}
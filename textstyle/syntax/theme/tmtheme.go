@@ -0,0 +1,133 @@
+package theme
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+// LoadTMTheme loads a TextMate color theme from its .tmTheme property list.
+// The rules with a scope are added as the styles of their scopes, and the
+// settings of the rule without a scope, such as "background" and
+// "selection", fill the color palette.
+func LoadTMTheme(r io.Reader) (*syntax.ColorScheme, error) {
+	root, err := decodePlist(xml.NewDecoder(r))
+	if err != nil {
+		return nil, fmt.Errorf("theme: invalid tmTheme: %w", err)
+	}
+	theme, ok := root.(map[string]any)
+	if !ok {
+		return nil, errors.New("theme: invalid tmTheme: no theme dictionary")
+	}
+
+	name, _ := theme["name"].(string)
+	scheme := &syntax.ColorScheme{Name: name}
+	b := &builder{}
+	rules, _ := theme["settings"].([]any)
+	for _, item := range rules {
+		rule, _ := item.(map[string]any)
+		values, _ := rule["settings"].(map[string]any)
+		str := func(key string) string {
+			s, _ := values[key].(string)
+			return s
+		}
+
+		selector, ok := rule["scope"].(string)
+		if !ok {
+			// The rule without scope sets the colors of the editor.
+			setColor(&scheme.Foreground, str("foreground"))
+			setColor(&scheme.Background, str("background"))
+			setColor(&scheme.SelectColor, str("selection"))
+			setColor(&scheme.LineColor, str("lineHighlight"))
+			setColor(&scheme.LineNumberColor, str("gutterForeground"))
+			continue
+		}
+
+		s := settings{foreground: str("foreground"), background: str("background")}
+		if fontStyle, ok := values["fontStyle"].(string); ok {
+			s.fontStyle = &fontStyle
+		}
+		b.add(selector, s)
+	}
+
+	if err := b.build(scheme); err != nil {
+		return nil, err
+	}
+	return scheme, nil
+}
+
+// decodePlist decodes the first value of an XML property list. The
+// dictionaries are decoded as map[string]any, the arrays as []any, and the
+// other values as their text.
+func decodePlist(d *xml.Decoder) (any, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("no value")
+			}
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local != "plist" {
+			return decodePlistValue(d, start)
+		}
+	}
+}
+
+// decodePlistValue decodes the value of the element start.
+func decodePlistValue(d *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]any)
+		var key string
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				if tok.Name.Local == "key" {
+					if err := d.DecodeElement(&key, &tok); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				value, err := decodePlistValue(d, tok)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = value
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		var array []any
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				value, err := decodePlistValue(d, tok)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	case "true", "false":
+		return start.Name.Local == "true", d.Skip()
+	default:
+		var text string
+		err := d.DecodeElement(&text, &start)
+		return text, err
+	}
+}
@@ -0,0 +1,155 @@
+package theme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/oligo/gvcode/color"
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+// vscodeTheme is a VS Code color theme as it is written.
+type vscodeTheme struct {
+	Name   string            `json:"name"`
+	Colors map[string]string `json:"colors"`
+	// TokenColors is a list of rules, or the path of a .tmTheme file.
+	TokenColors json.RawMessage `json:"tokenColors"`
+}
+
+type vscodeRule struct {
+	// Scope is a scope selector, or a list of them.
+	Scope    json.RawMessage `json:"scope"`
+	Settings struct {
+		Foreground string  `json:"foreground"`
+		Background string  `json:"background"`
+		FontStyle  *string `json:"fontStyle"`
+	} `json:"settings"`
+}
+
+// selectors returns the scope selectors of the rule.
+func (r *vscodeRule) selectors() []string {
+	var selector string
+	if err := json.Unmarshal(r.Scope, &selector); err == nil {
+		return []string{selector}
+	}
+	var selectors []string
+	json.Unmarshal(r.Scope, &selectors)
+	return selectors
+}
+
+// LoadVSCode loads a VS Code color theme from its JSON file, which can have
+// comments and trailing commas. The token colors are added as the styles of
+// their scopes, and the editor colors, such as "editor.background" and
+// "editor.selectionBackground", fill the color palette. The themes included
+// by the theme are not loaded, nor are the token colors referring to a
+// .tmTheme file.
+func LoadVSCode(r io.Reader) (*syntax.ColorScheme, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	theme := &vscodeTheme{}
+	if err := json.Unmarshal(stripJSONC(data), theme); err != nil {
+		return nil, fmt.Errorf("theme: invalid VS Code theme: %w", err)
+	}
+
+	var rules []vscodeRule
+	if bytes.HasPrefix(theme.TokenColors, []byte("[")) {
+		if err := json.Unmarshal(theme.TokenColors, &rules); err != nil {
+			return nil, fmt.Errorf("theme: invalid VS Code theme: %w", err)
+		}
+	}
+
+	scheme := &syntax.ColorScheme{Name: theme.Name}
+	b := &builder{}
+	for _, rule := range rules {
+		s := settings{
+			foreground: rule.Settings.Foreground,
+			background: rule.Settings.Background,
+			fontStyle:  rule.Settings.FontStyle,
+		}
+		if len(rule.Scope) == 0 {
+			// A rule without scope sets the default text colors.
+			setColor(&scheme.Foreground, s.foreground)
+			setColor(&scheme.Background, s.background)
+			continue
+		}
+		for _, selector := range rule.selectors() {
+			b.add(selector, s)
+		}
+	}
+
+	setColor(&scheme.Foreground, theme.Colors["foreground"])
+	setColor(&scheme.Foreground, theme.Colors["editor.foreground"])
+	setColor(&scheme.Background, theme.Colors["editor.background"])
+	setColor(&scheme.SelectColor, theme.Colors["editor.selectionBackground"])
+	setColor(&scheme.LineColor, theme.Colors["editor.lineHighlightBackground"])
+	setColor(&scheme.LineNumberColor, theme.Colors["editorLineNumber.foreground"])
+
+	if err := b.build(scheme); err != nil {
+		return nil, err
+	}
+	return scheme, nil
+}
+
+// setColor sets dst to the color s, unless s is not a valid color.
+func setColor(dst *color.Color, s string) {
+	if c := parseColor(s); c.IsSet() {
+		*dst = c
+	}
+}
+
+// stripJSONC removes the comments and the trailing commas of JSON with
+// comments, as VS Code allows in its JSON files.
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	// comma is the index in out of a comma which may be trailing.
+	comma := -1
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '"':
+			j := i + 1
+			for j < len(data) && data[j] != '"' {
+				if data[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(data))
+			out = append(out, data[i:j]...)
+			i = j - 1
+			comma = -1
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			j := bytes.IndexByte(data[i:], '\n')
+			if j < 0 {
+				return out
+			}
+			i += j - 1
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			j := bytes.Index(data[i+2:], []byte("*/"))
+			if j < 0 {
+				return out
+			}
+			i += j + 3
+		case c == ',':
+			comma = len(out)
+			out = append(out, c)
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			comma = -1
+			out = append(out, c)
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			out = append(out, c)
+		default:
+			comma = -1
+			out = append(out, c)
+		}
+	}
+	return out
+}
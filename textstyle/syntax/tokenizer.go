@@ -0,0 +1,25 @@
+package syntax
+
+// State is the state of a Tokenizer at the end of a line, which is needed to
+// tokenize the next line, such as being in a block comment or a string. The
+// states are kept by the Highlighter for every line, and must not be changed
+// once returned by the tokenizer.
+type State interface {
+	// Equal reports whether the state is the same as other, in which case
+	// the following lines are tokenized the same way as before.
+	Equal(other State) bool
+}
+
+// Tokenizer tokenizes a document a line at a time, carrying the state from a
+// line to the next, so that the document can be tokenized again from the
+// first changed line until the states converge.
+type Tokenizer interface {
+	// InitialState returns the state at the start of the document.
+	InitialState() State
+	// Tokenize tokenizes a line of the document, including its line break if
+	// it has one, starting from the state at the end of the previous line.
+	// It returns the tokens of the line, sorted and not overlapping, with
+	// their rune offsets relative to the start of the line, and the state at
+	// the end of the line.
+	Tokenize(line string, state State) ([]Token, State)
+}
@@ -0,0 +1,110 @@
+package textview
+
+import (
+	"sort"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+)
+
+// trimState tracks the text trimmed from the start since the last layout.
+type trimState struct {
+	lines, runes int
+}
+
+// Append adds s to the end of the text without recording it in the undo
+// history. Only the last paragraphs are laid out again, and the carets are
+// kept where they are. It returns the number of runes appended.
+func (e *TextView) Append(s string) int {
+	end := e.src.Len()
+	if !e.src.Append(s) {
+		return 0
+	}
+
+	n := e.src.Len() - end
+	e.invalidateRange(end, end, end+n)
+	e.folding.dirty = true
+	return n
+}
+
+// refresher is a source whose text grows in the background, like a
+// buffer.ReadOnlySource being indexed, which shows the new text when it is
+// refreshed.
+type refresher interface {
+	Ready() <-chan struct{}
+	Refresh() bool
+}
+
+// refreshInterval is the time between the frames refreshing a growing source.
+const refreshInterval = 100 * time.Millisecond
+
+// refreshSource lays out the text the source has grown by since the last
+// layout, the same way as the text appended, and asks for another frame until
+// the source stops growing.
+func (e *TextView) refreshSource(gtx layout.Context) {
+	src, ok := e.src.(refresher)
+	if !ok {
+		return
+	}
+
+	select {
+	case <-src.Ready():
+	default:
+		gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(refreshInterval)})
+	}
+	end := e.src.Len()
+	if src.Refresh() {
+		e.invalidateRange(end, end, e.src.Len())
+		e.folding.dirty = true
+	}
+}
+
+// TrimLines removes the first n lines of the text without recording it in
+// the undo history. The viewport is kept on the same text, unless it is
+// trimmed. It returns the number of runes removed.
+func (e *TextView) TrimLines(n int) int {
+	removed := e.src.TrimLines(n)
+	if removed == 0 {
+		return 0
+	}
+
+	adjust := func(pos int) int {
+		return max(pos-removed, 0)
+	}
+	e.caret.start = adjust(e.caret.start)
+	e.caret.end = adjust(e.caret.end)
+	e.adjustCarets(adjust)
+	e.invalidateRange(0, removed, 0)
+	e.folding.dirty = true
+
+	e.trimmed.lines += n
+	e.trimmed.runes += removed
+	return removed
+}
+
+// trimmedHeight returns the height of the trimmed text in the last layout.
+func (e *TextView) trimmedHeight() int {
+	if e.layouter.Virtualized() {
+		return e.layouter.ParagraphY(e.trimmed.lines)
+	}
+
+	paragraphs := e.layouter.Paragraphs
+	if len(paragraphs) == 0 {
+		return 0
+	}
+	idx := sort.Search(len(paragraphs), func(i int) bool {
+		return paragraphs[i].RuneOff >= e.trimmed.runes
+	})
+	if idx == len(paragraphs) {
+		return e.dims.Size.Y
+	}
+	return paragraphs[idx].StartY - paragraphs[0].StartY
+}
+
+// ScrollToEnd scrolls the viewport to the bottom of the text.
+func (e *TextView) ScrollToEnd() {
+	e.makeValid()
+	e.ensureRune(e.src.Len())
+	e.scrollAbs(e.scrollOff.X, e.dims.Size.Y)
+}
@@ -0,0 +1,139 @@
+package textview
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"strings"
+	"testing"
+
+	"gioui.org/layout"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"github.com/oligo/gvcode/buffer"
+)
+
+func TestAppendTrimLines(t *testing.T) {
+	lines := func(from, to int) string {
+		var sb strings.Builder
+		for i := from; i < to; i++ {
+			fmt.Fprintf(&sb, "line %d\n", i)
+		}
+		return sb.String()
+	}
+
+	gtx := layout.Context{
+		Constraints: layout.Exact(image.Pt(800, 600)),
+		Metric:      unit.Metric{PxPerDp: 1, PxPerSp: 1},
+	}
+	shaper := text.NewShaper()
+	setup := func(virtual bool, s string) *TextView {
+		vw := NewTextView()
+		vw.TextSize = 14
+		vw.TabWidth = 4
+		vw.SetVirtualLayout(virtual)
+		vw.SetText(s)
+		vw.Layout(gtx, shaper)
+		return vw
+	}
+
+	for _, virtual := range []bool{false, true} {
+		vw := setup(virtual, lines(0, 2000))
+		vw.ScrollRel(0, vw.FullDimensions().Size.Y/2)
+		vw.Layout(gtx, shaper)
+		top, _, _ := vw.QueryPos(image.Pt(0, 0))
+
+		// Append and trim several times between the layouts.
+		for i := 0; i < 3; i++ {
+			if n := vw.Append(lines(2000+i*100, 2100+i*100)); n != len(lines(2000+i*100, 2100+i*100)) {
+				t.Fatalf("virtual %v: want %d runes appended, got %d", virtual, len(lines(0, 100)), n)
+			}
+			vw.TrimLines(100)
+		}
+		vw.Layout(gtx, shaper)
+
+		want := lines(300, 2300)
+		var buf strings.Builder
+		vw.src.WriteTo(&buf)
+		if buf.String() != want {
+			t.Fatalf("virtual %v: unexpected text after appending and trimming", virtual)
+		}
+		if got := vw.FullDimensions().Size.Y; got != setup(virtual, want).FullDimensions().Size.Y {
+			t.Errorf("virtual %v: want the height of a new layout, got %d", virtual, got)
+		}
+		if line, _, _ := vw.QueryPos(image.Pt(0, 0)); line != top-300 {
+			t.Errorf("virtual %v: want line %d at the top of viewport, got %d", virtual, top-300, line)
+		}
+
+		vw.ScrollToEnd()
+		vw.Layout(gtx, shaper)
+		if b := vw.ScrollBounds(); vw.ScrollOff().Y != b.Max.Y {
+			t.Errorf("virtual %v: want scrolled to %d, got %d", virtual, b.Max.Y, vw.ScrollOff().Y)
+		}
+	}
+}
+
+// gatedReader blocks reading from offset limit until gate is closed, after
+// closing reached.
+type gatedReader struct {
+	r       io.ReaderAt
+	limit   int64
+	reached chan struct{}
+	gate    chan struct{}
+}
+
+func (g *gatedReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= g.limit {
+		select {
+		case <-g.reached:
+		default:
+			close(g.reached)
+		}
+		<-g.gate
+	}
+	return g.r.ReadAt(p, off)
+}
+
+func TestRefreshGrowingSource(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; sb.Len() < 200*1024; i++ {
+		fmt.Fprintf(&sb, "line %d\n", i)
+	}
+	content := sb.String()
+
+	gtx := layout.Context{
+		Constraints: layout.Exact(image.Pt(800, 600)),
+		Metric:      unit.Metric{PxPerDp: 1, PxPerSp: 1},
+	}
+	shaper := text.NewShaper()
+	newView := func(src buffer.TextSource) *TextView {
+		vw := NewTextView()
+		vw.TextSize = 14
+		vw.SetSource(src)
+		vw.Layout(gtx, shaper)
+		return vw
+	}
+
+	g := &gatedReader{r: strings.NewReader(content), limit: 1, reached: make(chan struct{}), gate: make(chan struct{})}
+	src := buffer.NewReadOnlySource(g, int64(len(content)))
+	vw := newView(src)
+	<-g.reached
+	vw.Layout(gtx, shaper)
+	n := src.Len()
+	if n == 0 || n == len(content) {
+		t.Fatalf("want the first block shown, got %d runes", n)
+	}
+	if got, want := vw.FullDimensions().Size.Y, newView(buffer.NewPieceTable([]byte(content[:n]))).FullDimensions().Size.Y; got != want {
+		t.Errorf("want the height %d of the first block, got %d", want, got)
+	}
+
+	close(g.gate)
+	<-src.Ready()
+	vw.Layout(gtx, shaper)
+	if src.Len() != len(content) {
+		t.Fatalf("want all the text shown, got %d runes", src.Len())
+	}
+	if got, want := vw.FullDimensions().Size.Y, newView(buffer.NewPieceTable([]byte(content))).FullDimensions().Size.Y; got != want {
+		t.Errorf("want the height %d of all the text, got %d", want, got)
+	}
+}
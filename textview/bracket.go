@@ -169,6 +169,10 @@ func (bq *bracketsQuotes) GetClosingQuote(r rune) (rune, bool) {
 	return bq.quotePairs.getClosing(r)
 }
 
+// maxBracketDistance is the maximum distance in runes from the caret to look
+// for the matching brackets, which keeps large documents responsive.
+const maxBracketDistance = 64 * 1024
+
 // NearestMatchingBrackets finds the nearest matching brackets of the caret.
 func (e *TextView) NearestMatchingBrackets() (left int, right int) {
 	left, right = -1, -1
@@ -232,7 +236,7 @@ func (e *TextView) NearestMatchingBrackets() (left int, right int) {
 				stack.push(next, offset)
 			}
 
-			if offset <= 0 {
+			if offset <= 0 || start-offset >= maxBracketDistance {
 				break
 			}
 		}
@@ -266,7 +270,7 @@ func (e *TextView) NearestMatchingBrackets() (left int, right int) {
 				}
 			}
 
-			if offset >= e.Len() {
+			if offset >= e.Len() || offset-start >= maxBracketDistance {
 				break
 			}
 
@@ -0,0 +1,313 @@
+package textview
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/oligo/gvcode/buffer"
+)
+
+// CaretCount returns the number of carets in the view, including the primary
+// caret.
+func (e *TextView) CaretCount() int {
+	return len(e.carets) + 1
+}
+
+// Carets returns the positions of all the carets, starting with the primary
+// caret. Start is the caret position and End is the other end of the selection.
+// Start can be > End.
+func (e *TextView) Carets() []buffer.CursorPos {
+	positions := make([]buffer.CursorPos, 0, e.CaretCount())
+	positions = append(positions, buffer.CursorPos{Start: e.caret.start, End: e.caret.end})
+	for _, c := range e.carets {
+		positions = append(positions, buffer.CursorPos{Start: c.start, End: c.end})
+	}
+	return positions
+}
+
+// AddCaret adds a caret at start, with the selection end set to end. The new
+// caret becomes the primary caret, and the previous primary caret is kept as a
+// secondary one. Carets that overlap are merged.
+func (e *TextView) AddCaret(start, end int) {
+	e.carets = append(e.carets, e.caret)
+	e.caret = caretPos{}
+	e.SetCaret(start, end)
+	e.mergeCarets()
+}
+
+// AddCaretCoord adds a caret at the position closest to the provided point,
+// as AddCaret does.
+func (e *TextView) AddCaretCoord(pos image.Point) {
+	e.carets = append(e.carets, e.caret)
+	e.caret = caretPos{}
+	e.MoveCoord(pos)
+	e.ClearSelection()
+	e.mergeCarets()
+}
+
+// AddCaretVertical adds a caret distance lines away from the top-most
+// (distance < 0) or the bottom-most (distance > 0) caret, keeping its
+// horizontal position. It reports whether a caret was added.
+func (e *TextView) AddCaretVertical(distance int) bool {
+	if distance == 0 {
+		return false
+	}
+
+	ref := e.caret
+	for _, c := range e.carets {
+		if (distance < 0 && c.start < ref.start) || (distance > 0 && c.start > ref.start) {
+			ref = c
+		}
+	}
+
+	refPos := e.closestToRune(ref.start)
+	x := refPos.X + ref.xoff
+	pos := e.closestToLineCol(refPos.LineCol.Line+distance, 0)
+	if pos.LineCol.Line == refPos.LineCol.Line {
+		return false
+	}
+	pos = e.closestToXYGraphemes(x, pos.Y)
+
+	e.carets = append(e.carets, e.caret)
+	e.caret = caretPos{start: pos.Runes, end: pos.Runes, xoff: x - pos.X}
+	e.mergeCarets()
+	return true
+}
+
+// AddNextOccurrence adds a caret selecting the next occurrence of the text
+// selected by the primary caret, searching forward and wrapping around at the
+// end of the document. If the primary caret has no selection, the word around
+// the caret is selected instead. It reports whether the carets changed.
+func (e *TextView) AddNextOccurrence() bool {
+	if e.caret.start == e.caret.end {
+		word, offset := e.ReadWord(false)
+		if word == "" {
+			return false
+		}
+		caret := max(e.caret.start, e.caret.end)
+		e.SetCaret(caret-offset+utf8.RuneCountInString(word), caret-offset)
+		return true
+	}
+
+	needle := e.SelectedText(nil)
+	caret := max(e.caret.start, e.caret.end)
+	from := e.src.RuneOffset(caret)
+	size := e.src.Size()
+
+	added := false
+	found := func(start, end int) bool {
+		if e.hasCaretAt(start, end) {
+			return false
+		}
+		e.AddCaret(end, start)
+		added = true
+		return true
+	}
+	if e.findText(needle, from, size, caret, found) {
+		return added
+	}
+	e.findText(needle, 0, min(from+len(needle), size), 0, found)
+	return added
+}
+
+// findSearchChunk is the size of the chunks of text read by findText.
+const findSearchChunk = 64 * 1024
+
+// findText searches the text in the byte range [start, end) for needle, which
+// is read in chunks instead of copying the document. runeStart is the rune
+// offset of start. fn is called with the rune range of each match until it
+// returns true, in which case findText returns true too.
+func (e *TextView) findText(needle []byte, start, end, runeStart int, fn func(start, end int) bool) bool {
+	if len(needle) == 0 || end-start < len(needle) {
+		return false
+	}
+
+	needleRunes := utf8.RuneCount(needle)
+	r := io.NewSectionReader(e.src, int64(start), int64(end-start))
+	buf := make([]byte, 0, findSearchChunk+len(needle))
+	// pos is where to search from in buf, and bufRune is the rune offset of
+	// buf[0].
+	pos, bufRune := 0, runeStart
+	for {
+		n, err := io.ReadFull(r, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+
+		counted, countedRunes := 0, 0
+		for {
+			idx := bytes.Index(buf[pos:], needle)
+			if idx < 0 {
+				break
+			}
+			idx += pos
+			countedRunes += utf8.RuneCount(buf[counted:idx])
+			counted = idx
+			if fn(bufRune+countedRunes, bufRune+countedRunes+needleRunes) {
+				return true
+			}
+			pos = idx + len(needle)
+		}
+		if err != nil {
+			return false
+		}
+
+		// Keep the tail which may start a match spanning the chunks, from
+		// the start of a rune.
+		keep := max(len(buf)-len(needle)+1, 0)
+		for keep > 0 && keep < len(buf) && !utf8.RuneStart(buf[keep]) {
+			keep--
+		}
+		bufRune += utf8.RuneCount(buf[:keep])
+		pos = max(pos-keep, 0)
+		buf = buf[:copy(buf, buf[keep:])]
+	}
+}
+
+// hasCaretAt checks if there is a caret selecting exactly [start, end).
+func (e *TextView) hasCaretAt(start, end int) bool {
+	match := func(c caretPos) bool {
+		return min(c.start, c.end) == start && max(c.start, c.end) == end
+	}
+
+	return match(e.caret) || slices.ContainsFunc(e.carets, match)
+}
+
+// ClearCarets removes all the secondary carets, leaving only the primary one.
+func (e *TextView) ClearCarets() {
+	e.carets = e.carets[:0]
+}
+
+// RestoreCarets replaces all the carets with the cursor positions returned by
+// Undo or Redo. The last position becomes the primary caret.
+func (e *TextView) RestoreCarets(positions []buffer.CursorPos) {
+	if len(positions) == 0 {
+		return
+	}
+
+	e.ClearCarets()
+	for i, pos := range positions {
+		if i > 0 {
+			e.carets = append(e.carets, e.caret)
+			e.caret = caretPos{}
+		}
+		e.SetCaret(pos.End, pos.Start)
+	}
+	e.mergeCarets()
+}
+
+// EachCaret calls fn once for every caret, with the caret temporarily installed
+// as the primary caret, so that all the single caret operations of TextView
+// apply to it. Carets are visited from the end of the document to the start, so
+// that an edit never shifts the carets not yet visited. Carets are merged when
+// they overlap after fn is applied.
+func (e *TextView) EachCaret(fn func()) {
+	if len(e.carets) == 0 {
+		fn()
+		return
+	}
+
+	e.carets = append(e.carets, e.caret)
+	primary := len(e.carets) - 1
+	order := make([]int, len(e.carets))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return caretStart(e.carets[b]) - caretStart(e.carets[a])
+	})
+
+	for _, i := range order {
+		e.caret = e.carets[i]
+		fn()
+		e.carets[i] = e.caret
+	}
+
+	e.caret = e.carets[primary]
+	e.carets = slices.Delete(e.carets, primary, primary+1)
+	e.mergeCarets()
+}
+
+// mergeCarets merges overlapping carets. A caret swallowing the primary caret
+// becomes the primary one.
+func (e *TextView) mergeCarets() {
+	if len(e.carets) == 0 {
+		return
+	}
+
+	type entry struct {
+		caretPos
+		primary bool
+	}
+
+	all := make([]entry, 0, len(e.carets)+1)
+	all = append(all, entry{caretPos: e.caret, primary: true})
+	for _, c := range e.carets {
+		all = append(all, entry{caretPos: c})
+	}
+	slices.SortStableFunc(all, func(a, b entry) int {
+		return caretStart(a.caretPos) - caretStart(b.caretPos)
+	})
+
+	merged := all[:1]
+	for _, c := range all[1:] {
+		last := &merged[len(merged)-1]
+		if !caretsOverlap(last.caretPos, c.caretPos) {
+			merged = append(merged, c)
+			continue
+		}
+
+		keep := last.caretPos
+		if c.primary {
+			keep = c.caretPos
+		}
+		start := caretStart(last.caretPos)
+		end := max(caretEnd(last.caretPos), caretEnd(c.caretPos))
+		if keep.start < keep.end {
+			// backward selection, the caret stays at the start.
+			last.caretPos = caretPos{start: start, end: end, xoff: keep.xoff}
+		} else {
+			last.caretPos = caretPos{start: end, end: start, xoff: keep.xoff}
+		}
+		last.primary = last.primary || c.primary
+	}
+
+	e.carets = e.carets[:0]
+	for _, c := range merged {
+		if c.primary {
+			e.caret = c.caretPos
+		} else {
+			e.carets = append(e.carets, c.caretPos)
+		}
+	}
+}
+
+// adjustCarets applies adjust to both ends of the secondary carets. It is used
+// to keep them in place after a text replacement.
+func (e *TextView) adjustCarets(adjust func(pos int) int) {
+	for i := range e.carets {
+		e.carets[i].start = adjust(e.carets[i].start)
+		e.carets[i].end = adjust(e.carets[i].end)
+	}
+}
+
+func caretStart(c caretPos) int {
+	return min(c.start, c.end)
+}
+
+func caretEnd(c caretPos) int {
+	return max(c.start, c.end)
+}
+
+// caretsOverlap checks if two carets overlap, a must not start after b.
+// Adjacent selections are not considered overlapping, while an empty caret
+// touching a selection is.
+func caretsOverlap(a, b caretPos) bool {
+	aStart, aEnd := caretStart(a), caretEnd(a)
+	bStart, bEnd := caretStart(b), caretEnd(b)
+	if bStart < aEnd || bStart == aStart {
+		return true
+	}
+	return bStart == aEnd && (aStart == aEnd || bStart == bEnd)
+}
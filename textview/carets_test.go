@@ -0,0 +1,227 @@
+package textview
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"gioui.org/layout"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"github.com/oligo/gvcode/buffer"
+)
+
+func TestEachCaret(t *testing.T) {
+	setup := func(input string, carets [][]int) *TextView {
+		vw := NewTextView()
+		vw.SetText(input)
+		vw.Layout(layout.Context{}, text.NewShaper())
+
+		for i, c := range carets {
+			if i == 0 {
+				vw.SetCaret(c[0], c[1])
+			} else {
+				vw.AddCaret(c[0], c[1])
+			}
+		}
+		return vw
+	}
+
+	cases := []struct {
+		input      string
+		carets     [][]int
+		insert     string
+		want       string
+		wantCarets []int
+	}{
+		{
+			input:      "abc\nabc\nabc",
+			carets:     [][]int{{0, 0}, {4, 4}, {8, 8}},
+			insert:     "x",
+			want:       "xabc\nxabc\nxabc",
+			wantCarets: []int{1, 6, 11},
+		},
+		{
+			input:      "abc\nabc\nabc",
+			carets:     [][]int{{8, 11}, {0, 3}},
+			insert:     "de",
+			want:       "de\nabc\nde",
+			wantCarets: []int{2, 9},
+		},
+		{
+			// overlapping carets are merged.
+			input:      "abcdef",
+			carets:     [][]int{{0, 3}, {2, 5}},
+			insert:     "x",
+			want:       "xf",
+			wantCarets: []int{1},
+		},
+		{
+			input:      "ab",
+			carets:     [][]int{{1, 1}, {1, 1}},
+			insert:     "x",
+			want:       "axb",
+			wantCarets: []int{2},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.input), func(t *testing.T) {
+			vw := setup(tc.input, tc.carets)
+			vw.EachCaret(func() {
+				start, end := vw.Selection()
+				n := vw.Replace(start, end, tc.insert)
+				start = min(start, end) + n
+				vw.SetCaret(start, start)
+			})
+
+			content := string(buffer.NewReader(vw.src).ReadAll(nil))
+			if content != tc.want {
+				t.Logf("want content: %q, actual content: %q", tc.want, content)
+				t.Fail()
+			}
+
+			carets := vw.Carets()
+			if len(carets) != len(tc.wantCarets) {
+				t.Fatalf("want %d carets, actual %d", len(tc.wantCarets), len(carets))
+			}
+			for _, want := range tc.wantCarets {
+				found := false
+				for _, c := range carets {
+					if c.Start == want && c.End == want {
+						found = true
+					}
+				}
+				if !found {
+					t.Logf("caret at %d not found in %v", want, carets)
+					t.Fail()
+				}
+			}
+		})
+	}
+}
+
+func TestAddNextOccurrence(t *testing.T) {
+	vw := NewTextView()
+	vw.SetText("foo bar foo baz foo")
+	vw.Layout(layout.Context{}, text.NewShaper())
+	vw.SetCaret(1, 1)
+
+	// select the word around the caret first.
+	if !vw.AddNextOccurrence() || vw.CaretCount() != 1 {
+		t.Fatal("expected the word at the caret to be selected")
+	}
+	if start, end := vw.Selection(); start != 3 || end != 0 {
+		t.Fatalf("unexpected selection: %d, %d", start, end)
+	}
+
+	for _, want := range []int{8, 16} {
+		if !vw.AddNextOccurrence() {
+			t.Fatalf("expected an occurrence at %d", want)
+		}
+		if start, end := vw.Selection(); start != want+3 || end != want {
+			t.Fatalf("want selection at %d, got: %d, %d", want, start, end)
+		}
+	}
+
+	if vw.AddNextOccurrence() {
+		t.Fatal("all occurrences are selected")
+	}
+	if vw.CaretCount() != 3 {
+		t.Fatalf("want 3 carets, got %d", vw.CaretCount())
+	}
+}
+
+func TestAddNextOccurrenceAcrossChunks(t *testing.T) {
+	// The occurrences span the chunks read by the search, after multi-byte
+	// runes, and the last one is found by wrapping around.
+	for _, pad := range []int{findSearchChunk - 2, findSearchChunk - 1, findSearchChunk} {
+		t.Run(fmt.Sprintf("pad-%d", pad), func(t *testing.T) {
+			filler := strings.Repeat("é", pad/2) + strings.Repeat("x", pad%2)
+			doc := "ñeedle " + filler + "ñeedle " + filler + "ñeedle"
+			vw := NewTextView()
+			vw.SetText(doc)
+			vw.Layout(layout.Context{}, text.NewShaper())
+			second := 7 + utf8.RuneCountInString(filler)
+			vw.SetCaret(second+6, second)
+
+			want := [][2]int{{2*second + 6, 2 * second}, {6, 0}}
+			for _, w := range want {
+				if !vw.AddNextOccurrence() {
+					t.Fatalf("expected an occurrence at %d", w[1])
+				}
+				if start, end := vw.Selection(); start != w[0] || end != w[1] {
+					t.Fatalf("want selection %v, got: %d, %d", w, start, end)
+				}
+			}
+			if vw.AddNextOccurrence() {
+				t.Fatal("all occurrences are selected")
+			}
+		})
+	}
+}
+
+func TestUndoRestoreCarets(t *testing.T) {
+	vw := NewTextView()
+	vw.SetText("abc\nabc")
+	vw.Layout(layout.Context{}, text.NewShaper())
+	vw.SetCaret(1, 1)
+	vw.AddCaret(5, 5)
+
+	vw.src.GroupOp()
+	vw.EachCaret(func() {
+		start, _ := vw.Selection()
+		vw.Replace(start, start, "x")
+	})
+	vw.src.UnGroupOp()
+
+	positions, ok := vw.Undo()
+	if !ok {
+		t.Fatal("undo failed")
+	}
+	vw.RestoreCarets(positions)
+
+	if vw.CaretCount() != 2 {
+		t.Fatalf("want 2 carets, got %d", vw.CaretCount())
+	}
+	for _, c := range vw.Carets() {
+		if c.Start != c.End || (c.Start != 1 && c.Start != 5) {
+			t.Fatalf("unexpected carets: %v", vw.Carets())
+		}
+	}
+}
+
+func TestColumnSelection(t *testing.T) {
+	vw := NewTextView()
+	vw.TabWidth = 4
+	vw.TextSize = unit.Sp(14)
+	vw.SetText("a\tb\n    efgh\na")
+	vw.Layout(layout.Context{}, text.NewShaper())
+	vw.SetCaret(2, 2)
+
+	vw.MoveColumnSelection(1, 0)
+	vw.MoveColumnSelection(0, 1)
+
+	if !vw.InColumnSelection() {
+		t.Fatal("expected a column selection")
+	}
+
+	want := []buffer.CursorPos{{Start: 9, End: 8}, {Start: 3, End: 2}}
+	if got := vw.Carets(); !slices.Equal(got, want) {
+		t.Fatalf("want carets: %v, got: %v", want, got)
+	}
+
+	// the last line is shorter than the block.
+	vw.MoveColumnSelection(0, 1)
+	want = []buffer.CursorPos{{Start: 14, End: 14}, {Start: 3, End: 2}, {Start: 9, End: 8}}
+	if got := vw.Carets(); !slices.Equal(got, want) {
+		t.Fatalf("want carets: %v, got: %v", want, got)
+	}
+
+	vw.ClearCarets()
+	if vw.InColumnSelection() {
+		t.Fatal("column selection should be dropped")
+	}
+}
@@ -0,0 +1,121 @@
+package textview
+
+import (
+	"image"
+	"slices"
+
+	"golang.org/x/image/math/fixed"
+)
+
+// columnSelection tracks a rectangular selection. The block spans the visual
+// lines between anchorLine and headLine, and the horizontal range between
+// anchorX and headX. The X coordinates are measured in the document space,
+// so the tab stops expanded by the layout are respected.
+type columnSelection struct {
+	anchorX    fixed.Int26_6
+	anchorLine int
+	headX      fixed.Int26_6
+	headLine   int
+	// carets built for the block. It is used to detect whether the carets
+	// were changed by other operations since the last update.
+	carets []caretPos
+}
+
+// StartColumnSelection starts a rectangular selection at the position closest
+// to the provided point.
+func (e *TextView) StartColumnSelection(pos image.Point) {
+	x, line := e.columnPoint(pos)
+	e.column = &columnSelection{anchorX: x, anchorLine: line, headX: x, headLine: line}
+	e.buildColumnCarets()
+}
+
+// ExtendColumnSelection moves the corner of the rectangular selection to the
+// position closest to the provided point. If there is no active rectangular
+// selection, one is started from the primary caret.
+func (e *TextView) ExtendColumnSelection(pos image.Point) {
+	e.ensureColumnSelection()
+	e.column.headX, e.column.headLine = e.columnPoint(pos)
+	e.buildColumnCarets()
+}
+
+// MoveColumnSelection moves the corner of the rectangular selection by columns
+// horizontally and by lines vertically. A column is as wide as a space glyph.
+// If there is no active rectangular selection, one is started from the primary
+// caret.
+func (e *TextView) MoveColumnSelection(columns, lines int) {
+	e.ensureColumnSelection()
+	e.column.headX = max(0, e.column.headX+e.layouter.SpaceWidth()*fixed.Int26_6(columns))
+	e.column.headLine = max(0, min(e.column.headLine+lines, e.layouter.LineCount()-1))
+	e.buildColumnCarets()
+}
+
+// InColumnSelection reports whether the carets are created by a rectangular
+// selection and have not been changed since.
+func (e *TextView) InColumnSelection() bool {
+	if e.column == nil {
+		return false
+	}
+
+	if len(e.column.carets) != e.CaretCount() {
+		return false
+	}
+	current := append([]caretPos{e.caret}, e.carets...)
+	for _, c := range current {
+		if !slices.ContainsFunc(e.column.carets, func(cc caretPos) bool {
+			return cc.start == c.start && cc.end == c.end
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *TextView) ensureColumnSelection() {
+	if e.InColumnSelection() {
+		return
+	}
+
+	caret := e.closestToRune(e.caret.start)
+	x := caret.X + e.caret.xoff
+	e.column = &columnSelection{
+		anchorX:    x,
+		anchorLine: caret.LineCol.Line,
+		headX:      x,
+		headLine:   caret.LineCol.Line,
+	}
+}
+
+// columnPoint converts a point in the viewport to the X coordinate and the
+// visual line in the document.
+func (e *TextView) columnPoint(pos image.Point) (fixed.Int26_6, int) {
+	x := fixed.I(max(0, pos.X+e.scrollOff.X))
+	y := pos.Y + e.scrollOff.Y
+	return x, e.closestToXY(x, y).LineCol.Line
+}
+
+// buildColumnCarets replaces all the carets with one caret per visual line
+// covered by the rectangular selection. The caret on the head line becomes
+// the primary caret.
+func (e *TextView) buildColumnCarets() {
+	col := e.column
+	firstLine, lastLine := min(col.anchorLine, col.headLine), max(col.anchorLine, col.headLine)
+
+	e.ClearCarets()
+	col.carets = col.carets[:0]
+	for line := firstLine; line <= lastLine; line++ {
+		lineStart := e.closestToLineCol(line, 0)
+		if lineStart.LineCol.Line != line {
+			continue
+		}
+		anchor := e.closestToXYGraphemes(col.anchorX, lineStart.Y)
+		head := e.closestToXYGraphemes(col.headX, lineStart.Y)
+		caret := caretPos{start: head.Runes, end: anchor.Runes, xoff: col.headX - head.X}
+		col.carets = append(col.carets, caret)
+
+		if line == col.headLine {
+			e.caret = caret
+		} else {
+			e.carets = append(e.carets, caret)
+		}
+	}
+}
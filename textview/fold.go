@@ -0,0 +1,310 @@
+package textview
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/oligo/gvcode/buffer"
+	lt "github.com/oligo/gvcode/internal/layout"
+)
+
+// FoldRange is a range of lines that can be folded. When folded, StartLine
+// stays visible as the placeholder of the range, and the lines after it, up to
+// and including EndLine, are hidden. Lines are counted from zero.
+type FoldRange struct {
+	StartLine int
+	EndLine   int
+}
+
+// FoldProvider computes the fold ranges of a document. It can be backed by the
+// indentation of the text, the syntax tree, or a language server.
+type FoldProvider interface {
+	// FoldRanges returns the fold ranges of the text read from r.
+	FoldRanges(r io.Reader) []FoldRange
+}
+
+// IndentFoldProvider computes fold ranges from the indentation of lines. A
+// range starts at a line followed by more indented lines, and ends at the last
+// of them. Blank lines do not start or end a range.
+type IndentFoldProvider struct {
+	// TabWidth is the number of columns of a tab character. If zero, 4 is used.
+	TabWidth int
+}
+
+func (p *IndentFoldProvider) FoldRanges(r io.Reader) []FoldRange {
+	tabWidth := p.TabWidth
+	if tabWidth <= 0 {
+		tabWidth = 4
+	}
+
+	type indentedLine struct {
+		line, indent int
+	}
+
+	var ranges []FoldRange
+	var stack []indentedLine
+	lastLine := -1
+	closeRanges := func(indent int) {
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if lastLine > top.line {
+				ranges = append(ranges, FoldRange{StartLine: top.line, EndLine: lastLine})
+			}
+		}
+	}
+
+	reader := bufio.NewReader(r)
+	for line := 0; ; line++ {
+		text, err := reader.ReadString('\n')
+		if strings.TrimSpace(text) != "" {
+			indent := 0
+		indentLoop:
+			for _, r := range text {
+				switch r {
+				case ' ':
+					indent++
+				case '\t':
+					indent = (indent/tabWidth + 1) * tabWidth
+				default:
+					break indentLoop
+				}
+			}
+
+			closeRanges(indent)
+			stack = append(stack, indentedLine{line: line, indent: indent})
+			lastLine = line
+		}
+
+		if err != nil {
+			break
+		}
+	}
+	closeRanges(0)
+
+	slices.SortFunc(ranges, func(a, b FoldRange) int {
+		return a.StartLine - b.StartLine
+	})
+	return ranges
+}
+
+// fold is a folded range anchored with markers, so that it follows the edits
+// of the text. start is in the placeholder line, and end is in the last hidden
+// line.
+type fold struct {
+	start, end *buffer.Marker
+}
+
+type foldState struct {
+	provider FoldProvider
+	// ranges is the fold ranges from the provider. It is computed again when
+	// dirty is set.
+	ranges []FoldRange
+	dirty  bool
+	folds  []fold
+}
+
+// SetFoldProvider sets the provider of the fold ranges. A nil provider disables
+// folding, and unfolds all the folded ranges.
+func (e *TextView) SetFoldProvider(provider FoldProvider) {
+	e.folding.provider = provider
+	e.folding.dirty = true
+	if provider == nil {
+		e.UnfoldAll()
+	}
+}
+
+// FoldProvider returns the provider of the fold ranges.
+func (e *TextView) FoldProvider() FoldProvider {
+	return e.folding.provider
+}
+
+// InvalidateFoldRanges marks the fold ranges as stale, so they are requested
+// from the provider again. Providers computing the ranges asynchronously, such
+// as a language server, should call this when new ranges are ready.
+func (e *TextView) InvalidateFoldRanges() {
+	e.folding.dirty = true
+}
+
+// FoldRanges returns the fold ranges of the text, sorted by the start line.
+func (e *TextView) FoldRanges() []FoldRange {
+	f := &e.folding
+	if f.provider == nil {
+		return nil
+	}
+	if f.dirty {
+		f.ranges = f.provider.FoldRanges(buffer.NewReader(e.src))
+		f.dirty = false
+	}
+	return f.ranges
+}
+
+// foldRangeAt returns the fold range starting at line.
+func (e *TextView) foldRangeAt(line int) (FoldRange, bool) {
+	ranges := e.FoldRanges()
+	idx := sort.Search(len(ranges), func(i int) bool { return ranges[i].StartLine >= line })
+	if idx < len(ranges) && ranges[idx].StartLine == line && ranges[idx].EndLine > line {
+		return ranges[idx], true
+	}
+	return FoldRange{}, false
+}
+
+// foldLines returns the line range of the fold, and whether the fold still
+// hides some lines.
+func (e *TextView) foldLines(f fold) (startLine, endLine int, ok bool) {
+	startLine = e.src.LineOf(f.start.Offset())
+	endLine = e.src.LineOf(f.end.Offset())
+	return startLine, endLine, endLine > startLine
+}
+
+// IsFolded reports whether the line is the placeholder of a folded range.
+func (e *TextView) IsFolded(line int) bool {
+	for _, f := range e.folding.folds {
+		if start, _, ok := e.foldLines(f); ok && start == line {
+			return true
+		}
+	}
+	return false
+}
+
+// FoldedRanges returns the line ranges currently folded.
+func (e *TextView) FoldedRanges() []FoldRange {
+	var ranges []FoldRange
+	for _, f := range e.folding.folds {
+		if start, end, ok := e.foldLines(f); ok {
+			ranges = append(ranges, FoldRange{StartLine: start, EndLine: end})
+		}
+	}
+	slices.SortFunc(ranges, func(a, b FoldRange) int { return a.StartLine - b.StartLine })
+	return ranges
+}
+
+// ToggleFold folds the fold range starting at line, or unfolds it if it is
+// folded. It reports whether the folding state is changed.
+func (e *TextView) ToggleFold(line int) bool {
+	for i, f := range e.folding.folds {
+		if start, _, ok := e.foldLines(f); ok && start == line {
+			e.removeFold(i)
+			e.invalidate()
+			return true
+		}
+	}
+
+	rng, ok := e.foldRangeAt(line)
+	if !ok {
+		return false
+	}
+	return e.Fold(rng)
+}
+
+// Fold hides the lines of the range after its start line. It reports whether
+// the range is folded.
+func (e *TextView) Fold(rng FoldRange) bool {
+	if rng.StartLine < 0 || rng.EndLine <= rng.StartLine || rng.EndLine >= max(1, e.src.Lines()) {
+		return false
+	}
+
+	// The end marker stays before the line break of the last hidden line, so
+	// text inserted after the fold does not go into it.
+	endOff := e.src.LineStart(rng.EndLine) + e.src.LineLength(rng.EndLine)
+	if r, err := e.src.ReadRuneAt(endOff - 1); err == nil && r == '\n' {
+		endOff--
+	}
+	start, err := e.src.CreateMarker(e.src.LineStart(rng.StartLine), buffer.BiasForward)
+	if err != nil {
+		return false
+	}
+	end, err := e.src.CreateMarker(endOff, buffer.BiasBackward)
+	if err != nil {
+		e.src.RemoveMarker(start)
+		return false
+	}
+	e.folding.folds = append(e.folding.folds, fold{start: start, end: end})
+
+	// Move the carets out of the hidden lines, to the end of the placeholder line.
+	hiddenStart := e.src.LineStart(rng.StartLine + 1)
+	hiddenEnd := e.src.LineStart(rng.EndLine + 1)
+	adjust := func(pos int) int {
+		if pos >= hiddenStart && pos < hiddenEnd {
+			return hiddenStart - 1
+		}
+		return pos
+	}
+	e.caret.start = adjust(e.caret.start)
+	e.caret.end = adjust(e.caret.end)
+	e.adjustCarets(adjust)
+	e.invalidate()
+	return true
+}
+
+// UnfoldAll unfolds all the folded ranges.
+func (e *TextView) UnfoldAll() {
+	if len(e.folding.folds) == 0 {
+		return
+	}
+	for len(e.folding.folds) > 0 {
+		e.removeFold(len(e.folding.folds) - 1)
+	}
+	e.invalidate()
+}
+
+// UnfoldRange unfolds the folded ranges hiding any text in the rune range
+// [start, end). It reports whether any range is unfolded.
+func (e *TextView) UnfoldRange(start, end int) bool {
+	if start > end {
+		start, end = end, start
+	}
+
+	unfolded := false
+	for i := len(e.folding.folds) - 1; i >= 0; i-- {
+		startLine, endLine, ok := e.foldLines(e.folding.folds[i])
+		hiddenStart, hiddenEnd := e.src.LineStart(startLine+1), e.src.LineStart(endLine+1)
+		if ok && start < hiddenEnd && end > hiddenStart {
+			e.removeFold(i)
+			unfolded = true
+		}
+	}
+	if unfolded {
+		e.invalidate()
+	}
+	return unfolded
+}
+
+func (e *TextView) removeFold(idx int) {
+	f := e.folding.folds[idx]
+	e.src.RemoveMarker(f.start)
+	e.src.RemoveMarker(f.end)
+	e.folding.folds = slices.Delete(e.folding.folds, idx, idx+1)
+}
+
+// hiddenFolds returns the rune ranges hidden by the folds, sorted and merged
+// for the layout. Folds no longer hiding any line after edits are dropped.
+func (e *TextView) hiddenFolds(folds []lt.Fold) []lt.Fold {
+	folds = folds[:0]
+	for i := len(e.folding.folds) - 1; i >= 0; i-- {
+		startLine, endLine, ok := e.foldLines(e.folding.folds[i])
+		if !ok {
+			e.removeFold(i)
+			continue
+		}
+		folds = append(folds, lt.Fold{Start: e.src.LineStart(startLine + 1), End: e.src.LineStart(endLine + 1)})
+	}
+	if len(folds) == 0 {
+		return folds
+	}
+
+	slices.SortFunc(folds, func(a, b lt.Fold) int { return a.Start - b.Start })
+	merged := folds[:1]
+	for _, f := range folds[1:] {
+		last := &merged[len(merged)-1]
+		if f.Start <= last.End {
+			last.End = max(last.End, f.End)
+			continue
+		}
+		merged = append(merged, f)
+	}
+	return merged
+}
@@ -0,0 +1,159 @@
+package textview
+
+import (
+	"image"
+	"sort"
+
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"golang.org/x/image/math/fixed"
+)
+
+// foldMarker is painted after the placeholder line of a folded range.
+const foldMarker = "⋯"
+
+// PaintFoldGutter paints a chevron next to the start line of each visible fold
+// range, pointing right if the range is folded and down otherwise. It returns
+// the dimensions of the gutter.
+func (e *TextView) PaintFoldGutter(gtx layout.Context, material op.CallOp) layout.Dimensions {
+	e.makeValid()
+	width := e.lineHeight.Ceil()
+	dims := layout.Dimensions{Size: image.Pt(width, e.viewSize.Y)}
+	if e.folding.provider == nil || len(e.layouter.Paragraphs) == 0 {
+		return dims
+	}
+
+	folded := make(map[int]bool)
+	for _, rng := range e.FoldedRanges() {
+		folded[rng.StartLine] = true
+	}
+
+	defer clip.Rect(image.Rectangle{Max: dims.Size}).Push(gtx.Ops).Pop()
+	paragraphs := e.layouter.Paragraphs
+	minY, maxY := e.scrollOff.Y-width, e.scrollOff.Y+e.viewSize.Y+width
+	idx := sort.Search(len(paragraphs), func(i int) bool { return paragraphs[i].StartY >= minY })
+	for _, p := range paragraphs[idx:] {
+		if p.StartY > maxY {
+			break
+		}
+
+		line := e.src.LineOf(p.RuneOff)
+		if _, ok := e.foldRangeAt(line); !ok && !folded[line] {
+			continue
+		}
+
+		pos := e.closestToRune(p.RuneOff)
+		center := f32.Pt(float32(width)/2, float32(p.StartY-e.scrollOff.Y)-float32(pos.Ascent.Ceil()-pos.Descent.Ceil())/2)
+		paintChevron(gtx, center, float32(width)/4, folded[line], material)
+	}
+
+	return dims
+}
+
+func paintChevron(gtx layout.Context, center f32.Point, size float32, pointRight bool, material op.CallOp) {
+	var path clip.Path
+	path.Begin(gtx.Ops)
+	if pointRight {
+		path.MoveTo(center.Add(f32.Pt(-size/2, -size)))
+		path.LineTo(center.Add(f32.Pt(size/2, 0)))
+		path.LineTo(center.Add(f32.Pt(-size/2, size)))
+	} else {
+		path.MoveTo(center.Add(f32.Pt(-size, -size/2)))
+		path.LineTo(center.Add(f32.Pt(0, size/2)))
+		path.LineTo(center.Add(f32.Pt(size, -size/2)))
+	}
+
+	stroke := clip.Stroke{
+		Path:  path.End(),
+		Width: float32(gtx.Dp(unit.Dp(1))),
+	}.Op().Push(gtx.Ops)
+	material.Add(gtx.Ops)
+	paint.PaintOp{}.Add(gtx.Ops)
+	stroke.Pop()
+}
+
+// ToggleFoldAt toggles the fold range of the line at y, which is relative to
+// the top of the viewport. It is used to handle clicks in the fold gutter, and
+// reports whether the folding state is changed.
+func (e *TextView) ToggleFoldAt(y int) bool {
+	if e.folding.provider == nil {
+		return false
+	}
+
+	pos := e.closestToXY(0, y+e.scrollOff.Y)
+	if y+e.scrollOff.Y > pos.Y+pos.Descent.Ceil() {
+		// below the last line.
+		return false
+	}
+	_, p := e.FindParagraph(pos.Runes)
+	return e.ToggleFold(e.src.LineOf(p.RuneOff))
+}
+
+// PaintFoldMarkers paints a "⋯" marker after the placeholder line of each
+// visible folded range, using material to fill the marker.
+func (e *TextView) PaintFoldMarkers(gtx layout.Context, material op.CallOp) {
+	if len(e.layouter.Folds) == 0 || e.shaper == nil {
+		return
+	}
+
+	params := e.params
+	params.Alignment = text.Start
+	params.MinWidth = 0
+	params.MaxWidth = 1e6
+	params.MaxLines = 1
+
+	defer clip.Rect(image.Rectangle{Max: e.viewSize}).Push(gtx.Ops).Pop()
+	glyphs := make([]text.Glyph, 0, 1)
+	for _, f := range e.layouter.Folds {
+		// The line break of the placeholder line is right before the fold.
+		if !e.layouter.RuneInWindow(f.Start - 1) {
+			continue
+		}
+		pos := e.closestToRune(f.Start - 1)
+		if pos.Y+pos.Descent.Ceil() < e.scrollOff.Y || pos.Y-pos.Ascent.Ceil() > e.scrollOff.Y+e.viewSize.Y {
+			continue
+		}
+
+		e.shaper.LayoutString(params, foldMarker)
+		glyphs = glyphs[:0]
+		for {
+			g, ok := e.shaper.NextGlyph()
+			if !ok {
+				break
+			}
+			glyphs = append(glyphs, g)
+		}
+		if len(glyphs) == 0 {
+			continue
+		}
+
+		x := pos.X + e.layouter.SpaceWidth()
+		trans := op.Affine(f32.Affine2D{}.Offset(
+			f32.Pt(fixedToFloat(x)+float32(glyphs[0].X.Floor()), float32(pos.Y)).Sub(layout.FPt(e.scrollOff))),
+		).Push(gtx.Ops)
+		outline := clip.Outline{Path: e.shaper.Shape(glyphs)}.Op().Push(gtx.Ops)
+		material.Add(gtx.Ops)
+		paint.PaintOp{}.Add(gtx.Ops)
+		outline.Pop()
+
+		var advance fixed.Int26_6
+		for _, g := range glyphs {
+			advance += g.Advance
+		}
+		// The box around the marker, relative to its baseline.
+		box := image.Rect(0, -pos.Ascent.Ceil(), advance.Ceil(), pos.Descent.Ceil())
+		border := clip.Stroke{
+			Path:  clip.UniformRRect(box, gtx.Dp(unit.Dp(2))).Path(gtx.Ops),
+			Width: float32(gtx.Dp(unit.Dp(1))),
+		}.Op().Push(gtx.Ops)
+		material.Add(gtx.Ops)
+		paint.PaintOp{}.Add(gtx.Ops)
+		border.Pop()
+		trans.Pop()
+	}
+}
@@ -0,0 +1,103 @@
+package textview
+
+import (
+	"image"
+	"slices"
+	"strings"
+	"testing"
+
+	"gioui.org/layout"
+	"gioui.org/text"
+	"gioui.org/unit"
+)
+
+func TestIndentFoldRanges(t *testing.T) {
+	cases := []struct {
+		input string
+		want  []FoldRange
+	}{
+		{
+			input: "a\nb\nc",
+			want:  nil,
+		},
+		{
+			input: "func a() {\n\tb\n\tc\n}\n",
+			want:  []FoldRange{{0, 2}},
+		},
+		{
+			// blank lines are ignored.
+			input: "def a():\n    b\n\n    if c:\n        d\n\n\ne\n",
+			want:  []FoldRange{{0, 4}, {3, 4}},
+		},
+		{
+			input: "a\n  b\n  c\n    d\n",
+			want:  []FoldRange{{0, 3}, {2, 3}},
+		},
+	}
+
+	provider := &IndentFoldProvider{TabWidth: 4}
+	for i, tc := range cases {
+		got := provider.FoldRanges(strings.NewReader(tc.input))
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("#%d: want %v, got %v", i, tc.want, got)
+		}
+	}
+}
+
+func TestToggleFold(t *testing.T) {
+	input := "func a() {\n\tb\n\tc\n}\nd\n"
+	vw := NewTextView()
+	vw.TextSize = unit.Sp(14)
+	vw.TabWidth = 4
+	vw.SetFoldProvider(&IndentFoldProvider{})
+	vw.SetText(input)
+
+	gtx := layout.Context{Constraints: layout.Exact(image.Pt(800, 600))}
+	shaper := text.NewShaper()
+	vw.Layout(gtx, shaper)
+
+	// The caret in the hidden lines moves to the end of the placeholder line.
+	vw.SetCaret(13, 13)
+	if !vw.ToggleFold(0) || !vw.IsFolded(0) {
+		t.Fatalf("line 0 is not folded")
+	}
+	vw.Layout(gtx, shaper)
+	if start, _ := vw.Selection(); start != 10 {
+		t.Fatalf("want caret at 10, got %d", start)
+	}
+	if vw.Paragraphs() != 6 {
+		t.Fatalf("want 6 paragraphs, got %d", vw.Paragraphs())
+	}
+
+	// Caret movements skip the hidden lines.
+	vw.MoveCaret(1, 1)
+	if start, _ := vw.Selection(); start != 17 {
+		t.Fatalf("want caret at 17 after moving right, got %d", start)
+	}
+	vw.MoveLines(-1, SelectionClear)
+	if line, _ := vw.CaretPos(); line != 0 {
+		t.Fatalf("want caret at line 0 after moving up, got %d", line)
+	}
+	vw.MoveLines(1, SelectionClear)
+	if line, _ := vw.CaretPos(); line != 3 {
+		t.Fatalf("want caret at line 3 after moving down, got %d", line)
+	}
+
+	// The fold follows the edits.
+	vw.Replace(0, 0, "// a\n")
+	vw.Layout(gtx, shaper)
+	if vw.IsFolded(0) || !vw.IsFolded(1) {
+		t.Fatalf("want line 1 folded, got %v", vw.FoldedRanges())
+	}
+	if got := vw.FoldedRanges(); !slices.Equal(got, []FoldRange{{1, 3}}) {
+		t.Fatalf("want folded ranges [{1 3}], got %v", got)
+	}
+
+	if !vw.UnfoldRange(17, 17) {
+		t.Fatalf("range in the hidden lines is not unfolded")
+	}
+	vw.Layout(gtx, shaper)
+	if len(vw.FoldedRanges()) != 0 || len(vw.layouter.Lines) != 7 {
+		t.Fatalf("want all lines shown, got %d", len(vw.layouter.Lines))
+	}
+}
@@ -10,7 +10,7 @@ import (
 // Paragraphs returns the total number of rendered paragraphs(or logical lines).
 func (e *TextView) Paragraphs() int {
 	e.makeValid()
-	return len(e.layouter.Paragraphs)
+	return e.layouter.ParagraphCount()
 }
 
 // find a paragraph by rune index, returning the line number(starting from zero)
@@ -19,6 +19,7 @@ func (e *TextView) FindParagraph(runeIdx int) (int, lt.Paragraph) {
 	if len(e.layouter.Paragraphs) == 0 {
 		return 0, lt.Paragraph{}
 	}
+	first := e.layouter.FirstParagraph()
 
 	idx := sort.Search(len(e.layouter.Paragraphs), func(i int) bool {
 		rng := e.layouter.Paragraphs[i]
@@ -27,102 +28,67 @@ func (e *TextView) FindParagraph(runeIdx int) (int, lt.Paragraph) {
 
 	// No exsiting paragraph found.
 	if idx == len(e.layouter.Paragraphs) {
-		return idx - 1, e.layouter.Paragraphs[idx-1]
+		idx--
 	}
 
-	return idx, e.layouter.Paragraphs[idx]
+	p := e.layouter.Paragraphs[idx]
+	if len(e.layouter.Folds) > 0 {
+		// Folded paragraphs are not in the layout.
+		return e.src.LineOf(p.RuneOff), p
+	}
+	return first + idx, p
 }
 
 // ConvertPos convert a line/col position to rune offset.
 // line is counted by paragrah, and col is counted by rune.
 func (e *TextView) ConvertPos(line, col int) int {
-	e.makeValid()
-	if line < 0 || len(e.layouter.Paragraphs) == 0 {
+	if line < 0 {
 		return 0
 	}
 
-	if line >= len(e.layouter.Paragraphs) {
-		p := e.layouter.Paragraphs[len(e.layouter.Paragraphs)-1]
-		return p.RuneOff + p.Runes
-	}
-
-	p := e.layouter.Paragraphs[line]
-	runeOff := min(p.RuneOff+col, p.RuneOff+p.Runes)
+	lineStart := e.src.LineStart(line)
+	runeOff := min(lineStart+col, lineStart+e.src.LineLength(line))
 	// Ensures that the final positions are on grapheme cluster boundaries.
+	e.makeValid()
 	return e.moveByGraphemes(runeOff, 0)
 }
 
-// selectedParagraphs returns the paragraphs that the carent selection covers.
-// If there's no selection, it returns the paragraph that the caret is in.
-func (e *TextView) selectedParagraphs() []lt.Paragraph {
-	if len(e.layouter.Paragraphs) <= 0 {
-		return nil
-	}
-
-	selections := make([]lt.Paragraph, 0)
-
+// selectedLines returns the range of paragraphs that the caret selection
+// covers, including the end line. If there's no selection, it returns the
+// paragraph that the caret is in.
+func (e *TextView) selectedLines() (startLine, endLine int) {
 	caretStart := min(e.caret.start, e.caret.end)
 	caretEnd := max(e.caret.start, e.caret.end)
 
-	startIdx := sort.Search(len(e.layouter.Paragraphs), func(i int) bool {
-		rng := e.layouter.Paragraphs[i]
-		return rng.EndY >= e.closestToRune(caretStart).Y
-	})
-
-	// No exsiting paragraph found.
-	if startIdx == len(e.layouter.Paragraphs) {
-		return selections
-	}
-	selections = append(selections, e.layouter.Paragraphs[startIdx])
-
+	startLine = e.src.LineOf(caretStart)
+	endLine = startLine
 	if caretStart != caretEnd {
-		endIdx := sort.Search(len(e.layouter.Paragraphs), func(i int) bool {
-			rng := e.layouter.Paragraphs[i]
-			return rng.EndY >= e.closestToRune(caretEnd).Y
-		})
-
-		if endIdx == len(e.layouter.Paragraphs) {
-			return selections
-		}
-
-		for i := startIdx + 1; i <= endIdx; i++ {
-			p := e.layouter.Paragraphs[i]
-			if i == endIdx && p.RuneOff == caretEnd {
-				// skip the last empty-selection line as it indicates we are at the end
-				// of the previous line.
-				break
-			}
-			selections = append(selections, e.layouter.Paragraphs[i])
+		endLine = e.src.LineOf(caretEnd)
+		if endLine > startLine && e.src.LineStart(endLine) == caretEnd {
+			// skip the last empty-selection line as it indicates we are at the end
+			// of the previous line.
+			endLine--
 		}
 	}
 
-	return selections
-
+	return
 }
 
 // SelectedLineRange returns the start and end rune index of the paragraphs selected by the caret.
 // If there is no selection, the range of current paragraph the caret is in is returned.
 func (e *TextView) SelectedLineRange() (start, end int) {
-	paragraphs := e.selectedParagraphs()
-	if len(paragraphs) == 0 {
-		return
-	}
-
-	last := paragraphs[len(paragraphs)-1]
-	return paragraphs[0].RuneOff, last.RuneOff + last.Runes
+	startLine, endLine := e.selectedLines()
+	return e.src.LineStart(startLine), e.src.LineStart(endLine) + e.src.LineLength(endLine)
 }
 
 // SelectedLine returns the text of the selected lines and the rune range. An empty selection is treated
 // as a single line selection.
 func (e *TextView) SelectedLineText(buf []byte) ([]byte, int, int) {
-	paragraphs := e.selectedParagraphs()
-	if len(paragraphs) == 0 {
-		return buf[:0], 0, 0
+	start, end := e.SelectedLineRange()
+	if start == end {
+		return buf[:0], start, end
 	}
 
-	start := paragraphs[0].RuneOff
-	end := paragraphs[len(paragraphs)-1].RuneOff + paragraphs[len(paragraphs)-1].Runes
-
 	startOff := e.src.RuneOffset(start)
 	endOff := e.src.RuneOffset(end)
 
@@ -140,28 +106,29 @@ func (e *TextView) PartialLineSelected() bool {
 		return false
 	}
 
-	paragraphs := e.selectedParagraphs()
-	if len(paragraphs) > 1 {
+	startLine, endLine := e.selectedLines()
+	if endLine > startLine {
 		return false
 	}
 
 	caretStart := min(e.caret.start, e.caret.end)
 	caretEnd := max(e.caret.start, e.caret.end)
-	p := paragraphs[0]
+	lineStart := e.src.LineStart(startLine)
+	lineEnd := lineStart + e.src.LineLength(startLine)
 
-	if p.RuneOff != caretStart {
+	if lineStart != caretStart {
 		return true
 	}
 
-	lastRune, err := e.src.ReadRuneAt(p.RuneOff + p.Runes - 1)
+	lastRune, err := e.src.ReadRuneAt(lineEnd - 1)
 	if err != nil {
 		// TODO: how to handle the read error?
 	}
 
 	if lastRune == '\n' {
-		return p.RuneOff+p.Runes != caretEnd+1
+		return lineEnd != caretEnd+1
 	} else {
-		return p.RuneOff+p.Runes != caretEnd
+		return lineEnd != caretEnd
 	}
 }
 
@@ -178,12 +145,7 @@ func (e *TextView) expandTab(start, end int, s string) string {
 		start = end
 	}
 
-	_, p := e.FindParagraph(start)
-	if p == (lt.Paragraph{}) {
-		return strings.Repeat(" ", e.TabWidth)
-	}
-
-	advance := start - p.RuneOff
+	advance := start - e.src.LineStart(e.src.LineOf(start))
 	nextTabStop := (advance/e.TabWidth + 1) * e.TabWidth
 	spaces := nextTabStop - advance
 
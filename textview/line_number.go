@@ -14,13 +14,15 @@ import (
 	"golang.org/x/image/math/fixed"
 )
 
-func paintLineNumber(gtx layout.Context, shaper *text.Shaper, params text.Parameters, viewport image.Rectangle, paragraphs *[]lt.Paragraph, textMaterial op.CallOp) layout.Dimensions {
+// paintLineNumber paints the line numbers of the visible paragraphs. lineOf
+// returns the line number, counted from zero, of the paragraph at the index.
+func paintLineNumber(gtx layout.Context, shaper *text.Shaper, params text.Parameters, viewport image.Rectangle, paragraphs *[]lt.Paragraph, lineOf func(idx int) int, lineCount int, textMaterial op.CallOp) layout.Dimensions {
 	// inherit all other settings from the main text layout.
 	params.Alignment = text.End
 	params.MinWidth = 0
 	params.MaxLines = 1
 
-	maxWidth := getMaxLineNumWidth(shaper, params, len(*paragraphs))
+	maxWidth := getMaxLineNumWidth(shaper, params, lineCount)
 	params.MinWidth = maxWidth.Ceil()
 
 	var dims layout.Dimensions
@@ -33,7 +35,7 @@ lineLoop:
 			break
 		}
 
-		shaper.LayoutString(params, strconv.Itoa(i+1))
+		shaper.LayoutString(params, strconv.Itoa(lineOf(i)+1))
 		glyphs = glyphs[:0]
 
 		var bounds image.Rectangle
@@ -1,6 +1,9 @@
 package textview
 
 import (
+	"unicode/utf8"
+
+	"github.com/oligo/gvcode/buffer"
 	"github.com/oligo/gvcode/textstyle/decoration"
 	"github.com/oligo/gvcode/textstyle/syntax"
 )
@@ -25,10 +28,34 @@ func (e *TextView) ClearDecorations(source string) error {
 	}
 }
 
+// ClearDecorationsInRange removes the decorations of source overlapping the
+// range [start, end) of rune offsets.
+func (e *TextView) ClearDecorationsInRange(source string, start, end int) error {
+	if e.decorations == nil {
+		panic("TextView is not properly initialized.")
+	}
+
+	return e.decorations.RemoveRange(source, start, end)
+}
+
 func (e *TextView) SetColorScheme(scheme *syntax.ColorScheme) {
 	e.syntaxStyles = syntax.NewTextTokens(scheme)
 }
 
+// onTextChange keeps the syntax tokens on the same text after the change, until
+// they are set again.
+func (e *TextView) onTextChange(c buffer.TextChange) {
+	if e.syntaxStyles != nil {
+		e.syntaxStyles.Edit(c.Start, c.Start+c.RemovedRunes, c.Start+utf8.RuneCountInString(c.Text))
+	}
+}
+
+// SyntaxTokens returns the syntax tokens styling the text, or nil if no color
+// scheme is set.
+func (e *TextView) SyntaxTokens() *syntax.TextTokens {
+	return e.syntaxStyles
+}
+
 func (e *TextView) SetSyntaxTokens(tokens ...syntax.Token) {
 	if e.syntaxStyles == nil {
 		panic("TextView is not properly initialized.")
@@ -0,0 +1,60 @@
+package textview
+
+import (
+	"image"
+	"testing"
+
+	"gioui.org/layout"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"github.com/oligo/gvcode/color"
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+func TestSyntaxTokensFollowEdits(t *testing.T) {
+	scheme := &syntax.ColorScheme{}
+	scheme.AddStyle("keyword", syntax.Bold, color.Color{}, color.Color{})
+
+	gtx := layout.Context{
+		Constraints: layout.Exact(image.Pt(800, 600)),
+		Metric:      unit.Metric{PxPerDp: 1, PxPerSp: 1},
+	}
+	vw := NewTextView()
+	vw.TextSize = 14
+	vw.TabWidth = 4
+	vw.SetText("func main() {}\nfunc init() {}\n")
+	vw.Layout(gtx, text.NewShaper())
+	vw.SetColorScheme(scheme)
+	vw.SetSyntaxTokens(syntax.Token{Scope: "keyword", Start: 0, End: 4}, syntax.Token{Scope: "keyword", Start: 15, End: 19})
+
+	starts := func() []int {
+		var starts []int
+		for _, token := range vw.SyntaxTokens().QueryRange(0, vw.Source().Len()) {
+			starts = append(starts, token.Start)
+		}
+		return starts
+	}
+	check := func(name string, want ...int) {
+		t.Helper()
+		got := starts()
+		if len(got) != len(want) {
+			t.Fatalf("%s: want tokens at %v, got %v", name, want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s: want tokens at %v, got %v", name, want, got)
+			}
+		}
+	}
+
+	vw.Replace(5, 5, "x")
+	check("insert", 0, 16)
+	vw.Undo()
+	check("undo", 0, 15)
+	vw.Redo()
+	check("redo", 0, 16)
+	vw.Replace(0, 4, "")
+	check("remove a token", 12)
+	vw.SetText("")
+	check("set text")
+}
@@ -2,6 +2,7 @@ package textview
 
 import (
 	"image"
+	"io"
 	"math"
 	"unicode/utf8"
 
@@ -10,7 +11,7 @@ import (
 	"gioui.org/layout"
 	"gioui.org/text"
 	"gioui.org/unit"
-	"github.com/oligo/gvcode/internal/buffer"
+	"github.com/oligo/gvcode/buffer"
 	lt "github.com/oligo/gvcode/internal/layout"
 	"github.com/oligo/gvcode/internal/painter"
 	"github.com/oligo/gvcode/textstyle/decoration"
@@ -55,6 +56,9 @@ type TextView struct {
 
 	// CaretWidth set the visual width of a caret.
 	CaretWidth unit.Dp
+	// BlockCaret draws the caret as a block covering the character after it,
+	// as in the normal mode of Vim.
+	BlockCaret bool
 
 	// SoftTab controls the behaviour when user try to insert a Tab character.
 	// If set to true, the editor will insert the amount of space characters specified by
@@ -69,6 +73,10 @@ type TextView struct {
 	// WrapLine configures whether the displayed text will be broken into lines or not.
 	WrapLine bool
 
+	// VirtualLayout configures whether to shape only the text near the viewport,
+	// instead of the whole document.
+	VirtualLayout bool
+
 	// WordSeperators configures a set of characters that will be used as word separators
 	// when doing word related operations, like navigating or deleting by word.
 	WordSeperators string
@@ -97,27 +105,48 @@ type TextView struct {
 
 	// The layout is valid or not. Invalid layout requires a re-layout.
 	valid bool
+	// edit tracks the text changed since the last layout, so that only the
+	// changed paragraphs are laid out again. It is nil if the whole text
+	// needs a re-layout.
+	edit *textEdit
+	// trimmed tracks the text trimmed from the start since the last layout,
+	// which is scrolled out of the viewport with the layout.
+	trimmed trimState
 	// caret position in the view.
-	caret   caretPos
+	caret caretPos
+	// carets holds the secondary carets when editing with multiple cursors.
+	carets []caretPos
+	// column tracks the rectangular selection, if there is one.
+	column *columnSelection
+	// folding tracks the fold ranges and the folded ones.
+	folding foldState
 	regions []Region
 	// line buffer for line related operations.
 	lineBuf []byte
+	// unsubscribe cancels the subscription to the changes of src.
+	unsubscribe func()
 }
 
 func NewTextView() *TextView {
 	e := TextView{}
-	e.setSource(buffer.NewTextSource())
+	e.SetSource(buffer.NewTextSource())
 	return &e
 }
 
-// SetSource initializes the underlying data source for the Text. This
-// must be done before invoking any other methods on Text.
-func (e *TextView) setSource(source buffer.TextSource) {
+// SetSource replaces the underlying data source of the text. The carets,
+// folds and decorations bound to the old source are cleared.
+func (e *TextView) SetSource(source buffer.TextSource) {
+	if e.unsubscribe != nil {
+		e.unsubscribe()
+	}
 	e.src = source
+	e.unsubscribe = source.Subscribe(e.onTextChange)
 	e.layouter = lt.NewTextLayout(e.src)
-	e.BracketsQuotes = &bracketsQuotes{}
+	if e.BracketsQuotes == nil {
+		e.BracketsQuotes = &bracketsQuotes{}
+	}
 	e.decorations = decoration.NewDecorationTree(e.src)
-	e.invalidate()
+	e.resetText()
 }
 
 func (e *TextView) Source() buffer.TextSource {
@@ -152,23 +181,47 @@ func (e *TextView) makeValid() {
 	if e.valid {
 		return
 	}
-	e.layoutText(e.shaper)
+	edit := e.edit
+	e.edit = nil
+	if e.VirtualLayout && e.shaper == nil {
+		// Leave the layout to the first frame, which shapes only the text
+		// around the viewport, rather than laying out the whole text here.
+		return
+	}
+	if e.trimmed.lines > 0 {
+		e.scrollOff.Y = max(0, e.scrollOff.Y-e.trimmedHeight())
+		e.trimmed = trimState{}
+	}
+	e.layouter.Folds = e.hiddenFolds(e.layouter.Folds)
+	if e.virtualized() {
+		paragraph, _ := e.layouter.ParagraphAtY(e.scrollOff.Y)
+		e.layoutWindow(paragraph)
+		return
+	}
+	if edit != nil {
+		e.dims = e.layouter.Update(e.shaper, &e.params, e.TabWidth, e.WrapLine, edit.start, edit.end-edit.delta, edit.end)
+	} else {
+		e.layoutText(e.shaper)
+	}
 	e.valid = true
 }
 
 func (e *TextView) closestToRune(runeIdx int) lt.CombinedPos {
 	e.makeValid()
+	e.ensureRune(runeIdx)
 	pos, _ := e.layouter.ClosestToRune(runeIdx)
 	return pos
 }
 
 func (e *TextView) closestToLineCol(line, col int) lt.CombinedPos {
 	e.makeValid()
+	line = e.ensureLine(line)
 	return e.layouter.ClosestToLineCol(lt.ScreenPos{Line: line, Col: col})
 }
 
 func (e *TextView) closestToXY(x fixed.Int26_6, y int) lt.CombinedPos {
 	e.makeValid()
+	y = e.ensureY(y)
 	return e.layouter.ClosestToXY(x, y)
 }
 
@@ -210,6 +263,7 @@ func (e *TextView) MoveLines(distance int, selAct SelectionAction) {
 // Layout the text, reshaping it as necessary.
 func (e *TextView) Layout(gtx layout.Context, lt *text.Shaper) {
 	e.params.DisableSpaceTrim = true
+	e.refreshSource(gtx)
 
 	if e.params.Locale != gtx.Locale {
 		e.params.Locale = gtx.Locale
@@ -267,6 +321,7 @@ func (e *TextView) Layout(gtx layout.Context, lt *text.Shaper) {
 	}
 
 	e.makeValid()
+	e.ensureViewport()
 }
 
 // Calculate line height. Maybe there's a better way?
@@ -300,6 +355,9 @@ func (e *TextView) ReadRuneAt(runeOff int) (rune, error) {
 // Len is the length of the editor contents, in runes.
 func (e *TextView) Len() int {
 	e.makeValid()
+	if e.VirtualLayout || len(e.layouter.Folds) > 0 {
+		return e.src.Len()
+	}
 	return e.closestToRune(math.MaxInt).Runes
 }
 
@@ -332,6 +390,7 @@ func (e *TextView) scrollAbs(x, y int) {
 	if e.scrollOff.Y < b.Min.Y {
 		e.scrollOff.Y = b.Min.Y
 	}
+	e.ensureViewport()
 }
 
 // MoveCoord moves the caret to the position closest to the provided
@@ -345,8 +404,8 @@ func (e *TextView) MoveCoord(pos image.Point) {
 
 // CaretPos returns the line & column numbers of the caret.
 func (e *TextView) CaretPos() (line, col int) {
-	line, p := e.FindParagraph(e.caret.start)
-	return line, e.caret.start - p.RuneOff
+	line = e.src.LineOf(e.caret.start)
+	return line, e.caret.start - e.src.LineStart(line)
 }
 
 // CaretCoords returns the coordinates of the caret, relative to the
@@ -382,16 +441,66 @@ func (e *TextView) QueryPos(pos image.Point) (line, col int, runeOff int) {
 // invalidate mark the layout as invalid.
 func (e *TextView) invalidate() {
 	e.valid = false
+	e.edit = nil
+}
+
+// textEdit is the range of text changed since the last layout.
+type textEdit struct {
+	// start and end are the changed rune range in the current text.
+	start, end int
+	// delta is the change of the text length.
+	delta int
+}
+
+// invalidateRange marks the layout as invalid after the text in [start, oldEnd)
+// is replaced by the text now in [start, newEnd).
+func (e *TextView) invalidateRange(start, oldEnd, newEnd int) {
+	switch {
+	case e.valid:
+		e.edit = &textEdit{start: start, end: newEnd, delta: newEnd - oldEnd}
+	case e.edit != nil:
+		// Merge with the pending edit.
+		end := e.edit.end
+		if end >= oldEnd {
+			end += newEnd - oldEnd
+		} else if end > start {
+			end = newEnd
+		}
+		e.edit.start = min(e.edit.start, start)
+		e.edit.end = max(end, newEnd)
+		e.edit.delta += newEnd - oldEnd
+	}
+	e.valid = false
 }
 
 // Set the text of the buffer. It returns the number of runes inserted.
 func (e *TextView) SetText(s string) int {
 	e.src.SetText([]byte(s))
-	sc := e.src.Len()
+	e.resetText()
+	return e.src.Len()
+}
 
-	// e.SetCaret(0, 0)
+// ReadFrom sets the text of the buffer to the text read from r until EOF,
+// without copying it to a string first. It implements [io.ReaderFrom].
+func (e *TextView) ReadFrom(r io.Reader) (int64, error) {
+	n, err := e.src.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+	e.resetText()
+	return n, nil
+}
+
+// resetText resets the states bound to the old text after the text is set.
+func (e *TextView) resetText() {
+	// markers are cleared with the old text.
+	e.folding.folds = e.folding.folds[:0]
+	e.folding.dirty = true
+
+	e.ClearCarets()
+	e.caret.start, e.caret.end = 0, 0
+	e.trimmed = trimState{}
 	e.invalidate()
-	return sc
 }
 
 // Replace the text between start and end with s. Indices are in runes.
@@ -420,7 +529,9 @@ func (e *TextView) Replace(start, end int, s string) int {
 	}
 	e.caret.start = adjust(e.caret.start)
 	e.caret.end = adjust(e.caret.end)
-	e.invalidate()
+	e.adjustCarets(adjust)
+	e.invalidateRange(startOff, endPos.Runes, newEnd)
+	e.folding.dirty = true
 	return sc
 }
 
@@ -439,11 +550,27 @@ func (e *TextView) MovePages(pages int, selAct SelectionAction) {
 // moveByGraphemes returns the rune index resulting from moving the
 // specified number of grapheme clusters from startRuneidx.
 func (e *TextView) moveByGraphemes(startRuneIdx, graphemes int) int {
+	e.ensureRune(startRuneIdx)
 	if len(e.layouter.Graphemes) == 0 {
 		return startRuneIdx
 	}
 
 	startGraphemeIdx, _ := slices.BinarySearch(e.layouter.Graphemes, startRuneIdx)
+	for e.virtualized() {
+		// Lay out the text again if the move crosses the edges of the shaped
+		// paragraphs.
+		target := startGraphemeIdx + graphemes
+		edge := max(0, min(target, len(e.layouter.Graphemes)-1))
+		if target == edge || (target < 0 && e.layouter.RuneInWindow(0)) ||
+			(target > edge && e.layouter.RuneInWindow(e.src.Len())) {
+			break
+		}
+
+		graphemes -= edge - startGraphemeIdx
+		startRuneIdx = e.layouter.Graphemes[edge]
+		e.layoutWindow(e.src.LineOf(startRuneIdx))
+		startGraphemeIdx, _ = slices.BinarySearch(e.layouter.Graphemes, startRuneIdx)
+	}
 	startGraphemeIdx = max(startGraphemeIdx+graphemes, 0)
 	startGraphemeIdx = min(startGraphemeIdx, len(e.layouter.Graphemes)-1)
 	startRuneIdx = e.layouter.Graphemes[startGraphemeIdx]
@@ -602,6 +729,7 @@ func (e *TextView) Undo() ([]buffer.CursorPos, bool) {
 	cursors, ok := e.src.Undo()
 	if ok {
 		e.invalidate()
+		e.folding.dirty = true
 	}
 
 	return cursors, ok
@@ -612,6 +740,19 @@ func (e *TextView) Redo() ([]buffer.CursorPos, bool) {
 	cursors, ok := e.src.Redo()
 	if ok {
 		e.invalidate()
+		e.folding.dirty = true
+	}
+
+	return cursors, ok
+}
+
+// GotoHistory restores the state of the undo history after the change of seq,
+// and mark the textview invalid.
+func (e *TextView) GotoHistory(seq int) ([]buffer.CursorPos, bool) {
+	cursors, ok := e.src.GotoHistory(seq)
+	if ok {
+		e.invalidate()
+		e.folding.dirty = true
 	}
 
 	return cursors, ok
@@ -48,7 +48,14 @@ func (e *TextView) PaintSelection(gtx layout.Context, material op.CallOp) {
 	localViewport := image.Rectangle{Max: e.viewSize}
 	docViewport := image.Rectangle{Max: e.viewSize}.Add(e.scrollOff)
 	defer clip.Rect(localViewport).Push(gtx.Ops).Pop()
-	e.regions = e.layouter.Locate(docViewport, e.caret.start, e.caret.end, e.regions)
+	e.paintSelection(gtx, docViewport, e.caret, material)
+	for _, caret := range e.carets {
+		e.paintSelection(gtx, docViewport, caret, material)
+	}
+}
+
+func (e *TextView) paintSelection(gtx layout.Context, docViewport image.Rectangle, caret caretPos, material op.CallOp) {
+	e.regions = e.layouter.Locate(docViewport, caret.start, caret.end, e.regions)
 	//log.Println("regions count: ", len(e.regions), e.regions)
 	expandEmptyRegion := len(e.regions) > 1
 	for _, region := range e.regions {
@@ -126,12 +133,11 @@ func (e *TextView) HighlightMatchingBrackets(gtx layout.Context, material op.Cal
 // Only the start position is checked.
 func (e *TextView) caretCurrentLine() (start lt.CombinedPos, end lt.CombinedPos) {
 	caretStart := e.closestToRune(e.caret.start)
-	lines := e.selectedParagraphs()
-	if len(lines) == 0 {
+	if len(e.layouter.Paragraphs) == 0 {
 		return caretStart, caretStart
 	}
 
-	line := lines[0]
+	_, line := e.FindParagraph(e.caret.start)
 	start = e.closestToXY(line.StartX, line.StartY)
 	end = e.closestToXY(line.EndX, line.EndY)
 
@@ -141,7 +147,7 @@ func (e *TextView) caretCurrentLine() (start lt.CombinedPos, end lt.CombinedPos)
 // paintLineHighlight clips and paints the visible line that the caret is in when there is no
 // text selected.
 func (e *TextView) PaintLineHighlight(gtx layout.Context, material op.CallOp) {
-	if e.caret.start != e.caret.end {
+	if e.caret.start != e.caret.end || !e.layouter.RuneInWindow(e.caret.start) {
 		return
 	}
 
@@ -168,7 +174,15 @@ func (e *TextView) PaintLineNumber(gtx layout.Context, lt *text.Shaper, material
 		Max: e.viewSize.Add(e.scrollOff),
 	}
 
-	dims := paintLineNumber(gtx, lt, e.params, viewport, &e.layouter.Paragraphs, material)
+	first := e.layouter.FirstParagraph()
+	lineOf := func(idx int) int { return first + idx }
+	if len(e.layouter.Folds) > 0 {
+		// Folded paragraphs are not in the layout.
+		lineOf = func(idx int) int { return e.src.LineOf(e.layouter.Paragraphs[idx].RuneOff) }
+	}
+
+	dims := paintLineNumber(gtx, lt, e.params, viewport, &e.layouter.Paragraphs,
+		lineOf, e.layouter.ParagraphCount(), material)
 	call := m.Stop()
 
 	rect := viewport.Sub(e.scrollOff)
@@ -182,13 +196,28 @@ func (e *TextView) PaintLineNumber(gtx layout.Context, lt *text.Shaper, material
 // PaintCaret clips and paints the caret rectangle, adding material immediately
 // before painting to set the appropriate paint material.
 func (e *TextView) PaintCaret(gtx layout.Context, material op.CallOp) {
+	e.paintCaret(gtx, e.caret.start, material)
+	for _, caret := range e.carets {
+		e.paintCaret(gtx, caret.start, material)
+	}
+}
+
+func (e *TextView) paintCaret(gtx layout.Context, runeOff int, material op.CallOp) {
+	if !e.layouter.RuneInWindow(runeOff) {
+		// The caret is far out of the viewport.
+		return
+	}
 	carWidth2 := gtx.Dp(e.CaretWidth)
-	caretPos, carAsc, carDesc := e.CaretInfo()
+	caretPos, carAsc, carDesc := e.caretInfo(runeOff)
 
 	carRect := image.Rectangle{
 		Min: caretPos.Sub(image.Pt(carWidth2, carAsc)),
 		Max: caretPos.Add(image.Pt(carWidth2, carDesc)),
 	}
+	if e.BlockCaret {
+		carRect.Min.X = caretPos.X
+		carRect.Max.X = caretPos.X + e.blockCaretWidth(runeOff)
+	}
 	cl := image.Rectangle{Max: e.viewSize}
 	carRect = cl.Intersect(carRect)
 	if !carRect.Empty() {
@@ -198,8 +227,33 @@ func (e *TextView) PaintCaret(gtx layout.Context, material op.CallOp) {
 	}
 }
 
+// blockCaretWidth returns the width of the block caret at runeOff, which is
+// the width of the character after it, or half of the em size at line ends.
+func (e *TextView) blockCaretWidth(runeOff int) int {
+	width := (e.params.PxPerEm / 2).Ceil()
+	if r, err := e.src.ReadRuneAt(runeOff); err != nil || r == '\n' {
+		return width
+	}
+
+	caret := e.closestToRune(runeOff)
+	next := e.closestToRune(runeOff + 1)
+	if next.Runes > caret.Runes && next.LineCol.Line == caret.LineCol.Line {
+		width = max(1, (next.X - caret.X).Round())
+	}
+	return width
+}
+
 func (e *TextView) CaretInfo() (pos image.Point, ascent, descent int) {
-	caretStart := e.closestToRune(e.caret.start)
+	// The caret has no position until the text is shaped, and laying out the
+	// text without a shaper may take long for large texts.
+	if e.shaper == nil {
+		return
+	}
+	return e.caretInfo(e.caret.start)
+}
+
+func (e *TextView) caretInfo(runeOff int) (pos image.Point, ascent, descent int) {
+	caretStart := e.closestToRune(runeOff)
 
 	ascent = caretStart.Ascent.Ceil()
 	descent = caretStart.Descent.Ceil()
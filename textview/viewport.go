@@ -0,0 +1,84 @@
+package textview
+
+// The virtualized layout only shapes the paragraphs around the viewport. When
+// the viewport or a queried position moves out of the shaped paragraphs, the
+// text is laid out again around them. Positions outside of the shaped
+// paragraphs use estimated coordinates, so they are translated to the new
+// layout by their paragraphs before and after the relayout.
+
+// SetVirtualLayout configures whether to shape only the text near the viewport.
+// This makes it possible to edit huge files, at the cost of estimated scroll
+// bounds when the lines are wrapped.
+func (e *TextView) SetVirtualLayout(enabled bool) {
+	changed := e.VirtualLayout != enabled
+	e.VirtualLayout = enabled
+	if changed {
+		e.invalidate()
+	}
+}
+
+func (e *TextView) virtualized() bool {
+	return e.VirtualLayout && e.shaper != nil
+}
+
+// layoutWindow lays out the text around the paragraph, keeping the text at the
+// top of the viewport in place.
+func (e *TextView) layoutWindow(paragraph int) {
+	anchor, offset := e.layouter.ParagraphAtY(e.scrollOff.Y)
+	e.dims = e.layouter.LayoutWindow(e.shaper, &e.params, e.TabWidth, e.WrapLine, paragraph, e.viewSize.Y)
+	e.valid = true
+
+	e.scrollOff.Y = e.layouter.ParagraphY(anchor) + offset
+	b := e.ScrollBounds()
+	e.scrollOff.Y = max(b.Min.Y, min(e.scrollOff.Y, b.Max.Y))
+}
+
+// ensureViewport lays out the text again if the viewport is not covered by the
+// shaped paragraphs.
+func (e *TextView) ensureViewport() {
+	if !e.virtualized() {
+		return
+	}
+
+	e.makeValid()
+	if e.layouter.YInWindow(e.scrollOff.Y) && e.layouter.YInWindow(e.scrollOff.Y+e.viewSize.Y) {
+		return
+	}
+	paragraph, _ := e.layouter.ParagraphAtY(e.scrollOff.Y)
+	e.layoutWindow(paragraph)
+}
+
+// ensureRune makes sure the paragraph containing the rune is shaped.
+func (e *TextView) ensureRune(runeIdx int) {
+	if !e.virtualized() || e.layouter.RuneInWindow(runeIdx) {
+		return
+	}
+
+	runeIdx = max(0, min(runeIdx, e.src.Len()))
+	e.layoutWindow(e.src.LineOf(runeIdx))
+}
+
+// ensureLine makes sure the line is shaped, returning the index of the line in
+// the new layout.
+func (e *TextView) ensureLine(line int) int {
+	if !e.virtualized() || e.layouter.LineInWindow(line) {
+		return line
+	}
+
+	paragraph, within := e.layouter.ParagraphAtLine(line)
+	e.layoutWindow(paragraph)
+	first := e.layouter.ParagraphLine(paragraph)
+	return min(first+within, max(first, e.layouter.ParagraphLine(paragraph+1)-1))
+}
+
+// ensureY makes sure the line at the Y offset is shaped, returning the offset
+// in the new layout.
+func (e *TextView) ensureY(y int) int {
+	if !e.virtualized() || e.layouter.YInWindow(y) {
+		return y
+	}
+
+	paragraph, offset := e.layouter.ParagraphAtY(y)
+	e.layoutWindow(paragraph)
+	return e.layouter.ParagraphY(paragraph) + offset
+}
@@ -0,0 +1,86 @@
+package textview
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"testing"
+
+	"gioui.org/layout"
+	"gioui.org/text"
+	"gioui.org/unit"
+)
+
+func TestVirtualLayout(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&sb, "line %d\n", i)
+	}
+
+	gtx := layout.Context{
+		Constraints: layout.Exact(image.Pt(800, 600)),
+		Metric:      unit.Metric{PxPerDp: 1, PxPerSp: 1},
+	}
+	shaper := text.NewShaper()
+	setup := func(virtual bool) *TextView {
+		vw := NewTextView()
+		vw.TextSize = 14
+		vw.TabWidth = 4
+		vw.SetVirtualLayout(virtual)
+		vw.SetText(sb.String())
+		vw.Layout(gtx, shaper)
+		return vw
+	}
+
+	full := setup(false)
+	virtual := setup(true)
+
+	if len(virtual.layouter.Lines) >= len(full.layouter.Lines)/10 {
+		t.Fatalf("too many lines shaped: %d", len(virtual.layouter.Lines))
+	}
+	if virtual.Paragraphs() != full.Paragraphs() {
+		t.Fatalf("want %d paragraphs, got %d", full.Paragraphs(), virtual.Paragraphs())
+	}
+	if virtual.FullDimensions().Size.Y != full.FullDimensions().Size.Y {
+		t.Fatalf("want height %d, got %d", full.FullDimensions().Size.Y, virtual.FullDimensions().Size.Y)
+	}
+
+	steps := []struct {
+		name string
+		fn   func(vw *TextView)
+	}{
+		{"convert pos", func(vw *TextView) { vw.SetCaret(vw.ConvertPos(5000, 2), vw.ConvertPos(5000, 2)) }},
+		{"move lines", func(vw *TextView) { vw.MoveLines(-300, SelectionClear) }},
+		{"move pages", func(vw *TextView) { vw.MovePages(3, SelectionClear) }},
+		{"move caret", func(vw *TextView) { vw.MoveCaret(-3000, -3000) }},
+		{"text end", func(vw *TextView) { vw.MoveTextEnd(SelectionClear) }},
+		{"line start", func(vw *TextView) { vw.MoveLines(-1, SelectionClear) }},
+		{"text start", func(vw *TextView) { vw.MoveTextStart(SelectionClear) }},
+	}
+
+	for _, step := range steps {
+		for _, vw := range []*TextView{full, virtual} {
+			step.fn(vw)
+			vw.ScrollToCaret()
+			vw.Layout(gtx, shaper)
+		}
+
+		if virtual.caret != full.caret {
+			t.Fatalf("%s: want caret %v, got %v", step.name, full.caret, virtual.caret)
+		}
+		if virtual.ScrollOff() != full.ScrollOff() {
+			t.Fatalf("%s: want scroll offset %v, got %v", step.name, full.ScrollOff(), virtual.ScrollOff())
+		}
+		if virtual.CaretCoords() != full.CaretCoords() {
+			t.Fatalf("%s: want caret coords %v, got %v", step.name, full.CaretCoords(), virtual.CaretCoords())
+		}
+	}
+
+	virtual.ScrollRel(0, virtual.FullDimensions().Size.Y/2)
+	full.ScrollRel(0, full.FullDimensions().Size.Y/2)
+	line, _, _ := virtual.QueryPos(image.Pt(0, 0))
+	wantLine, _, _ := full.QueryPos(image.Pt(0, 0))
+	if line != wantLine {
+		t.Fatalf("want line %d at the top of viewport, got %d", wantLine, line)
+	}
+}
@@ -0,0 +1,1194 @@
+package gvcode
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gioui.org/io/clipboard"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"github.com/oligo/gvcode/internal/vim"
+)
+
+// VimState is the state of the editor when the Vim mode is enabled.
+type VimState uint8
+
+const (
+	// VimNormal is the state to move around and to apply operators.
+	VimNormal VimState = iota
+	// VimInsert is the state to type text, where the editor works as usual.
+	VimInsert
+	// VimVisual is the state to select text by characters.
+	VimVisual
+	// VimVisualLine is the state to select text by lines.
+	VimVisualLine
+	// VimCommandLine is the state to type an ex command after ':'.
+	VimCommandLine
+)
+
+func (s VimState) String() string {
+	switch s {
+	case VimNormal:
+		return "NORMAL"
+	case VimInsert:
+		return "INSERT"
+	case VimVisual:
+		return "VISUAL"
+	case VimVisualLine:
+		return "VISUAL LINE"
+	case VimCommandLine:
+		return "COMMAND"
+	default:
+		return ""
+	}
+}
+
+// A VimCommandEvent is generated when an ex command is entered in the
+// command-line state, and the editor does not handle it itself, e.g. :w or
+// :q. Command is the text typed after ':'.
+type VimCommandEvent struct {
+	Command string
+}
+
+func (s VimCommandEvent) isEditorEvent() {}
+
+// vimRegister holds the text of a register. Linewise text is put as whole
+// lines.
+type vimRegister struct {
+	text     string
+	linewise bool
+}
+
+// vimChange is a change to repeat with '.'.
+type vimChange struct {
+	cmd vim.Command
+	// text is the text typed in the insert state entered by cmd.
+	text string
+}
+
+// vimMotionKind tells how the text between the caret and the end of a motion
+// is operated on.
+type vimMotionKind uint8
+
+const (
+	// vimExclusive leaves out the character at the end of the motion.
+	vimExclusive vimMotionKind = iota
+	// vimInclusive includes the character at the end of the motion.
+	vimInclusive
+	// vimLinewise includes the whole lines of the motion.
+	vimLinewise
+)
+
+type vimState struct {
+	enabled bool
+	state   VimState
+	// keys is the keys typed in the normal or visual state, which do not
+	// form a command yet.
+	keys      []rune
+	registers map[rune]vimRegister
+	// anchor and cursor are the ends of the visual selection, both of which
+	// are included in the selection.
+	anchor, cursor int
+	// wantCol is the column kept by the caret when moving across lines, which
+	// is valid while the caret stays at colCaret. It is math.MaxInt to stick
+	// to the line ends.
+	wantCol  int
+	colCaret int
+	cmdline  []rune
+	// lastFind is the last f, F, t or T motion, repeated by ';' and ','.
+	lastFind vim.Command
+	// lastChange is the change repeated by '.'.
+	lastChange *vimChange
+	// insert is the change which entered the insert state, and insertStart
+	// is where the typed text starts.
+	insert      *vimChange
+	insertStart int
+	repeating   bool
+}
+
+// VimMode configures whether to emulate the modal editing of Vim. The editor
+// starts in the normal state, with a block caret.
+func VimMode(enabled bool) EditorOption {
+	return func(e *Editor) {
+		e.initBuffer()
+		e.setVimMode(enabled)
+	}
+}
+
+// VimState returns the current state of the Vim mode. It is VimInsert when the
+// Vim mode is not enabled, as the editor always accepts typing then.
+func (e *Editor) VimState() VimState {
+	if !e.vim.enabled {
+		return VimInsert
+	}
+	return e.vim.state
+}
+
+// VimPendingKeys returns the keys typed so far of an incomplete Vim command,
+// e.g. "2d" or "\"a".
+func (e *Editor) VimPendingKeys() string {
+	return string(e.vim.keys)
+}
+
+// VimCommandLine returns the text typed after ':' in the command-line state.
+func (e *Editor) VimCommandLine() string {
+	return string(e.vim.cmdline)
+}
+
+func (e *Editor) setVimMode(enabled bool) {
+	if e.vim.enabled == enabled {
+		return
+	}
+
+	tag := &e.vim
+	if !enabled {
+		e.RemoveCommands(tag)
+		e.vim = vimState{}
+		e.text.BlockCaret = false
+		return
+	}
+
+	e.vim = vimState{enabled: true, registers: make(map[rune]vimRegister)}
+	e.setVimState(VimNormal)
+	e.registerVimCommands()
+}
+
+func (e *Editor) setVimState(state VimState) {
+	e.vim.state = state
+	e.text.BlockCaret = state != VimInsert
+}
+
+// registerVimCommands overrides the key commands which work differently in the
+// Vim mode. In the insert state, they fall back to the overridden commands.
+func (e *Editor) registerVimCommands() {
+	tag := &e.vim
+	fallback := func(gtx layout.Context, evt key.Event) EditorEvent {
+		return e.fallbackCommand(gtx, tag, evt)
+	}
+	escape := func(gtx layout.Context, evt key.Event) EditorEvent {
+		e.vimEscape()
+		return fallback(gtx, evt)
+	}
+
+	e.RegisterCommand(tag, key.Filter{Name: key.NameEscape}, escape)
+	e.RegisterCommand(tag, key.Filter{Name: "[", Required: key.ModCtrl}, escape)
+
+	lineBreak := func(gtx layout.Context, evt key.Event) EditorEvent {
+		switch e.vim.state {
+		case VimInsert:
+			return fallback(gtx, evt)
+		case VimCommandLine:
+			return e.vimExecuteCommandLine()
+		default:
+			e.vimInput(gtx, "+")
+			return nil
+		}
+	}
+	e.RegisterCommand(tag, key.Filter{Name: key.NameEnter, Optional: key.ModShift}, lineBreak)
+	e.RegisterCommand(tag, key.Filter{Name: key.NameReturn, Optional: key.ModShift}, lineBreak)
+
+	e.RegisterCommand(tag, key.Filter{Name: key.NameDeleteBackward, Optional: key.ModShortcutAlt | key.ModShift},
+		func(gtx layout.Context, evt key.Event) EditorEvent {
+			switch e.vim.state {
+			case VimInsert:
+				return fallback(gtx, evt)
+			case VimCommandLine:
+				if len(e.vim.cmdline) == 0 {
+					e.setVimState(VimNormal)
+				} else {
+					e.vim.cmdline = e.vim.cmdline[:len(e.vim.cmdline)-1]
+				}
+			default:
+				e.vimInput(gtx, "h")
+			}
+			return nil
+		})
+
+	e.RegisterCommand(tag, key.Filter{Name: key.NameDeleteForward, Optional: key.ModShortcutAlt | key.ModShift},
+		func(gtx layout.Context, evt key.Event) EditorEvent {
+			switch e.vim.state {
+			case VimInsert:
+				return fallback(gtx, evt)
+			case VimNormal, VimVisual, VimVisualLine:
+				e.vimInput(gtx, "x")
+			}
+			return nil
+		})
+
+	e.RegisterCommand(tag, key.Filter{Name: key.NameTab, Optional: key.ModShift},
+		func(gtx layout.Context, evt key.Event) EditorEvent {
+			if e.vim.state == VimInsert {
+				return fallback(gtx, evt)
+			}
+			return nil
+		})
+
+	e.RegisterCommand(tag, key.Filter{Name: "R", Required: key.ModCtrl},
+		func(gtx layout.Context, evt key.Event) EditorEvent {
+			if e.vim.state != VimNormal || e.mode == ModeReadOnly {
+				return fallback(gtx, evt)
+			}
+			for range max(1, e.vimCount()) {
+				if _, ok := e.redo(); !ok {
+					break
+				}
+			}
+			e.vim.keys = e.vim.keys[:0]
+			e.vimClampCaret()
+			return nil
+		})
+}
+
+// fallbackCommand runs the handler of evt overridden by the handlers of tag.
+func (e *Editor) fallbackCommand(gtx layout.Context, tag any, evt key.Event) EditorEvent {
+	cmds := e.commands[evt.Name]
+	for i := len(cmds) - 1; i >= 0; i-- {
+		if cmds[i].tag == tag {
+			continue
+		}
+		if cmds[i].tag == nil || cmds[i].tag == e {
+			e.cancelCompletor()
+		}
+		return cmds[i].handler(gtx, evt)
+	}
+	return nil
+}
+
+// vimCount returns the count typed before a pending command.
+func (e *Editor) vimCount() int {
+	count := 0
+	for _, r := range e.vim.keys {
+		if r < '0' || r > '9' {
+			return 0
+		}
+		count = count*10 + int(r-'0')
+	}
+	return count
+}
+
+func (e *Editor) vimEscape() {
+	v := &e.vim
+	v.keys = v.keys[:0]
+	switch v.state {
+	case VimInsert:
+		e.vimFinishInsert()
+	case VimVisual, VimVisualLine:
+		e.setVimState(VimNormal)
+		e.text.SetCaret(v.cursor, v.cursor)
+	case VimCommandLine:
+		v.cmdline = v.cmdline[:0]
+		e.setVimState(VimNormal)
+	}
+	e.vimClampCaret()
+}
+
+// vimInput handles the text typed in the Vim mode, except in the insert state.
+func (e *Editor) vimInput(gtx layout.Context, text string) {
+	v := &e.vim
+	for i, r := range text {
+		switch v.state {
+		case VimInsert:
+			// The rest of the text is typed after a command entered the insert
+			// state.
+			e.Insert(text[i:])
+			return
+		case VimCommandLine:
+			v.cmdline = append(v.cmdline, r)
+			continue
+		}
+
+		v.keys = append(v.keys, r)
+		visual := v.state == VimVisual || v.state == VimVisualLine
+		cmd, status := vim.Parse(v.keys, visual)
+		switch status {
+		case vim.Complete:
+			v.keys = v.keys[:0]
+			e.vimExecute(gtx, cmd)
+		case vim.Invalid:
+			v.keys = v.keys[:0]
+		}
+	}
+	e.scrollCaret = true
+	e.scroller.Stop()
+}
+
+// vimAliases maps the actions which are short for an operator and a motion.
+var vimAliases = map[string]vim.Command{
+	"x": {Operator: 'd', Motion: "l"},
+	"X": {Operator: 'd', Motion: "h"},
+	"s": {Operator: 'c', Motion: "l"},
+	"S": {Operator: 'c', Motion: "c"},
+	"D": {Operator: 'd', Motion: "$"},
+	"C": {Operator: 'c', Motion: "$"},
+	"Y": {Operator: 'y', Motion: "y"},
+}
+
+func (e *Editor) vimExecute(gtx layout.Context, cmd vim.Command) {
+	v := &e.vim
+	if v.state == VimVisual || v.state == VimVisualLine {
+		e.vimExecuteVisual(gtx, cmd)
+		return
+	}
+
+	if alias, ok := vimAliases[cmd.Action]; ok {
+		alias.Register, alias.Count = cmd.Register, cmd.Count
+		cmd = alias
+	}
+
+	caret, _ := e.text.Selection()
+	if caret != v.colCaret {
+		v.wantCol = caret - e.buffer.LineStart(e.buffer.LineOf(caret))
+	}
+
+	changed := false
+	switch {
+	case cmd.Operator != 0:
+		changed = e.vimOperator(gtx, cmd) && cmd.Operator != 'y'
+	case cmd.Action != "":
+		changed = e.vimAction(gtx, cmd)
+	default:
+		to, _, ok := e.vimMove(cmd, caret)
+		if ok {
+			e.text.SetCaret(to, to)
+		}
+	}
+	if changed && v.state == VimNormal && !v.repeating {
+		v.lastChange = &vimChange{cmd: cmd}
+	}
+
+	e.vimClampCaret()
+	switch {
+	case cmd.Operator == 0 && (cmd.Motion == "j" || cmd.Motion == "k"):
+		// Keep the wanted column when moving across lines.
+	case cmd.Operator == 0 && cmd.Motion == "$":
+		v.wantCol = math.MaxInt
+	default:
+		caret, _ = e.text.Selection()
+		v.wantCol = caret - e.buffer.LineStart(e.buffer.LineOf(caret))
+	}
+	v.colCaret, _ = e.text.Selection()
+}
+
+// vimClampCaret clears the selection in the normal state, and moves the caret
+// off the line break, unless the line is empty.
+func (e *Editor) vimClampCaret() {
+	if e.vim.state != VimNormal {
+		return
+	}
+	caret, _ := e.text.Selection()
+	line := e.buffer.LineOf(caret)
+	if caret >= vim.LineEnd(e.buffer, line) && caret > e.buffer.LineStart(line) {
+		caret = vim.LineEnd(e.buffer, line) - 1
+	}
+	e.text.SetCaret(caret, caret)
+}
+
+// vimLine returns the line distance lines away from line, counting a folded
+// range as one line.
+func (e *Editor) vimLine(line, distance int) int {
+	last := max(0, e.buffer.Lines()-1)
+	folded := e.text.FoldedRanges()
+	for ; distance > 0 && line < last; distance-- {
+		line++
+		for _, f := range folded {
+			if line > f.StartLine && line <= f.EndLine {
+				line = min(last, f.EndLine+1)
+			}
+		}
+	}
+	for ; distance < 0 && line > 0; distance++ {
+		line--
+		for _, f := range folded {
+			if line > f.StartLine && line <= f.EndLine {
+				line = f.StartLine
+			}
+		}
+	}
+	return line
+}
+
+// vimMove returns where the motion of cmd moves from the offset from, and how
+// the text it moves over is operated on.
+func (e *Editor) vimMove(cmd vim.Command, from int) (int, vimMotionKind, bool) {
+	v := &e.vim
+	src := e.buffer
+	count := cmd.Counts()
+	line := src.LineOf(from)
+	lineStart, lineEnd := src.LineStart(line), vim.LineEnd(src, line)
+	lastLine := max(0, src.Lines()-1)
+	// lineCount is the line of a motion like G, counted from one.
+	lineCount := max(cmd.Count, 1) * max(cmd.MotionCount, 1)
+	if cmd.Count == 0 && cmd.MotionCount == 0 {
+		lineCount = 0
+	}
+
+	repeat := func(fn func(int) int) int {
+		pos := from
+		for range count {
+			pos = fn(pos)
+		}
+		return pos
+	}
+
+	switch cmd.Motion {
+	case "h":
+		return max(lineStart, from-count), vimExclusive, from > lineStart
+	case "l":
+		return min(lineEnd, from+count), vimExclusive, from < lineEnd
+	case "j", "k":
+		distance := count
+		if cmd.Motion == "k" {
+			distance = -count
+		}
+		target := e.vimLine(line, distance)
+		start, end := src.LineStart(target), vim.LineEnd(src, target)
+		pos := end
+		if v.wantCol < end-start {
+			pos = start + v.wantCol
+		}
+		return pos, vimLinewise, target != line
+	case "+", "-", "_":
+		distance := count
+		switch cmd.Motion {
+		case "-":
+			distance = -count
+		case "_":
+			distance = count - 1
+		}
+		target := e.vimLine(line, distance)
+		return vim.FirstNonBlank(src, target), vimLinewise, target != line || cmd.Motion == "_"
+	case "w", "W":
+		return repeat(func(pos int) int { return vim.WordStart(src, pos, cmd.Motion == "W") }), vimExclusive, true
+	case "b", "B":
+		return repeat(func(pos int) int { return vim.WordBackward(src, pos, cmd.Motion == "B") }), vimExclusive, true
+	case "e", "E":
+		return repeat(func(pos int) int { return vim.WordEnd(src, pos, cmd.Motion == "E") }), vimInclusive, true
+	case "ge", "gE":
+		return repeat(func(pos int) int { return vim.WordEndBackward(src, pos, cmd.Motion == "gE") }), vimInclusive, true
+	case "0":
+		return lineStart, vimExclusive, true
+	case "^":
+		return vim.FirstNonBlank(src, line), vimExclusive, true
+	case "$":
+		return vim.LineEnd(src, min(lastLine, line+count-1)), vimExclusive, true
+	case "g_":
+		target := min(lastLine, line+count-1)
+		pos := vim.LineEnd(src, target)
+		for pos > src.LineStart(target) && unicode.IsSpace(e.vimRuneAt(pos-1)) {
+			pos--
+		}
+		return max(src.LineStart(target), pos-1), vimInclusive, true
+	case "|":
+		return min(lineStart+count-1, lineEnd), vimExclusive, true
+	case "G", "gg":
+		target := lastLine
+		if cmd.Motion == "gg" {
+			target = 0
+		}
+		if lineCount > 0 {
+			target = min(lastLine, lineCount-1)
+		}
+		return vim.FirstNonBlank(src, target), vimLinewise, true
+	case "%":
+		if lineCount > 0 {
+			target := min(lastLine, (lineCount*src.Lines()+99)/100-1)
+			return vim.FirstNonBlank(src, max(0, target)), vimLinewise, true
+		}
+		pos, ok := vim.MatchPair(src, from)
+		return pos, vimInclusive, ok
+	case "f", "F", "t", "T":
+		v.lastFind = cmd
+		return e.vimFind(from, cmd.Motion, cmd.Char, count, false)
+	case ";", ",":
+		if v.lastFind.Motion == "" {
+			return from, vimExclusive, false
+		}
+		motion := v.lastFind.Motion
+		if cmd.Motion == "," {
+			motion = strings.Map(func(r rune) rune {
+				if unicode.IsUpper(r) {
+					return unicode.ToLower(r)
+				}
+				return unicode.ToUpper(r)
+			}, motion)
+		}
+		return e.vimFind(from, motion, v.lastFind.Char, count, true)
+	}
+
+	return from, vimExclusive, false
+}
+
+// vimFind moves to a character in the line, as by f, F, t and T. A repeated t
+// or T does not stop right before the character it already stops at.
+func (e *Editor) vimFind(from int, motion string, ch rune, count int, repeated bool) (int, vimMotionKind, bool) {
+	backward := motion == "F" || motion == "T"
+	till := motion == "t" || motion == "T"
+	start := from
+	if till && repeated && count == 1 {
+		if backward {
+			start--
+		} else {
+			start++
+		}
+	}
+
+	pos, ok := vim.FindChar(e.buffer, start, ch, backward, till, count)
+	if !ok {
+		return from, vimExclusive, false
+	}
+	if backward {
+		return pos, vimExclusive, true
+	}
+	return pos, vimInclusive, true
+}
+
+func (e *Editor) vimRuneAt(off int) rune {
+	r, err := e.buffer.ReadRuneAt(off)
+	if err != nil {
+		return 0
+	}
+	return r
+}
+
+// vimTextRange returns the text between the rune offsets start and end.
+func (e *Editor) vimTextRange(start, end int) string {
+	startOff, endOff := e.buffer.RuneOffset(start), e.buffer.RuneOffset(end)
+	if endOff <= startOff {
+		return ""
+	}
+	buf := make([]byte, endOff-startOff)
+	n, _ := e.buffer.ReadAt(buf, int64(startOff))
+	return string(buf[:n])
+}
+
+// vimLineRange returns the range of the whole lines from the line of start to
+// the line of end.
+func (e *Editor) vimLineRange(start, end int) (int, int) {
+	return e.buffer.LineStart(e.buffer.LineOf(start)), e.buffer.LineStart(e.buffer.LineOf(end) + 1)
+}
+
+// vimOperator applies the operator of cmd to the text its motion moves over.
+// It reports whether any text is operated on.
+func (e *Editor) vimOperator(gtx layout.Context, cmd vim.Command) bool {
+	src := e.buffer
+	from, _ := e.text.Selection()
+
+	var start, end int
+	linewise := false
+	switch {
+	case cmd.IsLineOperator():
+		line := src.LineOf(from)
+		last := min(line+cmd.Counts()-1, max(0, src.Lines()-1))
+		start, end = src.LineStart(line), src.LineStart(last+1)
+		linewise = true
+	case len(cmd.Motion) == 2 && (cmd.Motion[0] == 'i' || cmd.Motion[0] == 'a'):
+		var ok bool
+		start, end, ok = vim.TextObject(src, from, rune(cmd.Motion[1]), cmd.Motion[0] == 'i')
+		if !ok {
+			return false
+		}
+	default:
+		// cw changes to the end of the word, like ce, unless on white space.
+		if cmd.Operator == 'c' && (cmd.Motion == "w" || cmd.Motion == "W") && !unicode.IsSpace(e.vimRuneAt(from)) {
+			return e.vimApply(gtx, cmd, from, e.vimChangeWordEnd(from, cmd.Motion == "W", cmd.Counts()), false)
+		}
+		to, kind, ok := e.vimMove(cmd, from)
+		if !ok {
+			return false
+		}
+		if (cmd.Motion == "w" || cmd.Motion == "W") && src.LineOf(to) > src.LineOf(from) {
+			// The last word moved over is at the end of a line, so the
+			// operator stops at the end of that line.
+			to = max(from, vim.LineEnd(src, src.LineOf(to)-1))
+		}
+
+		start, end = min(from, to), max(from, to)
+		switch kind {
+		case vimInclusive:
+			end = min(end+1, src.Len())
+		case vimLinewise:
+			start, end = e.vimLineRange(start, end)
+			linewise = true
+		}
+	}
+
+	return e.vimApply(gtx, cmd, start, end, linewise)
+}
+
+// vimChangeWordEnd returns the end of the count-th word from off, for cw.
+func (e *Editor) vimChangeWordEnd(off int, bigWord bool, count int) int {
+	end := vim.LineEnd(e.buffer, e.buffer.LineOf(off))
+	class := func(r rune) int {
+		switch {
+		case unicode.IsSpace(r):
+			return 0
+		case bigWord || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			return 2
+		default:
+			return 1
+		}
+	}
+	pos := off
+	for i := range count {
+		if i > 0 {
+			for pos < end && class(e.vimRuneAt(pos)) == 0 {
+				pos++
+			}
+		}
+		c := class(e.vimRuneAt(pos))
+		for pos < end && class(e.vimRuneAt(pos)) == c {
+			pos++
+		}
+	}
+	return pos
+}
+
+// vimApply applies the operator of cmd to the text between start and end. It
+// reports whether any text is operated on.
+func (e *Editor) vimApply(gtx layout.Context, cmd vim.Command, start, end int, linewise bool) bool {
+	src := e.buffer
+	if start >= end && !linewise && cmd.Operator != 'c' {
+		return false
+	}
+	if cmd.Operator != 'y' && e.mode == ModeReadOnly {
+		return false
+	}
+	text := e.vimTextRange(start, end)
+
+	switch cmd.Operator {
+	case 'y':
+		e.vimStore(gtx, cmd.Register, text, linewise, true)
+		caret, _ := e.text.Selection()
+		if !linewise || src.LineOf(caret) != src.LineOf(start) {
+			caret = start
+		}
+		e.text.SetCaret(caret, caret)
+	case 'd':
+		e.vimStore(gtx, cmd.Register, text, linewise, false)
+		if linewise && end == src.Len() && start > 0 && !strings.HasSuffix(text, "\n") {
+			// Remove the line break before the deleted last line.
+			start--
+		}
+		e.replace(start, end, "")
+		if linewise {
+			start = vim.FirstNonBlank(src, src.LineOf(start))
+		}
+		e.text.SetCaret(start, start)
+	case 'c':
+		e.vimStore(gtx, cmd.Register, text, linewise, false)
+		e.buffer.GroupOp()
+		if linewise {
+			// Keep the indentation of the first line, and the last line break.
+			start = vim.FirstNonBlank(src, src.LineOf(start))
+			if end > start && e.vimRuneAt(end-1) == '\n' {
+				end--
+			}
+		}
+		e.replace(start, end, "")
+		e.buffer.UnGroupOp()
+		e.text.SetCaret(start, start)
+		e.vimStartInsert(cmd)
+	case '>', '<':
+		if end > start {
+			end--
+		}
+		e.vimShiftLines(src.LineOf(start), src.LineOf(end), cmd.Operator == '<')
+	}
+	return true
+}
+
+// vimShiftLines indents the lines from startLine to endLine by one level, or
+// removes one level of the indentation if dedent is set.
+func (e *Editor) vimShiftLines(startLine, endLine int, dedent bool) {
+	indent := e.text.Indentation()
+	e.buffer.GroupOp()
+	for line := endLine; line >= startLine; line-- {
+		start := e.buffer.LineStart(line)
+		if !dedent {
+			if vim.LineEnd(e.buffer, line) > start {
+				e.replace(start, start, indent)
+			}
+			continue
+		}
+
+		width := 0
+		end := start
+		for ; width < e.text.TabWidth; end++ {
+			r := e.vimRuneAt(end)
+			if r == '\t' {
+				end++
+				break
+			}
+			if r != ' ' {
+				break
+			}
+			width++
+		}
+		e.replace(start, end, "")
+	}
+	e.buffer.UnGroupOp()
+	caret := vim.FirstNonBlank(e.buffer, startLine)
+	e.text.SetCaret(caret, caret)
+}
+
+// vimStore puts text into the register reg, and into the unnamed register. By
+// default yanked text goes to register 0, deleted lines to register 1, and
+// deleted text within a line to register -.
+func (e *Editor) vimStore(gtx layout.Context, reg rune, text string, linewise, yank bool) {
+	if reg == '_' {
+		return
+	}
+	if linewise && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	r := vimRegister{text: text, linewise: linewise}
+	regs := e.vim.registers
+
+	switch {
+	case reg >= 'A' && reg <= 'Z':
+		reg = unicode.ToLower(reg)
+		prev := regs[reg]
+		r = vimRegister{text: prev.text + text, linewise: prev.linewise || linewise}
+		regs[reg] = r
+	case reg >= 'a' && reg <= 'z', reg >= '0' && reg <= '9', reg == '-':
+		regs[reg] = r
+	case reg == '+' || reg == '*':
+		gtx.Execute(clipboard.WriteCmd{Type: "application/text", Data: io.NopCloser(strings.NewReader(text))})
+	case yank:
+		regs['0'] = r
+	case linewise || strings.Contains(text, "\n"):
+		for i := '9'; i > '1'; i-- {
+			regs[i] = regs[i-1]
+		}
+		regs['1'] = r
+	default:
+		regs['-'] = r
+	}
+	regs['"'] = r
+}
+
+// vimStartInsert enters the insert state, to record the text typed for the
+// change of cmd.
+func (e *Editor) vimStartInsert(cmd vim.Command) {
+	e.setVimState(VimInsert)
+	e.vim.insert = &vimChange{cmd: cmd}
+	e.vim.insertStart, _ = e.text.Selection()
+}
+
+// vimFinishInsert leaves the insert state, repeating the typed text as many
+// times as the count of the insert command.
+func (e *Editor) vimFinishInsert() {
+	v := &e.vim
+	e.setVimState(VimNormal)
+	caret, _ := e.text.Selection()
+	if v.insert == nil {
+		return
+	}
+
+	if caret >= v.insertStart {
+		v.insert.text = e.vimTextRange(v.insertStart, caret)
+	}
+	if strings.Contains("iaIAoO", v.insert.cmd.Action) && v.insert.text != "" && v.insert.cmd.Counts() > 1 {
+		text := v.insert.text
+		if v.insert.cmd.Action == "o" || v.insert.cmd.Action == "O" {
+			text = "\n" + text
+		}
+		e.Insert(strings.Repeat(text, v.insert.cmd.Counts()-1))
+		caret, _ = e.text.Selection()
+	}
+	// Changes of a visual selection are not repeated.
+	if !v.repeating && (v.insert.cmd.Operator == 0 || v.insert.cmd.Motion != "") {
+		v.lastChange = v.insert
+	}
+	v.insert = nil
+
+	// Like Vim, the caret moves back onto the last typed character.
+	if caret > e.buffer.LineStart(e.buffer.LineOf(caret)) {
+		caret--
+	}
+	e.text.SetCaret(caret, caret)
+}
+
+// vimAction executes the action of cmd in the normal state. It reports whether
+// the text is changed.
+func (e *Editor) vimAction(gtx layout.Context, cmd vim.Command) bool {
+	v := &e.vim
+	src := e.buffer
+	count := cmd.Counts()
+	caret, _ := e.text.Selection()
+	line := src.LineOf(caret)
+	lineStart, lineEnd := src.LineStart(line), vim.LineEnd(src, line)
+	readOnly := e.mode == ModeReadOnly
+
+	switch cmd.Action {
+	case "i", "a", "I", "A", "o", "O":
+		if readOnly {
+			return false
+		}
+		switch cmd.Action {
+		case "a":
+			caret = min(caret+1, lineEnd)
+		case "I":
+			caret = vim.FirstNonBlank(src, line)
+		case "A":
+			caret = lineEnd
+		case "o", "O":
+			indent := e.vimTextRange(lineStart, vim.FirstNonBlank(src, line))
+			if cmd.Action == "o" {
+				e.replace(lineEnd, lineEnd, "\n"+indent)
+				caret = lineEnd + 1 + utf8.RuneCountInString(indent)
+			} else {
+				e.replace(lineStart, lineStart, indent+"\n")
+				caret = lineStart + utf8.RuneCountInString(indent)
+			}
+		}
+		e.text.SetCaret(caret, caret)
+		e.vimStartInsert(cmd)
+		return false
+	case "p", "P":
+		return e.vimPut(gtx, cmd)
+	case "u":
+		if readOnly {
+			return false
+		}
+		for range count {
+			if _, ok := e.undo(); !ok {
+				break
+			}
+		}
+	case "g-", "g+":
+		if readOnly {
+			return false
+		}
+		if cmd.Action == "g-" {
+			e.Earlier(count)
+		} else {
+			e.Later(count)
+		}
+	case "J", "gJ":
+		if readOnly {
+			return false
+		}
+		return e.vimJoinLines(line, max(2, count), cmd.Action == "J")
+	case "r":
+		if readOnly || caret+count > lineEnd || cmd.Char == '\r' || cmd.Char == '\n' {
+			return false
+		}
+		e.replace(caret, caret+count, strings.Repeat(string(cmd.Char), count))
+		e.text.SetCaret(caret+count-1, caret+count-1)
+		return true
+	case "~":
+		end := min(caret+count, lineEnd)
+		if readOnly || end <= caret {
+			return false
+		}
+		e.replace(caret, end, strings.Map(toggleCase, e.vimTextRange(caret, end)))
+		e.text.SetCaret(end, end)
+		return true
+	case "v", "V":
+		v.anchor, v.cursor = caret, caret
+		if cmd.Action == "v" {
+			e.setVimState(VimVisual)
+		} else {
+			e.setVimState(VimVisualLine)
+		}
+		e.vimSyncVisual()
+	case ":":
+		v.cmdline = v.cmdline[:0]
+		e.setVimState(VimCommandLine)
+	case ".":
+		e.vimRepeat(gtx, cmd.Count)
+	}
+	return false
+}
+
+func toggleCase(r rune) rune {
+	if unicode.IsUpper(r) {
+		return unicode.ToLower(r)
+	}
+	return unicode.ToUpper(r)
+}
+
+// vimPut puts the text of the register of cmd after the caret, or before it
+// for P. Text of whole lines is put below or above the current line.
+func (e *Editor) vimPut(gtx layout.Context, cmd vim.Command) bool {
+	if e.mode == ModeReadOnly {
+		return false
+	}
+	src := e.buffer
+	caret, _ := e.text.Selection()
+	line := src.LineOf(caret)
+	after := cmd.Action == "p"
+
+	reg := cmd.Register
+	if reg == 0 {
+		reg = '"'
+	}
+	if reg == '+' || reg == '*' {
+		// Paste from the clipboard. The other half is in onPasteEvent.
+		if after && caret < vim.LineEnd(src, line) {
+			e.text.SetCaret(caret+1, caret+1)
+		}
+		gtx.Execute(clipboard.ReadCmd{Tag: e})
+		return false
+	}
+	r, ok := e.vim.registers[unicode.ToLower(reg)]
+	if !ok || r.text == "" {
+		return false
+	}
+	text := strings.Repeat(r.text, cmd.Counts())
+
+	if r.linewise {
+		pos, firstLine := src.LineStart(line), line
+		if after {
+			pos, firstLine = src.LineStart(line+1), line+1
+			if pos == src.Len() && (pos == 0 || e.vimRuneAt(pos-1) != '\n') {
+				// The last line has no line break to put the lines after.
+				text = "\n" + strings.TrimSuffix(text, "\n")
+			}
+		}
+		e.replace(pos, pos, text)
+		caret = vim.FirstNonBlank(src, firstLine)
+	} else {
+		pos := caret
+		if after && caret < vim.LineEnd(src, line) {
+			pos++
+		}
+		n := e.replace(pos, pos, text)
+		caret = pos + n - 1
+	}
+	e.text.SetCaret(caret, caret)
+	return true
+}
+
+// vimJoinLines joins count lines from line. J removes the indentation of the
+// joined lines and puts a space between them, while gJ keeps them as is.
+func (e *Editor) vimJoinLines(line, count int, spaced bool) bool {
+	src := e.buffer
+	joined := false
+	caret := 0
+	e.buffer.GroupOp()
+	defer e.buffer.UnGroupOp()
+	for range count - 1 {
+		if line+1 >= src.Lines() {
+			break
+		}
+		end := vim.LineEnd(src, line)
+		next := end + 1
+		sep := ""
+		if spaced {
+			next = vim.FirstNonBlank(src, line+1)
+			for end > src.LineStart(line) && unicode.IsSpace(e.vimRuneAt(end-1)) {
+				end--
+			}
+			if end > src.LineStart(line) && next < vim.LineEnd(src, line+1) && e.vimRuneAt(next) != ')' {
+				sep = " "
+			}
+		}
+		e.replace(end, next, sep)
+		caret = end
+		joined = true
+	}
+	if joined {
+		e.text.SetCaret(caret, caret)
+	}
+	return joined
+}
+
+// vimSyncVisual selects the text between the visual anchor and cursor. The
+// caret stays at the cursor, whose block covers the last selected character
+// when selecting forward.
+func (e *Editor) vimSyncVisual() {
+	v := &e.vim
+	src := e.buffer
+	switch v.state {
+	case VimVisual:
+		end := v.anchor
+		if v.cursor < v.anchor {
+			end = min(v.anchor+1, src.Len())
+		}
+		e.text.SetCaret(v.cursor, end)
+	case VimVisualLine:
+		start, end := e.vimLineRange(min(v.anchor, v.cursor), max(v.anchor, v.cursor))
+		if v.cursor >= v.anchor {
+			e.text.SetCaret(vim.LineEnd(src, src.LineOf(v.cursor)), start)
+		} else {
+			e.text.SetCaret(start, end)
+		}
+	}
+}
+
+// vimVisualRange returns the range of the visual selection.
+func (e *Editor) vimVisualRange() (start, end int, linewise bool) {
+	v := &e.vim
+	start, end = min(v.anchor, v.cursor), max(v.anchor, v.cursor)
+	if v.state == VimVisualLine {
+		start, end = e.vimLineRange(start, end)
+		return start, end, true
+	}
+	return start, min(end+1, e.buffer.Len()), false
+}
+
+func (e *Editor) vimExecuteVisual(gtx layout.Context, cmd vim.Command) {
+	v := &e.vim
+	start, end, linewise := e.vimVisualRange()
+	leave := func() {
+		if v.state == VimVisual || v.state == VimVisualLine {
+			e.setVimState(VimNormal)
+		}
+		e.vimClampCaret()
+	}
+
+	action := cmd.Action
+	switch action {
+	case "x":
+		action = "d"
+	case "s":
+		action = "c"
+	case "X", "D":
+		action, linewise = "d", true
+	case "S", "C":
+		action, linewise = "c", true
+	case "Y":
+		action, linewise = "y", true
+	}
+	if linewise && v.state == VimVisual {
+		start, end = e.vimLineRange(start, max(start, end-1))
+	}
+
+	switch {
+	case cmd.Operator != 0 || action == "d" || action == "c" || action == "y":
+		op := cmd.Operator
+		if op == 0 {
+			op = rune(action[0])
+		}
+		if op == 'c' {
+			e.setVimState(VimNormal)
+		}
+		e.vimApply(gtx, vim.Command{Register: cmd.Register, Operator: op}, start, end, linewise)
+		leave()
+	case cmd.Motion != "" && len(cmd.Motion) == 2 && (cmd.Motion[0] == 'i' || cmd.Motion[0] == 'a'):
+		objStart, objEnd, ok := vim.TextObject(e.buffer, v.cursor, rune(cmd.Motion[1]), cmd.Motion[0] == 'i')
+		if ok && objEnd > objStart {
+			if v.anchor == v.cursor || objStart < v.anchor {
+				v.anchor = objStart
+			}
+			v.cursor = objEnd - 1
+			e.vimSyncVisual()
+		}
+	case cmd.Motion != "":
+		if to, _, ok := e.vimMove(cmd, v.cursor); ok {
+			v.cursor = to
+			e.vimSyncVisual()
+		}
+	case action == "o":
+		v.anchor, v.cursor = v.cursor, v.anchor
+		e.vimSyncVisual()
+	case action == "v" || action == "V":
+		state := VimVisual
+		if action == "V" {
+			state = VimVisualLine
+		}
+		if v.state == state {
+			e.setVimState(VimNormal)
+			e.text.SetCaret(v.cursor, v.cursor)
+			leave()
+		} else {
+			e.setVimState(state)
+			e.vimSyncVisual()
+		}
+	case action == "p" || action == "P":
+		if e.mode == ModeReadOnly {
+			return
+		}
+		// The selection is replaced by the register, and then goes into the
+		// unnamed register.
+		reg := cmd.Register
+		if reg == 0 {
+			reg = '"'
+		}
+		r := e.vim.registers[unicode.ToLower(reg)]
+		text := e.vimTextRange(start, end)
+		e.buffer.GroupOp()
+		n := e.replace(start, end, strings.Repeat(r.text, cmd.Counts()))
+		e.buffer.UnGroupOp()
+		e.vimStore(gtx, 0, text, linewise, false)
+		if !r.linewise {
+			start += max(0, n-1)
+		}
+		e.text.SetCaret(start, start)
+		leave()
+	case action == "J":
+		if e.mode != ModeReadOnly {
+			startLine := e.buffer.LineOf(start)
+			e.vimJoinLines(startLine, max(2, e.buffer.LineOf(max(start, end-1))-startLine+1), true)
+		}
+		leave()
+	case action == "~" || action == "u" || action == "U":
+		if e.mode != ModeReadOnly {
+			mapping := toggleCase
+			switch action {
+			case "u":
+				mapping = unicode.ToLower
+			case "U":
+				mapping = unicode.ToUpper
+			}
+			e.replace(start, end, strings.Map(mapping, e.vimTextRange(start, end)))
+		}
+		e.text.SetCaret(start, start)
+		leave()
+	case action == ":":
+		e.text.SetCaret(v.cursor, v.cursor)
+		v.cmdline = v.cmdline[:0]
+		e.setVimState(VimCommandLine)
+	}
+}
+
+// vimRepeat repeats the last change, with the count if it is not zero.
+func (e *Editor) vimRepeat(gtx layout.Context, count int) {
+	v := &e.vim
+	if v.lastChange == nil {
+		return
+	}
+	change := *v.lastChange
+	if count > 0 {
+		change.cmd.Count, change.cmd.MotionCount = count, 0
+	}
+
+	v.repeating = true
+	defer func() { v.repeating = false }()
+	e.buffer.GroupOp()
+	defer e.buffer.UnGroupOp()
+
+	e.vimExecute(gtx, change.cmd)
+	if v.state == VimInsert {
+		e.Insert(change.text)
+		e.vimFinishInsert()
+	}
+	v.lastChange = &change
+}
+
+// vimExecuteCommandLine executes the ex command typed in the command-line
+// state. A number moves the caret to the line. Other commands are sent as a
+// VimCommandEvent.
+func (e *Editor) vimExecuteCommandLine() EditorEvent {
+	cmdline := strings.TrimSpace(string(e.vim.cmdline))
+	e.vim.cmdline = e.vim.cmdline[:0]
+	e.setVimState(VimNormal)
+	if cmdline == "" {
+		return nil
+	}
+
+	if n, err := strconv.Atoi(cmdline); err == nil {
+		line := min(max(0, n-1), max(0, e.buffer.Lines()-1))
+		caret := vim.FirstNonBlank(e.buffer, line)
+		e.SetCaret(caret, caret)
+		e.vim.colCaret = -1
+		return nil
+	}
+	return VimCommandEvent{Command: cmdline}
+}
@@ -0,0 +1,151 @@
+package gvcode
+
+import (
+	"image"
+	"testing"
+
+	"gioui.org/font/gofont"
+	"gioui.org/io/input"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/text"
+	"github.com/oligo/gvcode/textstyle/syntax"
+)
+
+// vimHarness drives an Editor in the Vim mode with key events routed the same
+// way as in a window.
+type vimHarness struct {
+	t      *testing.T
+	e      *Editor
+	router *input.Router
+	shaper *text.Shaper
+}
+
+func newVimHarness(t *testing.T, content string) *vimHarness {
+	h := &vimHarness{
+		t:      t,
+		e:      &Editor{},
+		router: new(input.Router),
+		shaper: text.NewShaper(text.WithCollection(gofont.Collection())),
+	}
+	h.e.WithOptions(WithColorScheme(syntax.ColorScheme{}), VimMode(true))
+	h.e.SetText(content)
+	h.e.SetCaret(0, 0)
+	h.frame(func(gtx layout.Context) {
+		gtx.Execute(key.FocusCmd{Tag: h.e})
+	})
+	h.frame(nil)
+	return h
+}
+
+// frame lays out the editor, after calling fn with the context.
+func (h *vimHarness) frame(fn func(gtx layout.Context)) {
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(800, 600)),
+		Source:      h.router.Source(),
+	}
+	if fn != nil {
+		fn(gtx)
+	}
+	h.e.Layout(gtx, h.shaper)
+	h.router.Frame(gtx.Ops)
+}
+
+// typeKeys types keys, where "<esc>" presses the Escape key.
+func (h *vimHarness) typeKeys(keys ...string) {
+	for _, k := range keys {
+		if k == "<esc>" {
+			h.router.Queue(
+				key.Event{Name: key.NameEscape, State: key.Press},
+				key.Event{Name: key.NameEscape, State: key.Release},
+			)
+		} else {
+			// The input method replaces the selection with the text.
+			start, end := h.e.Selection()
+			h.router.Queue(key.EditEvent{Range: key.Range{Start: min(start, end), End: max(start, end)}, Text: k})
+		}
+		h.frame(nil)
+	}
+}
+
+func (h *vimHarness) check(text string, caret int, state VimState) {
+	h.t.Helper()
+	if got := h.e.Text(); got != text {
+		h.t.Errorf("want text %q, got %q", text, got)
+	}
+	if start, end := h.e.Selection(); start != caret || end != caret {
+		h.t.Errorf("want caret at %d, got selection %d-%d", caret, start, end)
+	}
+	if got := h.e.VimState(); got != state {
+		h.t.Errorf("want state %v, got %v", state, got)
+	}
+}
+
+func TestVimDeleteWord(t *testing.T) {
+	h := newVimHarness(t, "one two three")
+	h.typeKeys("w", "dw")
+	h.check("one three", 4, VimNormal)
+
+	// The deleted word is put after the caret.
+	h.typeKeys("p")
+	h.check("one ttwo hree", 8, VimNormal)
+}
+
+func TestVimChangeInnerWord(t *testing.T) {
+	h := newVimHarness(t, "foo bar baz")
+	h.typeKeys("w", "l", "ciw")
+	h.check("foo  baz", 4, VimInsert)
+
+	h.typeKeys("qux", "<esc>")
+	h.check("foo qux baz", 6, VimNormal)
+
+	// Repeat the change on the next word.
+	h.typeKeys("w", ".")
+	h.check("foo qux qux", 10, VimNormal)
+}
+
+func TestVimCountedMotion(t *testing.T) {
+	h := newVimHarness(t, "l0\nl1 x\nl2\nl3 y\nl4")
+	h.typeKeys("l", "3j")
+	h.check("l0\nl1 x\nl2\nl3 y\nl4", 12, VimNormal)
+
+	// The count applies to the operator too.
+	h.typeKeys("2k", "dd")
+	h.check("l0\nl2\nl3 y\nl4", 3, VimNormal)
+}
+
+func TestVimPutLines(t *testing.T) {
+	h := newVimHarness(t, "a\nb\nc")
+	h.typeKeys("yy", "j", "p")
+	h.check("a\nb\na\nc", 4, VimNormal)
+
+	h.typeKeys("G", "P")
+	h.check("a\nb\na\na\nc", 6, VimNormal)
+
+	// Repeat the put.
+	h.typeKeys(".")
+	h.check("a\nb\na\na\na\nc", 6, VimNormal)
+}
+
+func TestVimDotRepeatsDelete(t *testing.T) {
+	h := newVimHarness(t, "a b c d e")
+	h.typeKeys("2dw", ".")
+	h.check("e", 0, VimNormal)
+}
+
+func TestVimVisualSelection(t *testing.T) {
+	h := newVimHarness(t, "one two three")
+	h.typeKeys("w", "v", "e")
+	if got := h.e.VimState(); got != VimVisual {
+		t.Fatalf("want state %v, got %v", VimVisual, got)
+	}
+	// The block caret covers the last rune of the selection.
+	if start, end := h.e.Selection(); start != 6 || end != 4 {
+		t.Fatalf("want selection 6-4, got %d-%d", start, end)
+	}
+
+	h.typeKeys("d")
+	h.check("one  three", 4, VimNormal)
+}
@@ -0,0 +1,392 @@
+package widget
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+
+	"gioui.org/font"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/oligo/gvcode"
+)
+
+// CommandPalette is a popup listing the named commands of an editor with their
+// key bindings. The commands are filtered by fuzzy searching their titles and
+// IDs, and the selected one is run with the Enter key or by clicking.
+//
+// NewCommandPalette adds the "palette.show" command to the editor, which can
+// be bound to keys to open the palette:
+//
+//	editor.Keymap().Bind("Shortcut+Shift+P", "palette.show")
+type CommandPalette struct {
+	editor  *gvcode.Editor
+	input   widget.Editor
+	list    widget.List
+	items   []paletteItem
+	clicks  []widget.Clickable
+	focused int
+	query   string
+	visible bool
+	// focusInput and focusEditor request moving the focus in the next frame.
+	focusInput  bool
+	focusEditor bool
+
+	// Size configures the max popup dimensions. If no value
+	// is provided, a reasonable value is set.
+	Size image.Point
+	// TextSize configures the size the text displayed in the popup. If no value
+	// is provided, a reasonable value is set.
+	TextSize unit.Sp
+	// Color used to highlight the selected item.
+	HighlightColor color.NRGBA
+	Theme          *material.Theme
+	// Filter selects the commands to list. All the commands are listed if it
+	// is nil. Commands requiring arguments are usually left out, as the
+	// palette runs commands without arguments.
+	Filter func(cmd gvcode.Command) bool
+}
+
+type paletteItem struct {
+	cmd   gvcode.Command
+	keys  string
+	score int
+}
+
+// NewCommandPalette creates a command palette of the editor.
+func NewCommandPalette(editor *gvcode.Editor) *CommandPalette {
+	p := &CommandPalette{editor: editor}
+	editor.AddCommand(gvcode.Command{
+		ID:    "palette.show",
+		Title: "Show All Commands",
+		Handler: func(gtx layout.Context, args any) (gvcode.EditorEvent, error) {
+			p.Open()
+			gtx.Execute(op.InvalidateCmd{})
+			return nil, nil
+		},
+	})
+	return p
+}
+
+// Open shows the palette with an empty query, and moves the focus to it.
+func (p *CommandPalette) Open() {
+	p.visible = true
+	p.focusInput = true
+	p.focusEditor = false
+	p.input.SetText("")
+	p.query = ""
+	p.focused = 0
+	p.list.ScrollTo(0)
+}
+
+// Close hides the palette, and gives the focus back to the editor.
+func (p *CommandPalette) Close() {
+	if !p.visible {
+		return
+	}
+	p.visible = false
+	p.focusInput = false
+	p.focusEditor = true
+}
+
+// Visible reports whether the palette is open.
+func (p *CommandPalette) Visible() bool {
+	return p.visible
+}
+
+func (p *CommandPalette) Layout(gtx layout.Context) layout.Dimensions {
+	p.update(gtx)
+	if !p.visible {
+		return layout.Dimensions{}
+	}
+
+	border := widget.Border{
+		Color:        adjustAlpha(p.Theme.Fg, 0xb0),
+		Width:        unit.Dp(1),
+		CornerRadius: unit.Dp(4),
+	}
+
+	return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		gtx.Constraints.Max.X = min(gtx.Constraints.Max.X, p.Size.X)
+		gtx.Constraints.Max.Y = min(gtx.Constraints.Max.Y, p.Size.Y)
+		gtx.Constraints.Min = image.Point{X: gtx.Constraints.Max.X}
+
+		macro := op.Record(gtx.Ops)
+		dims := layout.UniformInset(unit.Dp(4)).Layout(gtx, p.layout)
+		callOp := macro.Stop()
+
+		defer clip.UniformRRect(image.Rectangle{Max: dims.Size}, gtx.Dp(unit.Dp(4))).Push(gtx.Ops).Pop()
+		paint.Fill(gtx.Ops, p.Theme.Bg)
+		callOp.Add(gtx.Ops)
+		return dims
+	})
+}
+
+func (p *CommandPalette) update(gtx layout.Context) {
+	if p.TextSize <= 0 {
+		p.TextSize = unit.Sp(12)
+	}
+	if p.Size == (image.Point{}) {
+		p.Size = image.Point{
+			X: gtx.Dp(unit.Dp(500)),
+			Y: gtx.Dp(unit.Dp(300)),
+		}
+	}
+
+	if p.focusEditor {
+		p.focusEditor = false
+		gtx.Execute(key.FocusCmd{Tag: p.editor})
+	}
+	if !p.visible {
+		return
+	}
+	if p.focusInput {
+		p.focusInput = false
+		gtx.Execute(key.FocusCmd{Tag: &p.input})
+	}
+	p.input.SingleLine = true
+
+	// Handle the navigation keys before the input does.
+	for {
+		evt, ok := gtx.Event(
+			key.Filter{Focus: &p.input, Name: key.NameUpArrow},
+			key.Filter{Focus: &p.input, Name: key.NameDownArrow},
+			key.Filter{Focus: &p.input, Name: key.NameEnter, Optional: key.ModShift},
+			key.Filter{Focus: &p.input, Name: key.NameReturn, Optional: key.ModShift},
+			key.Filter{Focus: &p.input, Name: key.NameEscape},
+		)
+		if !ok {
+			break
+		}
+		ke, ok := evt.(key.Event)
+		if !ok || ke.State != key.Press {
+			continue
+		}
+
+		switch ke.Name {
+		case key.NameUpArrow:
+			p.moveFocus(-1)
+		case key.NameDownArrow:
+			p.moveFocus(1)
+		case key.NameEnter, key.NameReturn:
+			if p.focused < len(p.items) {
+				p.run(gtx, p.items[p.focused].cmd)
+				return
+			}
+		case key.NameEscape:
+			p.close(gtx)
+			return
+		}
+	}
+
+	for {
+		if _, ok := p.input.Update(gtx); !ok {
+			break
+		}
+	}
+	if query := p.input.Text(); query != p.query {
+		p.query = query
+		p.focused = 0
+		p.list.ScrollTo(0)
+	}
+	p.filter()
+
+	for i := range p.items {
+		if p.clicks[i].Clicked(gtx) {
+			p.run(gtx, p.items[i].cmd)
+			return
+		}
+	}
+}
+
+// filter lists the commands matching the query, with the best matches first.
+func (p *CommandPalette) filter() {
+	p.items = p.items[:0]
+	km := p.editor.Keymap()
+	for _, cmd := range p.editor.Commands() {
+		if p.Filter != nil && !p.Filter(cmd) {
+			continue
+		}
+		titleScore, titleOk := fuzzyMatch(p.query, cmd.Title)
+		idScore, idOk := fuzzyMatch(p.query, cmd.ID)
+		if !titleOk && !idOk {
+			continue
+		}
+		p.items = append(p.items, paletteItem{
+			cmd:   cmd,
+			keys:  strings.Join(km.KeysFor(cmd.ID), ", "),
+			score: max(titleScore, idScore),
+		})
+	}
+
+	slices.SortStableFunc(p.items, func(a, b paletteItem) int {
+		if a.score != b.score {
+			return b.score - a.score
+		}
+		return strings.Compare(a.cmd.Title, b.cmd.Title)
+	})
+
+	if len(p.clicks) < len(p.items) {
+		p.clicks = append(p.clicks, make([]widget.Clickable, len(p.items)-len(p.clicks))...)
+	}
+	p.focused = max(0, min(p.focused, len(p.items)-1))
+}
+
+// close hides the palette, and gives the focus back to the editor
+// immediately.
+func (p *CommandPalette) close(gtx layout.Context) {
+	p.visible = false
+	p.focusInput = false
+	p.focusEditor = false
+	gtx.Execute(key.FocusCmd{Tag: p.editor})
+	gtx.Execute(op.InvalidateCmd{})
+}
+
+func (p *CommandPalette) run(gtx layout.Context, cmd gvcode.Command) {
+	p.close(gtx)
+	// Commands requiring arguments fail to run without them, which is
+	// nothing to report to the user here.
+	_ = p.editor.ExecuteCommand(gtx, cmd.ID, nil)
+}
+
+func (p *CommandPalette) moveFocus(direction int) {
+	if len(p.items) == 0 {
+		return
+	}
+	p.focused = max(0, min(p.focused+direction, len(p.items)-1))
+
+	// Scroll the focused item into view.
+	first := p.list.Position.First
+	if p.list.Position.Offset > 0 {
+		first++
+	}
+	last := p.list.Position.First + p.list.Position.Count - 1
+	if p.list.Position.OffsetLast < 0 {
+		last--
+	}
+	if p.focused < first || p.focused > last {
+		p.list.ScrollBy(float32(direction))
+	}
+}
+
+func (p *CommandPalette) layout(gtx layout.Context) layout.Dimensions {
+	th := p.Theme
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{
+				Top:    unit.Dp(4),
+				Bottom: unit.Dp(6),
+				Left:   unit.Dp(6),
+				Right:  unit.Dp(6),
+			}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				ed := material.Editor(th, &p.input, "Type a command")
+				ed.TextSize = p.TextSize + 1
+				return ed.Layout(gtx)
+			})
+		}),
+		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+			return p.layoutList(gtx)
+		}),
+	)
+}
+
+func (p *CommandPalette) layoutList(gtx layout.Context) layout.Dimensions {
+	th := p.Theme
+	p.list.Axis = layout.Vertical
+
+	highlightColor := p.HighlightColor
+	if highlightColor == (color.NRGBA{}) {
+		highlightColor = adjustAlpha(th.ContrastBg, 0x60)
+	}
+
+	li := material.List(th, &p.list)
+	li.AnchorStrategy = material.Overlay
+	li.ScrollbarStyle.Indicator.HoverColor = adjustAlpha(th.ContrastBg, 0xb0)
+	li.ScrollbarStyle.Indicator.Color = adjustAlpha(th.ContrastBg, 0x30)
+	li.ScrollbarStyle.Indicator.MinorWidth = unit.Dp(8)
+
+	return li.Layout(gtx, len(p.items), func(gtx layout.Context, index int) layout.Dimensions {
+		item := p.items[index]
+		return p.clicks[index].Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			return layout.Background{}.Layout(gtx,
+				func(gtx layout.Context) layout.Dimensions {
+					var fill color.NRGBA
+					if index == p.focused {
+						fill = highlightColor
+					} else if p.clicks[index].Hovered() {
+						fill = adjustAlpha(highlightColor, 0x30)
+					} else {
+						return layout.Dimensions{Size: gtx.Constraints.Min}
+					}
+					rect := clip.Rect{Max: gtx.Constraints.Min}
+					paint.FillShape(gtx.Ops, fill, rect.Op())
+					return layout.Dimensions{Size: gtx.Constraints.Min}
+				},
+				func(gtx layout.Context) layout.Dimensions {
+					return p.layoutItem(gtx, item)
+				},
+			)
+		})
+	})
+}
+
+func (p *CommandPalette) layoutItem(gtx layout.Context, item paletteItem) layout.Dimensions {
+	th := p.Theme
+	gtx.Constraints.Min.X = gtx.Constraints.Max.X
+	return layout.Inset{
+		Top:    unit.Dp(3),
+		Bottom: unit.Dp(3),
+		Left:   unit.Dp(6),
+		Right:  unit.Dp(8),
+	}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{
+			Axis:      layout.Horizontal,
+			Alignment: layout.Middle,
+			Spacing:   layout.SpaceBetween,
+		}.Layout(gtx,
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Alignment: layout.Baseline,
+				}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						lb := material.Label(th, p.TextSize, item.cmd.Title)
+						lb.Font.Weight = font.SemiBold
+						lb.MaxLines = 1
+						return lb.Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						lb := material.Label(th, p.TextSize-1, item.cmd.ID)
+						lb.Color = adjustAlpha(th.Fg, 0x90)
+						lb.MaxLines = 1
+						return lb.Layout(gtx)
+					}),
+				)
+			}),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				lb := material.Label(th, p.TextSize-1, item.keys)
+				lb.Color = adjustAlpha(th.Fg, 0xc8)
+				lb.MaxLines = 1
+				return lb.Layout(gtx)
+			}),
+		)
+	})
+}
+
+func adjustAlpha(c color.NRGBA, alpha uint8) color.NRGBA {
+	return color.NRGBA{
+		R: c.R,
+		G: c.G,
+		B: c.B,
+		A: alpha,
+	}
+}
@@ -0,0 +1,61 @@
+package widget
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// fuzzyMatch reports whether the runes of pattern appear in s in order,
+// ignoring case, and scores the match. Consecutive matches and matches at the
+// start of words score higher, so "su" ranks "Select Up" above "Cursor Up".
+func fuzzyMatch(pattern, s string) (score int, ok bool) {
+	if pattern == "" {
+		return 0, true
+	}
+
+	p, size := utf8.DecodeRuneInString(pattern)
+	p = unicode.ToLower(p)
+	prev := rune(0)
+	consecutive := false
+	for i, r := range s {
+		if unicode.ToLower(r) != p {
+			consecutive = false
+			prev = r
+			continue
+		}
+
+		score++
+		if consecutive {
+			score += 4
+		}
+		if isWordStart(prev, r) {
+			score += 6
+			if i == 0 {
+				score += 2
+			}
+		}
+		consecutive = true
+		prev = r
+
+		pattern = pattern[size:]
+		if pattern == "" {
+			// Prefer the shorter ones of otherwise equal matches.
+			return score*64 - min(63, utf8.RuneCountInString(s)), true
+		}
+		p, size = utf8.DecodeRuneInString(pattern)
+		p = unicode.ToLower(p)
+	}
+	return 0, false
+}
+
+// isWordStart checks if r starts a word after prev, which is the case at the
+// start of the text, after a non-alphanumeric rune, or at a camel case hump.
+func isWordStart(prev, r rune) bool {
+	if prev == 0 {
+		return true
+	}
+	if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	return unicode.IsLower(prev) && unicode.IsUpper(r)
+}
@@ -0,0 +1,39 @@
+package widget
+
+import (
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		ok         bool
+	}{
+		{"", "Undo", true},
+		{"undo", "Undo", true},
+		{"cwl", "Cursor Word Left", true},
+		{"cwl", "cursor.wordLeft", true},
+		{"wlc", "Cursor Word Left", false},
+		{"undoo", "Undo", false},
+	}
+	for _, tc := range cases {
+		if _, ok := fuzzyMatch(tc.pattern, tc.s); ok != tc.ok {
+			t.Errorf("%q in %q: want %v, got %v", tc.pattern, tc.s, tc.ok, ok)
+		}
+	}
+
+	// Word starts and consecutive matches rank higher.
+	ranked := [][2]string{
+		{"Select Up", "Cursor Up"},
+		{"Cursor Word Left", "Cursor Down Left"},
+		{"Undo", "Undo Everything"},
+	}
+	patterns := []string{"su", "cwl", "undo"}
+	for i, pair := range ranked {
+		better, _ := fuzzyMatch(patterns[i], pair[0])
+		worse, ok := fuzzyMatch(patterns[i], pair[1])
+		if ok && better <= worse {
+			t.Errorf("%q: want %q (%d) above %q (%d)", patterns[i], pair[0], better, pair[1], worse)
+		}
+	}
+}